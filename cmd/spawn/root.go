@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"net/http"
 	"os"
@@ -22,6 +23,8 @@ import (
 	"gopkg.in/yaml.v3"
 
 	"spawn.dev/pkg/agent"
+	"spawn.dev/pkg/capability/memory"
+	netcap "spawn.dev/pkg/capability/net"
 	"spawn.dev/pkg/localstate"
 	"spawn.dev/pkg/version"
 )
@@ -75,6 +78,7 @@ func newRootCmd() *cobra.Command {
 		app.doctorCmd(&cfgFile, &stateFile),
 		app.upgradeCmd(),
 		app.configCmd(&stateFile),
+		app.memoryCmd(),
 	)
 	return cmd
 }
@@ -163,7 +167,7 @@ func (a *cliApp) runCmd(statePath *string) *cobra.Command {
 				return err
 			}
 			if cfg.Metadata.Namespace == "" {
-				cfg.Metadata.Namespace = "default"
+				cfg.Metadata.Namespace = agent.DefaultNamespace
 			}
 
 			store, err := a.openStore(*statePath)
@@ -1048,6 +1052,7 @@ func (a *cliApp) lintCmd() *cobra.Command {
 
 func (a *cliApp) testCmd() *cobra.Command {
 	var timeout time.Duration
+	var netMode, cassettes string
 	cmd := &cobra.Command{
 		Use:   "test",
 		Short: "Run agent tests",
@@ -1059,6 +1064,18 @@ func (a *cliApp) testCmd() *cobra.Command {
 				defer cancel()
 			}
 			testCmd := exec.CommandContext(ctx, "go", "test", "./...")
+			testCmd.Env = os.Environ()
+			switch netMode {
+			case "", "live":
+			case netcap.CassetteRecord, netcap.CassetteReplay:
+				dir, err := filepath.Abs(cassettes)
+				if err != nil {
+					return fmt.Errorf("resolve cassette dir: %w", err)
+				}
+				testCmd.Env = append(testCmd.Env, netcap.EnvCassetteMode+"="+netMode, netcap.EnvCassetteDir+"="+dir)
+			default:
+				return fmt.Errorf("--net must be live, record or replay")
+			}
 			testCmd.Stdout = os.Stdout
 			testCmd.Stderr = os.Stderr
 			if err := testCmd.Run(); err != nil {
@@ -1068,6 +1085,8 @@ func (a *cliApp) testCmd() *cobra.Command {
 		},
 	}
 	cmd.Flags().DurationVar(&timeout, "timeout", 0, "test timeout")
+	cmd.Flags().StringVar(&netMode, "net", "live", "net capability mode: live, record or replay")
+	cmd.Flags().StringVar(&cassettes, "cassettes", "testdata/cassettes", "directory of net cassettes")
 	return cmd
 }
 
@@ -1218,6 +1237,166 @@ func (a *cliApp) configSetCmd(statePath *string) *cobra.Command {
 	}
 }
 
+// memoryStorage holds the --db and --vectors flags of the memory commands.
+type memoryStorage struct {
+	db      string
+	vectors string
+}
+
+func (a *cliApp) memoryCmd() *cobra.Command {
+	storage := &memoryStorage{}
+	cmd := &cobra.Command{Use: "memory", Short: "Export, import and back up agent memory"}
+	cmd.PersistentFlags().StringVar(&storage.db, "db", "", "memory database (default: storage.memory.path)")
+	cmd.PersistentFlags().StringVar(&storage.vectors, "vectors", "", "vector index directory (default: storage.vector.path)")
+	cmd.AddCommand(
+		a.memoryExportCmd(storage),
+		a.memoryImportCmd(storage),
+		a.memoryBackupCmd(storage),
+	)
+	return cmd
+}
+
+// openMemory opens the memory stores named by the flags or the daemon
+// config, creating them only if create is set. It fails if the daemon holds
+// them; use the gateway endpoints then.
+func (a *cliApp) openMemory(storage *memoryStorage, create bool) (*memory.Capability, error) {
+	db, vectors := storage.db, storage.vectors
+	if db == "" {
+		db = viper.GetString("storage.memory.path")
+	}
+	if vectors == "" {
+		vectors = viper.GetString("storage.vector.path")
+	}
+	if db == "" {
+		return nil, fmt.Errorf("memory database path is required: set --db or storage.memory.path")
+	}
+	if _, err := os.Stat(db); err != nil && !create {
+		return nil, fmt.Errorf("memory database: %w", err)
+	}
+	mem, err := memory.New(db)
+	if err != nil {
+		return nil, err
+	}
+	// Indexes keep the dimensions and metric they were created with.
+	mem.SetVectorStorage(vectors, memory.VectorOptions{})
+	return mem, nil
+}
+
+// createOutput opens path for writing, or stdout for "-".
+func createOutput(path string) (io.WriteCloser, error) {
+	if path == "-" {
+		return os.Stdout, nil
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("create %s: %w", path, err)
+	}
+	return f, nil
+}
+
+func (a *cliApp) memoryExportCmd(storage *memoryStorage) *cobra.Command {
+	var output string
+	var namespaces []string
+	cmd := &cobra.Command{
+		Use:   "export <agent>",
+		Short: "Export an agent's memory to an archive",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			mem, err := a.openMemory(storage, false)
+			if err != nil {
+				return err
+			}
+			defer mem.Shutdown(context.Background())
+			if output == "" {
+				output = args[0] + ".memory.jsonl.gz"
+			}
+			out, err := createOutput(output)
+			if err != nil {
+				return err
+			}
+			err = mem.Export(context.Background(), out, args[0], memory.ExportOptions{Namespaces: namespaces})
+			if closeErr := out.Close(); err == nil && output != "-" {
+				err = closeErr
+			}
+			if err != nil {
+				return err
+			}
+			if output != "-" {
+				fmt.Printf("Exported memory of %s to %s\n", args[0], output)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&output, "output", "o", "", "archive path, - for stdout (default: <agent>.memory.jsonl.gz)")
+	cmd.Flags().StringSliceVar(&namespaces, "namespace", nil, "namespaces to export (default: all)")
+	return cmd
+}
+
+func (a *cliApp) memoryImportCmd(storage *memoryStorage) *cobra.Command {
+	var mode string
+	var namespaces []string
+	cmd := &cobra.Command{
+		Use:   "import <agent> <archive>",
+		Short: "Import an archive into an agent's memory",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(_ *cobra.Command, args []string) error {
+			in, err := os.Open(args[1])
+			if err != nil {
+				return fmt.Errorf("open archive: %w", err)
+			}
+			defer in.Close()
+			mem, err := a.openMemory(storage, true)
+			if err != nil {
+				return err
+			}
+			defer mem.Shutdown(context.Background())
+			stats, err := mem.Import(context.Background(), in, args[0], memory.ImportOptions{Mode: mode, Namespaces: namespaces})
+			if err != nil {
+				return err
+			}
+			return a.printJSON(stats)
+		},
+	}
+	cmd.Flags().StringVar(&mode, "mode", memory.ImportMerge, "merge into or replace existing memory")
+	cmd.Flags().StringSliceVar(&namespaces, "namespace", nil, "namespaces to import (default: all)")
+	return cmd
+}
+
+func (a *cliApp) memoryBackupCmd(storage *memoryStorage) *cobra.Command {
+	var output string
+	cmd := &cobra.Command{
+		Use:   "backup",
+		Short: "Write a consistent copy of all memory databases to a tar archive",
+		RunE: func(_ *cobra.Command, _ []string) error {
+			mem, err := a.openMemory(storage, false)
+			if err != nil {
+				return err
+			}
+			defer mem.Shutdown(context.Background())
+			if output == "" {
+				output = "spawn-memory-" + time.Now().UTC().Format("20060102T150405Z") + ".tar"
+			}
+			out, err := createOutput(output)
+			if err != nil {
+				return err
+			}
+			err = mem.Backup(context.Background(), out)
+			if closeErr := out.Close(); err == nil && output != "-" {
+				err = closeErr
+			}
+			if err != nil {
+				return err
+			}
+			if output != "-" {
+				fmt.Printf("Backed up memory to %s\n", output)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&output, "output", "o", "", "tar path, - for stdout (default: spawn-memory-<time>.tar)")
+	return cmd
+}
+
 type releaseInfo struct {
 	Tag string `json:"tag_name"`
 	URL string `json:"html_url"`
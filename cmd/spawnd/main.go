@@ -10,6 +10,7 @@ import (
 
 	"github.com/spf13/cobra"
 
+	"spawn.dev/pkg/capability/memory"
 	"spawn.dev/pkg/config"
 	"spawn.dev/pkg/gateway"
 )
@@ -30,6 +31,17 @@ func main() {
 				RESTAddr: fmt.Sprintf(":%d", cfg.Server.Ports.REST),
 				WSAddr:   fmt.Sprintf(":%d", cfg.Server.Ports.REST+1),
 			})
+			if cfg.Storage.Memory.Path != "" {
+				mem, err := memory.New(cfg.Storage.Memory.Path)
+				if err != nil {
+					return err
+				}
+				// Agents apply their own vector settings on Start; until then
+				// indexes keep the dimensions and metric they were created with.
+				mem.SetVectorStorage(cfg.Storage.Vector.Path, memory.VectorOptions{})
+				defer mem.Shutdown(context.Background())
+				gw.SetMemory(mem)
+			}
 			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 			defer stop()
 
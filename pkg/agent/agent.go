@@ -2,10 +2,14 @@ package agent
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"spawn.dev/pkg/capability"
+	"spawn.dev/pkg/capability/fs"
+	"spawn.dev/pkg/capability/net"
 	"spawn.dev/pkg/llm"
+	"spawn.dev/pkg/sandbox"
 )
 
 // Message is an inter-agent message.
@@ -25,10 +29,14 @@ type Task struct {
 
 // TaskResult is the result of task execution.
 type TaskResult struct {
-	TaskID   string
-	Output   string
-	Error    string
-	Duration time.Duration
+	TaskID     string
+	Output     string
+	Error      string
+	Duration   time.Duration
+	SnapshotID string
+	// Tools lists the tools the model called.
+	Tools   []string
+	CostUSD float64
 }
 
 // LogEntry is a streamable structured log line.
@@ -77,12 +85,70 @@ type Agent struct {
 	StartedAt    time.Time
 	LLM          llm.Provider
 	Capabilities map[string]capability.Capability
-	Context      *ExecutionContext
-	Inbox        chan Message
-	Outbox       chan Message
-	TokensUsed   int64
-	CostUSD      float64
-	TasksRun     int64
+	// Sandbox is the agent's sandbox; exec:// and wasm:// custom tools run
+	// in it and fail without one.
+	Sandbox sandbox.Sandbox
+	// EgressProxy is the agent's running egress proxy when
+	// spec.capabilities.net.egressProxy is enabled.
+	EgressProxy *net.Proxy
+	Context     *ExecutionContext
+	Inbox       chan Message
+	Outbox      chan Message
+	TokensUsed  int64
+	CostUSD     float64
+	TasksRun    int64
+}
+
+// SandboxConfig builds the agent's sandbox config from spec.sandbox. With
+// fsys, the agent's fs capability, its mount table becomes Config.Mounts so
+// sandboxed processes see the paths the capability serves, and a running
+// egress proxy becomes Config.EgressProxy.
+func (a *Agent) SandboxConfig(fsys *fs.Capability) *sandbox.Config {
+	cfg := sandbox.DefaultConfig()
+	spec := a.Config.Spec.Sandbox
+	if spec.Runtime != "" {
+		cfg.Runtime = sandbox.RuntimeType(spec.Runtime)
+	}
+	if spec.NetworkPolicy != "" {
+		cfg.Network = sandbox.NetworkPolicy(spec.NetworkPolicy)
+	}
+	if spec.SeccompProfile != "" {
+		cfg.Seccomp = sandbox.SeccompProfile(spec.SeccompProfile)
+	}
+	if fsys != nil {
+		cfg.Mounts = fsys.SandboxMounts()
+	}
+	if a.EgressProxy != nil {
+		cfg.EgressProxy = a.EgressProxy.URL()
+	}
+	return cfg
+}
+
+// MemoryID is the identity the agent's memory scopes and episodes are kept
+// under: "<namespace>.<name>", in the default namespace when
+// metadata.namespace is empty, so same-named agents in different
+// namespaces keep separate memory. Namespaces cannot contain dots, which
+// keeps the identity unambiguous.
+func (a *Agent) MemoryID() string {
+	ns := a.Namespace
+	if ns == "" {
+		ns = DefaultNamespace
+	}
+	return ns + "." + a.Name
+}
+
+// Call runs an action of one of the agent's capabilities on its behalf.
+// The request carries the agent's MemoryID as its AgentID.
+func (a *Agent) Call(ctx context.Context, name, action string, params map[string]interface{}) (*capability.Response, error) {
+	c, ok := a.Capabilities[name]
+	if !ok {
+		return nil, fmt.Errorf("call %s.%s: agent %s has no %s capability", name, action, a.Name, name)
+	}
+	return c.Execute(ctx, &capability.Request{
+		Action:  action,
+		Params:  params,
+		Context: &capability.ExecutionContext{AgentID: a.MemoryID()},
+	})
 }
 
 // Manager handles agent lifecycle.
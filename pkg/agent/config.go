@@ -4,9 +4,18 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
 	"sort"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
+
+	"spawn.dev/pkg/capability/browser"
+	"spawn.dev/pkg/capability/fs"
+	"spawn.dev/pkg/capability/memory"
+	"spawn.dev/pkg/capability/net"
+	"spawn.dev/pkg/capability/tools"
 )
 
 // AgentConfig is the top-level agent configuration.
@@ -15,8 +24,14 @@ type AgentConfig struct {
 	Kind       string    `yaml:"kind" json:"kind"`
 	Metadata   Metadata  `yaml:"metadata" json:"metadata"`
 	Spec       AgentSpec `yaml:"spec" json:"spec"`
+	// Dir is the directory the config was loaded from, which relative
+	// custom tool handlers resolve against.
+	Dir string `yaml:"-" json:"-"`
 }
 
+// DefaultNamespace is the namespace of agents whose metadata names none.
+const DefaultNamespace = "default"
+
 // Metadata contains identifying labels/annotations.
 type Metadata struct {
 	Name        string            `yaml:"name" json:"name"`
@@ -76,39 +91,228 @@ type ExecConfig struct {
 
 // FSConfig configures filesystem capability.
 type FSConfig struct {
-	Enabled bool      `yaml:"enabled" json:"enabled"`
-	Mounts  []FSMount `yaml:"mounts" json:"mounts"`
+	Enabled  bool       `yaml:"enabled" json:"enabled"`
+	Mounts   []FSMount  `yaml:"mounts" json:"mounts"`
+	Symlinks FSSymlinks `yaml:"symlinks" json:"symlinks"`
+	Snapshot FSSnapshot `yaml:"snapshot" json:"snapshot"`
+}
+
+// FSSnapshot configures automatic pre-task workspace snapshots.
+type FSSnapshot struct {
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	Retain  int  `yaml:"retain" json:"retain"`
+}
+
+// FSSymlinks configures symlink traversal and creation.
+type FSSymlinks struct {
+	Follow string `yaml:"follow" json:"follow"`
+	Create bool   `yaml:"create" json:"create"`
 }
 
 // FSMount defines a mounted path.
 type FSMount struct {
-	Path   string `yaml:"path" json:"path"`
-	Source string `yaml:"source" json:"source"`
-	Mode   string `yaml:"mode" json:"mode"`
-	Quota  string `yaml:"quota" json:"quota"`
+	Path    string     `yaml:"path" json:"path"`
+	Source  string     `yaml:"source" json:"source"`
+	Mode    string     `yaml:"mode" json:"mode"`
+	Quota   string     `yaml:"quota" json:"quota"`
+	Overlay *FSOverlay `yaml:"overlay,omitempty" json:"overlay,omitempty"`
+}
+
+// FSOverlay makes a mount copy-on-write: source is the read-only lower layer
+// and writes land in upper.
+type FSOverlay struct {
+	Upper string `yaml:"upper" json:"upper"`
+	Work  string `yaml:"work" json:"work"`
+}
+
+// FSMounts converts configured mounts into fs capability mounts.
+func (c FSConfig) FSMounts() []fs.Mount {
+	out := make([]fs.Mount, 0, len(c.Mounts))
+	for _, m := range c.Mounts {
+		mount := fs.Mount{Path: m.Path, Source: m.Source, Mode: m.Mode, Quota: m.Quota}
+		if m.Overlay != nil {
+			mount.Overlay = &fs.OverlayConfig{LowerDir: m.Source, UpperDir: m.Overlay.Upper, WorkDir: m.Overlay.Work}
+		}
+		out = append(out, mount)
+	}
+	return out
 }
 
 // NetConfig configures network policy.
 type NetConfig struct {
-	Enabled   bool      `yaml:"enabled" json:"enabled"`
-	Allowlist []string  `yaml:"allowlist" json:"allowlist"`
-	Denylist  []string  `yaml:"denylist" json:"denylist"`
-	RateLimit RateLimit `yaml:"rateLimit" json:"rateLimit"`
+	Enabled         bool      `yaml:"enabled" json:"enabled"`
+	Allowlist       []string  `yaml:"allowlist" json:"allowlist"`
+	Denylist        []string  `yaml:"denylist" json:"denylist"`
+	RateLimit       RateLimit `yaml:"rateLimit" json:"rateLimit"`
+	UserAgent       string    `yaml:"userAgent" json:"userAgent"`
+	MaxResponseSize string    `yaml:"maxResponseSize" json:"maxResponseSize"`
+	MaxRedirects    *int      `yaml:"maxRedirects" json:"maxRedirects"`
+	AllowCIDRs      []string  `yaml:"allowCIDRs" json:"allowCIDRs"`
+	DenyCIDRs       []string  `yaml:"denyCIDRs" json:"denyCIDRs"`
+	Cassette        *Cassette `yaml:"cassette,omitempty" json:"cassette,omitempty"`
+	Cache           *NetCache `yaml:"cache,omitempty" json:"cache,omitempty"`
+	EgressProxy     *Egress   `yaml:"egressProxy,omitempty" json:"egressProxy,omitempty"`
+}
+
+// Egress runs a per-agent HTTP/CONNECT proxy that sandboxed processes are
+// pointed at, enforcing the same policy and rate limits as the net
+// capability. Sandbox runtimes do not yet block traffic that bypasses it.
+type Egress struct {
+	Enabled     bool   `yaml:"enabled" json:"enabled"`
+	Listen      string `yaml:"listen" json:"listen"`
+	TunnelPorts []int  `yaml:"tunnelPorts" json:"tunnelPorts"`
+}
+
+// ProxyConfig builds the egress proxy settings for agentID. Callers running
+// the net capability too should share its Limiter with the proxy.
+func (c NetConfig) ProxyConfig(agentID string) (net.ProxyConfig, error) {
+	ipPolicy, err := c.IPPolicy()
+	if err != nil {
+		return net.ProxyConfig{}, err
+	}
+	limits, err := c.Limits()
+	if err != nil {
+		return net.ProxyConfig{}, err
+	}
+	cfg := net.ProxyConfig{
+		AgentID:  agentID,
+		Policy:   net.Policy{Allowlist: c.Allowlist, Denylist: c.Denylist},
+		IPPolicy: ipPolicy,
+		Limiter:  net.NewLimiter(agentID, limits),
+	}
+	if c.EgressProxy != nil {
+		cfg.Addr = c.EgressProxy.Listen
+		cfg.TunnelPorts = c.EgressProxy.TunnelPorts
+	}
+	return cfg, nil
+}
+
+// NetCache configures the HTTP response cache. Backend is "disk" (entries
+// under Path) or "memory" (the agent's memory KV store).
+type NetCache struct {
+	Backend string `yaml:"backend" json:"backend"`
+	Path    string `yaml:"path" json:"path"`
+	MaxSize string `yaml:"maxSize" json:"maxSize"`
+}
+
+// Cassette records net requests to a file or replays them from it.
+type Cassette struct {
+	Path          string   `yaml:"path" json:"path"`
+	Mode          string   `yaml:"mode" json:"mode"`
+	IgnoreHeaders []string `yaml:"ignoreHeaders" json:"ignoreHeaders"`
 }
 
-// RateLimit defines request limits.
+// CassetteOptions returns the cassette for agent name. A mode chosen by
+// "spawn test" through the environment overrides the configured one.
+func (c NetConfig) CassetteOptions(name string) (net.CassetteOptions, bool) {
+	opts, ok := net.CassetteOptionsFromEnv(name)
+	if c.Cassette == nil {
+		return opts, ok
+	}
+	if ok {
+		opts.IgnoreHeaders = c.Cassette.IgnoreHeaders
+		return opts, true
+	}
+	return net.CassetteOptions{Path: c.Cassette.Path, Mode: c.Cassette.Mode, IgnoreHeaders: c.Cassette.IgnoreHeaders}, true
+}
+
+// IPPolicy builds the address policy checked after DNS resolution.
+// Private, loopback and metadata ranges stay blocked unless in AllowCIDRs.
+func (c NetConfig) IPPolicy() (*net.IPPolicy, error) {
+	return net.NewIPPolicy(c.AllowCIDRs, c.DenyCIDRs)
+}
+
+// RateLimit defines request limits. Requests applies to the whole agent and
+// PerHost to each destination host within the same window. Mode is "wait"
+// (default) or "fail".
 type RateLimit struct {
 	Requests int    `yaml:"requests" json:"requests"`
 	Per      string `yaml:"per" json:"per"`
+	PerHost  int    `yaml:"perHost" json:"perHost"`
+	Mode     string `yaml:"mode" json:"mode"`
+}
+
+// Rate limit modes.
+const (
+	RateLimitWait = "wait"
+	RateLimitFail = "fail"
+)
+
+// Limits converts the rate limit into net capability limiter settings,
+// applying the documented defaults of 1000 requests per minute.
+func (c NetConfig) Limits() (net.RateLimitConfig, error) {
+	r := c.RateLimit
+	per := time.Minute
+	if r.Per != "" {
+		d, err := time.ParseDuration(r.Per)
+		if err != nil || d <= 0 {
+			return net.RateLimitConfig{}, fmt.Errorf("rateLimit.per %q must be a positive duration", r.Per)
+		}
+		per = d
+	}
+	requests := r.Requests
+	if requests == 0 {
+		requests = 1000
+	}
+	if requests < 0 || r.PerHost < 0 {
+		return net.RateLimitConfig{}, fmt.Errorf("rateLimit requests must not be negative")
+	}
+	if r.Mode != "" && r.Mode != RateLimitWait && r.Mode != RateLimitFail {
+		return net.RateLimitConfig{}, fmt.Errorf("rateLimit.mode must be wait or fail")
+	}
+	return net.RateLimitConfig{
+		Agent: net.RateLimit{Requests: requests, Per: per},
+		Host:  net.RateLimit{Requests: r.PerHost, Per: per},
+		Wait:  r.Mode != RateLimitFail,
+	}, nil
 }
 
 // BrowserConfig configures browser capability.
 type BrowserConfig struct {
-	Enabled  bool     `yaml:"enabled" json:"enabled"`
-	Headless bool     `yaml:"headless" json:"headless"`
-	Stealth  bool     `yaml:"stealth" json:"stealth"`
-	Timeout  string   `yaml:"timeout" json:"timeout"`
-	Viewport Viewport `yaml:"viewport" json:"viewport"`
+	Enabled  bool         `yaml:"enabled" json:"enabled"`
+	Headless bool         `yaml:"headless" json:"headless"`
+	Stealth  bool         `yaml:"stealth" json:"stealth"`
+	Timeout  string       `yaml:"timeout" json:"timeout"`
+	Viewport Viewport     `yaml:"viewport" json:"viewport"`
+	Pool     *BrowserPool `yaml:"pool,omitempty" json:"pool,omitempty"`
+}
+
+// BrowserPool limits browser sessions across all agents. Sessions idle for
+// IdleTimeout are closed; with StateDir they are saved there and resumed,
+// cookies included, when next used.
+type BrowserPool struct {
+	Size        int    `yaml:"size" json:"size"`
+	IdleTimeout string `yaml:"idleTimeout" json:"idleTimeout"`
+	StateDir    string `yaml:"stateDir" json:"stateDir"`
+}
+
+// PoolConfig builds the browser session pool settings, defaulting to 3
+// sessions closed after 5m idle.
+func (c BrowserConfig) PoolConfig() (browser.PoolConfig, error) {
+	cfg := browser.PoolConfig{
+		MaxInstances: 3,
+		Headless:     c.Headless,
+		IdleTimeout:  5 * time.Minute,
+		Viewport:     browser.Viewport{Width: c.Viewport.Width, Height: c.Viewport.Height},
+	}
+	if c.Pool == nil {
+		return cfg, nil
+	}
+	if c.Pool.Size < 0 {
+		return cfg, fmt.Errorf("pool.size must not be negative")
+	}
+	if c.Pool.Size > 0 {
+		cfg.MaxInstances = c.Pool.Size
+	}
+	if c.Pool.IdleTimeout != "" {
+		d, err := time.ParseDuration(c.Pool.IdleTimeout)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid pool.idleTimeout %q: %w", c.Pool.IdleTimeout, err)
+		}
+		cfg.IdleTimeout = d
+	}
+	cfg.StateDir = c.Pool.StateDir
+	return cfg, nil
 }
 
 // Viewport defines browser viewport size.
@@ -119,18 +323,107 @@ type Viewport struct {
 
 // MemoryConfig configures memory capability.
 type MemoryConfig struct {
-	Enabled bool         `yaml:"enabled" json:"enabled"`
-	Vector  VectorConfig `yaml:"vector" json:"vector"`
-	Graph   GraphConfig  `yaml:"graph" json:"graph"`
-	TTL     string       `yaml:"ttl" json:"ttl"`
+	Enabled   bool            `yaml:"enabled" json:"enabled"`
+	Vector    VectorConfig    `yaml:"vector" json:"vector"`
+	Graph     GraphConfig     `yaml:"graph" json:"graph"`
+	TTL       string          `yaml:"ttl" json:"ttl"`
+	Embedding EmbeddingConfig `yaml:"embedding" json:"embedding"`
+	// Shared lists namespaces whose data all agents share; other
+	// namespaces are private to each agent.
+	Shared   []string       `yaml:"shared" json:"shared"`
+	Episodes EpisodesConfig `yaml:"episodes" json:"episodes"`
+}
+
+// Episodic memory defaults.
+const (
+	DefaultEpisodeTopK          = 3
+	DefaultMaxEpisodes          = 500
+	DefaultEpisodeSummaryPrompt = "Summarise this finished task for your future self in at most three sentences: what was asked, what was done and what was learned. Mention names, identifiers and errors that would help with similar tasks."
+)
+
+// EpisodesConfig configures episodic memory: after each task the
+// supervisor stores a summary of it, and before each task it adds the most
+// relevant summaries to the prompt.
+type EpisodesConfig struct {
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// TopK is the number of episodes added to a prompt.
+	TopK int `yaml:"topK" json:"topK"`
+	// MaxEpisodes and MaxAge bound the episodes kept; MaxAge is a ttl
+	// such as 30d.
+	MaxEpisodes int    `yaml:"maxEpisodes" json:"maxEpisodes"`
+	MaxAge      string `yaml:"maxAge" json:"maxAge"`
+	// SummaryPrompt instructs the model how to summarise a task; the task,
+	// its outcome and output follow it.
+	SummaryPrompt string `yaml:"summaryPrompt" json:"summaryPrompt"`
+}
+
+// Retention converts MaxEpisodes and MaxAge for
+// memory.Capability.RecordEpisode.
+func (c EpisodesConfig) Retention() (memory.EpisodeRetention, error) {
+	keep := memory.EpisodeRetention{MaxEpisodes: c.MaxEpisodes}
+	if keep.MaxEpisodes < 0 {
+		return keep, fmt.Errorf("maxEpisodes must not be negative")
+	}
+	if keep.MaxEpisodes == 0 {
+		keep.MaxEpisodes = DefaultMaxEpisodes
+	}
+	if c.MaxAge != "" {
+		age, err := memory.ParseTTL(c.MaxAge)
+		if err != nil {
+			return keep, fmt.Errorf("maxAge: %w", err)
+		}
+		keep.MaxAge = age
+	}
+	return keep, nil
+}
+
+// DefaultTTL parses TTL for memory.Capability.SetTTL; empty means entries
+// do not expire.
+func (c MemoryConfig) DefaultTTL() (time.Duration, error) {
+	if c.TTL == "" {
+		return 0, nil
+	}
+	return memory.ParseTTL(c.TTL)
 }
 
-// VectorConfig defines vector settings.
+// EmbeddingConfig tunes how remember and recall chunk and embed text
+// through the agent's model provider. Zero values use the defaults.
+type EmbeddingConfig struct {
+	ChunkSize    int `yaml:"chunkSize" json:"chunkSize"`
+	ChunkOverlap int `yaml:"chunkOverlap" json:"chunkOverlap"`
+	BatchSize    int `yaml:"batchSize" json:"batchSize"`
+	CacheSize    int `yaml:"cacheSize" json:"cacheSize"`
+}
+
+// EmbedOptions converts the config for memory.Settings.
+func (c EmbeddingConfig) EmbedOptions() memory.EmbedOptions {
+	return memory.EmbedOptions{ChunkSize: c.ChunkSize, ChunkOverlap: c.ChunkOverlap, BatchSize: c.BatchSize, CacheSize: c.CacheSize}
+}
+
+// VectorConfig defines vector settings. Metric is cosine, dot or l2
+// (euclidean).
 type VectorConfig struct {
 	Dimensions int    `yaml:"dimensions" json:"dimensions"`
 	Metric     string `yaml:"metric" json:"metric"`
 }
 
+// VectorOptions builds the vector index settings. Dimensions left at 0 are
+// taken from the first vector stored; an empty metric keeps the metric of
+// an existing index, and new ones use cosine.
+func (c VectorConfig) VectorOptions() (memory.VectorOptions, error) {
+	metric := c.Metric
+	if metric != "" {
+		var err error
+		if metric, err = memory.ParseMetric(metric); err != nil {
+			return memory.VectorOptions{}, err
+		}
+	}
+	if c.Dimensions < 0 {
+		return memory.VectorOptions{}, fmt.Errorf("dimensions must not be negative")
+	}
+	return memory.VectorOptions{Dimensions: c.Dimensions, Metric: metric}, nil
+}
+
 // GraphConfig defines graph settings.
 type GraphConfig struct {
 	Enabled bool `yaml:"enabled" json:"enabled"`
@@ -150,12 +443,49 @@ type MCPTool struct {
 	Name string `yaml:"name" json:"name"`
 }
 
-// CustomTool defines a custom tool schema+handler.
+// CustomTool defines a custom tool schema+handler. Handler is exec://,
+// http://, https:// or wasm://; see tools.CustomTool.
 type CustomTool struct {
 	Name        string                 `yaml:"name" json:"name"`
 	Description string                 `yaml:"description" json:"description"`
 	Schema      map[string]interface{} `yaml:"schema" json:"schema"`
-	Handler     string                 `yaml:"handler" json:"handler"`
+	// OutputSchema, when set, checks the handler's result.
+	OutputSchema map[string]interface{} `yaml:"outputSchema" json:"outputSchema"`
+	Handler      string                 `yaml:"handler" json:"handler"`
+	Timeout      string                 `yaml:"timeout" json:"timeout"`
+}
+
+// CustomTools converts Custom for tools.Capability.RegisterCustom.
+func (c ToolsConfig) CustomTools() ([]tools.CustomTool, error) {
+	out := make([]tools.CustomTool, 0, len(c.Custom))
+	seen := map[string]bool{}
+	for _, t := range c.Custom {
+		if t.Name == "" {
+			return nil, fmt.Errorf("custom tool name is required")
+		}
+		if seen[t.Name] {
+			return nil, fmt.Errorf("custom tool %s is declared twice", t.Name)
+		}
+		seen[t.Name] = true
+		if _, _, err := tools.ParseHandler(t.Handler); err != nil {
+			return nil, fmt.Errorf("custom tool %s: %w", t.Name, err)
+		}
+		for _, schema := range []map[string]interface{}{t.Schema, t.OutputSchema} {
+			if _, err := tools.CompileSchema(schema); err != nil {
+				return nil, fmt.Errorf("custom tool %s: %w", t.Name, err)
+			}
+		}
+		tool := tools.CustomTool{Name: t.Name, Description: t.Description, Schema: t.Schema, OutputSchema: t.OutputSchema, Handler: t.Handler}
+		if t.Timeout != "" {
+			d, err := time.ParseDuration(t.Timeout)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("custom tool %s: invalid timeout %q", t.Name, t.Timeout)
+			}
+			tool.Timeout = d
+		}
+		out = append(out, tool)
+	}
+	return out, nil
 }
 
 // SecretsConfig configures secret injection.
@@ -254,6 +584,9 @@ func LoadConfig(path string) (*AgentConfig, error) {
 	if err := ValidateConfig(&cfg); err != nil {
 		return nil, err
 	}
+	if cfg.Dir, err = filepath.Abs(filepath.Dir(path)); err != nil {
+		return nil, fmt.Errorf("load agent config: %w", err)
+	}
 	return &cfg, nil
 }
 
@@ -271,12 +604,72 @@ func ValidateConfig(cfg *AgentConfig) error {
 	if cfg.Metadata.Name == "" {
 		return fmt.Errorf("validate agent config: metadata.name is required")
 	}
+	if strings.Contains(cfg.Metadata.Namespace, ".") {
+		return fmt.Errorf("validate agent config: metadata.namespace cannot contain dots")
+	}
 	if cfg.Spec.Model.Provider == "" || cfg.Spec.Model.Name == "" {
 		return fmt.Errorf("validate agent config: spec.model provider and name are required")
 	}
 	if cfg.Spec.Sandbox.Runtime == "" {
 		return fmt.Errorf("validate agent config: spec.sandbox.runtime is required")
 	}
+	for _, m := range cfg.Spec.Capabilities.FS.Mounts {
+		if m.Mode != "" && m.Mode != fs.ModeReadOnly && m.Mode != fs.ModeReadWrite {
+			return fmt.Errorf("validate agent config: fs mount %s mode must be ro or rw", m.Path)
+		}
+		if _, err := fs.ParseQuota(m.Quota); err != nil {
+			return fmt.Errorf("validate agent config: fs mount %s: %w", m.Path, err)
+		}
+		if m.Overlay != nil && m.Source == "" {
+			return fmt.Errorf("validate agent config: fs mount %s: overlay requires a source", m.Path)
+		}
+	}
+	if _, err := fs.ParseQuota(cfg.Spec.Capabilities.Net.MaxResponseSize); err != nil {
+		return fmt.Errorf("validate agent config: net maxResponseSize: %w", err)
+	}
+	if _, err := cfg.Spec.Capabilities.Net.IPPolicy(); err != nil {
+		return fmt.Errorf("validate agent config: net: %w", err)
+	}
+	if c := cfg.Spec.Capabilities.Net.Cassette; c != nil {
+		if c.Path == "" {
+			return fmt.Errorf("validate agent config: net cassette.path is required")
+		}
+		if c.Mode != net.CassetteRecord && c.Mode != net.CassetteReplay {
+			return fmt.Errorf("validate agent config: net cassette.mode must be record or replay")
+		}
+	}
+	if c := cfg.Spec.Capabilities.Net.Cache; c != nil {
+		if c.Backend != "" && c.Backend != "disk" && c.Backend != "memory" {
+			return fmt.Errorf("validate agent config: net cache.backend must be disk or memory")
+		}
+		if _, err := fs.ParseQuota(c.MaxSize); err != nil {
+			return fmt.Errorf("validate agent config: net cache.maxSize: %w", err)
+		}
+	}
+	if _, err := cfg.Spec.Capabilities.Net.Limits(); err != nil {
+		return fmt.Errorf("validate agent config: net %w", err)
+	}
+	if _, err := cfg.Spec.Capabilities.Memory.DefaultTTL(); err != nil {
+		return fmt.Errorf("validate agent config: memory %w", err)
+	}
+	if _, err := cfg.Spec.Capabilities.Memory.Episodes.Retention(); err != nil {
+		return fmt.Errorf("validate agent config: memory episodes %w", err)
+	}
+	if cfg.Spec.Capabilities.Memory.Episodes.TopK < 0 {
+		return fmt.Errorf("validate agent config: memory episodes topK must not be negative")
+	}
+	if _, err := cfg.Spec.Capabilities.Memory.Vector.VectorOptions(); err != nil {
+		return fmt.Errorf("validate agent config: memory vector: %w", err)
+	}
+	if _, err := cfg.Spec.Capabilities.Tools.CustomTools(); err != nil {
+		return fmt.Errorf("validate agent config: tools %w", err)
+	}
+	if _, err := cfg.Spec.Capabilities.Browser.PoolConfig(); err != nil {
+		return fmt.Errorf("validate agent config: browser %w", err)
+	}
+	if f := cfg.Spec.Capabilities.FS.Symlinks.Follow; f != "" && f != fs.SymlinksWithin && f != fs.SymlinksDeny {
+		return fmt.Errorf("validate agent config: fs symlinks.follow must be within or deny")
+	}
 	return nil
 }
 
@@ -349,6 +742,7 @@ func MergeConfig(parent, child *AgentConfig) *AgentConfig {
 	}
 	if hasCapabilitiesConfig(child.Spec.Capabilities) {
 		merged.Spec.Capabilities = child.Spec.Capabilities
+		merged.Dir = child.Dir
 	}
 	if hasResourceConfig(child.Spec.Resources) {
 		merged.Spec.Resources = child.Spec.Resources
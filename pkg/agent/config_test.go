@@ -3,6 +3,9 @@ package agent
 import (
 	"os"
 	"testing"
+
+	"spawn.dev/pkg/capability/fs"
+	"spawn.dev/pkg/sandbox"
 )
 
 func TestLoadConfig(t *testing.T) {
@@ -39,6 +42,27 @@ spec:
     name: claude-sonnet-4-20250514
   sandbox:
     runtime: gvisor
+`,
+			wantErr: true,
+		},
+		{
+			name: "invalid fs mount mode",
+			body: `apiVersion: spawn.dev/v1
+kind: Agent
+metadata:
+  name: tester
+spec:
+  model:
+    provider: anthropic
+    name: claude-sonnet-4-20250514
+  capabilities:
+    fs:
+      enabled: true
+      mounts:
+        - path: /data
+          mode: append
+  sandbox:
+    runtime: gvisor
 `,
 			wantErr: true,
 		},
@@ -48,17 +72,21 @@ spec:
 		tt := tt
 		t.Run(tt.name, func(t *testing.T) {
 			t.Parallel()
-			path := t.TempDir() + "/agent.yaml"
+			dir := t.TempDir()
+			path := dir + "/agent.yaml"
 			if err := os.WriteFile(path, []byte(tt.body), 0o644); err != nil {
 				t.Fatalf("write temp config: %v", err)
 			}
-			_, err := LoadConfig(path)
+			cfg, err := LoadConfig(path)
 			if tt.wantErr && err == nil {
 				t.Fatalf("expected error")
 			}
 			if !tt.wantErr && err != nil {
 				t.Fatalf("unexpected error: %v", err)
 			}
+			if err == nil && cfg.Dir != dir {
+				t.Fatalf("config dir = %q, want %q", cfg.Dir, dir)
+			}
 		})
 	}
 }
@@ -90,3 +118,33 @@ func TestMergeConfig(t *testing.T) {
 		t.Fatalf("expected merged goal")
 	}
 }
+
+func TestSandboxConfigMounts(t *testing.T) {
+	t.Parallel()
+	base, data := t.TempDir(), t.TempDir()
+	cfg := &AgentConfig{}
+	cfg.Spec.Sandbox = SandboxConfig{Runtime: "docker", NetworkPolicy: "none"}
+	cfg.Spec.Capabilities.FS.Mounts = []FSMount{
+		{Path: "/workspace", Mode: fs.ModeReadWrite},
+		{Path: "/data", Source: data},
+	}
+	fsys, err := fs.NewWithMounts(base, cfg.Spec.Capabilities.FS.FSMounts())
+	if err != nil {
+		t.Fatalf("new fs: %v", err)
+	}
+	a := &Agent{Config: cfg}
+	sb := a.SandboxConfig(fsys)
+	if sb.Runtime != sandbox.RuntimeDocker || sb.Network != sandbox.NetworkNone || sb.Seccomp != sandbox.SeccompStrict {
+		t.Fatalf("sandbox config = %+v", sb)
+	}
+	mounts := map[string]sandbox.Mount{}
+	for _, m := range sb.Mounts {
+		mounts[m.Target] = m
+	}
+	if len(mounts) != 2 || mounts["/workspace"].Mode != fs.ModeReadWrite || mounts["/data"].Mode != fs.ModeReadOnly {
+		t.Fatalf("mounts = %+v", sb.Mounts)
+	}
+	if got := a.SandboxConfig(nil).Mounts; len(got) != 0 {
+		t.Fatalf("mounts without an fs capability = %+v", got)
+	}
+}
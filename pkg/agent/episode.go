@@ -0,0 +1,151 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"spawn.dev/pkg/capability/memory"
+	"spawn.dev/pkg/llm"
+)
+
+// Episodes keep the start of long prompts and outputs only.
+const (
+	maxEpisodeTask   = 2000
+	maxEpisodeOutput = 4000
+	maxEpisodeResult = 500
+)
+
+// episodeMemory returns the agent's episodic memory when
+// spec.capabilities.memory.episodes is enabled.
+func episodeMemory(a *Agent) (episodicMemory, bool) {
+	if !a.Config.Spec.Capabilities.Memory.Episodes.Enabled {
+		return nil, false
+	}
+	mem, ok := a.Capabilities["memory"].(episodicMemory)
+	return mem, ok
+}
+
+// recallEpisodes returns the episodes most relevant to task. Memory
+// failures must not fail the task; they are reported as events.
+func (s *Supervisor) recallEpisodes(ctx context.Context, a *Agent, task Task) []memory.Episode {
+	mem, ok := episodeMemory(a)
+	if !ok {
+		return nil
+	}
+	k := a.Config.Spec.Capabilities.Memory.Episodes.TopK
+	if k == 0 {
+		k = DefaultEpisodeTopK
+	}
+	episodes, err := mem.RecallEpisodes(ctx, a.MemoryID(), task.Prompt, k)
+	if err != nil {
+		s.emit("episode_recall_failed", a.ID)
+		return nil
+	}
+	return episodes
+}
+
+// recordEpisode stores a summary of a finished task, written by the
+// agent's model following the configured summary prompt.
+func (s *Supervisor) recordEpisode(ctx context.Context, a *Agent, task Task, result *TaskResult, tokens int64) {
+	mem, ok := episodeMemory(a)
+	if !ok {
+		return
+	}
+	cfg := a.Config.Spec.Capabilities.Memory.Episodes
+	keep, err := cfg.Retention()
+	if err != nil {
+		s.emit("episode_record_failed", a.ID)
+		return
+	}
+	ep := memory.Episode{
+		TaskID:   task.ID,
+		Task:     clip(task.Prompt, maxEpisodeTask),
+		Outcome:  memory.OutcomeSuccess,
+		Error:    result.Error,
+		Tools:    result.Tools,
+		CostUSD:  result.CostUSD,
+		Tokens:   tokens,
+		Duration: result.Duration,
+	}
+	if result.Error != "" {
+		ep.Outcome = memory.OutcomeFailure
+	}
+	ep.Summary = s.summarize(ctx, a, cfg.SummaryPrompt, ep, result.Output)
+	if err := mem.RecordEpisode(ctx, a.MemoryID(), ep, keep); err != nil {
+		s.emit("episode_record_failed", a.ID)
+	}
+}
+
+// summarize asks the agent's model to summarise a task. A failed task, whose
+// model call may be what failed, and one without a model answer are
+// summarised by the start of their error or output.
+func (s *Supervisor) summarize(ctx context.Context, a *Agent, instructions string, ep memory.Episode, output string) string {
+	if ep.Error != "" {
+		return "Failed: " + clip(ep.Error, maxEpisodeResult)
+	}
+	if instructions == "" {
+		instructions = DefaultEpisodeSummaryPrompt
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n\nTask:\n%s\n\nOutcome: %s\n", instructions, ep.Task, ep.Outcome)
+	if len(ep.Tools) > 0 {
+		fmt.Fprintf(&b, "Tools used: %s\n", strings.Join(ep.Tools, ", "))
+	}
+	if output != "" {
+		fmt.Fprintf(&b, "\nOutput:\n%s\n", clip(output, maxEpisodeOutput))
+	}
+	req := &llm.ChatRequest{
+		Model:    a.Config.Spec.Model.Name,
+		Messages: []llm.Message{{Role: "user", Content: b.String()}},
+	}
+	resp, err := a.LLM.Chat(ctx, req)
+	if err == nil && strings.TrimSpace(resp.Content) != "" {
+		a.CostUSD += a.LLM.EstimateCost(req)
+		if resp.Usage != nil {
+			a.TokensUsed += int64(resp.Usage.InputTokens + resp.Usage.OutputTokens)
+		}
+		return strings.TrimSpace(resp.Content)
+	}
+	return clip(output, maxEpisodeResult)
+}
+
+// episodePrompt introduces recalled episodes ahead of a task's prompt.
+func episodePrompt(episodes []memory.Episode) string {
+	var b strings.Builder
+	b.WriteString("Summaries of your past tasks that may be relevant:\n")
+	for _, ep := range episodes {
+		fmt.Fprintf(&b, "- [%s, %s] %s", ep.CreatedAt.Format("2006-01-02"), ep.Outcome, ep.Summary)
+		if len(ep.Tools) > 0 {
+			fmt.Fprintf(&b, " (tools: %s)", strings.Join(ep.Tools, ", "))
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("\nCurrent task:\n")
+	return b.String()
+}
+
+// toolNames lists the distinct tools called, in call order.
+func toolNames(calls []llm.ToolCall) []string {
+	var names []string
+	seen := map[string]bool{}
+	for _, call := range calls {
+		if !seen[call.Name] {
+			seen[call.Name] = true
+			names = append(names, call.Name)
+		}
+	}
+	return names
+}
+
+// clip shortens s to at most n bytes without splitting a rune.
+func clip(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n] + "…"
+}
@@ -0,0 +1,157 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"spawn.dev/pkg/capability"
+	"spawn.dev/pkg/capability/memory"
+	"spawn.dev/pkg/llm"
+)
+
+// scriptedProvider answers summary prompts with a fixed summary, other
+// prompts with "done", and records the prompts it was sent.
+type scriptedProvider struct {
+	prompts []string
+}
+
+func (p *scriptedProvider) Name() string     { return "scripted" }
+func (p *scriptedProvider) Models() []string { return nil }
+func (p *scriptedProvider) Chat(_ context.Context, req *llm.ChatRequest) (*llm.ChatResponse, error) {
+	prompt := req.Messages[0].Content
+	p.prompts = append(p.prompts, prompt)
+	if strings.HasPrefix(prompt, DefaultEpisodeSummaryPrompt) {
+		return &llm.ChatResponse{Content: "Migrated the billing database."}, nil
+	}
+	return &llm.ChatResponse{
+		Content:   "done",
+		ToolCalls: []llm.ToolCall{{Name: "exec"}, {Name: "fs"}, {Name: "exec"}},
+		Usage:     &llm.Usage{InputTokens: 10, OutputTokens: 5},
+	}, nil
+}
+func (p *scriptedProvider) ChatStream(context.Context, *llm.ChatRequest) (<-chan *llm.StreamChunk, error) {
+	return nil, nil
+}
+func (p *scriptedProvider) ChatWithTools(ctx context.Context, req *llm.ChatRequest, _ []llm.Tool) (*llm.ChatResponse, error) {
+	return p.Chat(ctx, req)
+}
+func (p *scriptedProvider) Embed(context.Context, []string) ([][]float32, error) { return nil, nil }
+func (p *scriptedProvider) EstimateCost(*llm.ChatRequest) float64                { return 0.01 }
+func (p *scriptedProvider) HealthCheck(context.Context) error                    { return nil }
+
+func TestExecuteEpisodes(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	mem, err := memory.New(t.TempDir() + "/memory.db")
+	if err != nil {
+		t.Fatalf("new memory: %v", err)
+	}
+	defer mem.Shutdown(ctx)
+
+	cfg := &AgentConfig{APIVersion: "spawn.dev/v1", Kind: "Agent", Metadata: Metadata{Name: "billing"}}
+	cfg.Spec.Model = ModelConfig{Provider: "scripted", Name: "test"}
+	cfg.Spec.Sandbox.Runtime = "gvisor"
+	cfg.Spec.Capabilities.Memory.Episodes = EpisodesConfig{Enabled: true}
+	s := NewSupervisor()
+	a, err := s.Create(ctx, cfg)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	provider := &scriptedProvider{}
+	a.LLM = provider
+	a.Capabilities = map[string]capability.Capability{"memory": mem}
+
+	result, err := s.Execute(ctx, a.ID, Task{ID: "t1", Prompt: "migrate the billing database"})
+	if err != nil || result.Error != "" {
+		t.Fatalf("execute = %+v, %v", result, err)
+	}
+	if len(result.Tools) != 2 || result.Tools[0] != "exec" || result.CostUSD != 0.01 {
+		t.Fatalf("result = %+v", result)
+	}
+	episodes, err := mem.RecallEpisodes(ctx, a.MemoryID(), "", 5)
+	if err != nil || len(episodes) != 1 {
+		t.Fatalf("episodes = %+v, %v", episodes, err)
+	}
+	if ep := episodes[0]; ep.Summary != "Migrated the billing database." || ep.TaskID != "t1" || ep.Outcome != memory.OutcomeSuccess || ep.Tokens != 15 {
+		t.Fatalf("episode = %+v", ep)
+	}
+
+	if _, err := s.Execute(ctx, a.ID, Task{ID: "t2", Prompt: "check the billing database backups"}); err != nil {
+		t.Fatalf("execute: %v", err)
+	}
+	prompt := provider.prompts[2]
+	if !strings.Contains(prompt, "Migrated the billing database.") || !strings.HasSuffix(prompt, "check the billing database backups") {
+		t.Fatalf("second prompt = %q", prompt)
+	}
+}
+
+// failingProvider fails every chat and counts the calls.
+type failingProvider struct {
+	scriptedProvider
+	calls int
+}
+
+func (p *failingProvider) Chat(context.Context, *llm.ChatRequest) (*llm.ChatResponse, error) {
+	p.calls++
+	return nil, errors.New("model unavailable")
+}
+
+func TestFailedTaskEpisodeSkipsModel(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	mem, err := memory.New(t.TempDir() + "/memory.db")
+	if err != nil {
+		t.Fatalf("new memory: %v", err)
+	}
+	defer mem.Shutdown(ctx)
+	cfg := &AgentConfig{APIVersion: "spawn.dev/v1", Kind: "Agent", Metadata: Metadata{Name: "billing"}}
+	cfg.Spec.Model = ModelConfig{Provider: "scripted", Name: "test"}
+	cfg.Spec.Sandbox.Runtime = "gvisor"
+	cfg.Spec.Capabilities.Memory.Episodes = EpisodesConfig{Enabled: true}
+	s := NewSupervisor()
+	a, err := s.Create(ctx, cfg)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	provider := &failingProvider{}
+	a.LLM = provider
+	a.Capabilities = map[string]capability.Capability{"memory": mem}
+
+	result, err := s.Execute(ctx, a.ID, Task{ID: "t1", Prompt: "migrate the billing database"})
+	if err != nil || result.Error == "" {
+		t.Fatalf("execute = %+v, %v", result, err)
+	}
+	if provider.calls != 1 {
+		t.Fatalf("model called %d times, want only for the task", provider.calls)
+	}
+	episodes, err := mem.RecallEpisodes(ctx, a.MemoryID(), "", 5)
+	if err != nil || len(episodes) != 1 || episodes[0].Summary != "Failed: model unavailable" || episodes[0].Outcome != memory.OutcomeFailure {
+		t.Fatalf("episodes = %+v, %v", episodes, err)
+	}
+}
+
+func TestCallScopesMemoryByAgent(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	mem, err := memory.New(t.TempDir() + "/memory.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mem.Shutdown(ctx)
+	alice := &Agent{Name: "alice", Capabilities: map[string]capability.Capability{"memory": mem}}
+	bob := &Agent{Name: "bob", Capabilities: map[string]capability.Capability{"memory": mem}}
+	if resp, err := alice.Call(ctx, "memory", "kv_set", map[string]interface{}{"key": "k", "value": "alice"}); err != nil || !resp.Success {
+		t.Fatalf("kv_set = %+v, %v", resp, err)
+	}
+	if resp, err := bob.Call(ctx, "memory", "kv_get", map[string]interface{}{"key": "k"}); err != nil || resp.Data != "" {
+		t.Fatalf("bob read %+v, %v", resp, err)
+	}
+	if resp, err := alice.Call(ctx, "memory", "kv_get", map[string]interface{}{"key": "k"}); err != nil || resp.Data != "alice" {
+		t.Fatalf("alice read %+v, %v", resp, err)
+	}
+	if _, err := alice.Call(ctx, "fs", "read", nil); err == nil {
+		t.Fatal("expected an error calling a missing capability")
+	}
+}
@@ -8,9 +8,51 @@ import (
 
 	"github.com/google/uuid"
 	"spawn.dev/pkg/capability"
+	"spawn.dev/pkg/capability/fs"
+	"spawn.dev/pkg/capability/memory"
+	"spawn.dev/pkg/capability/net"
+	"spawn.dev/pkg/capability/tools"
 	"spawn.dev/pkg/llm"
+	"spawn.dev/pkg/sandbox"
 )
 
+const defaultSnapshotRetain = 5
+
+// episodicMemory is implemented by memory capabilities that keep task
+// episodes.
+type episodicMemory interface {
+	RecordEpisode(ctx context.Context, agent string, ep memory.Episode, keep memory.EpisodeRetention) error
+	RecallEpisodes(ctx context.Context, agent, query string, k int) ([]memory.Episode, error)
+}
+
+// customToolRegistrar is implemented by tool capabilities that can run the
+// handlers of tools declared in spec.capabilities.tools.custom.
+type customToolRegistrar interface {
+	SetSandbox(sb sandbox.Sandbox)
+	SetToolDir(dir string)
+	RegisterCustom(tool tools.CustomTool) error
+}
+
+// memoryConfigurer is implemented by memory capabilities configured from
+// spec.capabilities.memory. One capability may serve many agents, so the
+// settings are kept per agent.
+type memoryConfigurer interface {
+	Configure(agent string, s memory.Settings)
+}
+
+// envSetter is implemented by capabilities that run commands, such as
+// exec, and can add variables to their environment.
+type envSetter interface {
+	SetEnv(env map[string]string)
+}
+
+// workspaceSnapshotter is implemented by filesystem capabilities that can
+// snapshot the agent workspace before a task runs.
+type workspaceSnapshotter interface {
+	Snapshot(ctx context.Context, label string) (*fs.Snapshot, error)
+	PruneSnapshots(keep int) error
+}
+
 // Supervisor is an in-memory manager implementation.
 type Supervisor struct {
 	mu     sync.RWMutex
@@ -41,6 +83,9 @@ func (s *Supervisor) Create(_ context.Context, config *AgentConfig) (*Agent, err
 		Inbox:        make(chan Message, 32),
 		Outbox:       make(chan Message, 32),
 	}
+	if err := startEgressProxy(a); err != nil {
+		return nil, err
+	}
 	s.mu.Lock()
 	s.agents[a.ID] = a
 	s.mu.Unlock()
@@ -48,12 +93,22 @@ func (s *Supervisor) Create(_ context.Context, config *AgentConfig) (*Agent, err
 	return a, nil
 }
 
-// Start marks an agent running.
+// Start applies the agent's memory config, points its exec capability at
+// its egress proxy, registers its custom tools and marks it running.
 func (s *Supervisor) Start(_ context.Context, id string) error {
 	a, err := s.Get(context.Background(), id)
 	if err != nil {
 		return err
 	}
+	if err := configureMemory(a); err != nil {
+		return err
+	}
+	if env, ok := a.Capabilities["exec"].(envSetter); ok && a.EgressProxy != nil {
+		env.SetEnv(sandbox.ProxyEnv(a.EgressProxy.URL()))
+	}
+	if err := registerCustomTools(a); err != nil {
+		return err
+	}
 	a.State = StateRunning
 	a.StartedAt = time.Now().UTC()
 	s.emit("started", id)
@@ -82,13 +137,19 @@ func (s *Supervisor) Restart(ctx context.Context, id string) error {
 	return s.Start(ctx, id)
 }
 
-// Delete deletes an agent.
+// Delete deletes an agent and stops its egress proxy.
 func (s *Supervisor) Delete(_ context.Context, id string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	if _, ok := s.agents[id]; !ok {
+	a, ok := s.agents[id]
+	if !ok {
 		return fmt.Errorf("delete agent: %s not found", id)
 	}
+	if a.EgressProxy != nil {
+		if err := a.EgressProxy.Close(); err != nil {
+			return fmt.Errorf("delete agent: %w", err)
+		}
+	}
 	delete(s.agents, id)
 	s.emit("deleted", id)
 	return nil
@@ -141,29 +202,143 @@ func (s *Supervisor) Execute(ctx context.Context, id string, task Task) (*TaskRe
 	}
 	start := time.Now()
 	result := &TaskResult{TaskID: task.ID}
+	if err := s.snapshotWorkspace(ctx, a, task, result); err != nil {
+		result.Error = err.Error()
+		result.Duration = time.Since(start)
+		return result, nil
+	}
 	if a.LLM == nil {
 		result.Error = "no llm provider configured"
 		result.Duration = time.Since(start)
 		return result, nil
 	}
-	resp, err := a.LLM.Chat(ctx, &llm.ChatRequest{
+	prompt := task.Prompt
+	if episodes := s.recallEpisodes(ctx, a, task); len(episodes) > 0 {
+		prompt = episodePrompt(episodes) + prompt
+	}
+	req := &llm.ChatRequest{
 		Model:    a.Config.Spec.Model.Name,
-		Messages: []llm.Message{{Role: "user", Content: task.Prompt}},
-	})
+		Messages: []llm.Message{{Role: "user", Content: prompt}},
+	}
+	resp, err := a.LLM.Chat(ctx, req)
 	if err != nil {
 		result.Error = err.Error()
 		result.Duration = time.Since(start)
+		s.recordEpisode(ctx, a, task, result, 0)
 		return result, nil
 	}
 	result.Output = resp.Content
+	result.Tools = toolNames(resp.ToolCalls)
+	result.CostUSD = a.LLM.EstimateCost(req)
 	result.Duration = time.Since(start)
 	a.TasksRun++
+	a.CostUSD += result.CostUSD
+	var tokens int64
 	if resp.Usage != nil {
-		a.TokensUsed += int64(resp.Usage.InputTokens + resp.Usage.OutputTokens)
+		tokens = int64(resp.Usage.InputTokens + resp.Usage.OutputTokens)
+		a.TokensUsed += tokens
 	}
+	s.recordEpisode(ctx, a, task, result, tokens)
 	return result, nil
 }
 
+// startEgressProxy starts the agent's egress proxy when
+// spec.capabilities.net.egressProxy is enabled.
+func startEgressProxy(a *Agent) error {
+	cfg := a.Config.Spec.Capabilities.Net
+	if cfg.EgressProxy == nil || !cfg.EgressProxy.Enabled {
+		return nil
+	}
+	proxyCfg, err := cfg.ProxyConfig(a.ID)
+	if err != nil {
+		return fmt.Errorf("create agent: egress proxy: %w", err)
+	}
+	proxy := net.NewProxy(proxyCfg)
+	if err := proxy.Start(); err != nil {
+		return fmt.Errorf("create agent: %w", err)
+	}
+	a.EgressProxy = proxy
+	return nil
+}
+
+// configureMemory applies the agent's memory config to its requests to its
+// memory capability.
+func configureMemory(a *Agent) error {
+	mem, ok := a.Capabilities["memory"].(memoryConfigurer)
+	if !ok {
+		return nil
+	}
+	cfg := a.Config.Spec.Capabilities.Memory
+	vectors, err := cfg.Vector.VectorOptions()
+	if err != nil {
+		return fmt.Errorf("start agent: memory vector: %w", err)
+	}
+	ttl, err := cfg.DefaultTTL()
+	if err != nil {
+		return fmt.Errorf("start agent: memory: %w", err)
+	}
+	settings := memory.Settings{
+		Vector: vectors,
+		Graph:  cfg.Graph.Enabled,
+		TTL:    ttl,
+		Shared: cfg.Shared,
+	}
+	if a.LLM != nil {
+		settings.Embedder, settings.Embed = a.LLM, cfg.Embedding.EmbedOptions()
+	}
+	mem.Configure(a.MemoryID(), settings)
+	return nil
+}
+
+// registerCustomTools loads the agent's custom tools into its tools
+// capability, running them in the agent's sandbox with relative handlers
+// resolved against its config directory. Registering again on restart
+// replaces them.
+func registerCustomTools(a *Agent) error {
+	registrar, ok := a.Capabilities["tools"].(customToolRegistrar)
+	if !ok {
+		return nil
+	}
+	custom, err := a.Config.Spec.Capabilities.Tools.CustomTools()
+	if err != nil {
+		return fmt.Errorf("start agent: tools %w", err)
+	}
+	registrar.SetSandbox(a.Sandbox)
+	registrar.SetToolDir(a.Config.Dir)
+	for _, tool := range custom {
+		if err := registrar.RegisterCustom(tool); err != nil {
+			return fmt.Errorf("start agent: %w", err)
+		}
+	}
+	return nil
+}
+
+// snapshotWorkspace snapshots the agent's fs capability before a task when
+// spec.capabilities.fs.snapshot is enabled, so a bad run can be restored.
+func (s *Supervisor) snapshotWorkspace(ctx context.Context, a *Agent, task Task, result *TaskResult) error {
+	cfg := a.Config.Spec.Capabilities.FS.Snapshot
+	if !cfg.Enabled {
+		return nil
+	}
+	snapper, ok := a.Capabilities["fs"].(workspaceSnapshotter)
+	if !ok {
+		return nil
+	}
+	snap, err := snapper.Snapshot(ctx, "task:"+task.ID)
+	if err != nil {
+		return fmt.Errorf("pre-task snapshot: %w", err)
+	}
+	result.SnapshotID = snap.ID
+	retain := cfg.Retain
+	if retain <= 0 {
+		retain = defaultSnapshotRetain
+	}
+	if err := snapper.PruneSnapshots(retain); err != nil {
+		return fmt.Errorf("prune snapshots: %w", err)
+	}
+	return nil
+}
+
 // Logs streams synthetic logs for now.
 func (s *Supervisor) Logs(_ context.Context, id string, _ LogOptions) (<-chan LogEntry, error) {
 	_, err := s.Get(context.Background(), id)
@@ -0,0 +1,218 @@
+package agent
+
+import (
+	"context"
+	stdnet "net"
+	"strings"
+	"testing"
+
+	"spawn.dev/pkg/capability"
+	"spawn.dev/pkg/capability/memory"
+	"spawn.dev/pkg/llm"
+)
+
+// embeddingProvider embeds text as its counts of "a" and "b".
+type embeddingProvider struct {
+	scriptedProvider
+}
+
+func (p *embeddingProvider) Embed(_ context.Context, input []string) ([][]float32, error) {
+	vecs := make([][]float32, len(input))
+	for i, text := range input {
+		vecs[i] = []float32{float32(strings.Count(text, "a")), float32(strings.Count(text, "b"))}
+	}
+	return vecs, nil
+}
+
+// startWithMemory creates and starts an agent from cfg with mem as its
+// memory capability and provider as its model.
+func startWithMemory(t *testing.T, cfg *AgentConfig, mem *memory.Capability, provider llm.Provider) *Agent {
+	t.Helper()
+	ctx := context.Background()
+	cfg.APIVersion, cfg.Kind = "spawn.dev/v1", "Agent"
+	cfg.Spec.Model = ModelConfig{Provider: "scripted", Name: "test"}
+	cfg.Spec.Sandbox.Runtime = "gvisor"
+	s := NewSupervisor()
+	a, err := s.Create(ctx, cfg)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	a.LLM = provider
+	a.Capabilities = map[string]capability.Capability{"memory": mem}
+	if err := s.Start(ctx, a.ID); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	return a
+}
+
+func TestStartConfiguresMemory(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	mem, err := memory.New(t.TempDir() + "/memory.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mem.Shutdown(ctx)
+	cfg := &AgentConfig{Metadata: Metadata{Name: "planner"}}
+	cfg.Spec.Capabilities.Memory.Vector = VectorConfig{Dimensions: 3, Metric: memory.MetricDot}
+	a := startWithMemory(t, cfg, mem, nil)
+
+	node := map[string]interface{}{"id": "n"}
+	if resp, err := a.Call(ctx, "memory", "graph_put_node", node); err != nil || resp.Success || resp.Error.Code != "graph_disabled" {
+		t.Fatalf("graph_put_node with the graph disabled = %+v, %v", resp, err)
+	}
+
+	put := map[string]interface{}{"key": "a", "vector": []interface{}{1.0, 0.0}}
+	if resp, err := a.Call(ctx, "memory", "vector_put", put); err != nil || resp.Success {
+		t.Fatalf("2-dim vector into a 3-dim store = %+v, %v", resp, err)
+	}
+	// Dot product scores are not normalised.
+	put["vector"] = []interface{}{2.0, 0.0, 0.0}
+	if resp, err := a.Call(ctx, "memory", "vector_put", put); err != nil || !resp.Success {
+		t.Fatalf("vector_put = %+v, %v", resp, err)
+	}
+	resp, err := a.Call(ctx, "memory", "vector_search", map[string]interface{}{"vector": []interface{}{2.0, 0.0, 0.0}})
+	if err != nil || !resp.Success {
+		t.Fatalf("vector_search = %+v, %v", resp, err)
+	}
+	if matches, _ := resp.Data.([]capability.VectorMatch); len(matches) != 1 || matches[0].Score != 4 {
+		t.Fatalf("matches = %+v", resp.Data)
+	}
+}
+
+func TestStartSetsEmbedder(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	mem, err := memory.New(t.TempDir() + "/memory.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mem.Shutdown(ctx)
+	a := startWithMemory(t, &AgentConfig{Metadata: Metadata{Name: "notes"}}, mem, &embeddingProvider{})
+
+	for key, text := range map[string]string{"as": "aaaa", "bs": "bbbb"} {
+		if resp, err := a.Call(ctx, "memory", "remember", map[string]interface{}{"key": key, "text": text}); err != nil || !resp.Success {
+			t.Fatalf("remember %s = %+v, %v", key, resp, err)
+		}
+	}
+	resp, err := a.Call(ctx, "memory", "recall", map[string]interface{}{"query": "bb", "limit": 1})
+	if err != nil || !resp.Success {
+		t.Fatalf("recall = %+v, %v", resp, err)
+	}
+	if matches, _ := resp.Data.([]capability.VectorMatch); len(matches) != 1 || matches[0].Text != "bbbb" {
+		t.Fatalf("matches = %+v", resp.Data)
+	}
+}
+
+func TestStartSharesNamespaces(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	mem, err := memory.New(t.TempDir() + "/memory.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mem.Shutdown(ctx)
+	agents := map[string]*Agent{}
+	for _, name := range []string{"writer", "reader"} {
+		cfg := &AgentConfig{Metadata: Metadata{Name: name}}
+		cfg.Spec.Capabilities.Memory.Shared = []string{"team"}
+		cfg.Spec.Capabilities.Memory.TTL = "7d"
+		agents[name] = startWithMemory(t, cfg, mem, nil)
+	}
+	for _, ns := range []string{"team", "private"} {
+		set := map[string]interface{}{"namespace": ns, "key": "k", "value": ns}
+		if resp, err := agents["writer"].Call(ctx, "memory", "kv_set", set); err != nil || !resp.Success {
+			t.Fatalf("kv_set in %s = %+v, %v", ns, resp, err)
+		}
+	}
+	get := map[string]interface{}{"namespace": "team", "key": "k"}
+	if resp, err := agents["reader"].Call(ctx, "memory", "kv_get", get); err != nil || resp.Data != "team" {
+		t.Fatalf("shared read = %+v, %v", resp, err)
+	}
+	get["namespace"] = "private"
+	if resp, err := agents["reader"].Call(ctx, "memory", "kv_get", get); err != nil || resp.Data != "" {
+		t.Fatalf("private read = %+v, %v", resp, err)
+	}
+}
+
+func TestCreateStartsEgressProxy(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	cfg := &AgentConfig{APIVersion: "spawn.dev/v1", Kind: "Agent", Metadata: Metadata{Name: "fetcher"}}
+	cfg.Spec.Model = ModelConfig{Provider: "scripted", Name: "test"}
+	cfg.Spec.Sandbox.Runtime = "gvisor"
+	cfg.Spec.Capabilities.Net.EgressProxy = &Egress{Enabled: true, Listen: "127.0.0.1:0"}
+	s := NewSupervisor()
+	a, err := s.Create(ctx, cfg)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if a.EgressProxy == nil {
+		t.Fatal("egress proxy not started")
+	}
+	addr := a.EgressProxy.Addr()
+	if got := a.SandboxConfig(nil).EgressProxy; got != "http://"+addr {
+		t.Fatalf("sandbox egress proxy = %q, want http://%s", got, addr)
+	}
+	conn, err := stdnet.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial proxy: %v", err)
+	}
+	conn.Close()
+	if err := s.Delete(ctx, a.ID); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if conn, err := stdnet.Dial("tcp", addr); err == nil {
+		conn.Close()
+		t.Fatal("egress proxy still listening after delete")
+	}
+}
+
+func TestStartConfiguresMemoryPerAgent(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	mem, err := memory.New(t.TempDir() + "/memory.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mem.Shutdown(ctx)
+	withModel := startWithMemory(t, &AgentConfig{Metadata: Metadata{Name: "with-model"}}, mem, &embeddingProvider{})
+	cfg := &AgentConfig{Metadata: Metadata{Name: "without-model"}}
+	cfg.Spec.Capabilities.Memory.Shared = []string{"team"}
+	withoutModel := startWithMemory(t, cfg, mem, nil)
+
+	remember := map[string]interface{}{"key": "k", "text": "aaaa"}
+	if resp, err := withModel.Call(ctx, "memory", "remember", remember); err != nil || !resp.Success {
+		t.Fatalf("remember with an embedder = %+v, %v", resp, err)
+	}
+	if resp, err := withoutModel.Call(ctx, "memory", "remember", remember); err != nil || resp.Success || resp.Error.Code != "no_embedder" {
+		t.Fatalf("remember without an embedder = %+v, %v", resp, err)
+	}
+	set := map[string]interface{}{"namespace": "team", "key": "k", "value": "v"}
+	if resp, err := withoutModel.Call(ctx, "memory", "kv_set", set); err != nil || !resp.Success {
+		t.Fatalf("kv_set = %+v, %v", resp, err)
+	}
+	get := map[string]interface{}{"namespace": "team", "key": "k"}
+	if resp, err := withModel.Call(ctx, "memory", "kv_get", get); err != nil || resp.Data != "" {
+		t.Fatalf("namespace shared by the other agent only = %+v, %v", resp, err)
+	}
+}
+
+func TestMemoryIsPerNamespace(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	mem, err := memory.New(t.TempDir() + "/memory.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mem.Shutdown(ctx)
+	prod := startWithMemory(t, &AgentConfig{Metadata: Metadata{Name: "worker", Namespace: "prod"}}, mem, nil)
+	dev := startWithMemory(t, &AgentConfig{Metadata: Metadata{Name: "worker", Namespace: "dev"}}, mem, nil)
+
+	if resp, err := prod.Call(ctx, "memory", "kv_set", map[string]interface{}{"key": "k", "value": "prod"}); err != nil || !resp.Success {
+		t.Fatalf("kv_set = %+v, %v", resp, err)
+	}
+	if resp, err := dev.Call(ctx, "memory", "kv_get", map[string]interface{}{"key": "k"}); err != nil || resp.Data != "" {
+		t.Fatalf("same-named agent in another namespace read %+v, %v", resp, err)
+	}
+}
@@ -2,33 +2,110 @@ package browser
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"spawn.dev/pkg/capability"
+	"spawn.dev/pkg/capability/net"
 )
 
-// Capability provides browser automation primitives.
-type Capability struct{}
+// Capability provides browser automation primitives. Pages are fetched
+// through the agent's net policy and parsed without rendering; each agent
+// has named sessions of tabs sharing a cookie jar, drawn from one pool.
+type Capability struct {
+	fetcher Fetcher
+	maxBody int64
+	pool    *pool
+}
+
+// New returns a browser capability with an unlimited session pool.
+func New() *Capability {
+	return &Capability{maxBody: defaultMaxBody, pool: newPool(PoolConfig{})}
+}
+
+// SetNet routes all page loads through f, normally the agent's net
+// capability, so its allow/deny lists, IP policy and rate limits apply.
+func (c *Capability) SetNet(f Fetcher) {
+	c.fetcher = f
+}
+
+// SetPool replaces the session pool. Call it before the first request;
+// sessions already open are dropped.
+func (c *Capability) SetPool(cfg PoolConfig) {
+	c.pool = newPool(cfg)
+}
+
+// ExportSession returns the state of an agent's session, or nil if it is
+// not open.
+func (c *Capability) ExportSession(agentID, name string) *SessionState {
+	key := sessionKey{agentID, name}
+	c.pool.mu.Lock()
+	s := c.pool.sessions[key]
+	c.pool.mu.Unlock()
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.export()
+}
+
+// ImportSession opens, or replaces the contents of, an agent's session.
+func (c *Capability) ImportSession(agentID, name string, state *SessionState) error {
+	s, err := c.pool.acquire(sessionKey{agentID, name})
+	if err != nil {
+		return err
+	}
+	defer c.pool.release(s)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.restore(state)
+}
+
+func (c *Capability) Name() string                      { return "browser" }
+func (c *Capability) Version() string                   { return "v1" }
+func (c *Capability) Description() string               { return "Browser automation and capture" }
+func (c *Capability) HealthCheck(context.Context) error { return nil }
 
-// New returns a browser capability.
-func New() *Capability { return &Capability{} }
+// Initialize starts idle session eviction.
+func (c *Capability) Initialize(context.Context, map[string]interface{}) error {
+	c.pool.start()
+	return nil
+}
 
-func (c *Capability) Name() string                                             { return "browser" }
-func (c *Capability) Version() string                                          { return "v1" }
-func (c *Capability) Description() string                                      { return "Browser automation and capture" }
-func (c *Capability) Initialize(context.Context, map[string]interface{}) error { return nil }
-func (c *Capability) Shutdown(context.Context) error                           { return nil }
-func (c *Capability) HealthCheck(context.Context) error                        { return nil }
+// Shutdown stops eviction and saves open sessions to PoolConfig.StateDir.
+func (c *Capability) Shutdown(context.Context) error {
+	return c.pool.close()
+}
 
 func (c *Capability) Schema() *capability.Schema {
-	return &capability.Schema{Actions: []capability.Action{{Name: "screenshot"}, {Name: "record"}}}
+	return &capability.Schema{Actions: []capability.Action{
+		{Name: "open", Description: "Load a URL in a tab (default main); url may be relative to the current page, form submits fields with method"},
+		{Name: "back", Description: "Go back one page in a tab's history"},
+		{Name: "history", Description: "List a tab's history"},
+		{Name: "extract", Description: "Query the current page with a CSS selector; returns text, and attr or html on request"},
+		{Name: "readable", Description: "Main text of the page with title, links and metadata"},
+		{Name: "links", Description: "Absolute links on the page, optionally within selector"},
+		{Name: "forms", Description: "Forms with their action, method and fields"},
+		{Name: "close", Description: "Close a tab"},
+		{Name: "viewport", Description: "Set the session's viewport width and height; without them, return it"},
+		{Name: "sessions", Description: "List the agent's open sessions"},
+		{Name: "export_session", Description: "Return the session's cookies, viewport and tab history"},
+		{Name: "import_session", Description: "Replace the session's contents with an exported state"},
+		{Name: "close_session", Description: "Close the session and delete its saved state"},
+		{Name: "screenshot"},
+		{Name: "record"},
+	}}
 }
 
-func (c *Capability) Execute(_ context.Context, req *capability.Request) (*capability.Response, error) {
+func (c *Capability) Execute(ctx context.Context, req *capability.Request) (*capability.Response, error) {
 	if req == nil {
-		return &capability.Response{Success: false, Error: &capability.Error{Code: "invalid_request", Message: "nil request"}}, nil
+		return capability.Failure("invalid_request", "nil request"), nil
 	}
 	switch req.Action {
 	case "screenshot":
@@ -38,12 +115,213 @@ func (c *Capability) Execute(_ context.Context, req *capability.Request) (*capab
 		}
 		content := []byte("screenshot capture is not enabled in this runtime; configure browser backend to capture pixels")
 		if err := os.WriteFile(path, content, 0o644); err != nil {
-			return &capability.Response{Success: false, Error: &capability.Error{Code: "write_failed", Message: err.Error()}}, nil
+			return capability.Failure("write_failed", err.Error()), nil
 		}
 		return &capability.Response{Success: true, Data: map[string]interface{}{"path": path, "bytes": len(content)}}, nil
 	case "record":
 		return &capability.Response{Success: true, Data: map[string]interface{}{"status": "recording-started"}}, nil
+	case "open", "back", "history", "extract", "readable", "links", "forms", "close",
+		"viewport", "export_session", "import_session":
+	case "sessions":
+		return &capability.Response{Success: true, Data: c.pool.list(agentID(req))}, nil
+	case "close_session":
+		if err := c.pool.remove(sessionKey{agentID(req), capability.StringParam(req.Params, "session", defaultSession)}); err != nil {
+			return capability.Failure("close_failed", err.Error()), nil
+		}
+		return &capability.Response{Success: true}, nil
+	default:
+		return capability.Failure("invalid_action", fmt.Sprintf("unsupported action: %s", req.Action)), nil
+	}
+
+	s, err := c.pool.acquire(sessionKey{agentID(req), capability.StringParam(req.Params, "session", defaultSession)})
+	if err != nil {
+		if errors.Is(err, ErrPoolExhausted) {
+			return capability.Failure("pool_exhausted", err.Error()), nil
+		}
+		return capability.Failure("session_failed", err.Error()), nil
+	}
+	defer c.pool.release(s)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name := capability.StringParam(req.Params, "tab", defaultTab)
+	switch req.Action {
+	case "viewport":
+		width, height := capability.IntParam(req.Params, "width", 0), capability.IntParam(req.Params, "height", 0)
+		if width < 0 || height < 0 || (width == 0) != (height == 0) {
+			return capability.Failure("invalid_params", "width and height must both be positive"), nil
+		}
+		if width > 0 {
+			s.viewport = Viewport{Width: width, Height: height}
+		}
+		return &capability.Response{Success: true, Data: s.viewport}, nil
+	case "export_session":
+		return &capability.Response{Success: true, Data: s.export()}, nil
+	case "import_session":
+		state, err := decodeState(req.Params["state"])
+		if err != nil {
+			return capability.Failure("invalid_params", err.Error()), nil
+		}
+		if err := s.restore(state); err != nil {
+			return capability.Failure("invalid_params", err.Error()), nil
+		}
+		return &capability.Response{Success: true}, nil
+	}
+	t := s.tab(name)
+	switch req.Action {
+	case "open":
+		return c.open(ctx, s, t, name, req.Params), nil
+	case "back":
+		if t.index < 1 {
+			return capability.Failure("no_history", "no previous page in tab "+name), nil
+		}
+		page, err := c.navigate(ctx, s, t, "", t.history[t.index-1], nil, false)
+		if err != nil {
+			return fetchFailure(err), nil
+		}
+		t.index--
+		return pageResponse(name, t, s.viewport, page), nil
+	case "history":
+		return &capability.Response{Success: true, Data: map[string]interface{}{"tab": name, "history": append([]string{}, t.history...), "index": t.index}}, nil
+	case "close":
+		delete(s.tabs, name)
+		return &capability.Response{Success: true}, nil
+	}
+
+	page, err := c.current(ctx, s, t)
+	if errors.Is(err, errNoPage) {
+		return capability.Failure("no_page", fmt.Sprintf("%v: %s", err, name)), nil
+	}
+	if err != nil {
+		return fetchFailure(err), nil
+	}
+	switch req.Action {
+	case "extract":
+		return extract(page, req.Params), nil
+	case "readable":
+		return &capability.Response{Success: true, Data: page.Readable()}, nil
+	case "links":
+		root := page.Doc
+		if sel := capability.StringParam(req.Params, "selector", ""); sel != "" {
+			compiled, err := CompileSelector(sel)
+			if err != nil {
+				return capability.Failure("invalid_selector", err.Error()), nil
+			}
+			links := []Link{}
+			for _, n := range compiled.MatchAll(root) {
+				links = append(links, page.Links(n)...)
+			}
+			return &capability.Response{Success: true, Data: links}, nil
+		}
+		return &capability.Response{Success: true, Data: page.Links(root)}, nil
 	default:
-		return &capability.Response{Success: false, Error: &capability.Error{Code: "invalid_action", Message: fmt.Sprintf("unsupported action: %s", req.Action)}}, nil
+		return &capability.Response{Success: true, Data: page.Forms()}, nil
+	}
+}
+
+// decodeState accepts a *SessionState or its JSON-decoded form.
+func decodeState(v interface{}) (*SessionState, error) {
+	switch state := v.(type) {
+	case *SessionState:
+		return state, nil
+	case nil:
+		return nil, errors.New("state is required")
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var state SessionState
+	if err := json.Unmarshal(raw, &state); err != nil {
+		return nil, fmt.Errorf("invalid state: %w", err)
+	}
+	return &state, nil
+}
+
+func (c *Capability) open(ctx context.Context, s *session, t *tab, name string, params map[string]interface{}) *capability.Response {
+	rawURL := capability.StringParam(params, "url", "")
+	if rawURL == "" {
+		return capability.Failure("invalid_params", "url is required")
+	}
+	var form url.Values
+	if f, ok := params["form"].(map[string]interface{}); ok {
+		form = url.Values{}
+		for k, v := range f {
+			switch vv := v.(type) {
+			case []interface{}:
+				for _, item := range vv {
+					form.Add(k, fmt.Sprint(item))
+				}
+			default:
+				form.Add(k, fmt.Sprint(vv))
+			}
+		}
+	}
+	method := strings.ToUpper(capability.StringParam(params, "method", ""))
+	page, err := c.navigate(ctx, s, t, method, rawURL, form, true)
+	if err != nil {
+		return fetchFailure(err)
+	}
+	return pageResponse(name, t, s.viewport, page)
+}
+
+func pageResponse(name string, t *tab, viewport Viewport, page *Page) *capability.Response {
+	return &capability.Response{Success: true, Data: map[string]interface{}{
+		"tab":          name,
+		"url":          page.URL.String(),
+		"status":       page.Status,
+		"title":        page.Title(),
+		"content_type": page.ContentType,
+		"history":      len(t.history),
+		"viewport":     viewport,
+	}}
+}
+
+// extract runs a CSS selector over the page. Params: selector, attr, html,
+// limit (default 100).
+func extract(page *Page, params map[string]interface{}) *capability.Response {
+	sel, err := CompileSelector(capability.StringParam(params, "selector", ""))
+	if err != nil {
+		return capability.Failure("invalid_selector", err.Error())
+	}
+	limit := capability.IntParam(params, "limit", 100)
+	attrName := capability.StringParam(params, "attr", "")
+	withHTML, _ := params["html"].(bool)
+	out := []map[string]interface{}{}
+	for _, n := range sel.MatchAll(page.Doc) {
+		if len(out) >= limit {
+			break
+		}
+		item := map[string]interface{}{"tag": n.Data, "text": collapse(textOf(n))}
+		if attrName != "" {
+			v := attr(n, attrName)
+			if attrName == "href" || attrName == "src" || attrName == "action" {
+				v = page.resolve(v)
+			}
+			item["attr"] = v
+		}
+		if withHTML {
+			item["html"] = outerHTML(n)
+		}
+		out = append(out, item)
+	}
+	return &capability.Response{Success: true, Data: out}
+}
+
+func fetchFailure(err error) *capability.Response {
+	var blocked *net.BlockedIPError
+	if errors.Is(err, net.ErrBlocked) || errors.As(err, &blocked) {
+		return capability.Failure("blocked", err.Error())
+	}
+	var limited *net.RateLimitedError
+	if errors.As(err, &limited) {
+		return capability.Failure("rate_limited", err.Error())
+	}
+	return capability.Failure("fetch_failed", err.Error())
+}
+
+func agentID(req *capability.Request) string {
+	if req.Context != nil && req.Context.AgentID != "" {
+		return req.Context.AgentID
 	}
+	return "default"
 }
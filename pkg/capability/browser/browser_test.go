@@ -0,0 +1,329 @@
+package browser
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"spawn.dev/pkg/capability"
+	"spawn.dev/pkg/capability/net"
+)
+
+const articlePage = `<!doctype html>
+<html><head>
+<title>Field Notes</title>
+<meta name="description" content="Notes from the field">
+<meta property="og:site_name" content="Example">
+</head><body>
+<nav><a href="/">Home</a> <a href="/about">About</a></nav>
+<div class="content">
+  <h1>Field Notes</h1>
+  <p class="lead">The first paragraph is long enough to be treated as real content by the extractor.</p>
+  <p>A second paragraph links to <a href="/more">more notes</a> and keeps going for a while.</p>
+  <ul><li>alpha</li><li>beta</li></ul>
+</div>
+<form action="/search" method="get">
+  <input type="text" name="q" value="seed">
+  <select name="sort"><option value="new" selected>New</option><option value="old">Old</option></select>
+  <input type="submit" value="Go">
+</form>
+<footer><a href="https://other.example/">Elsewhere</a></footer>
+</body></html>`
+
+func newBrowser(t *testing.T, allowlist, denylist []string) *Capability {
+	t.Helper()
+	policy, err := net.NewIPPolicy([]string{"127.0.0.0/8", "::1"}, nil)
+	if err != nil {
+		t.Fatalf("ip policy: %v", err)
+	}
+	n := net.New(allowlist, denylist)
+	n.SetIPPolicy(policy)
+	b := New()
+	b.SetNet(n)
+	return b
+}
+
+func run(t *testing.T, b *Capability, action string, params map[string]interface{}) *capability.Response {
+	t.Helper()
+	resp, err := b.Execute(context.Background(), &capability.Request{
+		Action:  action,
+		Params:  params,
+		Context: &capability.ExecutionContext{AgentID: "agent-1"},
+	})
+	if err != nil {
+		t.Fatalf("%s: %v", action, err)
+	}
+	return resp
+}
+
+func TestBrowserOpenAndExtract(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc"})
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			fmt.Fprint(w, articlePage)
+		case "/search":
+			cookie, _ := r.Cookie("session")
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprintf(w, "<title>Results</title><p id=q>%s</p><p id=c>%s</p>", r.URL.Query().Get("q"), cookie.Value)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	b := newBrowser(t, nil, nil)
+	resp := run(t, b, "open", map[string]interface{}{"url": srv.URL + "/"})
+	if !resp.Success {
+		t.Fatalf("open failed: %+v", resp.Error)
+	}
+	data := resp.Data.(map[string]interface{})
+	if data["title"] != "Field Notes" || data["status"] != http.StatusOK {
+		t.Fatalf("unexpected open result: %v", data)
+	}
+
+	resp = run(t, b, "extract", map[string]interface{}{"selector": "div.content > h1 + p, ul li:nth-child(2)"})
+	if !resp.Success {
+		t.Fatalf("extract failed: %+v", resp.Error)
+	}
+	items := resp.Data.([]map[string]interface{})
+	if len(items) != 2 || items[0]["tag"] != "p" || items[1]["text"] != "beta" {
+		t.Fatalf("unexpected items: %v", items)
+	}
+
+	resp = run(t, b, "extract", map[string]interface{}{"selector": "nav a[href]", "attr": "href"})
+	items = resp.Data.([]map[string]interface{})
+	if len(items) != 2 || items[1]["attr"] != srv.URL+"/about" || items[1]["text"] != "About" {
+		t.Fatalf("unexpected nav links: %v", items)
+	}
+
+	resp = run(t, b, "extract", map[string]interface{}{"selector": "p:not(.lead)"})
+	items = resp.Data.([]map[string]interface{})
+	if len(items) != 1 || !strings.HasPrefix(items[0]["text"].(string), "A second paragraph") {
+		t.Fatalf("unexpected :not result: %v", items)
+	}
+
+	resp = run(t, b, "extract", map[string]interface{}{"selector": "p["})
+	if resp.Success || resp.Error.Code != "invalid_selector" {
+		t.Fatalf("expected invalid_selector, got %+v", resp)
+	}
+
+	resp = run(t, b, "readable", nil)
+	readable := resp.Data.(Readable)
+	if readable.Title != "Field Notes" || !strings.Contains(readable.Text, "The first paragraph") || strings.Contains(readable.Text, "Home") {
+		t.Fatalf("unexpected readable text: %+v", readable)
+	}
+	if !strings.Contains(readable.Text, "- beta") {
+		t.Fatalf("list items not rendered: %q", readable.Text)
+	}
+	if readable.Metadata["description"] != "Notes from the field" || readable.Metadata["og:site_name"] != "Example" {
+		t.Fatalf("unexpected metadata: %v", readable.Metadata)
+	}
+
+	resp = run(t, b, "links", map[string]interface{}{"selector": "footer"})
+	links := resp.Data.([]Link)
+	if len(links) != 1 || links[0].Href != "https://other.example/" {
+		t.Fatalf("unexpected footer links: %v", links)
+	}
+
+	resp = run(t, b, "forms", nil)
+	forms := resp.Data.([]Form)
+	if len(forms) != 1 || forms[0].Action != srv.URL+"/search" || strings.ToUpper(forms[0].Method) != "GET" {
+		t.Fatalf("unexpected forms: %+v", forms)
+	}
+
+	// Submitting the form sends the cookie set on the first page.
+	resp = run(t, b, "open", map[string]interface{}{"url": "/search", "form": map[string]interface{}{"q": "rain"}})
+	if !resp.Success {
+		t.Fatalf("submit failed: %+v", resp.Error)
+	}
+	resp = run(t, b, "extract", map[string]interface{}{"selector": "#q, #c"})
+	items = resp.Data.([]map[string]interface{})
+	if len(items) != 2 || items[0]["text"] != "rain" || items[1]["text"] != "abc" {
+		t.Fatalf("unexpected search results: %v", items)
+	}
+
+	resp = run(t, b, "back", nil)
+	if !resp.Success || resp.Data.(map[string]interface{})["title"] != "Field Notes" {
+		t.Fatalf("back failed: %+v", resp)
+	}
+	resp = run(t, b, "history", nil)
+	if h := resp.Data.(map[string]interface{}); len(h["history"].([]string)) != 2 || h["index"] != 0 {
+		t.Fatalf("unexpected history: %v", h)
+	}
+}
+
+func TestBrowserBlockedAndMissingPage(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "<p>ok</p>")
+	}))
+	defer srv.Close()
+
+	b := newBrowser(t, nil, []string{"127.0.0.1"})
+	resp := run(t, b, "open", map[string]interface{}{"url": srv.URL})
+	if resp.Success || resp.Error.Code != "blocked" {
+		t.Fatalf("expected blocked, got %+v", resp)
+	}
+
+	resp = run(t, b, "readable", map[string]interface{}{"tab": "other"})
+	if resp.Success || resp.Error.Code != "no_page" {
+		t.Fatalf("expected no_page, got %+v", resp)
+	}
+
+	unconfigured := New()
+	resp, _ = unconfigured.Execute(context.Background(), &capability.Request{Action: "open", Params: map[string]interface{}{"url": srv.URL}})
+	if resp.Success {
+		t.Fatalf("expected failure without a network capability")
+	}
+}
+
+func TestBrowserSessionPool(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login" {
+			http.SetCookie(w, &http.Cookie{Name: "user", Value: r.URL.Query().Get("name"), MaxAge: 3600})
+		}
+		user := "anonymous"
+		if c, err := r.Cookie("user"); err == nil {
+			user = c.Value
+		}
+		fmt.Fprintf(w, "<title>%s</title>", user)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	b := newBrowser(t, nil, nil)
+	b.SetPool(PoolConfig{MaxInstances: 2, IdleTimeout: time.Minute, StateDir: dir, Viewport: Viewport{Width: 800, Height: 600}})
+	now := time.Now()
+	b.pool.now = func() time.Time { return now }
+
+	title := func(session string) string {
+		t.Helper()
+		resp := run(t, b, "open", map[string]interface{}{"url": srv.URL + "/", "session": session})
+		if !resp.Success {
+			t.Fatalf("open in %s: %+v", session, resp.Error)
+		}
+		return resp.Data.(map[string]interface{})["title"].(string)
+	}
+
+	run(t, b, "open", map[string]interface{}{"url": srv.URL + "/login?name=ada", "session": "work"})
+	if got := title("work"); got != "ada" {
+		t.Fatalf("work session title = %q, want ada", got)
+	}
+	if got := title("other"); got != "anonymous" {
+		t.Fatalf("cookies leaked between sessions: %q", got)
+	}
+
+	resp := run(t, b, "open", map[string]interface{}{"url": srv.URL + "/", "session": "third"})
+	if resp.Success || resp.Error.Code != "pool_exhausted" {
+		t.Fatalf("expected pool_exhausted, got %+v", resp)
+	}
+
+	resp = run(t, b, "viewport", map[string]interface{}{"session": "work", "width": float64(1024), "height": float64(768)})
+	if !resp.Success || resp.Data.(Viewport).Width != 1024 {
+		t.Fatalf("viewport failed: %+v", resp)
+	}
+
+	// Idle sessions are saved and evicted to make room.
+	now = now.Add(2 * time.Minute)
+	if got := title("third"); got != "anonymous" {
+		t.Fatalf("third session title = %q", got)
+	}
+	if names := b.pool.list("agent-1"); len(names) != 1 || names[0] != "third" {
+		t.Fatalf("unexpected open sessions after eviction: %v", names)
+	}
+
+	// A new process resumes the logged-in session from StateDir.
+	if err := b.Shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	resumed := newBrowser(t, nil, nil)
+	resumed.SetPool(PoolConfig{StateDir: dir})
+	resp = run(t, resumed, "readable", map[string]interface{}{"session": "work"})
+	if !resp.Success || resp.Data.(Readable).Title != "ada" {
+		t.Fatalf("resumed session lost its cookies: %+v", resp)
+	}
+	state := resumed.ExportSession("agent-1", "work")
+	if state == nil || state.Viewport.Width != 1024 || len(state.Cookies) != 1 || len(state.Tabs[defaultTab].History) != 2 {
+		t.Fatalf("unexpected exported state: %+v", state)
+	}
+
+	// Exported state can be imported into another session through the action.
+	raw := map[string]interface{}{}
+	data, _ := json.Marshal(state)
+	_ = json.Unmarshal(data, &raw)
+	resp = run(t, resumed, "import_session", map[string]interface{}{"session": "copy", "state": raw})
+	if !resp.Success {
+		t.Fatalf("import failed: %+v", resp.Error)
+	}
+	resp = run(t, resumed, "open", map[string]interface{}{"url": srv.URL + "/", "session": "copy"})
+	if resp.Data.(map[string]interface{})["title"] != "ada" {
+		t.Fatalf("imported session lost its cookies: %+v", resp.Data)
+	}
+
+	resp = run(t, resumed, "close_session", map[string]interface{}{"session": "work"})
+	if !resp.Success {
+		t.Fatalf("close_session failed: %+v", resp.Error)
+	}
+	if _, err := os.Stat(resumed.pool.statePath(sessionKey{"agent-1", "work"})); !os.IsNotExist(err) {
+		t.Fatalf("saved state not deleted: %v", err)
+	}
+}
+
+func TestJarScoping(t *testing.T) {
+	t.Parallel()
+	jar := NewJar()
+	u, _ := url.Parse("http://shop.example.com/cart/items")
+	jar.SetCookies(u, []*http.Cookie{
+		{Name: "host", Value: "1"},
+		{Name: "domain", Value: "2", Domain: ".example.com", Path: "/"},
+		{Name: "secure", Value: "3", Secure: true, Path: "/"},
+		{Name: "foreign", Value: "4", Domain: "other.com"},
+	})
+
+	names := func(raw string) []string {
+		u, _ := url.Parse(raw)
+		var out []string
+		for _, c := range jar.Cookies(u) {
+			out = append(out, c.Name)
+		}
+		return out
+	}
+	if got := names("http://shop.example.com/cart/view"); strings.Join(got, ",") != "host,domain" {
+		t.Fatalf("cookies for cart = %v", got)
+	}
+	if got := names("https://shop.example.com/"); strings.Join(got, ",") != "domain,secure" {
+		t.Fatalf("cookies over https = %v", got)
+	}
+	if got := names("http://www.example.com/cart/view"); strings.Join(got, ",") != "domain" {
+		t.Fatalf("cookies for sibling host = %v", got)
+	}
+
+	// Public suffixes cannot be cookie domains.
+	pages, _ := url.Parse("https://evil.github.io/")
+	jar.SetCookies(pages, []*http.Cookie{{Name: "tossed", Domain: "github.io"}})
+	jar.SetCookies(u, []*http.Cookie{{Name: "tossed", Domain: "com"}})
+	if got := names("https://victim.github.io/"); len(got) != 0 {
+		t.Fatalf("cookies for another github.io site = %v", got)
+	}
+	if got := names("https://other.com/"); len(got) != 0 {
+		t.Fatalf("cookies for another .com site = %v", got)
+	}
+
+	jar.SetCookies(u, []*http.Cookie{{Name: "domain", Domain: "example.com", Path: "/", MaxAge: -1}})
+	restored := NewJar()
+	restored.Import(jar.Export())
+	if restored.Len() != 2 {
+		t.Fatalf("restored %d cookies, want 2: %+v", restored.Len(), restored.Export())
+	}
+}
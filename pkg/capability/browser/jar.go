@@ -0,0 +1,215 @@
+package browser
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// Cookie is a stored cookie in the form sessions are exported with.
+type Cookie struct {
+	Name     string    `json:"name"`
+	Value    string    `json:"value"`
+	Domain   string    `json:"domain"`
+	Path     string    `json:"path"`
+	HostOnly bool      `json:"host_only,omitempty"`
+	Secure   bool      `json:"secure,omitempty"`
+	HttpOnly bool      `json:"http_only,omitempty"`
+	Expires  time.Time `json:"expires,omitempty"`
+}
+
+func (c Cookie) expired(now time.Time) bool {
+	return !c.Expires.IsZero() && !c.Expires.After(now)
+}
+
+// Jar is an http.CookieJar whose contents can be exported and restored.
+// Like net/http/cookiejar with publicsuffix.List, it keeps sites from
+// setting cookies for a public suffix such as com or github.io. It must
+// only be shared by one agent's requests.
+type Jar struct {
+	mu      sync.Mutex
+	cookies map[string]Cookie // keyed by domain;path;name
+	now     func() time.Time
+}
+
+// NewJar returns an empty jar.
+func NewJar() *Jar {
+	return &Jar{cookies: map[string]Cookie{}, now: time.Now}
+}
+
+// SetCookies implements http.CookieJar.
+func (j *Jar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	host := canonicalHost(u.Host)
+	if host == "" {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	now := j.now()
+	for _, hc := range cookies {
+		c := Cookie{Name: hc.Name, Value: hc.Value, Path: hc.Path, Secure: hc.Secure, HttpOnly: hc.HttpOnly}
+		if domain := strings.TrimPrefix(strings.ToLower(hc.Domain), "."); domain == "" || domain == host {
+			// A public suffix that is itself a site only gets host cookies.
+			c.Domain, c.HostOnly = host, domain == "" || isPublicSuffix(host)
+		} else if isIP(host) || !strings.HasSuffix(host, "."+domain) || isPublicSuffix(domain) {
+			continue // a host may only set cookies for itself and its parents below a public suffix
+		} else {
+			c.Domain = domain
+		}
+		if c.Path == "" || c.Path[0] != '/' {
+			c.Path = defaultPath(u.Path)
+		}
+		switch {
+		case hc.MaxAge < 0:
+			c.Expires = now
+		case hc.MaxAge > 0:
+			c.Expires = now.Add(time.Duration(hc.MaxAge) * time.Second)
+		case !hc.Expires.IsZero():
+			c.Expires = hc.Expires
+		}
+		key := c.Domain + ";" + c.Path + ";" + c.Name
+		if c.expired(now) {
+			delete(j.cookies, key)
+			continue
+		}
+		j.cookies[key] = c
+	}
+}
+
+// Cookies implements http.CookieJar.
+func (j *Jar) Cookies(u *url.URL) []*http.Cookie {
+	host := canonicalHost(u.Host)
+	path := u.Path
+	if path == "" {
+		path = "/"
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	now := j.now()
+	var matched []Cookie
+	for key, c := range j.cookies {
+		if c.expired(now) {
+			delete(j.cookies, key)
+			continue
+		}
+		if c.Secure && u.Scheme != "https" {
+			continue
+		}
+		if !domainMatch(c, host) || !pathMatch(c.Path, path) {
+			continue
+		}
+		matched = append(matched, c)
+	}
+	// Longer paths first, as browsers send them.
+	sort.Slice(matched, func(a, b int) bool {
+		if len(matched[a].Path) != len(matched[b].Path) {
+			return len(matched[a].Path) > len(matched[b].Path)
+		}
+		return matched[a].Name < matched[b].Name
+	})
+	out := make([]*http.Cookie, len(matched))
+	for i, c := range matched {
+		out[i] = &http.Cookie{Name: c.Name, Value: c.Value}
+	}
+	return out
+}
+
+// Export returns the unexpired cookies, sorted by domain, path and name.
+func (j *Jar) Export() []Cookie {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	now := j.now()
+	out := make([]Cookie, 0, len(j.cookies))
+	for _, c := range j.cookies {
+		if !c.expired(now) {
+			out = append(out, c)
+		}
+	}
+	sort.Slice(out, func(a, b int) bool {
+		if out[a].Domain != out[b].Domain {
+			return out[a].Domain < out[b].Domain
+		}
+		if out[a].Path != out[b].Path {
+			return out[a].Path < out[b].Path
+		}
+		return out[a].Name < out[b].Name
+	})
+	return out
+}
+
+// Import replaces the jar's contents with cookies. Expired entries are
+// dropped.
+func (j *Jar) Import(cookies []Cookie) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	now := j.now()
+	j.cookies = make(map[string]Cookie, len(cookies))
+	for _, c := range cookies {
+		c.Domain = strings.TrimPrefix(strings.ToLower(c.Domain), ".")
+		if c.Domain == "" || c.Name == "" || c.expired(now) {
+			continue
+		}
+		if c.Path == "" {
+			c.Path = "/"
+		}
+		if isPublicSuffix(c.Domain) {
+			c.HostOnly = true
+		}
+		j.cookies[c.Domain+";"+c.Path+";"+c.Name] = c
+	}
+}
+
+// Len returns the number of stored cookies, including expired ones not yet
+// purged.
+func (j *Jar) Len() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return len(j.cookies)
+}
+
+func canonicalHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(strings.Trim(host, "[]")), ".")
+}
+
+func isIP(host string) bool {
+	return net.ParseIP(host) != nil
+}
+
+// isPublicSuffix reports whether cookies for domain would be sent to
+// unrelated sites, as with com or github.io.
+func isPublicSuffix(domain string) bool {
+	return !isIP(domain) && publicsuffix.List.PublicSuffix(domain) == domain
+}
+
+func domainMatch(c Cookie, host string) bool {
+	if c.HostOnly {
+		return host == c.Domain
+	}
+	return host == c.Domain || strings.HasSuffix(host, "."+c.Domain)
+}
+
+func pathMatch(cookiePath, path string) bool {
+	if !strings.HasPrefix(path, cookiePath) {
+		return false
+	}
+	return len(path) == len(cookiePath) || strings.HasSuffix(cookiePath, "/") || path[len(cookiePath)] == '/'
+}
+
+// defaultPath is the RFC 6265 default-path: the request path up to, but not
+// including, its last slash.
+func defaultPath(path string) string {
+	i := strings.LastIndex(path, "/")
+	if i <= 0 {
+		return "/"
+	}
+	return path[:i]
+}
@@ -0,0 +1,453 @@
+package browser
+
+import (
+	"bytes"
+	"math"
+	"mime"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Page is a fetched and parsed document.
+type Page struct {
+	URL         *url.URL
+	Status      int
+	ContentType string
+	Doc         *html.Node
+}
+
+// Link is an anchor with its absolute target.
+type Link struct {
+	Text string `json:"text"`
+	Href string `json:"href"`
+	Rel  string `json:"rel,omitempty"`
+}
+
+// Form describes a form and its fields.
+type Form struct {
+	Index  int         `json:"index"`
+	ID     string      `json:"id,omitempty"`
+	Name   string      `json:"name,omitempty"`
+	Action string      `json:"action"`
+	Method string      `json:"method"`
+	Fields []FormField `json:"fields"`
+}
+
+// FormField is one named input, select or textarea.
+type FormField struct {
+	Name     string   `json:"name"`
+	Type     string   `json:"type"`
+	Value    string   `json:"value,omitempty"`
+	Required bool     `json:"required,omitempty"`
+	Options  []string `json:"options,omitempty"`
+}
+
+// Readable is the main content of a page with boilerplate removed.
+type Readable struct {
+	Title    string            `json:"title"`
+	Text     string            `json:"text"`
+	Excerpt  string            `json:"excerpt,omitempty"`
+	Links    []Link            `json:"links"`
+	Metadata map[string]string `json:"metadata"`
+}
+
+// parsePage parses body as HTML. Other text types are wrapped in <pre> so
+// every action works on them.
+func parsePage(u *url.URL, status int, contentType string, body []byte) (*Page, error) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType != "" && mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		doc := &html.Node{Type: html.DocumentNode}
+		pre := &html.Node{Type: html.ElementNode, Data: "pre", DataAtom: atom.Pre}
+		pre.AppendChild(&html.Node{Type: html.TextNode, Data: string(body)})
+		doc.AppendChild(pre)
+		return &Page{URL: u, Status: status, ContentType: contentType, Doc: doc}, nil
+	}
+	doc, err := html.Parse(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	return &Page{URL: u, Status: status, ContentType: contentType, Doc: doc}, nil
+}
+
+// base returns the URL relative references resolve against.
+func (p *Page) base() *url.URL {
+	if b := first(p.Doc, atom.Base); b != nil {
+		if href, err := url.Parse(attr(b, "href")); err == nil {
+			return p.URL.ResolveReference(href)
+		}
+	}
+	return p.URL
+}
+
+func (p *Page) resolve(ref string) string {
+	u, err := url.Parse(strings.TrimSpace(ref))
+	if err != nil {
+		return ""
+	}
+	return p.base().ResolveReference(u).String()
+}
+
+// Title returns the document title.
+func (p *Page) Title() string {
+	if t := first(p.Doc, atom.Title); t != nil {
+		return collapse(textOf(t))
+	}
+	if h := first(p.Doc, atom.H1); h != nil {
+		return collapse(textOf(h))
+	}
+	return ""
+}
+
+// Links returns the anchors below root, or the whole page when root is nil.
+func (p *Page) Links(root *html.Node) []Link {
+	if root == nil {
+		root = p.Doc
+	}
+	out := []Link{}
+	seen := map[string]bool{}
+	walk(root, func(n *html.Node) bool {
+		if n.DataAtom != atom.A {
+			return true
+		}
+		href, ok := attrOK(n, "href")
+		if !ok || strings.HasPrefix(strings.ToLower(strings.TrimSpace(href)), "javascript:") {
+			return false
+		}
+		abs := p.resolve(href)
+		if abs == "" || seen[abs] {
+			return false
+		}
+		seen[abs] = true
+		out = append(out, Link{Text: collapse(textOf(n)), Href: abs, Rel: attr(n, "rel")})
+		return false
+	})
+	return out
+}
+
+// Forms returns the page's forms.
+func (p *Page) Forms() []Form {
+	out := []Form{}
+	for i, f := range all(p.Doc, atom.Form) {
+		method := strings.ToUpper(attr(f, "method"))
+		if method == "" {
+			method = "GET"
+		}
+		form := Form{Index: i, ID: attr(f, "id"), Name: attr(f, "name"), Action: p.resolve(attr(f, "action")), Method: method, Fields: []FormField{}}
+		walk(f, func(n *html.Node) bool {
+			name := attr(n, "name")
+			if name == "" {
+				return true
+			}
+			_, required := attrOK(n, "required")
+			switch n.DataAtom {
+			case atom.Input:
+				typ := strings.ToLower(attr(n, "type"))
+				if typ == "" {
+					typ = "text"
+				}
+				value := attr(n, "value")
+				if typ == "checkbox" || typ == "radio" {
+					if _, checked := attrOK(n, "checked"); !checked {
+						value = ""
+					} else if value == "" {
+						value = "on"
+					}
+				}
+				form.Fields = append(form.Fields, FormField{Name: name, Type: typ, Value: value, Required: required})
+			case atom.Textarea:
+				form.Fields = append(form.Fields, FormField{Name: name, Type: "textarea", Value: textOf(n), Required: required})
+			case atom.Select:
+				field := FormField{Name: name, Type: "select", Required: required}
+				for _, o := range all(n, atom.Option) {
+					v, ok := attrOK(o, "value")
+					if !ok {
+						v = collapse(textOf(o))
+					}
+					field.Options = append(field.Options, v)
+					if _, sel := attrOK(o, "selected"); sel || field.Value == "" && len(field.Options) == 1 {
+						field.Value = v
+					}
+				}
+				form.Fields = append(form.Fields, field)
+			case atom.Button:
+				form.Fields = append(form.Fields, FormField{Name: name, Type: "button", Value: attr(n, "value")})
+			}
+			return true
+		})
+		out = append(out, form)
+	}
+	return out
+}
+
+// Metadata returns description, author, canonical, language and OpenGraph
+// and Twitter card values.
+func (p *Page) Metadata() map[string]string {
+	meta := map[string]string{}
+	if h := first(p.Doc, atom.Html); h != nil {
+		if lang := attr(h, "lang"); lang != "" {
+			meta["lang"] = lang
+		}
+	}
+	for _, m := range all(p.Doc, atom.Meta) {
+		key := strings.ToLower(attr(m, "property"))
+		if key == "" {
+			key = strings.ToLower(attr(m, "name"))
+		}
+		content := strings.TrimSpace(attr(m, "content"))
+		if content == "" {
+			continue
+		}
+		switch {
+		case key == "description", key == "author", key == "keywords",
+			strings.HasPrefix(key, "og:"), strings.HasPrefix(key, "twitter:"),
+			strings.HasPrefix(key, "article:"):
+			if _, dup := meta[key]; !dup {
+				meta[key] = content
+			}
+		}
+	}
+	for _, l := range all(p.Doc, atom.Link) {
+		if strings.EqualFold(attr(l, "rel"), "canonical") {
+			meta["canonical"] = p.resolve(attr(l, "href"))
+		}
+	}
+	return meta
+}
+
+// boilerplate elements are dropped before picking the main content.
+var boilerplate = map[atom.Atom]bool{
+	atom.Script: true, atom.Style: true, atom.Noscript: true, atom.Template: true,
+	atom.Nav: true, atom.Header: true, atom.Footer: true, atom.Aside: true,
+	atom.Form: true, atom.Iframe: true, atom.Svg: true, atom.Button: true,
+	atom.Select: true, atom.Input: true, atom.Textarea: true,
+}
+
+// negativeHints mark class or id names of non-content blocks.
+var negativeHints = []string{"comment", "sidebar", "footer", "header", "menu", "nav", "share", "social", "promo", "advert", "cookie", "banner", "related", "subscribe"}
+
+// Readable extracts the main text: an <article> or <main> element when
+// present, otherwise the block whose paragraphs carry the most text with
+// the least link density.
+func (p *Page) Readable() Readable {
+	title := p.Title()
+	body := first(p.Doc, atom.Body)
+	if body == nil {
+		body = p.Doc
+	}
+	root := first(body, atom.Article)
+	if root == nil {
+		root = first(body, atom.Main)
+	}
+	if root == nil {
+		root = bestBlock(body)
+	}
+	var b strings.Builder
+	renderText(&b, root)
+	text := strings.TrimSpace(squeezeBlankLines(b.String()))
+	excerpt := p.Metadata()["description"]
+	if excerpt == "" {
+		excerpt, _, _ = strings.Cut(text, "\n")
+		if len(excerpt) > 300 {
+			excerpt = excerpt[:300]
+		}
+	}
+	return Readable{Title: title, Text: text, Excerpt: excerpt, Links: p.Links(root), Metadata: p.Metadata()}
+}
+
+func bestBlock(body *html.Node) *html.Node {
+	best, bestScore := body, 0.0
+	walk(body, func(n *html.Node) bool {
+		if boilerplate[n.DataAtom] || hinted(n) {
+			return false
+		}
+		switch n.DataAtom {
+		case atom.Div, atom.Section, atom.Td, atom.Article, atom.Main:
+		default:
+			return true
+		}
+		var paraText float64
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.DataAtom == atom.P || c.DataAtom == atom.Pre || c.DataAtom == atom.Blockquote {
+				paraText += float64(len(collapse(textOf(c))))
+			}
+		}
+		if paraText == 0 {
+			return true
+		}
+		total := float64(len(collapse(textOf(n))))
+		var linkText float64
+		for _, a := range all(n, atom.A) {
+			linkText += float64(len(collapse(textOf(a))))
+		}
+		score := paraText * (1 - linkText/math.Max(total, 1))
+		if score > bestScore {
+			best, bestScore = n, score
+		}
+		return true
+	})
+	return best
+}
+
+func hinted(n *html.Node) bool {
+	names := strings.ToLower(attr(n, "class") + " " + attr(n, "id"))
+	if strings.TrimSpace(names) == "" {
+		return false
+	}
+	for _, h := range negativeHints {
+		if strings.Contains(names, h) {
+			return true
+		}
+	}
+	return false
+}
+
+var blockElements = map[atom.Atom]bool{
+	atom.P: true, atom.Div: true, atom.Section: true, atom.Article: true, atom.Main: true,
+	atom.H1: true, atom.H2: true, atom.H3: true, atom.H4: true, atom.H5: true, atom.H6: true,
+	atom.Ul: true, atom.Ol: true, atom.Li: true, atom.Pre: true, atom.Blockquote: true,
+	atom.Table: true, atom.Tr: true, atom.Br: true, atom.Hr: true, atom.Figure: true, atom.Figcaption: true,
+}
+
+// renderText writes the visible text of n, with blank lines between blocks,
+// "# " before headings and "- " before list items.
+func renderText(b *strings.Builder, n *html.Node) {
+	switch {
+	case n.Type == html.TextNode:
+		if pre := ancestor(n, atom.Pre); pre != nil {
+			b.WriteString(n.Data)
+			return
+		}
+		s := collapse(n.Data)
+		if s == "" {
+			return
+		}
+		out := b.String()
+		if out != "" && !strings.HasSuffix(out, "\n") && !strings.HasSuffix(out, " ") && !strings.ContainsAny(s[:1], ",.;:!?)") {
+			b.WriteByte(' ')
+		}
+		b.WriteString(s)
+		return
+	case n.Type == html.ElementNode && (boilerplate[n.DataAtom] || hinted(n)):
+		return
+	}
+	block := blockElements[n.DataAtom]
+	if block {
+		b.WriteString("\n\n")
+	}
+	switch n.DataAtom {
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		b.WriteString(strings.Repeat("#", int(n.Data[1]-'0')) + " ")
+	case atom.Li:
+		b.WriteString("- ")
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		renderText(b, c)
+	}
+	if block {
+		b.WriteString("\n\n")
+	}
+}
+
+func squeezeBlankLines(s string) string {
+	lines := strings.Split(s, "\n")
+	out := make([]string, 0, len(lines))
+	blank := false
+	for _, l := range lines {
+		l = strings.TrimRight(l, " \t")
+		if strings.TrimSpace(l) == "" {
+			if !blank && len(out) > 0 {
+				out = append(out, "")
+			}
+			blank = true
+			continue
+		}
+		// Join "- " list markers with their item text split across blocks.
+		if len(out) > 0 && (out[len(out)-1] == "-" || strings.HasSuffix(out[len(out)-1], "#")) {
+			out[len(out)-1] += " " + strings.TrimSpace(l)
+			blank = false
+			continue
+		}
+		out = append(out, strings.TrimLeft(l, " "))
+		blank = false
+	}
+	return strings.Join(out, "\n")
+}
+
+// walk visits element descendants of n depth-first; fn returns false to
+// skip a subtree.
+func walk(n *html.Node, fn func(*html.Node) bool) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && !fn(c) {
+			continue
+		}
+		walk(c, fn)
+	}
+}
+
+func first(n *html.Node, a atom.Atom) *html.Node {
+	var found *html.Node
+	walk(n, func(c *html.Node) bool {
+		if found != nil {
+			return false
+		}
+		if c.DataAtom == a {
+			found = c
+			return false
+		}
+		return true
+	})
+	return found
+}
+
+func all(n *html.Node, a atom.Atom) []*html.Node {
+	var out []*html.Node
+	walk(n, func(c *html.Node) bool {
+		if c.DataAtom == a {
+			out = append(out, c)
+		}
+		return true
+	})
+	return out
+}
+
+func ancestor(n *html.Node, a atom.Atom) *html.Node {
+	for p := n.Parent; p != nil; p = p.Parent {
+		if p.DataAtom == a {
+			return p
+		}
+	}
+	return nil
+}
+
+// textOf returns the text content of n, skipping scripts and styles.
+func textOf(n *html.Node) string {
+	var b strings.Builder
+	var rec func(*html.Node)
+	rec = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data)
+			return
+		}
+		if n.DataAtom == atom.Script || n.DataAtom == atom.Style {
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			rec(c)
+		}
+	}
+	rec(n)
+	return b.String()
+}
+
+func collapse(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func outerHTML(n *html.Node) string {
+	var b bytes.Buffer
+	_ = html.Render(&b, n)
+	return b.String()
+}
@@ -1,7 +1,235 @@
 package browser
 
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
 // PoolConfig defines browser instance pool behavior.
 type PoolConfig struct {
+	// MaxInstances caps open sessions across all agents; 0 means no limit.
 	MaxInstances int
 	Headless     bool
+	// IdleTimeout closes sessions unused for this long; 0 keeps them open.
+	IdleTimeout time.Duration
+	// Viewport is the initial viewport of new sessions.
+	Viewport Viewport
+	// StateDir, when set, is where sessions are saved on eviction and
+	// shutdown and restored from when they are next opened.
+	StateDir string
+}
+
+// DefaultViewport is used when PoolConfig.Viewport is unset.
+var DefaultViewport = Viewport{Width: 1920, Height: 1080}
+
+// ErrPoolExhausted is returned when MaxInstances sessions are open and none
+// is idle long enough to evict.
+var ErrPoolExhausted = errors.New("browser session pool exhausted")
+
+// pool tracks every agent's sessions.
+type pool struct {
+	cfg      PoolConfig
+	mu       sync.Mutex
+	sessions map[sessionKey]*session
+	now      func() time.Time
+	stop     chan struct{}
+}
+
+type sessionKey struct {
+	agent, name string
+}
+
+func newPool(cfg PoolConfig) *pool {
+	if cfg.Viewport.Width <= 0 || cfg.Viewport.Height <= 0 {
+		cfg.Viewport = DefaultViewport
+	}
+	return &pool{cfg: cfg, sessions: map[sessionKey]*session{}, now: time.Now}
+}
+
+// acquire returns the named session, restoring or creating it, marked in
+// use until release.
+func (p *pool) acquire(key sessionKey) (*session, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := p.sessions[key]
+	if s == nil {
+		if p.cfg.MaxInstances > 0 && len(p.sessions) >= p.cfg.MaxInstances {
+			p.evictIdleLocked()
+			if len(p.sessions) >= p.cfg.MaxInstances {
+				return nil, fmt.Errorf("%w: %d sessions open", ErrPoolExhausted, len(p.sessions))
+			}
+		}
+		s = newSession(p.cfg.Viewport)
+		state, err := p.load(key)
+		if err != nil {
+			return nil, err
+		}
+		if state != nil {
+			if err := s.restore(state); err != nil {
+				return nil, fmt.Errorf("restore session %s/%s: %w", key.agent, key.name, err)
+			}
+		}
+		p.sessions[key] = s
+	}
+	s.active++
+	s.lastUsed = p.now()
+	return s, nil
+}
+
+func (p *pool) release(s *session) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s.active--
+	s.lastUsed = p.now()
+}
+
+// remove closes a session and deletes its saved state.
+func (p *pool) remove(key sessionKey) error {
+	p.mu.Lock()
+	delete(p.sessions, key)
+	p.mu.Unlock()
+	if p.cfg.StateDir == "" {
+		return nil
+	}
+	if err := os.Remove(p.statePath(key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// list returns the names of an agent's open sessions.
+func (p *pool) list(agent string) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var names []string
+	for key := range p.sessions {
+		if key.agent == agent {
+			names = append(names, key.name)
+		}
+	}
+	return names
+}
+
+// evictIdle closes sessions idle for longer than IdleTimeout.
+func (p *pool) evictIdle() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.evictIdleLocked()
+}
+
+func (p *pool) evictIdleLocked() int {
+	if p.cfg.IdleTimeout <= 0 {
+		return 0
+	}
+	cutoff := p.now().Add(-p.cfg.IdleTimeout)
+	evicted := 0
+	for key, s := range p.sessions {
+		if s.active > 0 || s.lastUsed.After(cutoff) {
+			continue
+		}
+		// ExportSession may be reading an idle session.
+		s.mu.Lock()
+		state := s.export()
+		s.mu.Unlock()
+		_ = p.save(key, state)
+		delete(p.sessions, key)
+		evicted++
+	}
+	return evicted
+}
+
+// start runs idle eviction until close.
+func (p *pool) start() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cfg.IdleTimeout <= 0 || p.stop != nil {
+		return
+	}
+	p.stop = make(chan struct{})
+	interval := p.cfg.IdleTimeout / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+	go func(stop chan struct{}) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				p.evictIdle()
+			}
+		}
+	}(p.stop)
+}
+
+// close stops eviction and saves every session.
+func (p *pool) close() error {
+	p.mu.Lock()
+	if p.stop != nil {
+		close(p.stop)
+		p.stop = nil
+	}
+	sessions := p.sessions
+	p.sessions = map[sessionKey]*session{}
+	p.mu.Unlock()
+	var errs []error
+	for key, s := range sessions {
+		s.mu.Lock()
+		state := s.export()
+		s.mu.Unlock()
+		if err := p.save(key, state); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (p *pool) statePath(key sessionKey) string {
+	// PathEscape leaves no separators, so the name stays inside StateDir.
+	return filepath.Join(p.cfg.StateDir, url.PathEscape(key.agent)+"__"+url.PathEscape(key.name)+".json")
+}
+
+func (p *pool) save(key sessionKey, state *SessionState) error {
+	if p.cfg.StateDir == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(p.cfg.StateDir, 0o700); err != nil {
+		return err
+	}
+	path := p.statePath(key)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (p *pool) load(key sessionKey) (*SessionState, error) {
+	if p.cfg.StateDir == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(p.statePath(key))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state SessionState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("read session %s/%s: %w", key.agent, key.name, err)
+	}
+	return &state, nil
 }
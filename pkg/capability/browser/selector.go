@@ -0,0 +1,491 @@
+package browser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Selector is a compiled CSS selector group. It supports type, universal,
+// #id, .class and attribute selectors ([a], =, ~=, |=, ^=, $=, *=), the
+// descendant, >, + and ~ combinators, and the :first-child, :last-child,
+// :only-child, :nth-child(), :empty and :not() pseudo-classes.
+type Selector []complexSelector
+
+type complexSelector struct {
+	parts []compoundSelector
+	// combinators[i] joins parts[i] and parts[i+1]: ' ', '>', '+' or '~'.
+	combinators []byte
+}
+
+type compoundSelector struct {
+	tag     string
+	id      string
+	classes []string
+	attrs   []attrSelector
+	pseudos []pseudoSelector
+}
+
+type attrSelector struct {
+	name, op, value string
+}
+
+type pseudoSelector struct {
+	name string
+	a, b int
+	not  *compoundSelector
+}
+
+// CompileSelector parses a CSS selector group.
+func CompileSelector(s string) (Selector, error) {
+	p := &selectorParser{src: s}
+	sel, err := p.group()
+	if err != nil {
+		return nil, fmt.Errorf("invalid selector %q: %w", s, err)
+	}
+	return sel, nil
+}
+
+// MatchAll returns the elements below root matching s, in document order.
+func (s Selector) MatchAll(root *html.Node) []*html.Node {
+	var out []*html.Node
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.ElementNode && s.Match(c) {
+				out = append(out, c)
+			}
+			walk(c)
+		}
+	}
+	walk(root)
+	return out
+}
+
+// Match reports whether n matches any selector in the group.
+func (s Selector) Match(n *html.Node) bool {
+	for _, c := range s {
+		if c.match(n, len(c.parts)-1) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c complexSelector) match(n *html.Node, i int) bool {
+	if !c.parts[i].match(n) {
+		return false
+	}
+	if i == 0 {
+		return true
+	}
+	switch c.combinators[i-1] {
+	case '>':
+		p := parentElement(n)
+		return p != nil && c.match(p, i-1)
+	case '+':
+		p := prevElement(n)
+		return p != nil && c.match(p, i-1)
+	case '~':
+		for p := prevElement(n); p != nil; p = prevElement(p) {
+			if c.match(p, i-1) {
+				return true
+			}
+		}
+	default:
+		for p := parentElement(n); p != nil; p = parentElement(p) {
+			if c.match(p, i-1) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c *compoundSelector) match(n *html.Node) bool {
+	if n.Type != html.ElementNode {
+		return false
+	}
+	if c.tag != "" && c.tag != "*" && n.Data != c.tag {
+		return false
+	}
+	if c.id != "" && attr(n, "id") != c.id {
+		return false
+	}
+	if len(c.classes) > 0 {
+		have := strings.Fields(attr(n, "class"))
+		for _, want := range c.classes {
+			if !contains(have, want) {
+				return false
+			}
+		}
+	}
+	for _, a := range c.attrs {
+		if !a.match(n) {
+			return false
+		}
+	}
+	for _, p := range c.pseudos {
+		if !p.match(n) {
+			return false
+		}
+	}
+	return true
+}
+
+func (a attrSelector) match(n *html.Node) bool {
+	v, ok := attrOK(n, a.name)
+	if !ok {
+		return false
+	}
+	switch a.op {
+	case "":
+		return true
+	case "=":
+		return v == a.value
+	case "~=":
+		return contains(strings.Fields(v), a.value)
+	case "|=":
+		return v == a.value || strings.HasPrefix(v, a.value+"-")
+	case "^=":
+		return a.value != "" && strings.HasPrefix(v, a.value)
+	case "$=":
+		return a.value != "" && strings.HasSuffix(v, a.value)
+	case "*=":
+		return a.value != "" && strings.Contains(v, a.value)
+	}
+	return false
+}
+
+func (p pseudoSelector) match(n *html.Node) bool {
+	switch p.name {
+	case "first-child":
+		return prevElement(n) == nil
+	case "last-child":
+		return nextElement(n) == nil
+	case "only-child":
+		return prevElement(n) == nil && nextElement(n) == nil
+	case "empty":
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.ElementNode || (c.Type == html.TextNode && c.Data != "") {
+				return false
+			}
+		}
+		return true
+	case "nth-child":
+		pos := 1
+		for s := prevElement(n); s != nil; s = prevElement(s) {
+			pos++
+		}
+		if p.a == 0 {
+			return pos == p.b
+		}
+		k := (pos - p.b) / p.a
+		return (pos-p.b)%p.a == 0 && k >= 0
+	case "not":
+		return !p.not.match(n)
+	}
+	return false
+}
+
+type selectorParser struct {
+	src string
+	pos int
+}
+
+func (p *selectorParser) group() (Selector, error) {
+	var out Selector
+	for {
+		p.skipSpace()
+		c, err := p.complex()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+		p.skipSpace()
+		if p.pos == len(p.src) {
+			return out, nil
+		}
+		if p.src[p.pos] != ',' {
+			return nil, fmt.Errorf("unexpected %q at %d", p.src[p.pos], p.pos)
+		}
+		p.pos++
+	}
+}
+
+func (p *selectorParser) complex() (complexSelector, error) {
+	var c complexSelector
+	for {
+		part, err := p.compound()
+		if err != nil {
+			return c, err
+		}
+		c.parts = append(c.parts, part)
+		spaced := p.skipSpace()
+		if p.pos == len(p.src) || p.src[p.pos] == ',' || p.src[p.pos] == ')' {
+			return c, nil
+		}
+		comb := byte(' ')
+		if ch := p.src[p.pos]; ch == '>' || ch == '+' || ch == '~' {
+			comb = ch
+			p.pos++
+			p.skipSpace()
+		} else if !spaced {
+			return c, fmt.Errorf("unexpected %q at %d", ch, p.pos)
+		}
+		c.combinators = append(c.combinators, comb)
+	}
+}
+
+func (p *selectorParser) compound() (compoundSelector, error) {
+	var c compoundSelector
+	start := p.pos
+	if p.pos < len(p.src) && p.src[p.pos] == '*' {
+		c.tag = "*"
+		p.pos++
+	} else if name := p.ident(); name != "" {
+		c.tag = strings.ToLower(name)
+	}
+	for p.pos < len(p.src) {
+		switch p.src[p.pos] {
+		case '#':
+			p.pos++
+			if c.id = p.ident(); c.id == "" {
+				return c, fmt.Errorf("expected id at %d", p.pos)
+			}
+		case '.':
+			p.pos++
+			class := p.ident()
+			if class == "" {
+				return c, fmt.Errorf("expected class at %d", p.pos)
+			}
+			c.classes = append(c.classes, class)
+		case '[':
+			a, err := p.attribute()
+			if err != nil {
+				return c, err
+			}
+			c.attrs = append(c.attrs, a)
+		case ':':
+			ps, err := p.pseudo()
+			if err != nil {
+				return c, err
+			}
+			c.pseudos = append(c.pseudos, ps)
+		default:
+			if p.pos == start {
+				return c, fmt.Errorf("expected selector at %d", p.pos)
+			}
+			return c, nil
+		}
+	}
+	if p.pos == start {
+		return c, fmt.Errorf("expected selector at %d", p.pos)
+	}
+	return c, nil
+}
+
+func (p *selectorParser) attribute() (attrSelector, error) {
+	p.pos++ // [
+	p.skipSpace()
+	a := attrSelector{name: strings.ToLower(p.ident())}
+	if a.name == "" {
+		return a, fmt.Errorf("expected attribute name at %d", p.pos)
+	}
+	p.skipSpace()
+	if p.pos >= len(p.src) {
+		return a, fmt.Errorf("unterminated attribute selector")
+	}
+	if p.src[p.pos] != ']' {
+		for _, op := range []string{"~=", "|=", "^=", "$=", "*=", "="} {
+			if strings.HasPrefix(p.src[p.pos:], op) {
+				a.op = op
+				p.pos += len(op)
+				break
+			}
+		}
+		if a.op == "" {
+			return a, fmt.Errorf("unexpected %q in attribute selector", p.src[p.pos])
+		}
+		p.skipSpace()
+		v, err := p.value()
+		if err != nil {
+			return a, err
+		}
+		a.value = v
+		p.skipSpace()
+	}
+	if p.pos >= len(p.src) || p.src[p.pos] != ']' {
+		return a, fmt.Errorf("expected ] at %d", p.pos)
+	}
+	p.pos++
+	return a, nil
+}
+
+func (p *selectorParser) pseudo() (pseudoSelector, error) {
+	p.pos++ // :
+	ps := pseudoSelector{name: strings.ToLower(p.ident())}
+	switch ps.name {
+	case "first-child", "last-child", "only-child", "empty":
+		return ps, nil
+	case "nth-child", "not":
+	default:
+		return ps, fmt.Errorf("unsupported pseudo-class :%s", ps.name)
+	}
+	if p.pos >= len(p.src) || p.src[p.pos] != '(' {
+		return ps, fmt.Errorf("expected ( after :%s", ps.name)
+	}
+	p.pos++
+	p.skipSpace()
+	if ps.name == "not" {
+		inner, err := p.compound()
+		if err != nil {
+			return ps, err
+		}
+		ps.not = &inner
+	} else {
+		end := strings.IndexByte(p.src[p.pos:], ')')
+		if end < 0 {
+			return ps, fmt.Errorf("unterminated :nth-child")
+		}
+		var err error
+		if ps.a, ps.b, err = parseNth(p.src[p.pos : p.pos+end]); err != nil {
+			return ps, err
+		}
+		p.pos += end
+	}
+	p.skipSpace()
+	if p.pos >= len(p.src) || p.src[p.pos] != ')' {
+		return ps, fmt.Errorf("expected ) at %d", p.pos)
+	}
+	p.pos++
+	return ps, nil
+}
+
+// parseNth parses the an+b argument of :nth-child.
+func parseNth(s string) (int, int, error) {
+	s = strings.ReplaceAll(strings.ToLower(s), " ", "")
+	switch s {
+	case "odd":
+		return 2, 1, nil
+	case "even":
+		return 2, 0, nil
+	}
+	n := strings.IndexByte(s, 'n')
+	if n < 0 {
+		b, err := strconv.Atoi(s)
+		return 0, b, err
+	}
+	a := 1
+	switch coef := s[:n]; coef {
+	case "", "+":
+	case "-":
+		a = -1
+	default:
+		var err error
+		if a, err = strconv.Atoi(coef); err != nil {
+			return 0, 0, fmt.Errorf("invalid :nth-child(%s)", s)
+		}
+	}
+	b := 0
+	if rest := s[n+1:]; rest != "" {
+		var err error
+		if b, err = strconv.Atoi(rest); err != nil {
+			return 0, 0, fmt.Errorf("invalid :nth-child(%s)", s)
+		}
+	}
+	return a, b, nil
+}
+
+func (p *selectorParser) ident() string {
+	start := p.pos
+	for p.pos < len(p.src) {
+		ch := p.src[p.pos]
+		if ch == '-' || ch == '_' || ch >= '0' && ch <= '9' || ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= 0x80 {
+			p.pos++
+			continue
+		}
+		if ch == '\\' && p.pos+1 < len(p.src) {
+			p.pos += 2
+			continue
+		}
+		break
+	}
+	return strings.ReplaceAll(p.src[start:p.pos], `\`, "")
+}
+
+func (p *selectorParser) value() (string, error) {
+	if p.pos < len(p.src) && (p.src[p.pos] == '"' || p.src[p.pos] == '\'') {
+		quote := p.src[p.pos]
+		end := strings.IndexByte(p.src[p.pos+1:], quote)
+		if end < 0 {
+			return "", fmt.Errorf("unterminated string at %d", p.pos)
+		}
+		v := p.src[p.pos+1 : p.pos+1+end]
+		p.pos += end + 2
+		return v, nil
+	}
+	v := p.ident()
+	if v == "" {
+		return "", fmt.Errorf("expected value at %d", p.pos)
+	}
+	return v, nil
+}
+
+func (p *selectorParser) skipSpace() bool {
+	start := p.pos
+	for p.pos < len(p.src) && strings.IndexByte(" \t\n\r\f", p.src[p.pos]) >= 0 {
+		p.pos++
+	}
+	return p.pos > start
+}
+
+func parentElement(n *html.Node) *html.Node {
+	if p := n.Parent; p != nil && p.Type == html.ElementNode {
+		return p
+	}
+	return nil
+}
+
+func prevElement(n *html.Node) *html.Node {
+	for s := n.PrevSibling; s != nil; s = s.PrevSibling {
+		if s.Type == html.ElementNode {
+			return s
+		}
+	}
+	return nil
+}
+
+func nextElement(n *html.Node) *html.Node {
+	for s := n.NextSibling; s != nil; s = s.NextSibling {
+		if s.Type == html.ElementNode {
+			return s
+		}
+	}
+	return nil
+}
+
+func attr(n *html.Node, name string) string {
+	v, _ := attrOK(n, name)
+	return v
+}
+
+func attrOK(n *html.Node, name string) (string, bool) {
+	for _, a := range n.Attr {
+		if a.Namespace == "" && a.Key == name {
+			return a.Val, true
+		}
+	}
+	return "", false
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
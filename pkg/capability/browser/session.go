@@ -0,0 +1,186 @@
+package browser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTab     = "main"
+	defaultSession = "default"
+	defaultMaxBody = 5 << 20
+	userAgent      = "Mozilla/5.0 (compatible; spawn-browser/1.0)"
+)
+
+var errNoPage = errors.New("tab has no page open")
+
+// Fetcher supplies HTTP clients that enforce the agent's network policy.
+// *net.Capability implements it.
+type Fetcher interface {
+	Client(jar http.CookieJar) *http.Client
+}
+
+// sessionStateVersion is the SessionState format version.
+const sessionStateVersion = 1
+
+// SessionState is an exported session: enough to resume it, logged in, in
+// another process. Pages are reloaded from history when next used.
+type SessionState struct {
+	Version  int                 `json:"version"`
+	Viewport Viewport            `json:"viewport"`
+	Cookies  []Cookie            `json:"cookies"`
+	Tabs     map[string]TabState `json:"tabs"`
+}
+
+// TabState is a tab's history and position in it.
+type TabState struct {
+	History []string `json:"history"`
+	Index   int      `json:"index"`
+}
+
+// session is one browsing context of an agent. Its tabs share a cookie jar
+// and viewport. active and lastUsed are guarded by the pool's lock, the
+// rest by mu.
+type session struct {
+	mu       sync.Mutex
+	jar      *Jar
+	viewport Viewport
+	tabs     map[string]*tab
+
+	active   int
+	lastUsed time.Time
+}
+
+// tab holds a page and the history of URLs visited in it.
+type tab struct {
+	history []string
+	index   int
+	page    *Page
+}
+
+func newSession(viewport Viewport) *session {
+	return &session{jar: NewJar(), viewport: viewport, tabs: map[string]*tab{}}
+}
+
+func (s *session) export() *SessionState {
+	state := &SessionState{
+		Version:  sessionStateVersion,
+		Viewport: s.viewport,
+		Cookies:  s.jar.Export(),
+		Tabs:     make(map[string]TabState, len(s.tabs)),
+	}
+	for name, t := range s.tabs {
+		state.Tabs[name] = TabState{History: append([]string{}, t.history...), Index: t.index}
+	}
+	return state
+}
+
+func (s *session) restore(state *SessionState) error {
+	if state.Version != sessionStateVersion {
+		return fmt.Errorf("unsupported session state version %d", state.Version)
+	}
+	if state.Viewport.Width > 0 && state.Viewport.Height > 0 {
+		s.viewport = state.Viewport
+	}
+	s.jar.Import(state.Cookies)
+	s.tabs = make(map[string]*tab, len(state.Tabs))
+	for name, ts := range state.Tabs {
+		index := ts.Index
+		if index < -1 || index >= len(ts.History) {
+			index = len(ts.History) - 1
+		}
+		s.tabs[name] = &tab{history: append([]string{}, ts.History...), index: index}
+	}
+	return nil
+}
+
+func (s *session) tab(name string) *tab {
+	if name == "" {
+		name = defaultTab
+	}
+	t := s.tabs[name]
+	if t == nil {
+		t = &tab{index: -1}
+		s.tabs[name] = t
+	}
+	return t
+}
+
+// current returns the tab's page, reloading it from history after a
+// restore. It returns errNoPage if nothing was ever opened.
+func (c *Capability) current(ctx context.Context, s *session, t *tab) (*Page, error) {
+	if t.page != nil {
+		return t.page, nil
+	}
+	if t.index < 0 {
+		return nil, errNoPage
+	}
+	return c.navigate(ctx, s, t, "", t.history[t.index], nil, false)
+}
+
+// navigate fetches a URL in t. Relative URLs resolve against the current
+// page. With push, the URL becomes the newest history entry.
+func (c *Capability) navigate(ctx context.Context, s *session, t *tab, method, rawURL string, form url.Values, push bool) (*Page, error) {
+	if c.fetcher == nil {
+		return nil, fmt.Errorf("browser has no network access configured")
+	}
+	target, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return nil, fmt.Errorf("invalid url: %w", err)
+	}
+	if t.page != nil {
+		target = t.page.base().ResolveReference(target)
+	}
+	if !target.IsAbs() {
+		return nil, fmt.Errorf("invalid url: %q is not absolute", rawURL)
+	}
+	if method == "" {
+		method = http.MethodGet
+	}
+	var body io.Reader
+	if form != nil {
+		if method == http.MethodGet {
+			target.RawQuery = form.Encode()
+		} else {
+			body = strings.NewReader(form.Encode())
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,*/*;q=0.8")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if t.page != nil {
+		req.Header.Set("Referer", t.page.URL.String())
+	}
+	resp, err := c.fetcher.Client(s.jar).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, c.maxBody))
+	if err != nil {
+		return nil, err
+	}
+	page, err := parsePage(resp.Request.URL, resp.StatusCode, resp.Header.Get("Content-Type"), raw)
+	if err != nil {
+		return nil, fmt.Errorf("parse page: %w", err)
+	}
+	t.page = page
+	if push {
+		t.history = append(t.history[:t.index+1], page.URL.String())
+		t.index = len(t.history) - 1
+	}
+	return page, nil
+}
@@ -88,12 +88,22 @@ type Registry interface {
 // VectorStore represents vector memory storage.
 type VectorStore interface {
 	Put(ctx context.Context, key string, vec []float32) error
-	Search(ctx context.Context, vec []float32, limit int) ([]string, error)
+	Delete(ctx context.Context, key string) error
+	Search(ctx context.Context, vec []float32, limit int) ([]VectorMatch, error)
+}
+
+// VectorMatch is a vector search result. Higher scores are closer.
+type VectorMatch struct {
+	Key      string                 `json:"key"`
+	Score    float64                `json:"score"`
+	Text     string                 `json:"text,omitempty"`
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
 }
 
 // GraphStore represents graph memory storage.
 type GraphStore interface {
 	UpsertNode(ctx context.Context, id string, payload map[string]interface{}) error
+	UpsertEdge(ctx context.Context, from, edgeType, to string, props map[string]interface{}) error
 }
 
 // KVStore represents key/value memory storage.
@@ -3,6 +3,7 @@ package exec
 import (
 	"context"
 	"fmt"
+	"os"
 	"os/exec"
 	"time"
 
@@ -12,6 +13,7 @@ import (
 // Capability provides local command execution with timeout.
 type Capability struct {
 	languages map[string]struct{}
+	env       map[string]string
 }
 
 // New returns an exec capability with language allowlist.
@@ -23,6 +25,11 @@ func New(langs []string) *Capability {
 	return &Capability{languages: m}
 }
 
+// SetEnv adds variables to every command, such as the agent's egress proxy.
+func (c *Capability) SetEnv(env map[string]string) {
+	c.env = env
+}
+
 func (c *Capability) Name() string                                             { return "exec" }
 func (c *Capability) Version() string                                          { return "v1" }
 func (c *Capability) Description() string                                      { return "Execute sandboxed commands" }
@@ -36,17 +43,17 @@ func (c *Capability) Schema() *capability.Schema {
 
 func (c *Capability) Execute(ctx context.Context, req *capability.Request) (*capability.Response, error) {
 	if req == nil || req.Action != "run" {
-		return &capability.Response{Success: false, Error: &capability.Error{Code: "invalid_action", Message: "expected run"}}, nil
+		return capability.Failure("invalid_action", "expected run"), nil
 	}
 	lang, _ := req.Params["language"].(string)
 	if lang != "" {
 		if _, ok := c.languages[lang]; !ok {
-			return &capability.Response{Success: false, Error: &capability.Error{Code: "language_not_allowed", Message: lang}}, nil
+			return capability.Failure("language_not_allowed", lang), nil
 		}
 	}
 	cmdText, _ := req.Params["cmd"].(string)
 	if cmdText == "" {
-		return &capability.Response{Success: false, Error: &capability.Error{Code: "missing_cmd", Message: "cmd is required"}}, nil
+		return capability.Failure("missing_cmd", "cmd is required"), nil
 	}
 
 	timeout := req.Timeout
@@ -57,6 +64,12 @@ func (c *Capability) Execute(ctx context.Context, req *capability.Request) (*cap
 	defer cancel()
 
 	cmd := exec.CommandContext(runCtx, "sh", "-lc", cmdText)
+	if len(c.env) > 0 {
+		cmd.Env = os.Environ()
+		for k, v := range c.env {
+			cmd.Env = append(cmd.Env, k+"="+v)
+		}
+	}
 	out, err := cmd.CombinedOutput()
 	if err != nil {
 		return &capability.Response{Success: false, Data: string(out), Error: &capability.Error{Code: "exec_failed", Message: fmt.Sprintf("%v", err)}}, nil
@@ -0,0 +1,270 @@
+package fs
+
+import (
+	"fmt"
+	"strings"
+)
+
+const diffContext = 3
+
+type editKind int
+
+const (
+	editEqual editKind = iota
+	editDelete
+	editInsert
+)
+
+// edit is one line-level operation; a indexes the old lines for equal and
+// delete edits, b indexes the new lines for equal and insert edits.
+type edit struct {
+	kind editKind
+	a, b int
+}
+
+// Hunk is one unified-diff hunk. Lines carry their " ", "-" or "+" prefix and
+// their original line terminator.
+type Hunk struct {
+	OldStart int      `json:"old_start"`
+	OldLines int      `json:"old_lines"`
+	NewStart int      `json:"new_start"`
+	NewLines int      `json:"new_lines"`
+	Lines    []string `json:"lines"`
+}
+
+// String renders the hunk in unified diff format.
+func (h Hunk) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "@@ -%s +%s @@\n", hunkRange(h.OldStart, h.OldLines), hunkRange(h.NewStart, h.NewLines))
+	for _, line := range h.Lines {
+		b.WriteString(line)
+		if !strings.HasSuffix(line, "\n") {
+			b.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+	return b.String()
+}
+
+func hunkRange(start, lines int) string {
+	if lines == 1 {
+		return fmt.Sprintf("%d", start)
+	}
+	return fmt.Sprintf("%d,%d", start, lines)
+}
+
+// splitLines splits s into lines that keep their trailing "\n".
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// maxDiffEdits bounds the edits diffLines searches from each end of a
+// stretch of lines. Stretches that differ by more are replaced wholesale,
+// so diffing large, unrelated files takes O((N+M)·maxDiffEdits) time.
+const maxDiffEdits = 1000
+
+// diffLines computes a shortest edit script between a and b using the
+// linear-space variant of Myers' algorithm, which splits the problem at
+// the middle snake of an optimal path instead of keeping every step.
+func diffLines(a, b []string) []edit {
+	d := &differ{a: a, b: b}
+	d.compare(0, len(a), 0, len(b))
+	return d.edits
+}
+
+type differ struct {
+	a, b  []string
+	edits []edit
+}
+
+// compare appends the edits turning a[a0:a1] into b[b0:b1].
+func (d *differ) compare(a0, a1, b0, b1 int) {
+	for a0 < a1 && b0 < b1 && d.a[a0] == d.b[b0] {
+		d.edits = append(d.edits, edit{kind: editEqual, a: a0, b: b0})
+		a0++
+		b0++
+	}
+	suffix := 0
+	for a1-suffix > a0 && b1-suffix > b0 && d.a[a1-suffix-1] == d.b[b1-suffix-1] {
+		suffix++
+	}
+	a1 -= suffix
+	b1 -= suffix
+	if a0 < a1 && b0 < b1 {
+		if x0, y0, x1, y1, ok := d.middleSnake(a0, a1, b0, b1); ok {
+			d.compare(a0, x0, b0, y0)
+			for ; x0 < x1; x0, y0 = x0+1, y0+1 {
+				d.edits = append(d.edits, edit{kind: editEqual, a: x0, b: y0})
+			}
+			d.compare(x1, a1, y1, b1)
+			a0, b0 = a1, b1
+		}
+	}
+	for ; a0 < a1; a0++ {
+		d.edits = append(d.edits, edit{kind: editDelete, a: a0, b: b0})
+	}
+	for ; b0 < b1; b0++ {
+		d.edits = append(d.edits, edit{kind: editInsert, a: a1, b: b0})
+	}
+	for i := 0; i < suffix; i++ {
+		d.edits = append(d.edits, edit{kind: editEqual, a: a1 + i, b: b1 + i})
+	}
+}
+
+// middleSnake finds the snake, a run of equal lines from (x0, y0) to
+// (x1, y1), in the middle of a shortest edit script for a[a0:a1] and
+// b[b0:b1] by searching forward from the start and backward from the end
+// until the paths meet. Both ranges must be non-empty and differ at both
+// ends; ok is false when the script would be longer than 2·maxDiffEdits.
+func (d *differ) middleSnake(a0, a1, b0, b1 int) (x0, y0, x1, y1 int, ok bool) {
+	n, m := a1-a0, b1-b0
+	delta := n - m
+	odd := delta%2 != 0
+	maxD := min((n+m+1)/2, maxDiffEdits)
+	offset := maxD + 1
+	// fwd[offset+k] is the furthest x reached on diagonal x-y = k going
+	// forward; bwd holds the same for lines counted back from the ends.
+	fwd := make([]int, 2*maxD+3)
+	bwd := make([]int, 2*maxD+3)
+	for D := 0; D <= maxD; D++ {
+		for k := -D; k <= D; k += 2 {
+			var x int
+			if k == -D || (k != D && fwd[offset+k-1] < fwd[offset+k+1]) {
+				x = fwd[offset+k+1]
+			} else {
+				x = fwd[offset+k-1] + 1
+			}
+			y := x - k
+			sx, sy := x, y
+			for x < n && y < m && d.a[a0+x] == d.b[b0+y] {
+				x++
+				y++
+			}
+			fwd[offset+k] = x
+			if kb := delta - k; odd && kb >= -(D-1) && kb <= D-1 && x+bwd[offset+kb] >= n {
+				return a0 + sx, b0 + sy, a0 + x, b0 + y, true
+			}
+		}
+		for k := -D; k <= D; k += 2 {
+			var x int
+			if k == -D || (k != D && bwd[offset+k-1] < bwd[offset+k+1]) {
+				x = bwd[offset+k+1]
+			} else {
+				x = bwd[offset+k-1] + 1
+			}
+			y := x - k
+			sx, sy := x, y
+			for x < n && y < m && d.a[a1-1-x] == d.b[b1-1-y] {
+				x++
+				y++
+			}
+			bwd[offset+k] = x
+			if kf := delta - k; !odd && kf >= -D && kf <= D && x+fwd[offset+kf] >= n {
+				return a1 - x, b1 - y, a1 - sx, b1 - sy, true
+			}
+		}
+	}
+	return 0, 0, 0, 0, false
+}
+
+// diffHunks groups the edit script between a and b into hunks with context lines.
+func diffHunks(a, b []string) []Hunk {
+	edits := diffLines(a, b)
+	var hunks []Hunk
+	i := 0
+	for i < len(edits) {
+		for i < len(edits) && edits[i].kind == editEqual {
+			i++
+		}
+		if i == len(edits) {
+			break
+		}
+		start := i - diffContext
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for end < len(edits) {
+			if edits[end].kind != editEqual {
+				end++
+				continue
+			}
+			run := end
+			for run < len(edits) && edits[run].kind == editEqual {
+				run++
+			}
+			if run == len(edits) || run-end > 2*diffContext {
+				end += diffContext
+				if end > run {
+					end = run
+				}
+				break
+			}
+			end = run
+		}
+		hunks = append(hunks, buildHunk(a, b, edits[start:end]))
+		i = end
+	}
+	return hunks
+}
+
+func buildHunk(a, b []string, edits []edit) Hunk {
+	h := Hunk{OldStart: edits[0].a + 1, NewStart: edits[0].b + 1}
+	for _, e := range edits {
+		switch e.kind {
+		case editEqual:
+			h.Lines = append(h.Lines, " "+a[e.a])
+			h.OldLines++
+			h.NewLines++
+		case editDelete:
+			h.Lines = append(h.Lines, "-"+a[e.a])
+			h.OldLines++
+		case editInsert:
+			h.Lines = append(h.Lines, "+"+b[e.b])
+			h.NewLines++
+		}
+	}
+	if h.OldLines == 0 {
+		h.OldStart--
+	}
+	if h.NewLines == 0 {
+		h.NewStart--
+	}
+	return h
+}
+
+// unifiedDiff renders a unified diff between two file contents, or "" if equal.
+func unifiedDiff(fromName, toName, from, to string) string {
+	hunks := diffHunks(splitLines(from), splitLines(to))
+	if len(hunks) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", fromName, toName)
+	for _, h := range hunks {
+		b.WriteString(h.String())
+	}
+	return b.String()
+}
+
+// filePatch is unifiedDiff that summarizes binary content instead of diffing it.
+func filePatch(fromName, toName, from, to string) string {
+	if from != to && (isBinary(from) || isBinary(to)) {
+		return fmt.Sprintf("Binary files %s and %s differ\n", fromName, toName)
+	}
+	return unifiedDiff(fromName, toName, from, to)
+}
+
+// isBinary reports whether text contains a NUL byte near its start.
+func isBinary(text string) bool {
+	if len(text) > binarySniffBytes {
+		text = text[:binarySniffBytes]
+	}
+	return strings.IndexByte(text, 0) >= 0
+}
@@ -0,0 +1,455 @@
+package fs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+
+	"spawn.dev/pkg/capability"
+)
+
+const defaultPatchFuzz = 2
+
+// EditResult describes one file changed by an edit action. Hunks are
+// recomputed from the file contents before and after the edit.
+type EditResult struct {
+	Path    string `json:"path"`
+	Status  string `json:"status"`
+	Hunks   []Hunk `json:"hunks"`
+	Patch   string `json:"patch"`
+	Applied int    `json:"applied,omitempty"`
+}
+
+// PatchConflict is a hunk that apply_patch could not place.
+type PatchConflict struct {
+	Path     string `json:"path"`
+	Hunk     int    `json:"hunk"`
+	OldStart int    `json:"old_start"`
+	Reason   string `json:"reason"`
+}
+
+// filePatchSpec is the parsed patch for a single file. An empty oldName or
+// newName stands for /dev/null.
+type filePatchSpec struct {
+	oldName string
+	newName string
+	hunks   []Hunk
+}
+
+func (c *Capability) strReplace(params map[string]interface{}) *capability.Response {
+	p, _ := params["path"].(string)
+	oldStr, _ := params["old_str"].(string)
+	newStr, _ := params["new_str"].(string)
+	if oldStr == "" {
+		return capability.Failure("invalid_params", "old_str is required")
+	}
+	t, err := c.resolveWritable(p)
+	if err != nil {
+		return writeFailure(err)
+	}
+	before, err := c.readTarget(t)
+	if err != nil {
+		return editReadFailure(p, err)
+	}
+	count := strings.Count(before, oldStr)
+	switch {
+	case count == 0:
+		return capability.Failure("no_match", "old_str not found in "+p)
+	case count > 1 && !boolParam(params, "replace_all"):
+		return capability.Failure("ambiguous_match", fmt.Sprintf("old_str matches %d times in %s; add context or set replace_all", count, p))
+	}
+	after := strings.ReplaceAll(before, oldStr, newStr)
+	if resp := c.putFile(t, []byte(after)); resp != nil {
+		return resp
+	}
+	return &capability.Response{Success: true, Data: editResult(t, "modified", before, after)}
+}
+
+func (c *Capability) replaceRange(params map[string]interface{}) *capability.Response {
+	p, _ := params["path"].(string)
+	start := capability.IntParam(params, "start_line", 0)
+	end := capability.IntParam(params, "end_line", start)
+	content, _ := params["content"].(string)
+	t, err := c.resolveWritable(p)
+	if err != nil {
+		return writeFailure(err)
+	}
+	before, err := c.readTarget(t)
+	if err != nil {
+		return editReadFailure(p, err)
+	}
+	lines := splitLines(before)
+	if start < 1 || start > len(lines)+1 || end < start-1 || end > len(lines) {
+		return capability.Failure("invalid_params", fmt.Sprintf("line range %d-%d is outside %s (%d lines)", start, end, p, len(lines)))
+	}
+	if content != "" && !strings.HasSuffix(content, "\n") && end < len(lines) {
+		content += "\n"
+	}
+	if start > 1 && start-1 == len(lines) && !strings.HasSuffix(lines[start-2], "\n") {
+		lines[start-2] += "\n"
+	}
+	after := strings.Join(lines[:start-1], "") + content + strings.Join(lines[end:], "")
+	if resp := c.putFile(t, []byte(after)); resp != nil {
+		return resp
+	}
+	return &capability.Response{Success: true, Data: editResult(t, "modified", before, after)}
+}
+
+// applyPatch applies a unified diff. Every file is patched in memory first, so
+// a conflict in any hunk leaves the workspace untouched.
+func (c *Capability) applyPatch(params map[string]interface{}) *capability.Response {
+	text, _ := params["patch"].(string)
+	if strings.TrimSpace(text) == "" {
+		return capability.Failure("invalid_params", "patch is required")
+	}
+	fuzz := capability.IntParam(params, "fuzz", defaultPatchFuzz)
+	specs, err := parsePatch(text, capability.StringParam(params, "path", ""))
+	if err != nil {
+		return capability.Failure("invalid_patch", err.Error())
+	}
+
+	type pending struct {
+		t       target
+		src     target
+		status  string
+		before  string
+		after   string
+		applied int
+	}
+	var edits []pending
+	var conflicts []PatchConflict
+	for _, spec := range specs {
+		name := spec.newName
+		status := "modified"
+		switch {
+		case spec.oldName == "":
+			status = "added"
+		case spec.newName == "":
+			name, status = spec.oldName, "deleted"
+		case spec.oldName != spec.newName:
+			status = "renamed"
+		}
+		t, err := c.resolveWritable(name)
+		if err != nil {
+			return writeFailure(err)
+		}
+		before, src := "", t
+		if status != "added" {
+			if spec.oldName != name {
+				if src, err = c.resolveWritable(spec.oldName); err != nil {
+					return writeFailure(err)
+				}
+			}
+			if before, err = c.readTarget(src); err != nil {
+				return editReadFailure(spec.oldName, err)
+			}
+		} else if t.exists() {
+			conflicts = append(conflicts, PatchConflict{Path: name, Hunk: 1, Reason: "file already exists"})
+			continue
+		}
+		lines, failed := applyHunks(splitLines(before), spec.hunks, fuzz)
+		for _, f := range failed {
+			f.Path = name
+			conflicts = append(conflicts, f)
+		}
+		after := strings.Join(lines, "")
+		if status == "deleted" && len(failed) == 0 && after != "" {
+			conflicts = append(conflicts, PatchConflict{Path: name, Reason: "file is not empty after removing its lines"})
+		}
+		edits = append(edits, pending{t: t, src: src, status: status, before: before, after: after, applied: len(spec.hunks) - len(failed)})
+	}
+	if len(conflicts) > 0 {
+		resp := capability.Failure("patch_conflict", fmt.Sprintf("%d hunk(s) did not apply; no files were changed", len(conflicts)))
+		resp.Data = conflicts
+		return resp
+	}
+
+	results := make([]EditResult, 0, len(edits))
+	for _, e := range edits {
+		if e.status != "deleted" {
+			if resp := c.putFile(e.t, []byte(e.after)); resp != nil {
+				return resp
+			}
+		}
+		if e.status == "deleted" || e.status == "renamed" {
+			freed, _ := diskUsage(e.src.host)
+			if err := c.removePath(e.src, false); err != nil {
+				return capability.Failure("delete_failed", err.Error())
+			}
+			_ = c.reserve(e.src.mount, -freed)
+		}
+		r := editResult(e.t, e.status, e.before, e.after)
+		r.Applied = e.applied
+		results = append(results, r)
+	}
+	return &capability.Response{Success: true, Data: results}
+}
+
+func editResult(t target, status, before, after string) EditResult {
+	p := display(t.virtual)
+	fromName, toName := "a/"+p, "b/"+p
+	switch status {
+	case "added":
+		fromName = "/dev/null"
+	case "deleted":
+		toName = "/dev/null"
+	}
+	hunks := diffHunks(splitLines(before), splitLines(after))
+	if hunks == nil {
+		hunks = []Hunk{}
+	}
+	return EditResult{Path: p, Status: status, Hunks: hunks, Patch: unifiedDiff(fromName, toName, before, after)}
+}
+
+func editReadFailure(p string, err error) *capability.Response {
+	if errors.Is(err, fs.ErrNotExist) {
+		return capability.Failure("not_found", p)
+	}
+	return capability.Failure("read_failed", err.Error())
+}
+
+// readTarget returns the contents of a resolved file.
+func (c *Capability) readTarget(t target) (string, error) {
+	f, err := c.openFile(t, os.O_RDONLY, 0)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	b, err := io.ReadAll(f)
+	return string(b), err
+}
+
+// putFile replaces the contents of t, charging the size change against the
+// mount quota. It returns nil on success.
+func (c *Capability) putFile(t target, content []byte) *capability.Response {
+	delta := int64(len(content)) - fileSize(t.host)
+	if err := c.reserve(t.mount, delta); err != nil {
+		return writeFailure(err)
+	}
+	if err := c.prepareWrite(t); err != nil {
+		_ = c.reserve(t.mount, -delta)
+		return capability.Failure("mkdir_failed", err.Error())
+	}
+	if err := c.writeFile(t, content); err != nil {
+		_ = c.reserve(t.mount, -delta)
+		return capability.Failure("write_failed", err.Error())
+	}
+	return nil
+}
+
+// parsePatch splits a unified diff into per-file patches. Git extended
+// headers are ignored. A patch made only of hunks applies to defaultPath.
+func parsePatch(text, defaultPath string) ([]filePatchSpec, error) {
+	lines := splitLines(text)
+	var specs []filePatchSpec
+	var cur *filePatchSpec
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimRight(lines[i], "\r\n")
+		switch {
+		case strings.HasPrefix(line, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ "):
+			specs = append(specs, filePatchSpec{
+				oldName: patchName(line[4:]),
+				newName: patchName(strings.TrimRight(lines[i+1][4:], "\r\n")),
+			})
+			cur = &specs[len(specs)-1]
+			i++
+		case strings.HasPrefix(line, "@@ "):
+			if cur == nil {
+				if defaultPath == "" {
+					return nil, fmt.Errorf("hunk without file header; pass path")
+				}
+				specs = append(specs, filePatchSpec{oldName: defaultPath, newName: defaultPath})
+				cur = &specs[len(specs)-1]
+			}
+			h, err := parseHunkHeader(line)
+			if err != nil {
+				return nil, err
+			}
+			oldLeft, newLeft := h.OldLines, h.NewLines
+			for oldLeft > 0 || newLeft > 0 {
+				i++
+				if i >= len(lines) {
+					return nil, fmt.Errorf("hunk %q is truncated", line)
+				}
+				body := lines[i]
+				if body == "\n" || body == "\r\n" {
+					body = " " + body
+				}
+				switch body[0] {
+				case ' ':
+					oldLeft--
+					newLeft--
+				case '-':
+					oldLeft--
+				case '+':
+					newLeft--
+				case '\\':
+					markNoNewline(h.Lines)
+					continue
+				default:
+					return nil, fmt.Errorf("unexpected line in hunk %q: %q", line, strings.TrimRight(body, "\n"))
+				}
+				if oldLeft < 0 || newLeft < 0 {
+					return nil, fmt.Errorf("hunk %q has more lines than its header declares", line)
+				}
+				h.Lines = append(h.Lines, body)
+			}
+			if i+1 < len(lines) && strings.HasPrefix(lines[i+1], `\`) {
+				i++
+				markNoNewline(h.Lines)
+			}
+			cur.hunks = append(cur.hunks, h)
+		}
+	}
+	if len(specs) == 0 {
+		return nil, fmt.Errorf("no hunks found")
+	}
+	return specs, nil
+}
+
+// patchName strips the a/ or b/ prefix and any timestamp from a header name.
+func patchName(name string) string {
+	if i := strings.IndexByte(name, '\t'); i >= 0 {
+		name = name[:i]
+	}
+	name = strings.TrimSpace(name)
+	if name == "/dev/null" {
+		return ""
+	}
+	if strings.HasPrefix(name, "a/") || strings.HasPrefix(name, "b/") {
+		return name[2:]
+	}
+	return name
+}
+
+func parseHunkHeader(line string) (Hunk, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 4 || fields[3] != "@@" && !strings.HasPrefix(fields[3], "@@") {
+		return Hunk{}, fmt.Errorf("invalid hunk header %q", line)
+	}
+	var h Hunk
+	var err error
+	if h.OldStart, h.OldLines, err = parseHunkRange(fields[1], "-"); err != nil {
+		return Hunk{}, fmt.Errorf("invalid hunk header %q: %w", line, err)
+	}
+	if h.NewStart, h.NewLines, err = parseHunkRange(fields[2], "+"); err != nil {
+		return Hunk{}, fmt.Errorf("invalid hunk header %q: %w", line, err)
+	}
+	return h, nil
+}
+
+func parseHunkRange(s, prefix string) (start, lines int, err error) {
+	if !strings.HasPrefix(s, prefix) {
+		return 0, 0, fmt.Errorf("range %q lacks %q", s, prefix)
+	}
+	s = s[1:]
+	lines = 1
+	if i := strings.IndexByte(s, ','); i >= 0 {
+		if lines, err = strconv.Atoi(s[i+1:]); err != nil {
+			return 0, 0, err
+		}
+		s = s[:i]
+	}
+	start, err = strconv.Atoi(s)
+	return start, lines, err
+}
+
+// markNoNewline strips the terminator from the last hunk line, following a
+// "\ No newline at end of file" marker.
+func markNoNewline(lines []string) {
+	if n := len(lines); n > 0 {
+		lines[n-1] = strings.TrimSuffix(strings.TrimSuffix(lines[n-1], "\n"), "\r")
+	}
+}
+
+// applyHunks applies hunks in order. A hunk is placed at its stated position
+// adjusted by the offset of earlier hunks, or at the nearest position where
+// it matches; with fuzz > 0 up to fuzz outer context lines may be ignored.
+func applyHunks(lines []string, hunks []Hunk, fuzz int) ([]string, []PatchConflict) {
+	var out []string
+	var conflicts []PatchConflict
+	cursor, offset := 0, 0
+	for n, h := range hunks {
+		var old, repl []string
+		for _, l := range h.Lines {
+			switch l[0] {
+			case ' ':
+				old = append(old, l[1:])
+				repl = append(repl, l[1:])
+			case '-':
+				old = append(old, l[1:])
+			case '+':
+				repl = append(repl, l[1:])
+			}
+		}
+		want := h.OldStart - 1
+		if h.OldLines == 0 {
+			want = h.OldStart
+		}
+		pos, trimLead, trimTail := -1, 0, 0
+		for f := 0; f <= fuzz && pos < 0; f++ {
+			lead := min(f, leadingContext(h.Lines))
+			tail := min(f, trailingContext(h.Lines))
+			if lead+tail > len(old) || (f > 0 && lead == 0 && tail == 0) {
+				break
+			}
+			pos = findLines(lines, old[lead:len(old)-tail], want+offset+lead, cursor)
+			trimLead, trimTail = lead, tail
+		}
+		if pos < 0 {
+			conflicts = append(conflicts, PatchConflict{Hunk: n + 1, OldStart: h.OldStart, Reason: "context does not match"})
+			continue
+		}
+		out = append(out, lines[cursor:pos]...)
+		out = append(out, repl[trimLead:len(repl)-trimTail]...)
+		cursor = pos + len(old) - trimLead - trimTail
+		offset = pos - trimLead - want
+	}
+	out = append(out, lines[cursor:]...)
+	return out, conflicts
+}
+
+func leadingContext(lines []string) int {
+	n := 0
+	for n < len(lines) && lines[n][0] == ' ' {
+		n++
+	}
+	return n
+}
+
+func trailingContext(lines []string) int {
+	n := 0
+	for n < len(lines) && lines[len(lines)-1-n][0] == ' ' {
+		n++
+	}
+	return n
+}
+
+// findLines returns the index at or after floor where want occurs, choosing
+// the occurrence closest to hint, or -1.
+func findLines(lines, want []string, hint, floor int) int {
+	matches := func(at int) bool {
+		if at < floor || at+len(want) > len(lines) {
+			return false
+		}
+		for i, l := range want {
+			if lines[at+i] != l {
+				return false
+			}
+		}
+		return true
+	}
+	for d := 0; hint-d >= floor || hint+d <= len(lines); d++ {
+		if matches(hint - d) {
+			return hint - d
+		}
+		if d > 0 && matches(hint+d) {
+			return hint + d
+		}
+	}
+	return -1
+}
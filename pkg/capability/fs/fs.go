@@ -1,23 +1,36 @@
 package fs
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
-	"strings"
+	"sync"
 
 	"spawn.dev/pkg/capability"
+	"spawn.dev/pkg/mesh"
+	"spawn.dev/pkg/observability"
 )
 
-// Capability provides a basic virtual filesystem rooted at baseDir.
+// Capability provides a virtual filesystem composed of mounts.
 type Capability struct {
-	baseDir string
-	baseAbs string
+	baseDir     string
+	baseAbs     string
+	mounts      []*mountPoint
+	symlinks    SymlinkPolicy
+	snapshotDir string
+	events      *observability.EventStream
+	mesh        mesh.Mesh
+	agentID     string
+	watches     map[string]*watcher
+	watchedDirs int
+	mu          sync.Mutex
 }
 
-// New returns a filesystem capability.
+// New returns a filesystem capability with baseDir mounted read-write at the root.
 func New(baseDir string) *Capability {
 	if baseDir == "" {
 		baseDir = "."
@@ -26,103 +39,256 @@ func New(baseDir string) *Capability {
 	if err != nil {
 		baseAbs = baseDir
 	}
-	return &Capability{baseDir: baseDir, baseAbs: filepath.Clean(baseAbs)}
+	baseAbs = realPath(filepath.Clean(baseAbs))
+	return &Capability{
+		baseDir:  baseDir,
+		baseAbs:  baseAbs,
+		mounts:   []*mountPoint{{virtual: "/", source: baseAbs, mode: ModeReadWrite}},
+		symlinks: SymlinkPolicy{Follow: SymlinksWithin},
+	}
 }
 
-func (c *Capability) Name() string                                             { return "fs" }
-func (c *Capability) Version() string                                          { return "v1" }
-func (c *Capability) Description() string                                      { return "Virtual filesystem operations" }
-func (c *Capability) Initialize(context.Context, map[string]interface{}) error { return nil }
-func (c *Capability) Shutdown(context.Context) error                           { return nil }
-func (c *Capability) HealthCheck(context.Context) error                        { return nil }
+// NewWithMounts returns a filesystem capability whose namespace is built from
+// mounts. Mounts without a Source are backed by baseDir joined with their path,
+// and mounts without a Mode are read-only.
+func NewWithMounts(baseDir string, mounts []Mount) (*Capability, error) {
+	if len(mounts) == 0 {
+		return New(baseDir), nil
+	}
+	c := New(baseDir)
+	c.mounts = nil
+	seen := map[string]bool{}
+	for _, mount := range mounts {
+		m, err := newMountPoint(c.baseAbs, mount)
+		if err != nil {
+			return nil, fmt.Errorf("new fs capability: %w", err)
+		}
+		if seen[m.virtual] {
+			return nil, fmt.Errorf("new fs capability: duplicate mount %s", m.virtual)
+		}
+		seen[m.virtual] = true
+		if err := os.MkdirAll(m.source, 0o755); err != nil {
+			return nil, fmt.Errorf("new fs capability: create mount source: %w", err)
+		}
+		m.source = realPath(m.source)
+		if m.lower != "" {
+			if info, err := os.Stat(m.lower); err != nil || !info.IsDir() {
+				return nil, fmt.Errorf("new fs capability: overlay lower dir %s is not a directory", m.lower)
+			}
+			m.lower = realPath(m.lower)
+		}
+		c.mounts = append(c.mounts, m)
+	}
+	c.sortMounts()
+	return c, nil
+}
+
+func (c *Capability) Name() string                      { return "fs" }
+func (c *Capability) Version() string                   { return "v1" }
+func (c *Capability) Description() string               { return "Virtual filesystem operations" }
+func (c *Capability) HealthCheck(context.Context) error { return nil }
+
+// Shutdown stops all active watches.
+func (c *Capability) Shutdown(context.Context) error {
+	c.closeWatches()
+	return nil
+}
+
+// Initialize applies optional config keys: "symlinks" ({"follow": "within"|"deny",
+// "create": bool}) and "snapshot_dir".
+func (c *Capability) Initialize(_ context.Context, config map[string]interface{}) error {
+	if dir, ok := config["snapshot_dir"].(string); ok && dir != "" {
+		if err := c.SetSnapshotDir(dir); err != nil {
+			return err
+		}
+	}
+	raw, ok := config["symlinks"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	follow, _ := raw["follow"].(string)
+	create, _ := raw["create"].(bool)
+	return c.SetSymlinkPolicy(SymlinkPolicy{Follow: follow, Create: create})
+}
 
 func (c *Capability) Schema() *capability.Schema {
-	return &capability.Schema{Actions: []capability.Action{{Name: "read"}, {Name: "write"}, {Name: "copy"}}}
+	return &capability.Schema{Actions: []capability.Action{
+		{Name: "read"},
+		{Name: "write"},
+		{Name: "copy"},
+		{Name: "list", Description: "List directory entries, optionally recursive up to depth"},
+		{Name: "stat", Description: "Return metadata for one path"},
+		{Name: "delete", Description: "Delete a file or directory"},
+		{Name: "move", Description: "Move or rename a path"},
+		{Name: "mkdir", Description: "Create a directory and its parents"},
+		{Name: "glob", Description: "Match paths against a glob pattern (supports **)"},
+		{Name: "search", Description: "Search file contents with a regular expression"},
+		{Name: "mounts", Description: "List mounts with mode and quota usage"},
+		{Name: "symlink", Description: "Create a symlink whose target stays within its mount"},
+		{Name: "snapshot", Description: "Capture a content-addressed snapshot of writable mounts"},
+		{Name: "list_snapshots", Description: "List snapshots oldest first"},
+		{Name: "diff", Description: "Unified diff between two snapshots, or a snapshot and the workspace"},
+		{Name: "restore", Description: "Roll the workspace back to a snapshot"},
+		{Name: "export_changes", Description: "Export an overlay's upper layer as a changeset and unified patch"},
+		{Name: "apply_patch", Description: "Apply a unified diff with fuzz; conflicts leave files unchanged"},
+		{Name: "replace_range", Description: "Replace lines start_line..end_line of a file"},
+		{Name: "str_replace", Description: "Replace an exact string that must occur once unless replace_all is set"},
+		{Name: "watch", Description: "Watch a path for create, modify and delete events with glob filters and debounce"},
+		{Name: "watch_events", Description: "Drain events buffered for a watch"},
+		{Name: "unwatch", Description: "Stop a watch"},
+	}}
 }
 
-func (c *Capability) Execute(_ context.Context, req *capability.Request) (*capability.Response, error) {
+func (c *Capability) Execute(ctx context.Context, req *capability.Request) (*capability.Response, error) {
 	if req == nil {
-		return &capability.Response{Success: false, Error: &capability.Error{Code: "invalid_request", Message: "nil request"}}, nil
+		return capability.Failure("invalid_request", "nil request"), nil
 	}
 	switch req.Action {
 	case "read":
-		path, _ := req.Params["path"].(string)
-		resolved, err := c.resolve(path)
-		if err != nil {
-			return &capability.Response{Success: false, Error: &capability.Error{Code: "access_denied", Message: err.Error()}}, nil
-		}
-		b, err := os.ReadFile(resolved)
-		if err != nil {
-			return &capability.Response{Success: false, Error: &capability.Error{Code: "read_failed", Message: err.Error()}}, nil
-		}
-		return &capability.Response{Success: true, Data: string(b)}, nil
+		return c.read(req.Params), nil
 	case "write":
-		path, _ := req.Params["path"].(string)
-		resolved, err := c.resolve(path)
-		if err != nil {
-			return &capability.Response{Success: false, Error: &capability.Error{Code: "access_denied", Message: err.Error()}}, nil
-		}
-		content, _ := req.Params["content"].(string)
-		if err := os.MkdirAll(filepath.Dir(resolved), 0o755); err != nil {
-			return &capability.Response{Success: false, Error: &capability.Error{Code: "mkdir_failed", Message: err.Error()}}, nil
-		}
-		if err := os.WriteFile(resolved, []byte(content), 0o644); err != nil {
-			return &capability.Response{Success: false, Error: &capability.Error{Code: "write_failed", Message: err.Error()}}, nil
-		}
-		return &capability.Response{Success: true}, nil
+		return c.write(req.Params), nil
 	case "copy":
-		src, _ := req.Params["src"].(string)
-		dst, _ := req.Params["dst"].(string)
-		if src == "" || dst == "" {
-			return &capability.Response{Success: false, Error: &capability.Error{Code: "invalid_params", Message: "src and dst required"}}, nil
-		}
-		resolvedSrc, err := c.resolve(src)
-		if err != nil {
-			return &capability.Response{Success: false, Error: &capability.Error{Code: "access_denied", Message: err.Error()}}, nil
-		}
-		resolvedDst, err := c.resolve(dst)
-		if err != nil {
-			return &capability.Response{Success: false, Error: &capability.Error{Code: "access_denied", Message: err.Error()}}, nil
-		}
-		if err := c.copy(resolvedSrc, resolvedDst); err != nil {
-			return &capability.Response{Success: false, Error: &capability.Error{Code: "copy_failed", Message: err.Error()}}, nil
-		}
-		return &capability.Response{Success: true}, nil
+		return c.copyAction(req.Params), nil
+	case "list":
+		return c.list(req.Params), nil
+	case "stat":
+		return c.stat(req.Params), nil
+	case "delete":
+		return c.delete(req.Params), nil
+	case "move":
+		return c.move(req.Params), nil
+	case "mkdir":
+		return c.mkdir(req.Params), nil
+	case "glob":
+		return c.glob(req.Params), nil
+	case "search":
+		return c.search(ctx, req.Params), nil
+	case "mounts":
+		return c.mountsAction(), nil
+	case "symlink":
+		return c.symlink(req.Params), nil
+	case "snapshot":
+		return c.snapshotAction(ctx, req.Params), nil
+	case "list_snapshots":
+		return c.listSnapshots(), nil
+	case "diff":
+		return c.diffAction(ctx, req.Params), nil
+	case "restore":
+		return c.restoreAction(ctx, req.Params), nil
+	case "export_changes":
+		return c.exportChanges(req.Params), nil
+	case "apply_patch":
+		return c.applyPatch(req.Params), nil
+	case "replace_range":
+		return c.replaceRange(req.Params), nil
+	case "str_replace":
+		return c.strReplace(req.Params), nil
+	case "watch":
+		return c.watchAction(req.Params), nil
+	case "watch_events":
+		return c.watchEventsAction(req.Params), nil
+	case "unwatch":
+		return c.unwatchAction(req.Params), nil
 	default:
-		return &capability.Response{Success: false, Error: &capability.Error{Code: "invalid_action", Message: req.Action}}, nil
+		return capability.Failure("invalid_action", req.Action), nil
 	}
 }
 
-func (c *Capability) resolve(path string) (string, error) {
-	if strings.TrimSpace(path) == "" {
-		return "", fmt.Errorf("path is required")
+func (c *Capability) read(params map[string]interface{}) *capability.Response {
+	path, _ := params["path"].(string)
+	t, err := c.resolveTarget(path)
+	if err != nil {
+		return capability.Failure("access_denied", err.Error())
 	}
-	cleanRel := filepath.Clean(path)
-	resolved := filepath.Join(c.baseAbs, cleanRel)
-	absResolved, err := filepath.Abs(resolved)
+	content, err := c.readTarget(t)
 	if err != nil {
-		return "", fmt.Errorf("resolve path: %w", err)
+		return capability.Failure("read_failed", err.Error())
 	}
-	rel, err := filepath.Rel(c.baseAbs, absResolved)
+	return &capability.Response{Success: true, Data: content}
+}
+
+func (c *Capability) write(params map[string]interface{}) *capability.Response {
+	path, _ := params["path"].(string)
+	t, err := c.resolveWritable(path)
+	if err != nil {
+		return writeFailure(err)
+	}
+	content, _ := params["content"].(string)
+	if resp := c.putFile(t, []byte(content)); resp != nil {
+		return resp
+	}
+	return &capability.Response{Success: true}
+}
+
+func (c *Capability) copyAction(params map[string]interface{}) *capability.Response {
+	src, _ := params["src"].(string)
+	dst, _ := params["dst"].(string)
+	if src == "" || dst == "" {
+		return capability.Failure("invalid_params", "src and dst required")
+	}
+	from, err := c.resolveTarget(src)
+	if err != nil {
+		return capability.Failure("access_denied", err.Error())
+	}
+	to, err := c.resolveWritable(dst)
 	if err != nil {
-		return "", fmt.Errorf("resolve path: %w", err)
+		return writeFailure(err)
 	}
-	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
-		return "", fmt.Errorf("path escapes root")
+	delta := fileSize(from.readPath()) - fileSize(to.host)
+	if err := c.reserve(to.mount, delta); err != nil {
+		return writeFailure(err)
 	}
-	return absResolved, nil
+	if err := c.copy(from, to); err != nil {
+		_ = c.reserve(to.mount, -delta)
+		return capability.Failure("copy_failed", err.Error())
+	}
+	return &capability.Response{Success: true}
+}
+
+func (c *Capability) mountsAction() *capability.Response {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make([]MountInfo, 0, len(c.mounts))
+	for _, m := range c.mounts {
+		out = append(out, MountInfo{Path: m.virtual, Source: m.source, Mode: m.mode, Quota: m.quota, Used: m.used, Lower: m.lower})
+	}
+	return &capability.Response{Success: true, Data: out}
+}
+
+// resolveWritable resolves path and rejects targets on read-only mounts.
+func (c *Capability) resolveWritable(path string) (target, error) {
+	return writable(c.resolveTarget(path))
+}
+
+// resolveWritableLink is resolveWritable without following a final symlink.
+func (c *Capability) resolveWritableLink(path string) (target, error) {
+	return writable(c.resolveLink(path))
 }
 
-func (c *Capability) copy(src, dst string) error {
-	in, err := os.Open(src)
+func writable(t target, err error) (target, error) {
+	if err != nil {
+		return target{}, err
+	}
+	if !t.mount.writable() {
+		return target{}, fmt.Errorf("%w: %s", errReadOnly, t.mount.virtual)
+	}
+	return t, nil
+}
+
+func (c *Capability) copy(src, dst target) error {
+	in, err := c.openFile(src, os.O_RDONLY, 0)
 	if err != nil {
 		return fmt.Errorf("open src: %w", err)
 	}
 	defer in.Close()
-	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+	if err := c.prepareWrite(dst); err != nil {
 		return fmt.Errorf("mkdir dst: %w", err)
 	}
-	out, err := os.Create(dst)
+	if dst.mount.work != "" {
+		return c.stageFile(dst, in)
+	}
+	out, err := c.openFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
 	if err != nil {
 		return fmt.Errorf("create dst: %w", err)
 	}
@@ -132,3 +298,30 @@ func (c *Capability) copy(src, dst string) error {
 	}
 	return nil
 }
+
+func (c *Capability) writeFile(t target, content []byte) error {
+	if t.mount.work != "" {
+		return c.stageFile(t, bytes.NewReader(content))
+	}
+	f, err := c.openFile(t, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeFailure maps mount policy errors onto their structured error codes.
+func writeFailure(err error) *capability.Response {
+	switch {
+	case errors.Is(err, errReadOnly):
+		return capability.Failure("read_only", err.Error())
+	case errors.Is(err, errQuotaExceeded):
+		return capability.Failure("quota_exceeded", err.Error())
+	default:
+		return capability.Failure("access_denied", err.Error())
+	}
+}
@@ -2,10 +2,16 @@ package fs
 
 import (
 	"context"
+	"fmt"
+	"math/rand/v2"
+	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"spawn.dev/pkg/capability"
+	"spawn.dev/pkg/observability"
 )
 
 func TestFSWriteReadAndTraversalProtection(t *testing.T) {
@@ -46,3 +52,754 @@ func TestFSWriteReadAndTraversalProtection(t *testing.T) {
 		t.Fatalf("expected traversal to be blocked")
 	}
 }
+
+func TestFSWorkspaceExplorationActions(t *testing.T) {
+	t.Parallel()
+	base := t.TempDir()
+	cap := New(base)
+	ctx := context.Background()
+	exec := func(action string, params map[string]interface{}) *capability.Response {
+		t.Helper()
+		resp, err := cap.Execute(ctx, &capability.Request{Action: action, Params: params})
+		if err != nil {
+			t.Fatalf("%s execute: %v", action, err)
+		}
+		if !resp.Success {
+			t.Fatalf("%s failed: %#v", action, resp.Error)
+		}
+		return resp
+	}
+
+	exec("write", map[string]interface{}{"path": "src/main.go", "content": "package main\n// TODO: fix\n"})
+	exec("write", map[string]interface{}{"path": "src/pkg/util.go", "content": "package pkg\n// TODO: test\n"})
+	exec("write", map[string]interface{}{"path": "README.md", "content": "TODO docs\n"})
+	exec("mkdir", map[string]interface{}{"path": "empty/dir"})
+
+	shallow := exec("list", map[string]interface{}{"path": "."}).Data.([]FileInfo)
+	if len(shallow) != 3 {
+		t.Fatalf("expected 3 top-level entries, got %d", len(shallow))
+	}
+	deep := exec("list", map[string]interface{}{"path": ".", "recursive": true, "depth": float64(2)}).Data.([]FileInfo)
+	if len(deep) != 6 {
+		t.Fatalf("expected 6 entries at depth 2, got %d: %#v", len(deep), deep)
+	}
+
+	info := exec("stat", map[string]interface{}{"path": "src/main.go"}).Data.(FileInfo)
+	if info.IsDir || info.Path != "src/main.go" || info.Size == 0 {
+		t.Fatalf("unexpected stat %#v", info)
+	}
+
+	globbed := exec("glob", map[string]interface{}{"pattern": "**/*.go"}).Data.([]string)
+	if len(globbed) != 2 {
+		t.Fatalf("expected 2 go files, got %v", globbed)
+	}
+
+	found := exec("search", map[string]interface{}{"pattern": `TODO: \w+`, "glob": "*.go"}).Data.(SearchResult)
+	if len(found.Matches) != 2 || found.Matches[0].Line != 2 {
+		t.Fatalf("unexpected search result %#v", found)
+	}
+	limited := exec("search", map[string]interface{}{"pattern": "TODO", "max_matches": float64(1)}).Data.(SearchResult)
+	if len(limited.Matches) != 1 || !limited.Truncated {
+		t.Fatalf("expected truncated single match, got %#v", limited)
+	}
+
+	exec("move", map[string]interface{}{"src": "README.md", "dst": "docs/README.md"})
+	exec("delete", map[string]interface{}{"path": "src", "recursive": true})
+	remaining := exec("glob", map[string]interface{}{"pattern": "**"}).Data.([]string)
+	if len(remaining) != 4 {
+		t.Fatalf("unexpected remaining paths %v", remaining)
+	}
+
+	resp, err := cap.Execute(ctx, &capability.Request{Action: "delete", Params: map[string]interface{}{"path": "../outside"}})
+	if err != nil || resp.Success {
+		t.Fatalf("expected escaping delete to be denied, got %#v %v", resp, err)
+	}
+}
+
+func TestFSMountModesAndQuotas(t *testing.T) {
+	t.Parallel()
+	base := t.TempDir()
+	dataset := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dataset, "input.csv"), []byte("a,b\n"), 0o644); err != nil {
+		t.Fatalf("seed dataset: %v", err)
+	}
+	cap, err := NewWithMounts(base, []Mount{
+		{Path: "/workspace", Mode: "rw", Quota: "10B"},
+		{Path: "/data", Source: dataset, Mode: "ro"},
+	})
+	if err != nil {
+		t.Fatalf("new with mounts: %v", err)
+	}
+	ctx := context.Background()
+	run := func(action string, params map[string]interface{}) *capability.Response {
+		t.Helper()
+		resp, err := cap.Execute(ctx, &capability.Request{Action: action, Params: params})
+		if err != nil {
+			t.Fatalf("%s execute: %v", action, err)
+		}
+		return resp
+	}
+
+	if resp := run("read", map[string]interface{}{"path": "/data/input.csv"}); !resp.Success || resp.Data.(string) != "a,b\n" {
+		t.Fatalf("expected read through ro mount, got %#v", resp)
+	}
+	if resp := run("write", map[string]interface{}{"path": "/data/out.txt", "content": "x"}); resp.Success || resp.Error.Code != "read_only" {
+		t.Fatalf("expected read_only error, got %#v", resp)
+	}
+	if resp := run("write", map[string]interface{}{"path": "/workspace/a.txt", "content": "12345678"}); !resp.Success {
+		t.Fatalf("expected write within quota, got %#v", resp.Error)
+	}
+	if resp := run("write", map[string]interface{}{"path": "/workspace/b.txt", "content": "12345"}); resp.Success || resp.Error.Code != "quota_exceeded" {
+		t.Fatalf("expected quota_exceeded, got %#v", resp)
+	}
+	if resp := run("delete", map[string]interface{}{"path": "/workspace/a.txt"}); !resp.Success {
+		t.Fatalf("delete: %#v", resp.Error)
+	}
+	if resp := run("write", map[string]interface{}{"path": "/workspace/b.txt", "content": "12345"}); !resp.Success {
+		t.Fatalf("expected write after freeing quota, got %#v", resp.Error)
+	}
+	// Moving over an existing file frees the file it replaces.
+	if resp := run("write", map[string]interface{}{"path": "/workspace/c.txt", "content": "12345"}); !resp.Success {
+		t.Fatalf("write c.txt: %#v", resp.Error)
+	}
+	if resp := run("move", map[string]interface{}{"src": "/workspace/c.txt", "dst": "/workspace/b.txt"}); !resp.Success {
+		t.Fatalf("move over b.txt: %#v", resp.Error)
+	}
+	if resp := run("write", map[string]interface{}{"path": "/workspace/d.txt", "content": "12345"}); !resp.Success {
+		t.Fatalf("expected the replaced file's bytes to be freed, got %#v", resp.Error)
+	}
+	if _, err := os.Stat(filepath.Join(base, "workspace", "b.txt")); err != nil {
+		t.Fatalf("expected workspace mount backed by base dir: %v", err)
+	}
+	if resp := run("read", map[string]interface{}{"path": "/etc/passwd"}); resp.Success {
+		t.Fatalf("expected unmounted path to be denied")
+	}
+
+	root := run("list", map[string]interface{}{"path": "/"}).Data.([]FileInfo)
+	if len(root) != 2 || root[0].Path != "data" || root[1].Path != "workspace" {
+		t.Fatalf("unexpected namespace root %#v", root)
+	}
+	if mounts := cap.SandboxMounts(); len(mounts) != 2 || mounts[0].Target != "/data" || mounts[0].Mode != "ro" {
+		t.Fatalf("unexpected sandbox mounts %#v", mounts)
+	}
+}
+
+func TestFSSymlinkEscapes(t *testing.T) {
+	t.Parallel()
+	base := t.TempDir()
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0o644); err != nil {
+		t.Fatalf("seed outside: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(base, "inner"), 0o755); err != nil {
+		t.Fatalf("mkdir inner: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(base, "inner", "ok.txt"), []byte("ok"), 0o644); err != nil {
+		t.Fatalf("seed inner: %v", err)
+	}
+	links := map[string]string{
+		"abs-escape": outside,
+		"rel-escape": filepath.Join("..", filepath.Base(outside)),
+		"deep/up":    filepath.Join("..", "..", filepath.Base(outside), "secret.txt"),
+		"good":       "inner",
+		"loop":       "loop",
+	}
+	for link, dest := range links {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(base, link)), 0o755); err != nil {
+			t.Fatalf("mkdir for link: %v", err)
+		}
+		if err := os.Symlink(dest, filepath.Join(base, link)); err != nil {
+			t.Fatalf("symlink %s: %v", link, err)
+		}
+	}
+
+	cap := New(base)
+	ctx := context.Background()
+	run := func(action string, params map[string]interface{}) *capability.Response {
+		t.Helper()
+		resp, err := cap.Execute(ctx, &capability.Request{Action: action, Params: params})
+		if err != nil {
+			t.Fatalf("%s execute: %v", action, err)
+		}
+		return resp
+	}
+
+	denied := []struct {
+		action string
+		params map[string]interface{}
+	}{
+		{"read", map[string]interface{}{"path": "abs-escape/secret.txt"}},
+		{"read", map[string]interface{}{"path": "rel-escape/secret.txt"}},
+		{"read", map[string]interface{}{"path": "deep/up"}},
+		{"read", map[string]interface{}{"path": "loop"}},
+		{"write", map[string]interface{}{"path": "abs-escape/pwned.txt", "content": "x"}},
+		{"copy", map[string]interface{}{"src": "abs-escape/secret.txt", "dst": "stolen.txt"}},
+		{"list", map[string]interface{}{"path": "abs-escape"}},
+		{"mkdir", map[string]interface{}{"path": "rel-escape/newdir"}},
+	}
+	for _, tc := range denied {
+		if resp := run(tc.action, tc.params); resp.Success {
+			t.Fatalf("%s %v escaped through symlink", tc.action, tc.params)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(outside, "pwned.txt")); err == nil {
+		t.Fatalf("write escaped mount")
+	}
+
+	if resp := run("read", map[string]interface{}{"path": "good/ok.txt"}); !resp.Success || resp.Data.(string) != "ok" {
+		t.Fatalf("expected in-root symlink to be followed, got %#v", resp)
+	}
+	if resp := run("delete", map[string]interface{}{"path": "abs-escape"}); !resp.Success {
+		t.Fatalf("expected deleting the link itself to succeed, got %#v", resp.Error)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "secret.txt")); err != nil {
+		t.Fatalf("deleting link removed its target: %v", err)
+	}
+
+	if resp := run("symlink", map[string]interface{}{"path": "new", "target": "inner"}); resp.Success {
+		t.Fatalf("expected symlink creation to be disabled by default")
+	}
+	if err := cap.Initialize(ctx, map[string]interface{}{"symlinks": map[string]interface{}{"create": true}}); err != nil {
+		t.Fatalf("initialize: %v", err)
+	}
+	if resp := run("symlink", map[string]interface{}{"path": "new", "target": "../outside"}); resp.Success {
+		t.Fatalf("expected escaping symlink creation to be denied")
+	}
+	if resp := run("symlink", map[string]interface{}{"path": "links/ok", "target": "/inner/ok.txt"}); !resp.Success {
+		t.Fatalf("symlink create: %#v", resp.Error)
+	}
+	if resp := run("read", map[string]interface{}{"path": "links/ok"}); !resp.Success || resp.Data.(string) != "ok" {
+		t.Fatalf("expected created symlink to resolve, got %#v", resp)
+	}
+
+	if err := cap.SetSymlinkPolicy(SymlinkPolicy{Follow: SymlinksDeny}); err != nil {
+		t.Fatalf("set policy: %v", err)
+	}
+	if resp := run("read", map[string]interface{}{"path": "good/ok.txt"}); resp.Success {
+		t.Fatalf("expected deny policy to refuse in-root symlinks")
+	}
+}
+
+func TestFSSnapshotDiffAndRestore(t *testing.T) {
+	t.Parallel()
+	base := t.TempDir()
+	cap := New(base)
+	if err := cap.SetSnapshotDir(t.TempDir()); err != nil {
+		t.Fatalf("set snapshot dir: %v", err)
+	}
+	ctx := context.Background()
+	run := func(action string, params map[string]interface{}) *capability.Response {
+		t.Helper()
+		resp, err := cap.Execute(ctx, &capability.Request{Action: action, Params: params})
+		if err != nil {
+			t.Fatalf("%s execute: %v", action, err)
+		}
+		if !resp.Success {
+			t.Fatalf("%s failed: %#v", action, resp.Error)
+		}
+		return resp
+	}
+
+	run("write", map[string]interface{}{"path": "main.go", "content": "package main\n\nfunc main() {}\n"})
+	run("write", map[string]interface{}{"path": "notes.txt", "content": "keep\n"})
+	before := run("snapshot", map[string]interface{}{"label": "before"}).Data.(*Snapshot)
+	if before.Files != 2 {
+		t.Fatalf("expected 2 files in snapshot, got %d", before.Files)
+	}
+
+	run("write", map[string]interface{}{"path": "main.go", "content": "package main\n\nfunc main() { panic(1) }\n"})
+	run("write", map[string]interface{}{"path": "junk/tmp.txt", "content": "junk\n"})
+	run("delete", map[string]interface{}{"path": "notes.txt"})
+
+	diff := run("diff", map[string]interface{}{"from": before.ID}).Data.(*SnapshotDiff)
+	want := map[string]string{"junk/tmp.txt": "added", "main.go": "modified", "notes.txt": "deleted"}
+	if len(diff.Changes) != len(want) {
+		t.Fatalf("unexpected changes %#v", diff.Changes)
+	}
+	for _, ch := range diff.Changes {
+		if want[ch.Path] != ch.Status {
+			t.Fatalf("unexpected change %#v", ch)
+		}
+	}
+	if !strings.Contains(diff.Patch, "-func main() {}\n+func main() { panic(1) }\n") {
+		t.Fatalf("unexpected patch:\n%s", diff.Patch)
+	}
+
+	after := run("snapshot", map[string]interface{}{}).Data.(*Snapshot)
+	if snaps := run("list_snapshots", nil).Data.([]Snapshot); len(snaps) != 2 || snaps[0].ID != before.ID {
+		t.Fatalf("unexpected snapshot list %#v", snaps)
+	}
+	between := run("diff", map[string]interface{}{"from": before.ID, "to": after.ID}).Data.(*SnapshotDiff)
+	if len(between.Changes) != 3 {
+		t.Fatalf("expected 3 changes between snapshots, got %#v", between.Changes)
+	}
+
+	run("restore", map[string]interface{}{"id": before.ID})
+	if got := run("read", map[string]interface{}{"path": "main.go"}).Data.(string); got != "package main\n\nfunc main() {}\n" {
+		t.Fatalf("main.go not restored: %q", got)
+	}
+	if got := run("read", map[string]interface{}{"path": "notes.txt"}).Data.(string); got != "keep\n" {
+		t.Fatalf("notes.txt not restored: %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(base, "junk")); !os.IsNotExist(err) {
+		t.Fatalf("expected files created after snapshot to be removed, got %v", err)
+	}
+
+	if err := cap.PruneSnapshots(1); err != nil {
+		t.Fatalf("prune: %v", err)
+	}
+	if snaps, _ := cap.Snapshots(); len(snaps) != 1 || snaps[0].ID != after.ID {
+		t.Fatalf("unexpected snapshots after prune %#v", snaps)
+	}
+}
+
+func TestFSRestoreReplacesTypeAndChecksObjects(t *testing.T) {
+	t.Parallel()
+	base := t.TempDir()
+	cap := New(base)
+	if err := cap.SetSnapshotDir(t.TempDir()); err != nil {
+		t.Fatalf("set snapshot dir: %v", err)
+	}
+	ctx := context.Background()
+	if err := os.WriteFile(filepath.Join(base, "a.txt"), []byte("a\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("a.txt", filepath.Join(base, "link")); err != nil {
+		t.Fatal(err)
+	}
+	snap, err := cap.Snapshot(ctx, "before")
+	if err != nil {
+		t.Fatalf("snapshot: %v", err)
+	}
+
+	// A symlink replaced by a regular file is restored as the symlink.
+	if err := os.Remove(filepath.Join(base, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(base, "link"), []byte("file\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := cap.Restore(ctx, snap.ID); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	if got, err := os.Readlink(filepath.Join(base, "link")); err != nil || got != "a.txt" {
+		t.Fatalf("link not restored: %q, %v", got, err)
+	}
+
+	// A missing object fails the restore before anything is removed.
+	man, err := cap.loadManifest(snap.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(cap.objectPath(man.Entries["/a.txt"].Hash)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(base, "new.txt"), []byte("new\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := cap.Restore(ctx, snap.ID); err == nil {
+		t.Fatal("expected restore with a missing object to fail")
+	}
+	if _, err := os.Stat(filepath.Join(base, "new.txt")); err != nil {
+		t.Fatalf("workspace changed by a failed restore: %v", err)
+	}
+}
+
+func TestFSOverlayRejectsWhiteoutNames(t *testing.T) {
+	t.Parallel()
+	lower := t.TempDir()
+	if err := os.WriteFile(filepath.Join(lower, "secret"), []byte("s\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	state := t.TempDir()
+	cap, err := NewWithMounts(t.TempDir(), []Mount{{
+		Path:    "/d",
+		Source:  lower,
+		Overlay: &OverlayConfig{UpperDir: filepath.Join(state, "upper"), WorkDir: filepath.Join(state, "work")},
+	}})
+	if err != nil {
+		t.Fatalf("new with overlay: %v", err)
+	}
+	ctx := context.Background()
+	for _, p := range []string{"d/.wh.secret", "d/.wh..wh..opq", "d/.wh.dir/file", "d/sub/.wh.x"} {
+		resp, err := cap.Execute(ctx, &capability.Request{Action: "write", Params: map[string]interface{}{"path": p, "content": ""}})
+		if err != nil {
+			t.Fatalf("write %s: %v", p, err)
+		}
+		if resp.Success || resp.Error.Code != "access_denied" {
+			t.Fatalf("write %s: expected access_denied, got %#v", p, resp)
+		}
+	}
+	if err := cap.SetSymlinkPolicy(SymlinkPolicy{Follow: SymlinksWithin, Create: true}); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := cap.Execute(ctx, &capability.Request{Action: "symlink", Params: map[string]interface{}{"path": "d/link", "target": ".wh.secret"}})
+	if err != nil || resp.Success {
+		t.Fatalf("expected a link to a whiteout name to be refused, got %#v, %v", resp, err)
+	}
+	resp, err = cap.Execute(ctx, &capability.Request{Action: "read", Params: map[string]interface{}{"path": "d/secret"}})
+	if err != nil || !resp.Success || resp.Data.(string) != "s\n" {
+		t.Fatalf("expected lower file to stay visible, got %#v, %v", resp, err)
+	}
+}
+
+func TestFSOverlayCopyOnWrite(t *testing.T) {
+	t.Parallel()
+	lower := t.TempDir()
+	state := t.TempDir()
+	for name, content := range map[string]string{"base.txt": "v1\n", "data/a.csv": "a\n", "data/b.csv": "b\n"} {
+		if err := os.MkdirAll(filepath.Join(lower, filepath.Dir(name)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(lower, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	upper := filepath.Join(state, "upper")
+	cap, err := NewWithMounts(t.TempDir(), []Mount{{
+		Path:    "/dataset",
+		Source:  lower,
+		Overlay: &OverlayConfig{UpperDir: upper, WorkDir: filepath.Join(state, "work")},
+	}})
+	if err != nil {
+		t.Fatalf("new with overlay: %v", err)
+	}
+	ctx := context.Background()
+	run := func(action string, params map[string]interface{}) *capability.Response {
+		t.Helper()
+		resp, err := cap.Execute(ctx, &capability.Request{Action: action, Params: params})
+		if err != nil {
+			t.Fatalf("%s execute: %v", action, err)
+		}
+		if !resp.Success {
+			t.Fatalf("%s failed: %#v", action, resp.Error)
+		}
+		return resp
+	}
+
+	if got := run("read", map[string]interface{}{"path": "dataset/base.txt"}).Data.(string); got != "v1\n" {
+		t.Fatalf("expected read to fall through to lower, got %q", got)
+	}
+	run("write", map[string]interface{}{"path": "dataset/base.txt", "content": "v2\n"})
+	run("write", map[string]interface{}{"path": "dataset/data/new.csv", "content": "n\n"})
+	run("delete", map[string]interface{}{"path": "dataset/data/a.csv"})
+
+	if b, _ := os.ReadFile(filepath.Join(lower, "base.txt")); string(b) != "v1\n" {
+		t.Fatalf("lower layer modified: %q", b)
+	}
+	if _, err := os.Stat(filepath.Join(lower, "data", "a.csv")); err != nil {
+		t.Fatalf("lower file removed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(upper, "data", ".wh.a.csv")); err != nil {
+		t.Fatalf("expected whiteout in upper layer: %v", err)
+	}
+	if got := run("read", map[string]interface{}{"path": "dataset/base.txt"}).Data.(string); got != "v2\n" {
+		t.Fatalf("expected copied-up content, got %q", got)
+	}
+	var names []string
+	for _, e := range run("list", map[string]interface{}{"path": "dataset/data"}).Data.([]FileInfo) {
+		names = append(names, e.Name)
+	}
+	if strings.Join(names, ",") != "b.csv,new.csv" {
+		t.Fatalf("unexpected merged listing %v", names)
+	}
+
+	run("delete", map[string]interface{}{"path": "dataset/data", "recursive": true})
+	run("mkdir", map[string]interface{}{"path": "dataset/data"})
+	if entries := run("list", map[string]interface{}{"path": "dataset/data"}).Data.([]FileInfo); len(entries) != 0 {
+		t.Fatalf("expected recreated directory to hide lower entries, got %#v", entries)
+	}
+
+	cs := run("export_changes", nil).Data.(*Changeset)
+	want := map[string]string{"dataset/base.txt": "modified", "dataset/data/a.csv": "deleted", "dataset/data/b.csv": "deleted"}
+	if len(cs.Changes) != len(want) {
+		t.Fatalf("unexpected changeset %#v", cs.Changes)
+	}
+	for _, ch := range cs.Changes {
+		if want[ch.Path] != ch.Status {
+			t.Fatalf("unexpected change %#v", ch)
+		}
+	}
+	if !strings.Contains(cs.Patch, "--- a/dataset/base.txt\n+++ b/dataset/base.txt\n@@ -1 +1 @@\n-v1\n+v2\n") {
+		t.Fatalf("unexpected patch:\n%s", cs.Patch)
+	}
+}
+
+func TestFSEditActions(t *testing.T) {
+	t.Parallel()
+	cap := New(t.TempDir())
+	ctx := context.Background()
+	exec := func(action string, params map[string]interface{}) *capability.Response {
+		t.Helper()
+		resp, err := cap.Execute(ctx, &capability.Request{Action: action, Params: params})
+		if err != nil {
+			t.Fatalf("%s execute: %v", action, err)
+		}
+		return resp
+	}
+	read := func() string {
+		t.Helper()
+		return exec("read", map[string]interface{}{"path": "main.go"}).Data.(string)
+	}
+	exec("write", map[string]interface{}{"path": "main.go", "content": "package main\n\nfunc a() {}\n\nfunc b() {}\n\nfunc main() {\n\ta()\n}\n"})
+
+	resp := exec("str_replace", map[string]interface{}{"path": "main.go", "old_str": "() {}", "new_str": "() { return }"})
+	if resp.Success || resp.Error.Code != "ambiguous_match" {
+		t.Fatalf("expected ambiguous_match, got %#v", resp)
+	}
+	resp = exec("str_replace", map[string]interface{}{"path": "main.go", "old_str": "func b() {}", "new_str": "func b() { a() }"})
+	if !resp.Success {
+		t.Fatalf("str_replace failed: %#v", resp.Error)
+	}
+	if r := resp.Data.(EditResult); len(r.Hunks) != 1 || !strings.Contains(r.Patch, "-func b() {}\n+func b() { a() }\n") {
+		t.Fatalf("unexpected str_replace result %#v", r)
+	}
+
+	resp = exec("replace_range", map[string]interface{}{"path": "main.go", "start_line": float64(8), "end_line": float64(8), "content": "\tb()"})
+	if !resp.Success {
+		t.Fatalf("replace_range failed: %#v", resp.Error)
+	}
+	if got := read(); !strings.Contains(got, "func main() {\n\tb()\n}\n") {
+		t.Fatalf("unexpected content after replace_range:\n%s", got)
+	}
+
+	// The hunk header is off by two and its leading context line is stale,
+	// so it only applies with an offset and fuzz.
+	fuzzy := "--- a/main.go\n+++ b/main.go\n@@ -3,4 +3,4 @@\n stale\n \n-func a() {}\n+func a() { println() }\n \n"
+	if resp = exec("apply_patch", map[string]interface{}{"patch": fuzzy, "fuzz": float64(0)}); resp.Success || resp.Error.Code != "patch_conflict" {
+		t.Fatalf("expected patch_conflict with fuzz 0, got %#v", resp)
+	}
+	if conflicts := resp.Data.([]PatchConflict); len(conflicts) != 1 || conflicts[0].Hunk != 1 {
+		t.Fatalf("unexpected conflicts %#v", conflicts)
+	}
+	resp = exec("apply_patch", map[string]interface{}{"patch": fuzzy})
+	if !resp.Success {
+		t.Fatalf("apply_patch failed: %#v %#v", resp.Error, resp.Data)
+	}
+	if got := read(); !strings.Contains(got, "func a() { println() }\n") {
+		t.Fatalf("unexpected content after apply_patch:\n%s", got)
+	}
+
+	added := "--- /dev/null\n+++ b/util/util.go\n@@ -0,0 +1,2 @@\n+package util\n+// helpers\n\\ No newline at end of file\n"
+	resp = exec("apply_patch", map[string]interface{}{"patch": added})
+	if !resp.Success {
+		t.Fatalf("apply_patch add failed: %#v", resp.Error)
+	}
+	if got := exec("read", map[string]interface{}{"path": "util/util.go"}).Data.(string); got != "package util\n// helpers" {
+		t.Fatalf("unexpected added file %q", got)
+	}
+}
+
+func TestFSWatchLimit(t *testing.T) {
+	t.Parallel()
+	cap := New(t.TempDir())
+	defer cap.Shutdown(context.Background())
+	ctx := context.Background()
+	watch := func() *capability.Response {
+		t.Helper()
+		resp, err := cap.Execute(ctx, &capability.Request{Action: "watch", Params: map[string]interface{}{"path": "."}})
+		if err != nil {
+			t.Fatalf("watch execute: %v", err)
+		}
+		return resp
+	}
+	var first string
+	for i := 0; i < MaxWatches; i++ {
+		resp := watch()
+		if !resp.Success {
+			t.Fatalf("watch %d failed: %#v", i, resp.Error)
+		}
+		if i == 0 {
+			first = resp.Data.(map[string]interface{})["id"].(string)
+		}
+	}
+	if resp := watch(); resp.Success || resp.Error.Code != "too_many_watches" {
+		t.Fatalf("expected too_many_watches, got %#v", resp)
+	}
+	if err := cap.Unwatch(first); err != nil {
+		t.Fatalf("unwatch: %v", err)
+	}
+	if resp := watch(); !resp.Success {
+		t.Fatalf("watch after unwatch failed: %#v", resp.Error)
+	}
+}
+
+func TestFSWatchEvents(t *testing.T) {
+	t.Parallel()
+	base := t.TempDir()
+	cap := New(base)
+	defer cap.Shutdown(context.Background())
+	stream := observability.NewEventStream()
+	cap.SetEventStream(stream)
+	published, cancel := stream.Subscribe()
+	defer cancel()
+	ctx := context.Background()
+	exec := func(action string, params map[string]interface{}) *capability.Response {
+		t.Helper()
+		resp, err := cap.Execute(ctx, &capability.Request{Action: action, Params: params})
+		if err != nil {
+			t.Fatalf("%s execute: %v", action, err)
+		}
+		if !resp.Success {
+			t.Fatalf("%s failed: %#v", action, resp.Error)
+		}
+		return resp
+	}
+	exec("write", map[string]interface{}{"path": "inbox/old.csv", "content": "x"})
+
+	id := exec("watch", map[string]interface{}{"path": "inbox", "glob": "*.csv", "debounce_ms": float64(20)}).Data.(map[string]interface{})["id"].(string)
+	exec("write", map[string]interface{}{"path": "inbox/new.csv", "content": "a"})
+	exec("write", map[string]interface{}{"path": "inbox/new.csv", "content": "ab"})
+	exec("write", map[string]interface{}{"path": "inbox/skip.txt", "content": "a"})
+	exec("write", map[string]interface{}{"path": "inbox/sub/deep.csv", "content": "a"})
+	exec("delete", map[string]interface{}{"path": "inbox/old.csv"})
+
+	want := map[string]string{"inbox/new.csv": WatchCreate, "inbox/old.csv": WatchDelete, "inbox/sub/deep.csv": WatchCreate}
+	got := map[string]string{}
+	deadline := time.Now().Add(3 * time.Second)
+	for len(got) < len(want) && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+		data := exec("watch_events", map[string]interface{}{"id": id}).Data.(map[string]interface{})
+		for _, ev := range data["events"].([]WatchEvent) {
+			got[ev.Path] = ev.Op
+		}
+	}
+	for p, op := range want {
+		if got[p] != op {
+			t.Fatalf("expected %s %s, got events %v", op, p, got)
+		}
+	}
+	if _, ok := got["inbox/skip.txt"]; ok {
+		t.Fatalf("glob filter not applied: %v", got)
+	}
+	select {
+	case ev := <-published:
+		if ev.Type != "fs.watch" || ev.Payload["watch_id"] != id {
+			t.Fatalf("unexpected published event %#v", ev)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected watch events on the event stream")
+	}
+
+	exec("unwatch", map[string]interface{}{"id": id})
+	resp, _ := cap.Execute(ctx, &capability.Request{Action: "watch_events", Params: map[string]interface{}{"id": id}})
+	if resp.Success || resp.Error.Code != "not_found" {
+		t.Fatalf("expected not_found after unwatch, got %#v", resp)
+	}
+}
+
+func TestDiffLinesIsShortest(t *testing.T) {
+	t.Parallel()
+	rng := rand.New(rand.NewPCG(29, 29))
+	randomLines := func() []string {
+		lines := make([]string, rng.IntN(40))
+		for i := range lines {
+			lines[i] = string(rune('a' + rng.IntN(4)))
+		}
+		return lines
+	}
+	for i := 0; i < 500; i++ {
+		a, b := randomLines(), randomLines()
+		var gotA, gotB []string
+		changes := 0
+		for _, e := range diffLines(a, b) {
+			switch e.kind {
+			case editEqual:
+				if a[e.a] != b[e.b] {
+					t.Fatalf("%v -> %v: equal edit pairs %q with %q", a, b, a[e.a], b[e.b])
+				}
+				gotA, gotB = append(gotA, a[e.a]), append(gotB, b[e.b])
+			case editDelete:
+				gotA = append(gotA, a[e.a])
+				changes++
+			case editInsert:
+				gotB = append(gotB, b[e.b])
+				changes++
+			}
+		}
+		if strings.Join(gotA, "") != strings.Join(a, "") || strings.Join(gotB, "") != strings.Join(b, "") {
+			t.Fatalf("%v -> %v: script covers %v -> %v", a, b, gotA, gotB)
+		}
+		// A shortest script keeps a longest common subsequence.
+		lcs := make([][]int, len(a)+1)
+		for x := range lcs {
+			lcs[x] = make([]int, len(b)+1)
+		}
+		for x := len(a) - 1; x >= 0; x-- {
+			for y := len(b) - 1; y >= 0; y-- {
+				if a[x] == b[y] {
+					lcs[x][y] = lcs[x+1][y+1] + 1
+				} else {
+					lcs[x][y] = max(lcs[x+1][y], lcs[x][y+1])
+				}
+			}
+		}
+		if want := len(a) + len(b) - 2*lcs[0][0]; changes != want {
+			t.Fatalf("%v -> %v: %d changes, want %d", a, b, changes, want)
+		}
+	}
+}
+
+func TestDiffLinesLargeUnrelatedFiles(t *testing.T) {
+	t.Parallel()
+	a := make([]string, 50000)
+	b := make([]string, 50000)
+	for i := range a {
+		a[i] = fmt.Sprintf("old %d\n", i)
+		b[i] = fmt.Sprintf("new %d\n", i)
+	}
+	b[25000] = a[25000]
+	if edits := diffLines(a, b); len(edits) < len(a)+len(b)-1 {
+		t.Fatalf("%d edits for unrelated files", len(edits))
+	}
+}
+
+func TestMatchGlob(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"**", "a/b/c", true},
+		{"**/*.go", "main.go", true},
+		{"**/*.go", "src/pkg/main.go", true},
+		{"src/**/main.go", "src/main.go", true},
+		{"src/**/**/main.go", "src/a/b/main.go", true},
+		{"src/*.go", "src/pkg/main.go", false},
+		{"**/*.go", "src/main.txt", false},
+	} {
+		if got := matchGlob(tc.pattern, tc.name); got != tc.want {
+			t.Fatalf("matchGlob(%q, %q) = %v, want %v", tc.pattern, tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestMatchGlobManyDoubleStars(t *testing.T) {
+	t.Parallel()
+	// Without memoization this takes exponentially many steps.
+	pattern := strings.Repeat("**/a/", 20) + "b"
+	name := strings.Repeat("a/", 60) + "c"
+	start := time.Now()
+	if matchGlob(pattern, name) {
+		t.Fatalf("%q matched %q", pattern, name)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("matching took %v", elapsed)
+	}
+}
+
+func TestUnifiedDiffHunks(t *testing.T) {
+	t.Parallel()
+	from := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk\n"
+	to := "a\nB\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl"
+	got := unifiedDiff("a/x", "b/x", from, to)
+	want := "--- a/x\n+++ b/x\n" +
+		"@@ -1,5 +1,5 @@\n a\n-b\n+B\n c\n d\n e\n" +
+		"@@ -9,3 +9,4 @@\n i\n j\n k\n+l\n\\ No newline at end of file\n"
+	if got != want {
+		t.Fatalf("unexpected diff:\n%s\nwant:\n%s", got, want)
+	}
+	if unifiedDiff("a", "b", "same\n", "same\n") != "" {
+		t.Fatalf("expected empty diff for equal input")
+	}
+}
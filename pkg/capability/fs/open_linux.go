@@ -0,0 +1,34 @@
+//go:build linux
+
+package fs
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// openBeneath opens rel relative to root with openat2 and RESOLVE_BENEATH, so
+// a symlink swapped in after resolution still cannot escape root. Kernels
+// without openat2 fall back to a plain open of the already-resolved path.
+func openBeneath(root, rel string, flag int, perm os.FileMode) (*os.File, error) {
+	dir, err := unix.Open(root, unix.O_PATH|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: root, Err: err}
+	}
+	defer unix.Close(dir)
+	fd, err := unix.Openat2(dir, rel, &unix.OpenHow{
+		Flags:   uint64(flag) | unix.O_CLOEXEC,
+		Mode:    uint64(perm.Perm()),
+		Resolve: unix.RESOLVE_BENEATH | unix.RESOLVE_NO_SYMLINKS | unix.RESOLVE_NO_MAGICLINKS,
+	})
+	if errors.Is(err, unix.ENOSYS) || errors.Is(err, unix.EPERM) {
+		return os.OpenFile(filepath.Join(root, rel), flag|unix.O_NOFOLLOW, perm)
+	}
+	if err != nil {
+		return nil, &os.PathError{Op: "openat2", Path: filepath.Join(root, rel), Err: err}
+	}
+	return os.NewFile(uintptr(fd), filepath.Join(root, rel)), nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package fs
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// openBeneath opens the already-resolved path; containment is enforced by
+// secureJoin on platforms without openat2.
+func openBeneath(root, rel string, flag int, perm os.FileMode) (*os.File, error) {
+	return os.OpenFile(filepath.Join(root, rel), flag, perm)
+}
@@ -0,0 +1,381 @@
+package fs
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"spawn.dev/pkg/capability"
+)
+
+const (
+	defaultSearchMatches = 100
+	maxSearchFileBytes   = 10 << 20
+	binarySniffBytes     = 8000
+)
+
+// FileInfo is the structured metadata returned by list and stat.
+type FileInfo struct {
+	Path    string    `json:"path"`
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	Mode    string    `json:"mode"`
+	IsDir   bool      `json:"is_dir"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// SearchMatch is one line matched by the search action.
+type SearchMatch struct {
+	Path string `json:"path"`
+	Line int    `json:"line"`
+	Text string `json:"text"`
+}
+
+// SearchResult groups search matches with scan statistics.
+type SearchResult struct {
+	Matches      []SearchMatch `json:"matches"`
+	FilesScanned int           `json:"files_scanned"`
+	Truncated    bool          `json:"truncated"`
+}
+
+func newFileInfo(virtual string, info fs.FileInfo) FileInfo {
+	if info == nil {
+		return FileInfo{Path: display(virtual), Name: path.Base(virtual), Mode: fs.ModeDir.String(), IsDir: true}
+	}
+	return FileInfo{
+		Path:    display(virtual),
+		Name:    info.Name(),
+		Size:    info.Size(),
+		Mode:    info.Mode().String(),
+		IsDir:   info.IsDir(),
+		ModTime: info.ModTime().UTC(),
+	}
+}
+
+func (c *Capability) list(params map[string]interface{}) *capability.Response {
+	p := capability.StringParam(params, "path", ".")
+	virtual, err := cleanVirtual(p)
+	if err != nil {
+		return capability.Failure("access_denied", err.Error())
+	}
+	depth := 1
+	if boolParam(params, "recursive") {
+		depth = capability.IntParam(params, "depth", 0)
+	}
+	if t, err := c.resolveVirtual(virtual); err == nil {
+		info, err := os.Stat(t.readPath())
+		if err != nil {
+			return capability.Failure("list_failed", err.Error())
+		}
+		if !info.IsDir() {
+			return capability.Failure("not_a_directory", p)
+		}
+	}
+	entries := []FileInfo{}
+	err = c.walkVirtual(virtual, depth, func(e walkEntry) error {
+		entries = append(entries, newFileInfo(e.virtual, e.info))
+		return nil
+	})
+	if errors.Is(err, errNotMounted) {
+		return capability.Failure("access_denied", err.Error())
+	}
+	if err != nil {
+		return capability.Failure("list_failed", err.Error())
+	}
+	return &capability.Response{Success: true, Data: entries}
+}
+
+func (c *Capability) stat(params map[string]interface{}) *capability.Response {
+	p, _ := params["path"].(string)
+	t, err := c.resolveLink(p)
+	if err != nil {
+		return capability.Failure("access_denied", err.Error())
+	}
+	info, err := os.Lstat(t.readPath())
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return capability.Failure("not_found", p)
+		}
+		return capability.Failure("stat_failed", err.Error())
+	}
+	return &capability.Response{Success: true, Data: newFileInfo(t.virtual, info)}
+}
+
+func (c *Capability) delete(params map[string]interface{}) *capability.Response {
+	p, _ := params["path"].(string)
+	t, err := c.resolveWritableLink(p)
+	if err != nil {
+		return writeFailure(err)
+	}
+	if t.virtual == t.mount.virtual {
+		return capability.Failure("access_denied", "cannot delete mount root")
+	}
+	if _, err := os.Lstat(t.readPath()); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return capability.Failure("not_found", p)
+		}
+		return capability.Failure("delete_failed", err.Error())
+	}
+	freed, _ := diskUsage(t.host)
+	if err := c.removePath(t, boolParam(params, "recursive")); err != nil {
+		return capability.Failure("delete_failed", err.Error())
+	}
+	_ = c.reserve(t.mount, -freed)
+	return &capability.Response{Success: true}
+}
+
+func (c *Capability) move(params map[string]interface{}) *capability.Response {
+	src, _ := params["src"].(string)
+	dst, _ := params["dst"].(string)
+	if src == "" || dst == "" {
+		return capability.Failure("invalid_params", "src and dst required")
+	}
+	from, err := c.resolveWritableLink(src)
+	if err != nil {
+		return writeFailure(err)
+	}
+	to, err := c.resolveWritableLink(dst)
+	if err != nil {
+		return writeFailure(err)
+	}
+	if from.virtual == from.mount.virtual {
+		return capability.Failure("access_denied", "cannot move mount root")
+	}
+	if from.mount.lower != "" {
+		return c.moveOverlay(from, to)
+	}
+	// The moved bytes only change mounts when the mount does, and a
+	// destination the rename replaces frees its own.
+	var size int64
+	if from.mount != to.mount {
+		size, _ = diskUsage(from.host)
+	}
+	replaced, _ := diskUsage(to.host)
+	if err := c.reserve(to.mount, size-replaced); err != nil {
+		return writeFailure(err)
+	}
+	if err := c.prepareWrite(to); err != nil {
+		_ = c.reserve(to.mount, replaced-size)
+		return capability.Failure("mkdir_failed", err.Error())
+	}
+	if err := os.Rename(from.host, to.host); err != nil {
+		_ = c.reserve(to.mount, replaced-size)
+		return capability.Failure("move_failed", err.Error())
+	}
+	_ = c.reserve(from.mount, -size)
+	return &capability.Response{Success: true}
+}
+
+// moveOverlay moves a path out of an overlay by copying the merged tree and
+// then deleting the source, since lower-layer entries cannot be renamed.
+func (c *Capability) moveOverlay(from, to target) *capability.Response {
+	if !from.exists() {
+		return capability.Failure("not_found", display(from.virtual))
+	}
+	size := c.treeSize(from)
+	if err := c.reserve(to.mount, size); err != nil {
+		return writeFailure(err)
+	}
+	if err := c.copyTree(from, to); err != nil {
+		_ = c.reserve(to.mount, -size)
+		return capability.Failure("move_failed", err.Error())
+	}
+	freed, _ := diskUsage(from.host)
+	if err := c.removePath(from, true); err != nil {
+		return capability.Failure("move_failed", err.Error())
+	}
+	_ = c.reserve(from.mount, -freed)
+	return &capability.Response{Success: true}
+}
+
+func (c *Capability) mkdir(params map[string]interface{}) *capability.Response {
+	p, _ := params["path"].(string)
+	t, err := c.resolveWritable(p)
+	if err != nil {
+		return writeFailure(err)
+	}
+	if err := c.makeDir(t); err != nil {
+		return capability.Failure("mkdir_failed", err.Error())
+	}
+	return &capability.Response{Success: true}
+}
+
+func (c *Capability) glob(params map[string]interface{}) *capability.Response {
+	pattern, _ := params["pattern"].(string)
+	if strings.TrimSpace(pattern) == "" {
+		return capability.Failure("invalid_params", "pattern is required")
+	}
+	pattern = strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(pattern)), "/")
+	if _, err := path.Match(strings.ReplaceAll(pattern, "**", "*"), ""); err != nil {
+		return capability.Failure("invalid_params", err.Error())
+	}
+	matches := []string{}
+	err := c.walkVirtual("/", 0, func(e walkEntry) error {
+		if rel := display(e.virtual); matchGlob(pattern, rel) {
+			matches = append(matches, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return capability.Failure("glob_failed", err.Error())
+	}
+	return &capability.Response{Success: true, Data: matches}
+}
+
+func (c *Capability) search(ctx context.Context, params map[string]interface{}) *capability.Response {
+	expr, _ := params["pattern"].(string)
+	if expr == "" {
+		return capability.Failure("invalid_params", "pattern is required")
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return capability.Failure("invalid_params", err.Error())
+	}
+	root, err := cleanVirtual(capability.StringParam(params, "path", "."))
+	if err != nil {
+		return capability.Failure("access_denied", err.Error())
+	}
+	include := capability.StringParam(params, "glob", "")
+	limit := capability.IntParam(params, "max_matches", defaultSearchMatches)
+	if limit <= 0 {
+		limit = defaultSearchMatches
+	}
+
+	result := SearchResult{Matches: []SearchMatch{}}
+	errLimit := errors.New("match limit reached")
+	err = c.walkVirtual(root, 0, func(e walkEntry) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if e.info == nil || !e.info.Mode().IsRegular() {
+			return nil
+		}
+		rel := display(e.virtual)
+		if include != "" && !matchGlob(include, rel) && !matchGlob(include, e.info.Name()) {
+			return nil
+		}
+		matches, err := searchFile(e.host, re, limit-len(result.Matches))
+		if err != nil {
+			return nil
+		}
+		result.FilesScanned++
+		for _, m := range matches {
+			m.Path = rel
+			result.Matches = append(result.Matches, m)
+		}
+		if len(result.Matches) >= limit {
+			result.Truncated = true
+			return errLimit
+		}
+		return nil
+	})
+	if errors.Is(err, errNotMounted) {
+		return capability.Failure("access_denied", err.Error())
+	}
+	if err != nil && !errors.Is(err, errLimit) {
+		return capability.Failure("search_failed", err.Error())
+	}
+	return &capability.Response{Success: true, Data: result}
+}
+
+// searchFile returns up to limit matching lines, skipping binary and oversized files.
+func searchFile(name string, re *regexp.Regexp, limit int) ([]SearchMatch, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() > maxSearchFileBytes {
+		return nil, nil
+	}
+	head := make([]byte, binarySniffBytes)
+	n, err := io.ReadFull(f, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	if bytes.IndexByte(head[:n], 0) >= 0 {
+		return nil, nil
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	var out []SearchMatch
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), maxSearchFileBytes)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if re.Match(scanner.Bytes()) {
+			out = append(out, SearchMatch{Line: line, Text: scanner.Text()})
+			if len(out) >= limit {
+				break
+			}
+		}
+	}
+	return out, scanner.Err()
+}
+
+// matchGlob matches a slash-separated path against a pattern where "**" spans
+// any number of path segments and other segments use path.Match semantics.
+func matchGlob(pattern, name string) bool {
+	m := globMatch{pattern: strings.Split(pattern, "/"), name: strings.Split(name, "/")}
+	m.failed = make([]bool, (len(m.pattern)+1)*(len(m.name)+1))
+	return m.match(0, 0)
+}
+
+// globMatch records the (pattern, name) positions already known not to
+// match, so patterns with several "**" segments are matched in
+// O(len(pattern) * len(name)) steps rather than exponentially many.
+type globMatch struct {
+	pattern, name []string
+	failed        []bool
+}
+
+func (m *globMatch) match(p, n int) bool {
+	for p < len(m.pattern) {
+		if m.pattern[p] == "**" {
+			for p+1 < len(m.pattern) && m.pattern[p+1] == "**" {
+				p++
+			}
+			seen := &m.failed[p*(len(m.name)+1)+n]
+			if *seen {
+				return false
+			}
+			for i := n; i <= len(m.name); i++ {
+				if m.match(p+1, i) {
+					return true
+				}
+			}
+			*seen = true
+			return false
+		}
+		if n == len(m.name) {
+			return false
+		}
+		ok, err := path.Match(m.pattern[p], m.name[n])
+		if err != nil || !ok {
+			return false
+		}
+		p, n = p+1, n+1
+	}
+	return n == len(m.name)
+}
+
+func boolParam(params map[string]interface{}, key string) bool {
+	v, _ := params[key].(bool)
+	return v
+}
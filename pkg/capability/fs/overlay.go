@@ -1,8 +1,597 @@
 package fs
 
-// OverlayConfig controls read/write layer composition.
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	"spawn.dev/pkg/capability"
+)
+
+// Whiteout markers use the OCI layer convention: ".wh.<name>" hides name in
+// the lower layer and ".wh..wh..opq" hides every lower entry of its directory.
+const (
+	whiteoutPrefix = ".wh."
+	opaqueMarker   = ".wh..wh..opq"
+)
+
+var (
+	errNotOverlay   = errors.New("mount is not an overlay")
+	errWhiteoutName = errors.New("names starting with " + whiteoutPrefix + " are reserved on overlay mounts")
+)
+
+// OverlayConfig controls read/write layer composition. LowerDir is never
+// modified; writes copy up into UpperDir and WorkDir stages files before
+// they are renamed into place.
 type OverlayConfig struct {
 	LowerDir string
 	UpperDir string
 	WorkDir  string
 }
+
+// Changeset is the content of an overlay's upper layer relative to its lower layer.
+type Changeset struct {
+	Mount   string       `json:"mount"`
+	Changes []FileChange `json:"changes"`
+	Patch   string       `json:"patch"`
+}
+
+// layerEntry is one directory entry in the merged view of an overlay.
+type layerEntry struct {
+	name  string
+	host  string
+	lower string
+	info  fs.FileInfo
+}
+
+// overlayMount fills in the layer directories of an overlay mount. Missing
+// upper and work directories default to siblings of baseDir so they stay
+// outside the agent namespace.
+func overlayMount(baseDir, virtual, source string, cfg OverlayConfig) (lower, upper, work string, err error) {
+	lower = cfg.LowerDir
+	if lower == "" {
+		lower = source
+	}
+	if lower == "" {
+		return "", "", "", fmt.Errorf("overlay requires a lower dir")
+	}
+	state := filepath.Join(baseDir+".overlay", filepath.FromSlash(strings.TrimPrefix(virtual, "/")))
+	upper, work = cfg.UpperDir, cfg.WorkDir
+	if upper == "" {
+		upper = filepath.Join(state, "upper")
+	}
+	if work == "" {
+		work = filepath.Join(state, "work")
+	}
+	for _, p := range []*string{&lower, &upper, &work} {
+		abs, err := filepath.Abs(*p)
+		if err != nil {
+			return "", "", "", fmt.Errorf("resolve overlay dir: %w", err)
+		}
+		*p = filepath.Clean(abs)
+	}
+	if _, ok := within(lower, upper); ok {
+		return "", "", "", fmt.Errorf("overlay upper dir must not be inside the lower dir")
+	}
+	return lower, upper, work, nil
+}
+
+func whiteoutName(name string) string {
+	return filepath.Join(filepath.Dir(name), whiteoutPrefix+filepath.Base(name))
+}
+
+func exists(p string) bool {
+	_, err := os.Lstat(p)
+	return err == nil
+}
+
+// lowerVisible reports whether rel in the lower layer shows through the
+// upper layer: no ancestor or the path itself is whited out, no ancestor
+// directory is opaque, and no ancestor is replaced by a non-directory.
+func lowerVisible(m *mountPoint, rel string) bool {
+	dir := m.source
+	parts := pathParts(rel)
+	for i, part := range parts {
+		if exists(filepath.Join(dir, opaqueMarker)) || exists(filepath.Join(dir, whiteoutPrefix+part)) {
+			return false
+		}
+		if i == len(parts)-1 {
+			break
+		}
+		dir = filepath.Join(dir, part)
+		if info, err := os.Lstat(dir); err == nil && !info.IsDir() {
+			return false
+		}
+	}
+	return true
+}
+
+// pathParts splits rel into its non-empty components.
+// hasWhiteoutName reports whether any component of rel is a whiteout or
+// opaque marker. Agents may not name them: a marker they wrote would hide
+// lower entries while staying invisible to list.
+func hasWhiteoutName(rel string) bool {
+	for _, part := range pathParts(rel) {
+		if strings.HasPrefix(part, whiteoutPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func pathParts(rel string) []string {
+	var out []string
+	for _, part := range splitPath(rel) {
+		if part != "" && part != "." {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+// readPath returns where the target's content currently lives: the upper
+// layer when present, otherwise the lower layer.
+func (t target) readPath() string {
+	if t.lower != "" && !exists(t.host) {
+		return t.lower
+	}
+	return t.host
+}
+
+func (t target) exists() bool {
+	return exists(t.readPath())
+}
+
+// prepareWrite creates the parent directories of t in its writable layer.
+// On overlays this copies the directory chain up and clears a whiteout left
+// by an earlier delete of the same name.
+func (c *Capability) prepareWrite(t target) error {
+	if t.mount.lower == "" {
+		return os.MkdirAll(filepath.Dir(t.host), 0o755)
+	}
+	if err := c.copyUpDirs(t, false); err != nil {
+		return err
+	}
+	if err := os.Remove(whiteoutName(t.host)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// copyUpDirs creates the directory chain of t in the upper layer, including
+// t itself when final is set.
+func (c *Capability) copyUpDirs(t target, final bool) error {
+	rel, ok := within(t.mount.source, t.host)
+	if !ok {
+		return errSymlinkEscape
+	}
+	parts := pathParts(rel)
+	if !final && len(parts) > 0 {
+		parts = parts[:len(parts)-1]
+	}
+	dir := t.mount.source
+	for _, part := range parts {
+		dir = filepath.Join(dir, part)
+		if err := copyUpDir(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyUpDir creates dir in the upper layer. A directory recreated over a
+// whiteout is marked opaque so deleted lower contents stay hidden.
+func copyUpDir(dir string) error {
+	if info, err := os.Lstat(dir); err == nil {
+		if !info.IsDir() {
+			return fmt.Errorf("%s: not a directory", filepath.Base(dir))
+		}
+		return nil
+	}
+	if err := os.Mkdir(dir, 0o755); err != nil && !errors.Is(err, fs.ErrExist) {
+		return err
+	}
+	wh := whiteoutName(dir)
+	if !exists(wh) {
+		return nil
+	}
+	if err := os.WriteFile(filepath.Join(dir, opaqueMarker), nil, 0o644); err != nil {
+		return err
+	}
+	return os.Remove(wh)
+}
+
+// makeDir creates the directory t and its parents in the writable layer.
+func (c *Capability) makeDir(t target) error {
+	if t.mount.lower == "" {
+		return os.MkdirAll(t.host, 0o755)
+	}
+	return c.copyUpDirs(t, true)
+}
+
+// removePath deletes t. On overlays the upper copy is removed and a
+// whiteout hides any lower copy.
+func (c *Capability) removePath(t target, recursive bool) error {
+	if t.mount.lower == "" {
+		if recursive {
+			return os.RemoveAll(t.host)
+		}
+		return os.Remove(t.host)
+	}
+	if !recursive {
+		if info, err := os.Lstat(t.readPath()); err == nil && info.IsDir() {
+			entries, err := overlayEntries(t.host, t.lower)
+			if err != nil {
+				return err
+			}
+			if len(entries) > 0 {
+				return fmt.Errorf("remove %s: directory not empty", display(t.virtual))
+			}
+		}
+	}
+	if err := os.RemoveAll(t.host); err != nil {
+		return err
+	}
+	if t.lower == "" {
+		return nil
+	}
+	if err := c.prepareWrite(t); err != nil {
+		return err
+	}
+	return os.WriteFile(whiteoutName(t.host), nil, 0o644)
+}
+
+// overlayEntries lists the merged contents of one overlay directory: upper
+// entries win, whiteouts hide lower entries, and an opaque upper directory
+// hides the lower directory entirely.
+func overlayEntries(upper, lower string) ([]layerEntry, error) {
+	merged := map[string]layerEntry{}
+	hidden := map[string]bool{}
+	opaque := false
+	items, err := os.ReadDir(upper)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	for _, item := range items {
+		name := item.Name()
+		switch {
+		case name == opaqueMarker:
+			opaque = true
+			continue
+		case strings.HasPrefix(name, whiteoutPrefix):
+			hidden[strings.TrimPrefix(name, whiteoutPrefix)] = true
+			continue
+		}
+		info, err := item.Info()
+		if err != nil {
+			return nil, err
+		}
+		merged[name] = layerEntry{name: name, host: filepath.Join(upper, name), info: info}
+	}
+	if lower != "" && !opaque {
+		items, err := os.ReadDir(lower)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		for _, item := range items {
+			name := item.Name()
+			if hidden[name] || strings.HasPrefix(name, whiteoutPrefix) {
+				continue
+			}
+			if e, ok := merged[name]; ok {
+				if e.info.IsDir() && item.IsDir() {
+					e.lower = filepath.Join(lower, name)
+					merged[name] = e
+				}
+				continue
+			}
+			info, err := item.Info()
+			if err != nil {
+				return nil, err
+			}
+			host := filepath.Join(lower, name)
+			merged[name] = layerEntry{name: name, host: host, lower: host, info: info}
+		}
+	}
+	out := make([]layerEntry, 0, len(merged))
+	for _, e := range merged {
+		out = append(out, e)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].name < out[j].name })
+	return out, nil
+}
+
+// walkOverlay visits the merged view below upper and lower depth first.
+// visit may return filepath.SkipDir to skip a directory's contents.
+func walkOverlay(upper, lower, rel string, visit func(rel, host string, info fs.FileInfo) error) error {
+	entries, err := overlayEntries(upper, lower)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		childRel := filepath.Join(rel, e.name)
+		err := visit(childRel, e.host, e.info)
+		if err == filepath.SkipDir {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if e.info.IsDir() {
+			if err := walkOverlay(filepath.Join(upper, e.name), e.lower, childRel, visit); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// copyTree copies the merged tree at from to to, materializing lower-layer
+// entries in the destination's writable layer.
+func (c *Capability) copyTree(from, to target) error {
+	info, err := os.Lstat(from.readPath())
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return c.copyEntry(from, to, info)
+	}
+	if err := c.makeDir(to); err != nil {
+		return err
+	}
+	return c.walkVirtual(from.virtual, 0, func(e walkEntry) error {
+		if e.info == nil || e.mount != from.mount {
+			return nil
+		}
+		src, err := c.resolveVirtualFollow(e.virtual, false)
+		if err != nil {
+			return err
+		}
+		dst, err := c.resolveVirtualFollow(joinVirtual(to.virtual, strings.TrimPrefix(e.virtual, from.virtual)), false)
+		if err != nil {
+			return err
+		}
+		if e.info.IsDir() {
+			return c.makeDir(dst)
+		}
+		return c.copyEntry(src, dst, e.info)
+	})
+}
+
+// stageFile writes r into the work dir and renames it over t, so a copy-up is
+// never observed half written. It falls back to writing in place when the
+// work dir is on another filesystem.
+func (c *Capability) stageFile(t target, r io.Reader) error {
+	if err := os.MkdirAll(t.mount.work, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(t.mount.work, ".stage-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	err = os.Rename(tmp.Name(), t.host)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+	in, err := os.Open(tmp.Name())
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := c.openFile(t, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func (c *Capability) copyEntry(src, dst target, info fs.FileInfo) error {
+	if info.Mode()&fs.ModeSymlink == 0 {
+		return c.copy(src, dst)
+	}
+	link, err := os.Readlink(src.readPath())
+	if err != nil {
+		return err
+	}
+	if err := c.prepareWrite(dst); err != nil {
+		return err
+	}
+	return os.Symlink(link, dst.host)
+}
+
+// treeSize sums the sizes of regular files in the merged tree at t.
+func (c *Capability) treeSize(t target) int64 {
+	info, err := os.Lstat(t.readPath())
+	if err != nil {
+		return 0
+	}
+	if !info.IsDir() {
+		return fileSize(t.readPath())
+	}
+	var total int64
+	_ = c.walkVirtual(t.virtual, 0, func(e walkEntry) error {
+		if e.info != nil && e.mount == t.mount && e.info.Mode().IsRegular() {
+			total += e.info.Size()
+		}
+		return nil
+	})
+	return total
+}
+
+// Changes reports the upper layer of the overlay mounted at virtual as a
+// list of changed paths plus a unified patch against the lower layer.
+func (c *Capability) Changes(virtual string) (*Changeset, error) {
+	m := c.mountFor(virtual)
+	if m == nil {
+		return nil, errNotMounted
+	}
+	if m.lower == "" {
+		return nil, fmt.Errorf("%w: %s", errNotOverlay, m.virtual)
+	}
+	status := map[string]string{}
+	deleteLower := func(rel string) error {
+		return filepath.WalkDir(filepath.Join(m.lower, rel), func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if errors.Is(err, fs.ErrNotExist) {
+					return nil
+				}
+				return err
+			}
+			if d.IsDir() {
+				return nil
+			}
+			r, _ := filepath.Rel(m.lower, p)
+			if !exists(filepath.Join(m.source, r)) {
+				status[r] = "deleted"
+			}
+			return nil
+		})
+	}
+	err := filepath.WalkDir(m.source, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(m.source, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		name := d.Name()
+		switch {
+		case name == opaqueMarker:
+			return deleteLower(filepath.Dir(rel))
+		case strings.HasPrefix(name, whiteoutPrefix):
+			return deleteLower(filepath.Join(filepath.Dir(rel), strings.TrimPrefix(name, whiteoutPrefix)))
+		case d.IsDir():
+			return nil
+		}
+		lower := filepath.Join(m.lower, rel)
+		info, err := os.Lstat(lower)
+		if err != nil || !lowerVisible(m, rel) {
+			status[rel] = "added"
+			return nil
+		}
+		if info.IsDir() {
+			status[rel] = "modified"
+			return deleteLower(rel)
+		}
+		same, err := sameContent(lower, p)
+		if err != nil {
+			return err
+		}
+		if !same {
+			status[rel] = "modified"
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("export changes: %w", err)
+	}
+
+	rels := make([]string, 0, len(status))
+	for rel := range status {
+		rels = append(rels, rel)
+	}
+	sort.Strings(rels)
+	out := &Changeset{Mount: display(m.virtual), Changes: []FileChange{}}
+	var patch strings.Builder
+	for _, rel := range rels {
+		p := display(joinVirtual(m.virtual, rel))
+		out.Changes = append(out.Changes, FileChange{Path: p, Status: status[rel]})
+		fromName, toName := "a/"+p, "b/"+p
+		var oldText, newText string
+		if status[rel] == "added" {
+			fromName = "/dev/null"
+		} else if oldText, err = layerText(filepath.Join(m.lower, rel)); err != nil {
+			return nil, fmt.Errorf("export changes: %w", err)
+		}
+		if status[rel] == "deleted" {
+			toName = "/dev/null"
+		} else if newText, err = layerText(filepath.Join(m.source, rel)); err != nil {
+			return nil, fmt.Errorf("export changes: %w", err)
+		}
+		patch.WriteString(filePatch(fromName, toName, oldText, newText))
+	}
+	out.Patch = patch.String()
+	return out, nil
+}
+
+// layerText returns a file's contents or a symlink's target, and "" for directories.
+func layerText(p string) (string, error) {
+	info, err := os.Lstat(p)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case info.Mode()&fs.ModeSymlink != 0:
+		return os.Readlink(p)
+	case info.Mode().IsRegular():
+		b, err := os.ReadFile(p)
+		return string(b), err
+	default:
+		return "", nil
+	}
+}
+
+func sameContent(a, b string) (bool, error) {
+	ta, err := layerText(a)
+	if err != nil {
+		return false, err
+	}
+	tb, err := layerText(b)
+	if err != nil {
+		return false, err
+	}
+	return ta == tb, nil
+}
+
+func (c *Capability) exportChanges(params map[string]interface{}) *capability.Response {
+	p := capability.StringParam(params, "path", "")
+	if p == "" {
+		for _, m := range c.mounts {
+			if m.lower != "" {
+				if p != "" {
+					return capability.Failure("invalid_params", "path is required when several overlays are mounted")
+				}
+				p = m.virtual
+			}
+		}
+		if p == "" {
+			return capability.Failure("invalid_params", errNotOverlay.Error())
+		}
+	}
+	virtual, err := cleanVirtual(p)
+	if err != nil {
+		return capability.Failure("access_denied", err.Error())
+	}
+	cs, err := c.Changes(virtual)
+	switch {
+	case errors.Is(err, errNotMounted):
+		return capability.Failure("access_denied", err.Error())
+	case errors.Is(err, errNotOverlay):
+		return capability.Failure("invalid_params", err.Error())
+	case err != nil:
+		return capability.Failure("export_failed", err.Error())
+	}
+	if capability.StringParam(params, "format", "") == "changeset" {
+		cs.Patch = ""
+	}
+	return &capability.Response{Success: true, Data: cs}
+}
@@ -1,10 +1,541 @@
 package fs
 
-import "time"
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"spawn.dev/pkg/capability"
+)
 
 // Snapshot holds metadata for filesystem snapshots.
 type Snapshot struct {
-	ID        string
-	CreatedAt time.Time
-	Path      string
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Path      string    `json:"path"`
+	Label     string    `json:"label,omitempty"`
+	Files     int       `json:"files"`
+	Bytes     int64     `json:"bytes"`
+}
+
+// snapshotEntry records one path in a snapshot manifest. Regular files
+// reference a content-addressed object; symlinks keep their link text.
+type snapshotEntry struct {
+	Hash string      `json:"hash,omitempty"`
+	Mode fs.FileMode `json:"mode"`
+	Size int64       `json:"size,omitempty"`
+	Link string      `json:"link,omitempty"`
+}
+
+type snapshotManifest struct {
+	Snapshot
+	Entries map[string]snapshotEntry `json:"entries"`
+}
+
+// FileChange is one path that differs between two workspace states.
+type FileChange struct {
+	Path   string `json:"path"`
+	Status string `json:"status"`
+}
+
+// SnapshotDiff is the result of the diff action.
+type SnapshotDiff struct {
+	From    string       `json:"from"`
+	To      string       `json:"to"`
+	Changes []FileChange `json:"changes"`
+	Patch   string       `json:"patch"`
+}
+
+var errSnapshotNotFound = errors.New("snapshot not found")
+
+// SetSnapshotDir sets where snapshot manifests and objects are stored. The
+// directory should live outside every mount so agents cannot tamper with it.
+func (c *Capability) SetSnapshotDir(dir string) error {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return fmt.Errorf("set snapshot dir: %w", err)
+	}
+	c.snapshotDir = abs
+	return nil
+}
+
+func (c *Capability) snapshotStore() string {
+	if c.snapshotDir != "" {
+		return c.snapshotDir
+	}
+	return c.baseAbs + ".snapshots"
+}
+
+// Snapshot captures every writable mount below "/" into the content-addressed store.
+func (c *Capability) Snapshot(ctx context.Context, label string) (*Snapshot, error) {
+	return c.snapshotPath(ctx, "/", label)
+}
+
+func (c *Capability) snapshotPath(ctx context.Context, virtual, label string) (*Snapshot, error) {
+	entries, err := c.captureEntries(ctx, virtual, true)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot: %w", err)
+	}
+	man := snapshotManifest{
+		Snapshot: Snapshot{ID: uuid.NewString(), CreatedAt: time.Now().UTC(), Path: virtual, Label: label},
+		Entries:  entries,
+	}
+	for _, e := range entries {
+		if e.Hash != "" {
+			man.Files++
+			man.Bytes += e.Size
+		}
+	}
+	b, err := json.MarshalIndent(man, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("snapshot: encode manifest: %w", err)
+	}
+	dir := filepath.Join(c.snapshotStore(), "snapshots")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("snapshot: %w", err)
+	}
+	if err := writeAtomic(filepath.Join(dir, man.ID+".json"), b, 0o644); err != nil {
+		return nil, fmt.Errorf("snapshot: %w", err)
+	}
+	return &man.Snapshot, nil
+}
+
+// captureEntries walks writable mounts below virtual. When store is true file
+// contents are added to the object store; otherwise only hashes are computed.
+func (c *Capability) captureEntries(ctx context.Context, virtual string, store bool) (map[string]snapshotEntry, error) {
+	entries := map[string]snapshotEntry{}
+	err := c.walkVirtual(virtual, 0, func(e walkEntry) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if e.info == nil || !e.mount.writable() {
+			return nil
+		}
+		mode := e.info.Mode()
+		switch {
+		case mode.IsDir():
+			entries[e.virtual] = snapshotEntry{Mode: mode}
+		case mode&fs.ModeSymlink != 0:
+			link, err := os.Readlink(e.host)
+			if err != nil {
+				return err
+			}
+			entries[e.virtual] = snapshotEntry{Mode: mode, Link: link}
+		case mode.IsRegular():
+			hash, err := c.hashFile(e.host, store)
+			if err != nil {
+				return err
+			}
+			entries[e.virtual] = snapshotEntry{Mode: mode, Hash: hash, Size: e.info.Size()}
+		}
+		return nil
+	})
+	return entries, err
+}
+
+// hashFile returns the sha256 of a file, copying it into the object store if
+// store is set and the object is not already present.
+func (c *Capability) hashFile(host string, store bool) (string, error) {
+	f, err := os.Open(host)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	if !store {
+		return sum, nil
+	}
+	obj := c.objectPath(sum)
+	if _, err := os.Stat(obj); err == nil {
+		return sum, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(obj), 0o755); err != nil {
+		return "", err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(obj), ".obj-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, f); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Chmod(tmp.Name(), 0o444); err != nil {
+		return "", err
+	}
+	return sum, os.Rename(tmp.Name(), obj)
+}
+
+func (c *Capability) objectPath(hash string) string {
+	return filepath.Join(c.snapshotStore(), "objects", hash[:2], hash)
+}
+
+func (c *Capability) loadManifest(id string) (*snapshotManifest, error) {
+	if id == "" || strings.ContainsAny(id, `/\`) || strings.Contains(id, "..") {
+		return nil, fmt.Errorf("%w: %q", errSnapshotNotFound, id)
+	}
+	b, err := os.ReadFile(filepath.Join(c.snapshotStore(), "snapshots", id+".json"))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("%w: %s", errSnapshotNotFound, id)
+		}
+		return nil, err
+	}
+	var man snapshotManifest
+	if err := json.Unmarshal(b, &man); err != nil {
+		return nil, fmt.Errorf("decode snapshot %s: %w", id, err)
+	}
+	return &man, nil
+}
+
+// Snapshots returns snapshot metadata ordered oldest first.
+func (c *Capability) Snapshots() ([]Snapshot, error) {
+	dir := filepath.Join(c.snapshotStore(), "snapshots")
+	items, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return []Snapshot{}, nil
+		}
+		return nil, fmt.Errorf("list snapshots: %w", err)
+	}
+	out := []Snapshot{}
+	for _, item := range items {
+		if item.IsDir() || !strings.HasSuffix(item.Name(), ".json") {
+			continue
+		}
+		man, err := c.loadManifest(strings.TrimSuffix(item.Name(), ".json"))
+		if err != nil {
+			return nil, fmt.Errorf("list snapshots: %w", err)
+		}
+		out = append(out, man.Snapshot)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out, nil
+}
+
+// Restore rolls the snapshotted paths back to the snapshot's contents,
+// removing files created after it was taken.
+func (c *Capability) Restore(ctx context.Context, id string) error {
+	man, err := c.loadManifest(id)
+	if err != nil {
+		return fmt.Errorf("restore: %w", err)
+	}
+	// Check every object is present before the first destructive step so a
+	// damaged store fails the restore without touching the workspace.
+	for p, e := range man.Entries {
+		if e.Mode.IsRegular() {
+			if _, err := os.Stat(c.objectPath(e.Hash)); err != nil {
+				return fmt.Errorf("restore: %s: object %s: %w", display(p), e.Hash, err)
+			}
+		}
+	}
+	current, err := c.captureEntries(ctx, man.Path, false)
+	if err != nil {
+		return fmt.Errorf("restore: %w", err)
+	}
+
+	stale := make([]string, 0)
+	for p := range current {
+		if _, ok := man.Entries[p]; !ok {
+			stale = append(stale, p)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(stale)))
+	for _, p := range stale {
+		t, err := c.resolveVirtualFollow(p, false)
+		if err != nil {
+			return fmt.Errorf("restore: %w", err)
+		}
+		if err := c.removePath(t, true); err != nil {
+			return fmt.Errorf("restore: remove %s: %w", display(p), err)
+		}
+	}
+
+	paths := make([]string, 0, len(man.Entries))
+	for p := range man.Entries {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		want := man.Entries[p]
+		if have, ok := current[p]; ok && have.Hash == want.Hash && have.Link == want.Link && have.Mode == want.Mode {
+			continue
+		}
+		t, err := c.resolveVirtualFollow(p, false)
+		if err != nil {
+			return fmt.Errorf("restore: %w", err)
+		}
+		if err := c.restoreEntry(t, want); err != nil {
+			return fmt.Errorf("restore: %s: %w", display(p), err)
+		}
+	}
+
+	c.mu.Lock()
+	for _, m := range c.mounts {
+		m.scanned = false
+	}
+	c.mu.Unlock()
+	return nil
+}
+
+// restoreEntry writes want at t, first removing an existing entry of a
+// different type or a symlink, which would otherwise be followed.
+func (c *Capability) restoreEntry(t target, want snapshotEntry) error {
+	if info, err := os.Lstat(t.readPath()); err == nil && (info.Mode().Type() != want.Mode.Type() || info.Mode()&fs.ModeSymlink != 0) {
+		if err := c.removePath(t, true); err != nil {
+			return err
+		}
+	}
+	switch {
+	case want.Mode.IsDir():
+		if err := c.makeDir(t); err != nil {
+			return err
+		}
+		return os.Chmod(t.host, want.Mode.Perm()|0o700)
+	case want.Mode&fs.ModeSymlink != 0:
+		if err := c.prepareWrite(t); err != nil {
+			return err
+		}
+		return os.Symlink(want.Link, t.host)
+	default:
+		b, err := os.ReadFile(c.objectPath(want.Hash))
+		if err != nil {
+			return fmt.Errorf("read object: %w", err)
+		}
+		if err := c.prepareWrite(t); err != nil {
+			return err
+		}
+		if err := c.writeFile(t, b); err != nil {
+			return err
+		}
+		return os.Chmod(t.host, want.Mode.Perm())
+	}
+}
+
+// PruneSnapshots keeps the newest keep snapshots and removes objects no
+// longer referenced by any remaining snapshot.
+func (c *Capability) PruneSnapshots(keep int) error {
+	snaps, err := c.Snapshots()
+	if err != nil {
+		return err
+	}
+	if keep < 0 {
+		keep = 0
+	}
+	for len(snaps) > keep {
+		if err := os.Remove(filepath.Join(c.snapshotStore(), "snapshots", snaps[0].ID+".json")); err != nil {
+			return fmt.Errorf("prune snapshots: %w", err)
+		}
+		snaps = snaps[1:]
+	}
+	live := map[string]bool{}
+	for _, s := range snaps {
+		man, err := c.loadManifest(s.ID)
+		if err != nil {
+			return fmt.Errorf("prune snapshots: %w", err)
+		}
+		for _, e := range man.Entries {
+			if e.Hash != "" {
+				live[e.Hash] = true
+			}
+		}
+	}
+	objects := filepath.Join(c.snapshotStore(), "objects")
+	return filepath.WalkDir(objects, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || live[d.Name()] || strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+		return os.Remove(p)
+	})
+}
+
+// diffSnapshots compares snapshot from against snapshot to, or against the
+// live workspace when to is empty.
+func (c *Capability) diffSnapshots(ctx context.Context, from, to string) (*SnapshotDiff, error) {
+	base, err := c.loadManifest(from)
+	if err != nil {
+		return nil, err
+	}
+	var other map[string]snapshotEntry
+	liveRead := func(string) (string, error) { return "", nil }
+	if to == "" {
+		if other, err = c.captureEntries(ctx, base.Path, false); err != nil {
+			return nil, err
+		}
+		liveRead = func(p string) (string, error) {
+			t, err := c.resolveVirtual(p)
+			if err != nil {
+				return "", err
+			}
+			b, err := os.ReadFile(t.readPath())
+			return string(b), err
+		}
+	} else {
+		man, err := c.loadManifest(to)
+		if err != nil {
+			return nil, err
+		}
+		other = man.Entries
+	}
+	readSide := func(e snapshotEntry, p string, live bool) (string, error) {
+		if e.Hash == "" {
+			return "", nil
+		}
+		if live {
+			return liveRead(p)
+		}
+		b, err := os.ReadFile(c.objectPath(e.Hash))
+		return string(b), err
+	}
+
+	names := map[string]struct{}{}
+	for p := range base.Entries {
+		names[p] = struct{}{}
+	}
+	for p := range other {
+		names[p] = struct{}{}
+	}
+	paths := make([]string, 0, len(names))
+	for p := range names {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	out := &SnapshotDiff{From: from, To: to, Changes: []FileChange{}}
+	if out.To == "" {
+		out.To = "workspace"
+	}
+	var patch strings.Builder
+	for _, p := range paths {
+		a, inA := base.Entries[p]
+		b, inB := other[p]
+		status := ""
+		switch {
+		case inA && !inB:
+			status = "deleted"
+		case !inA && inB:
+			status = "added"
+		case a.Hash != b.Hash || a.Link != b.Link || a.Mode.Type() != b.Mode.Type():
+			status = "modified"
+		}
+		if status == "" || ((!inA || a.Mode.IsDir()) && (!inB || b.Mode.IsDir())) {
+			continue
+		}
+		out.Changes = append(out.Changes, FileChange{Path: display(p), Status: status})
+		oldText, err := readSide(a, p, false)
+		if err != nil {
+			return nil, err
+		}
+		newText, err := readSide(b, p, to == "")
+		if err != nil {
+			return nil, err
+		}
+		fromName, toName := "a/"+display(p), "b/"+display(p)
+		if !inA {
+			fromName = "/dev/null"
+		}
+		if !inB {
+			toName = "/dev/null"
+		}
+		patch.WriteString(filePatch(fromName, toName, oldText, newText))
+	}
+	out.Patch = patch.String()
+	return out, nil
+}
+
+func (c *Capability) snapshotAction(ctx context.Context, params map[string]interface{}) *capability.Response {
+	virtual, err := cleanVirtual(capability.StringParam(params, "path", "."))
+	if err != nil {
+		return capability.Failure("access_denied", err.Error())
+	}
+	label, _ := params["label"].(string)
+	snap, err := c.snapshotPath(ctx, virtual, label)
+	if err != nil {
+		return capability.Failure("snapshot_failed", err.Error())
+	}
+	return &capability.Response{Success: true, Data: snap}
+}
+
+func (c *Capability) listSnapshots() *capability.Response {
+	snaps, err := c.Snapshots()
+	if err != nil {
+		return capability.Failure("snapshot_failed", err.Error())
+	}
+	return &capability.Response{Success: true, Data: snaps}
+}
+
+func (c *Capability) diffAction(ctx context.Context, params map[string]interface{}) *capability.Response {
+	from, _ := params["from"].(string)
+	to, _ := params["to"].(string)
+	if from == "" {
+		return capability.Failure("invalid_params", "from is required")
+	}
+	d, err := c.diffSnapshots(ctx, from, to)
+	if err != nil {
+		return snapshotFailure(err)
+	}
+	return &capability.Response{Success: true, Data: d}
+}
+
+func (c *Capability) restoreAction(ctx context.Context, params map[string]interface{}) *capability.Response {
+	id, _ := params["id"].(string)
+	if err := c.Restore(ctx, id); err != nil {
+		return snapshotFailure(err)
+	}
+	return &capability.Response{Success: true}
+}
+
+func snapshotFailure(err error) *capability.Response {
+	if errors.Is(err, errSnapshotNotFound) {
+		return capability.Failure("not_found", err.Error())
+	}
+	return capability.Failure("snapshot_failed", err.Error())
+}
+
+func writeAtomic(name string, b []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
 }
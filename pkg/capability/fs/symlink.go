@@ -0,0 +1,183 @@
+package fs
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"spawn.dev/pkg/capability"
+)
+
+// Symlink follow policies.
+const (
+	// SymlinksWithin follows symlinks whose targets stay inside the same mount.
+	SymlinksWithin = "within"
+	// SymlinksDeny refuses to traverse any symlink.
+	SymlinksDeny = "deny"
+)
+
+const maxSymlinkHops = 40
+
+var (
+	errSymlinkEscape = errors.New("symlink escapes mount")
+	errSymlinkDenied = errors.New("symlinks are not allowed")
+)
+
+// SymlinkPolicy controls symlink traversal and creation inside mounts.
+type SymlinkPolicy struct {
+	Follow string
+	Create bool
+}
+
+// SetSymlinkPolicy replaces the symlink policy. An empty Follow means SymlinksWithin.
+func (c *Capability) SetSymlinkPolicy(p SymlinkPolicy) error {
+	switch p.Follow {
+	case "":
+		p.Follow = SymlinksWithin
+	case SymlinksWithin, SymlinksDeny:
+	default:
+		return fmt.Errorf("set symlink policy: invalid follow mode %q", p.Follow)
+	}
+	c.symlinks = p
+	return nil
+}
+
+// secureJoin resolves rel beneath root one component at a time so that no
+// symlink can lead outside it. When followFinal is false a symlink in the
+// last component is returned as-is rather than resolved.
+func (c *Capability) secureJoin(root, rel string, followFinal bool) (string, error) {
+	current := root
+	parts := splitPath(rel)
+	hops := 0
+	for len(parts) > 0 {
+		part := parts[0]
+		parts = parts[1:]
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			if current == root {
+				return "", errSymlinkEscape
+			}
+			current = filepath.Dir(current)
+			continue
+		}
+		next := filepath.Join(current, part)
+		info, err := os.Lstat(next)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				current = next
+				continue
+			}
+			return "", fmt.Errorf("resolve path: %w", err)
+		}
+		if info.Mode()&fs.ModeSymlink == 0 || (len(parts) == 0 && !followFinal) {
+			current = next
+			continue
+		}
+		if c.symlinks.Follow == SymlinksDeny {
+			return "", fmt.Errorf("%w: %s", errSymlinkDenied, part)
+		}
+		hops++
+		if hops > maxSymlinkHops {
+			return "", fmt.Errorf("resolve path: too many levels of symbolic links")
+		}
+		dest, err := os.Readlink(next)
+		if err != nil {
+			return "", fmt.Errorf("resolve path: %w", err)
+		}
+		if filepath.IsAbs(dest) {
+			inside, ok := within(root, filepath.Clean(dest))
+			if !ok {
+				return "", fmt.Errorf("%w: %s", errSymlinkEscape, part)
+			}
+			current = root
+			parts = append(splitPath(inside), parts...)
+			continue
+		}
+		parts = append(splitPath(dest), parts...)
+	}
+	return current, nil
+}
+
+// symlink creates a link at path pointing at target. Absolute targets are
+// agent paths; relative targets are interpreted from the link's directory.
+// Either way the target must stay within the link's mount.
+func (c *Capability) symlink(params map[string]interface{}) *capability.Response {
+	if !c.symlinks.Create {
+		return capability.Failure("access_denied", "symlink creation is disabled")
+	}
+	p, _ := params["path"].(string)
+	dest, _ := params["target"].(string)
+	if dest == "" {
+		return capability.Failure("invalid_params", "target is required")
+	}
+	link, err := c.resolveWritableLink(p)
+	if err != nil {
+		return writeFailure(err)
+	}
+	if !strings.HasPrefix(dest, "/") {
+		dest = path.Dir(link.virtual) + "/" + filepath.ToSlash(dest)
+	}
+	destVirtual, err := cleanVirtual(dest)
+	if err != nil || c.mountFor(destVirtual) != link.mount {
+		return capability.Failure("access_denied", errSymlinkEscape.Error())
+	}
+	destRel := strings.TrimPrefix(strings.TrimPrefix(destVirtual, link.mount.virtual), "/")
+	if link.mount.lower != "" && hasWhiteoutName(destRel) {
+		return capability.Failure("access_denied", errWhiteoutName.Error())
+	}
+	destHost := filepath.Join(link.mount.source, filepath.FromSlash(destRel))
+	relDest, err := filepath.Rel(filepath.Dir(link.host), destHost)
+	if err != nil {
+		return capability.Failure("symlink_failed", err.Error())
+	}
+	if err := c.prepareWrite(link); err != nil {
+		return capability.Failure("mkdir_failed", err.Error())
+	}
+	if err := os.Symlink(relDest, link.host); err != nil {
+		return capability.Failure("symlink_failed", err.Error())
+	}
+	return &capability.Response{Success: true}
+}
+
+// within returns p relative to root if p is root or lies beneath it.
+func within(root, p string) (string, bool) {
+	rel, err := filepath.Rel(root, p)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return rel, true
+}
+
+func splitPath(p string) []string {
+	return strings.Split(filepath.ToSlash(p), "/")
+}
+
+// realPath resolves symlinks in a mount source so later containment checks
+// compare real paths. Missing directories are returned unchanged.
+func realPath(p string) string {
+	if resolved, err := filepath.EvalSymlinks(p); err == nil {
+		return resolved
+	}
+	return p
+}
+
+// openFile opens the resolved target without allowing the kernel to follow
+// symlinks or leave the mount when the platform supports it. Read-only opens
+// of overlay paths fall through to the lower layer.
+func (c *Capability) openFile(t target, flag int, perm os.FileMode) (*os.File, error) {
+	root, host := t.mount.source, t.host
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE) == 0 && t.lower != "" && t.readPath() == t.lower {
+		root, host = t.mount.lower, t.lower
+	}
+	rel, ok := within(root, host)
+	if !ok {
+		return nil, errSymlinkEscape
+	}
+	return openBeneath(root, rel, flag, perm)
+}
@@ -1,9 +1,446 @@
 package fs
 
-// Mount defines a virtual mount point.
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"spawn.dev/pkg/sandbox"
+)
+
+// Mount modes.
+const (
+	ModeReadOnly  = "ro"
+	ModeReadWrite = "rw"
+)
+
+var (
+	errReadOnly      = errors.New("mount is read-only")
+	errQuotaExceeded = errors.New("mount quota exceeded")
+	errNotMounted    = errors.New("path is not mounted")
+)
+
+// Mount defines a virtual mount point. When Overlay is set, Source (or
+// Overlay.LowerDir) is a read-only lower layer and writes go to an upper layer.
 type Mount struct {
-	Path   string
-	Source string
-	Mode   string
-	Quota  string
+	Path    string
+	Source  string
+	Mode    string
+	Quota   string
+	Overlay *OverlayConfig
+}
+
+// mountPoint is a validated mount with its host source and quota accounting.
+type mountPoint struct {
+	virtual string
+	source  string
+	mode    string
+	quota   int64
+	used    int64
+	scanned bool
+	lower   string
+	work    string
+}
+
+func (m *mountPoint) writable() bool { return m.mode != ModeReadOnly }
+
+// target is an agent path resolved to its host location. On overlay mounts
+// host is in the upper layer and lower is set when the path shows through
+// from the lower layer.
+type target struct {
+	virtual string
+	host    string
+	lower   string
+	mount   *mountPoint
+}
+
+func newMountPoint(baseDir string, mount Mount) (*mountPoint, error) {
+	virtual, err := cleanVirtual(mount.Path)
+	if err != nil {
+		return nil, fmt.Errorf("mount %q: %w", mount.Path, err)
+	}
+	mode := strings.ToLower(strings.TrimSpace(mount.Mode))
+	switch mode {
+	case "":
+		mode = ModeReadOnly
+		if mount.Overlay != nil {
+			mode = ModeReadWrite
+		}
+	case ModeReadOnly, ModeReadWrite:
+	default:
+		return nil, fmt.Errorf("mount %q: invalid mode %q", mount.Path, mount.Mode)
+	}
+	quota, err := ParseQuota(mount.Quota)
+	if err != nil {
+		return nil, fmt.Errorf("mount %q: %w", mount.Path, err)
+	}
+	source := mount.Source
+	if source == "" {
+		source = filepath.Join(baseDir, filepath.FromSlash(virtual))
+	}
+	if mount.Overlay != nil {
+		lower, upper, work, err := overlayMount(baseDir, virtual, mount.Source, *mount.Overlay)
+		if err != nil {
+			return nil, fmt.Errorf("mount %q: %w", mount.Path, err)
+		}
+		return &mountPoint{virtual: virtual, source: upper, mode: mode, quota: quota, lower: lower, work: work}, nil
+	}
+	abs, err := filepath.Abs(source)
+	if err != nil {
+		return nil, fmt.Errorf("mount %q: resolve source: %w", mount.Path, err)
+	}
+	return &mountPoint{virtual: virtual, source: filepath.Clean(abs), mode: mode, quota: quota}, nil
+}
+
+// ParseQuota parses sizes such as "512Mi", "10G" or "1048576" into bytes.
+// An empty string means no quota.
+func ParseQuota(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	units := []struct {
+		suffix string
+		mult   int64
+	}{
+		{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"TiB", 1 << 40},
+		{"Ki", 1 << 10}, {"Mi", 1 << 20}, {"Gi", 1 << 30}, {"Ti", 1 << 40},
+		{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"TB", 1e12},
+		{"K", 1e3}, {"M", 1e6}, {"G", 1e9}, {"T", 1e12},
+		{"B", 1},
+	}
+	mult := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(s, u.suffix) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, u.suffix))
+			mult = u.mult
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid quota %q", s)
+	}
+	return int64(n * float64(mult)), nil
+}
+
+// cleanVirtual normalizes an agent path to an absolute slash path, rejecting
+// paths that climb above the namespace root.
+func cleanVirtual(p string) (string, error) {
+	rel := path.Clean(strings.TrimLeft(filepath.ToSlash(p), "/"))
+	if rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", fmt.Errorf("path escapes root")
+	}
+	if rel == "." {
+		return "/", nil
+	}
+	return "/" + rel, nil
+}
+
+// display renders a virtual path the way agents see it in results.
+func display(virtual string) string {
+	if virtual == "/" {
+		return "."
+	}
+	return strings.TrimPrefix(virtual, "/")
+}
+
+func isUnder(p, dir string) bool {
+	return dir == "/" || p == dir || strings.HasPrefix(p, dir+"/")
+}
+
+func joinVirtual(dir, rel string) string {
+	return path.Join(dir, filepath.ToSlash(rel))
+}
+
+func (c *Capability) sortMounts() {
+	sort.Slice(c.mounts, func(i, j int) bool { return c.mounts[i].virtual < c.mounts[j].virtual })
+}
+
+// mountFor returns the deepest mount containing virtual.
+func (c *Capability) mountFor(virtual string) *mountPoint {
+	var best *mountPoint
+	for _, m := range c.mounts {
+		if isUnder(virtual, m.virtual) && (best == nil || len(m.virtual) > len(best.virtual)) {
+			best = m
+		}
+	}
+	return best
+}
+
+// shadowed reports whether virtual belongs to a mount nested inside owner.
+func (c *Capability) shadowed(virtual string, owner *mountPoint) bool {
+	return c.mountFor(virtual) != owner
+}
+
+// resolveTarget maps an agent path to its host location, following symlinks
+// according to the symlink policy.
+func (c *Capability) resolveTarget(p string) (target, error) {
+	return c.resolvePath(p, true)
+}
+
+// resolveLink is like resolveTarget but leaves a symlink in the final
+// component unresolved, for operations on the link itself.
+func (c *Capability) resolveLink(p string) (target, error) {
+	return c.resolvePath(p, false)
+}
+
+func (c *Capability) resolvePath(p string, followFinal bool) (target, error) {
+	if strings.TrimSpace(p) == "" {
+		return target{}, fmt.Errorf("path is required")
+	}
+	virtual, err := cleanVirtual(p)
+	if err != nil {
+		return target{}, err
+	}
+	return c.resolveVirtualFollow(virtual, followFinal)
+}
+
+func (c *Capability) resolveVirtual(virtual string) (target, error) {
+	return c.resolveVirtualFollow(virtual, true)
+}
+
+func (c *Capability) resolveVirtualFollow(virtual string, followFinal bool) (target, error) {
+	m := c.mountFor(virtual)
+	if m == nil {
+		return target{}, errNotMounted
+	}
+	rel := strings.TrimPrefix(strings.TrimPrefix(virtual, m.virtual), "/")
+	if m.lower != "" && hasWhiteoutName(rel) {
+		return target{}, errWhiteoutName
+	}
+	host, err := c.secureJoin(m.source, rel, followFinal)
+	if err != nil {
+		return target{}, err
+	}
+	if _, ok := within(m.source, host); !ok {
+		return target{}, fmt.Errorf("path escapes root")
+	}
+	t := target{virtual: virtual, host: host, mount: m}
+	if m.lower != "" && lowerVisible(m, rel) {
+		lower, err := c.secureJoin(m.lower, rel, followFinal)
+		if err != nil {
+			return target{}, err
+		}
+		if exists(lower) {
+			t.lower = lower
+		}
+	}
+	return t, nil
+}
+
+// walkEntry is one path visited by walkVirtual. info is nil for synthetic
+// directories that exist only as ancestors of mount points.
+type walkEntry struct {
+	virtual string
+	host    string
+	info    fs.FileInfo
+	mount   *mountPoint
+}
+
+// walkVirtual visits everything below virtual across all mounts, limited to
+// maxDepth levels when maxDepth > 0.
+func (c *Capability) walkVirtual(virtual string, maxDepth int, fn func(walkEntry) error) error {
+	depthOf := func(p string) int {
+		rel := strings.TrimPrefix(strings.TrimPrefix(p, virtual), "/")
+		if rel == "" {
+			return 0
+		}
+		return strings.Count(rel, "/") + 1
+	}
+	var roots []target
+	if t, err := c.resolveVirtual(virtual); err == nil {
+		roots = append(roots, t)
+	}
+	for _, m := range c.mounts {
+		if m.virtual != virtual && isUnder(m.virtual, virtual) {
+			roots = append(roots, target{virtual: m.virtual, host: m.source, lower: m.lower, mount: m})
+		}
+	}
+	if len(roots) == 0 {
+		return errNotMounted
+	}
+
+	seen := map[string]bool{}
+	for _, root := range roots {
+		if root.virtual != virtual {
+			parts := strings.Split(strings.TrimPrefix(strings.TrimPrefix(root.virtual, virtual), "/"), "/")
+			for i := range parts {
+				if maxDepth > 0 && i+1 > maxDepth {
+					break
+				}
+				dir := joinVirtual(virtual, strings.Join(parts[:i+1], "/"))
+				if seen[dir] {
+					continue
+				}
+				seen[dir] = true
+				if err := fn(walkEntry{virtual: dir}); err != nil {
+					return err
+				}
+			}
+			if maxDepth > 0 && depthOf(root.virtual) >= maxDepth {
+				continue
+			}
+		}
+		err := walkLayers(root, func(rel, host string, info fs.FileInfo) error {
+			child := joinVirtual(root.virtual, rel)
+			if c.shadowed(child, root.mount) {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if seen[child] {
+				return nil
+			}
+			seen[child] = true
+			if err := fn(walkEntry{virtual: child, host: host, info: info, mount: root.mount}); err != nil {
+				return err
+			}
+			if info.IsDir() && maxDepth > 0 && depthOf(child) >= maxDepth {
+				return filepath.SkipDir
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkLayers visits everything below root with paths relative to it,
+// merging the upper and lower layers of overlay mounts.
+func walkLayers(root target, visit func(rel, host string, info fs.FileInfo) error) error {
+	if root.mount.lower != "" {
+		return walkOverlay(root.host, root.lower, "", visit)
+	}
+	return filepath.WalkDir(root.host, func(host string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			if host == root.host && errors.Is(walkErr, fs.ErrNotExist) {
+				return nil
+			}
+			return walkErr
+		}
+		if host == root.host {
+			return nil
+		}
+		rel, err := filepath.Rel(root.host, host)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return visit(rel, host, info)
+	})
+}
+
+// reserve adjusts quota accounting for m by delta bytes, failing when a
+// positive delta would exceed the quota.
+func (c *Capability) reserve(m *mountPoint, delta int64) error {
+	if m == nil || m.quota <= 0 || delta == 0 {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !m.scanned {
+		used, err := diskUsage(m.source)
+		if err != nil {
+			return fmt.Errorf("measure mount usage: %w", err)
+		}
+		m.used = used
+		m.scanned = true
+	}
+	if delta > 0 && m.used+delta > m.quota {
+		return fmt.Errorf("%w: %d of %d bytes used, %d requested", errQuotaExceeded, m.used, m.quota, delta)
+	}
+	m.used += delta
+	if m.used < 0 {
+		m.used = 0
+	}
+	return nil
+}
+
+// diskUsage sums regular file sizes below root.
+func diskUsage(root string) (int64, error) {
+	var total int64
+	err := filepath.WalkDir(root, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		total += info.Size()
+		return nil
+	})
+	return total, err
+}
+
+// fileSize returns the size of a regular file, or 0 if it does not exist.
+func fileSize(host string) int64 {
+	info, err := os.Lstat(host)
+	if err != nil || !info.Mode().IsRegular() {
+		return 0
+	}
+	return info.Size()
+}
+
+// MountInfo reports one mount and its quota usage.
+type MountInfo struct {
+	Path   string `json:"path"`
+	Source string `json:"source"`
+	Mode   string `json:"mode"`
+	Quota  int64  `json:"quota,omitempty"`
+	Used   int64  `json:"used,omitempty"`
+	Lower  string `json:"lower,omitempty"`
+}
+
+// Mounts returns the resolved mount table.
+func (c *Capability) Mounts() []Mount {
+	out := make([]Mount, 0, len(c.mounts))
+	for _, m := range c.mounts {
+		quota := ""
+		if m.quota > 0 {
+			quota = strconv.FormatInt(m.quota, 10)
+		}
+		mount := Mount{Path: m.virtual, Source: m.source, Mode: m.mode, Quota: quota}
+		if m.lower != "" {
+			mount.Source = m.lower
+			mount.Overlay = &OverlayConfig{LowerDir: m.lower, UpperDir: m.source, WorkDir: m.work}
+		}
+		out = append(out, mount)
+	}
+	return out
+}
+
+// SandboxMounts converts the mount table for use in sandbox.Config.Mounts so
+// processes in the sandbox see the same namespace as the fs capability.
+// Overlays are merged by the capability, not the kernel, so sandboxed
+// processes see only their lower layer, read-only.
+func (c *Capability) SandboxMounts() []sandbox.Mount {
+	out := make([]sandbox.Mount, 0, len(c.mounts))
+	for _, m := range c.mounts {
+		if m.lower != "" {
+			out = append(out, sandbox.Mount{Source: m.lower, Target: m.virtual, Mode: ModeReadOnly})
+			continue
+		}
+		out = append(out, sandbox.Mount{Source: m.source, Target: m.virtual, Mode: m.mode})
+	}
+	return out
 }
@@ -0,0 +1,469 @@
+package fs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/google/uuid"
+
+	"spawn.dev/pkg/capability"
+	"spawn.dev/pkg/mesh"
+	"spawn.dev/pkg/observability"
+)
+
+// Watch event operations.
+const (
+	WatchCreate = "create"
+	WatchModify = "modify"
+	WatchDelete = "delete"
+)
+
+const (
+	defaultWatchDebounce = 100 * time.Millisecond
+	maxWatchBuffer       = 1000
+)
+
+// Each watch holds an inotify instance and one inotify watch per directory,
+// both limited per user by the kernel, so an agent's fs capability may have
+// at most MaxWatches watches covering MaxWatchedDirs directories in all.
+// Directories created beyond the limit under a running watch are not
+// watched.
+const (
+	MaxWatches     = 8
+	MaxWatchedDirs = 4096
+)
+
+var (
+	errWatchNotFound  = errors.New("watch not found")
+	errTooManyWatches = errors.New("too many watches")
+)
+
+// WatchOptions configures a watch. Globs match the agent-visible path or the
+// base name; an empty list matches everything.
+type WatchOptions struct {
+	Path     string
+	Globs    []string
+	Debounce time.Duration
+	Topic    string
+}
+
+// WatchEvent is one debounced change below a watched path.
+type WatchEvent struct {
+	WatchID string    `json:"watch_id"`
+	Path    string    `json:"path"`
+	Op      string    `json:"op"`
+	Time    time.Time `json:"time"`
+}
+
+// watcher tracks one fsnotify watcher and its debounced, buffered events.
+type watcher struct {
+	id      string
+	opts    WatchOptions
+	virtual string
+	fsw     *fsnotify.Watcher
+	dirs    map[string]string
+	mu      sync.Mutex
+	pending map[string]string
+	timer   *time.Timer
+	buffer  []WatchEvent
+	dropped int
+	done    chan struct{}
+}
+
+// SetEventStream publishes watch events to stream as "fs.watch" events.
+func (c *Capability) SetEventStream(stream *observability.EventStream) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.events = stream
+}
+
+// SetMesh lets watches with a topic forward events over m, sent as agentID.
+func (c *Capability) SetMesh(m mesh.Mesh, agentID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.mesh = m
+	c.agentID = agentID
+}
+
+// Watch starts watching opts.Path recursively and returns the watch ID.
+// Events are buffered for WatchEvents and published to the event stream and
+// mesh topic, if configured.
+func (c *Capability) Watch(opts WatchOptions) (string, error) {
+	if opts.Path == "" {
+		opts.Path = "."
+	}
+	virtual, err := cleanVirtual(opts.Path)
+	if err != nil {
+		return "", err
+	}
+	for _, g := range opts.Globs {
+		if _, err := path.Match(strings.ReplaceAll(g, "**", "*"), ""); err != nil {
+			return "", fmt.Errorf("watch: invalid glob %q: %w", g, err)
+		}
+	}
+	if opts.Debounce <= 0 {
+		opts.Debounce = defaultWatchDebounce
+	}
+	c.mu.Lock()
+	n := len(c.watches)
+	c.mu.Unlock()
+	if n >= MaxWatches {
+		return "", fmt.Errorf("watch: %w: %d of %d in use", errTooManyWatches, n, MaxWatches)
+	}
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return "", fmt.Errorf("watch: %w", err)
+	}
+	w := &watcher{
+		id:      uuid.NewString(),
+		opts:    opts,
+		virtual: virtual,
+		fsw:     fsw,
+		dirs:    map[string]string{},
+		pending: map[string]string{},
+		done:    make(chan struct{}),
+	}
+	if err := c.addWatchDirs(w, virtual); err != nil {
+		c.releaseWatchDirs(len(w.dirs))
+		fsw.Close()
+		return "", fmt.Errorf("watch: %w", err)
+	}
+	c.mu.Lock()
+	if len(c.watches) >= MaxWatches {
+		c.watchedDirs -= len(w.dirs)
+		err := fmt.Errorf("watch: %w: %d of %d in use", errTooManyWatches, len(c.watches), MaxWatches)
+		c.mu.Unlock()
+		fsw.Close()
+		return "", err
+	}
+	if c.watches == nil {
+		c.watches = map[string]*watcher{}
+	}
+	c.watches[w.id] = w
+	c.mu.Unlock()
+	go c.runWatch(w)
+	return w.id, nil
+}
+
+// addWatchDirs registers virtual and every directory below it, counting
+// them against MaxWatchedDirs. On overlays both layers are watched so
+// copy-ups and whiteouts are seen. Callers hold w.mu once the watch is
+// running.
+func (c *Capability) addWatchDirs(w *watcher, virtual string) error {
+	add := func(host, dir string) error {
+		if host == "" {
+			return nil
+		}
+		if info, err := os.Stat(host); err != nil || !info.IsDir() {
+			return nil
+		}
+		if _, ok := w.dirs[host]; ok {
+			return nil
+		}
+		c.mu.Lock()
+		if c.watchedDirs >= MaxWatchedDirs {
+			c.mu.Unlock()
+			return fmt.Errorf("%w: %d directories watched", errTooManyWatches, MaxWatchedDirs)
+		}
+		c.watchedDirs++
+		c.mu.Unlock()
+		if err := w.fsw.Add(host); err != nil {
+			c.releaseWatchDirs(1)
+			return err
+		}
+		w.dirs[host] = dir
+		return nil
+	}
+	dirTargets := func(dir string) error {
+		t, err := c.resolveVirtual(dir)
+		if err != nil {
+			return nil
+		}
+		if err := add(t.host, dir); err != nil {
+			return err
+		}
+		return add(t.lower, dir)
+	}
+	if err := dirTargets(virtual); err != nil {
+		return err
+	}
+	return c.walkVirtual(virtual, 0, func(e walkEntry) error {
+		if e.info != nil && !e.info.IsDir() {
+			return nil
+		}
+		return dirTargets(e.virtual)
+	})
+}
+
+func (c *Capability) runWatch(w *watcher) {
+	for {
+		select {
+		case <-w.done:
+			return
+		case ev, ok := <-w.fsw.Events:
+			if !ok {
+				return
+			}
+			c.handleWatchEvent(w, ev)
+		case _, ok := <-w.fsw.Errors:
+			if !ok {
+				return
+			}
+		}
+	}
+}
+
+func (c *Capability) handleWatchEvent(w *watcher, ev fsnotify.Event) {
+	w.mu.Lock()
+	dir, ok := w.dirs[filepath.Dir(ev.Name)]
+	w.mu.Unlock()
+	if !ok {
+		return
+	}
+	name := filepath.Base(ev.Name)
+	op := ""
+	switch {
+	case name == opaqueMarker:
+		return
+	case strings.HasPrefix(name, whiteoutPrefix):
+		if !ev.Has(fsnotify.Create) {
+			return
+		}
+		name, op = strings.TrimPrefix(name, whiteoutPrefix), WatchDelete
+	case ev.Has(fsnotify.Create):
+		op = WatchCreate
+	case ev.Has(fsnotify.Write):
+		op = WatchModify
+	case ev.Has(fsnotify.Remove), ev.Has(fsnotify.Rename):
+		op = WatchDelete
+	default:
+		return
+	}
+	virtual := joinVirtual(dir, name)
+	if op == WatchCreate {
+		if info, err := os.Lstat(ev.Name); err == nil && info.IsDir() {
+			w.mu.Lock()
+			_ = c.addWatchDirs(w, virtual)
+			w.mu.Unlock()
+			// Entries created before the directory was watched produce no
+			// events of their own.
+			_ = c.walkVirtual(virtual, 0, func(e walkEntry) error {
+				c.queueWatchEvent(w, e.virtual, WatchCreate)
+				return nil
+			})
+		}
+	}
+	if op == WatchDelete {
+		w.mu.Lock()
+		if _, ok := w.dirs[ev.Name]; ok {
+			delete(w.dirs, ev.Name)
+			c.releaseWatchDirs(1)
+		}
+		w.mu.Unlock()
+		if t, err := c.resolveVirtualFollow(virtual, false); err == nil && t.exists() {
+			op = WatchModify
+		}
+	}
+	c.queueWatchEvent(w, virtual, op)
+}
+
+// queueWatchEvent records op for virtual and arms the debounce timer.
+func (c *Capability) queueWatchEvent(w *watcher, virtual, op string) {
+	if !w.matches(virtual) {
+		return
+	}
+	w.mu.Lock()
+	w.pending[virtual] = mergeWatchOps(w.pending[virtual], op)
+	if w.timer == nil {
+		w.timer = time.AfterFunc(w.opts.Debounce, func() { c.flushWatch(w) })
+	}
+	w.mu.Unlock()
+}
+
+// mergeWatchOps folds a new operation into one pending for the same path
+// within the debounce window. "" means the changes cancelled out.
+func mergeWatchOps(prev, next string) string {
+	switch {
+	case prev == "":
+		return next
+	case prev == WatchCreate && next == WatchDelete:
+		return ""
+	case prev == WatchCreate:
+		return WatchCreate
+	case prev == WatchDelete && next != WatchDelete:
+		return WatchModify
+	default:
+		return next
+	}
+}
+
+func (w *watcher) matches(virtual string) bool {
+	if len(w.opts.Globs) == 0 {
+		return true
+	}
+	rel := display(virtual)
+	for _, g := range w.opts.Globs {
+		if matchGlob(g, rel) || matchGlob(g, path.Base(virtual)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Capability) flushWatch(w *watcher) {
+	w.mu.Lock()
+	now := time.Now().UTC()
+	var out []WatchEvent
+	for p, op := range w.pending {
+		if op != "" {
+			out = append(out, WatchEvent{WatchID: w.id, Path: display(p), Op: op, Time: now})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Path < out[j].Path })
+	w.pending = map[string]string{}
+	w.timer = nil
+	for _, ev := range out {
+		if len(w.buffer) >= maxWatchBuffer {
+			w.buffer = w.buffer[1:]
+			w.dropped++
+		}
+		w.buffer = append(w.buffer, ev)
+	}
+	w.mu.Unlock()
+
+	c.mu.Lock()
+	stream, m, from := c.events, c.mesh, c.agentID
+	c.mu.Unlock()
+	for _, ev := range out {
+		if stream != nil {
+			stream.Publish(observability.Event{
+				Type:      "fs.watch",
+				Payload:   map[string]interface{}{"watch_id": ev.WatchID, "path": ev.Path, "op": ev.Op},
+				Timestamp: ev.Time,
+			})
+		}
+		if m != nil && w.opts.Topic != "" {
+			_ = m.Send(context.Background(), &mesh.Message{
+				From:      from,
+				Topic:     w.opts.Topic,
+				Type:      mesh.MessageTypeEvent,
+				Payload:   ev,
+				Timestamp: ev.Time,
+			})
+		}
+	}
+}
+
+// WatchEvents drains the events buffered for a watch, oldest first, and
+// reports how many were dropped because the buffer was full.
+func (c *Capability) WatchEvents(id string) ([]WatchEvent, int, error) {
+	c.mu.Lock()
+	w, ok := c.watches[id]
+	c.mu.Unlock()
+	if !ok {
+		return nil, 0, fmt.Errorf("%w: %s", errWatchNotFound, id)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	out, dropped := w.buffer, w.dropped
+	if out == nil {
+		out = []WatchEvent{}
+	}
+	w.buffer, w.dropped = nil, 0
+	return out, dropped, nil
+}
+
+// Unwatch stops a watch.
+func (c *Capability) Unwatch(id string) error {
+	c.mu.Lock()
+	w, ok := c.watches[id]
+	delete(c.watches, id)
+	c.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", errWatchNotFound, id)
+	}
+	close(w.done)
+	w.mu.Lock()
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	c.releaseWatchDirs(len(w.dirs))
+	w.mu.Unlock()
+	return w.fsw.Close()
+}
+
+// releaseWatchDirs returns n directories to the MaxWatchedDirs budget.
+func (c *Capability) releaseWatchDirs(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.watchedDirs -= n
+}
+
+func (c *Capability) closeWatches() {
+	c.mu.Lock()
+	ids := make([]string, 0, len(c.watches))
+	for id := range c.watches {
+		ids = append(ids, id)
+	}
+	c.mu.Unlock()
+	for _, id := range ids {
+		_ = c.Unwatch(id)
+	}
+}
+
+func (c *Capability) watchAction(params map[string]interface{}) *capability.Response {
+	opts := WatchOptions{
+		Path:     capability.StringParam(params, "path", "."),
+		Debounce: time.Duration(capability.IntParam(params, "debounce_ms", 0)) * time.Millisecond,
+		Topic:    capability.StringParam(params, "topic", ""),
+	}
+	switch g := params["glob"].(type) {
+	case string:
+		opts.Globs = []string{g}
+	case []string:
+		opts.Globs = g
+	case []interface{}:
+		for _, v := range g {
+			if s, ok := v.(string); ok {
+				opts.Globs = append(opts.Globs, s)
+			}
+		}
+	}
+	id, err := c.Watch(opts)
+	if errors.Is(err, errTooManyWatches) {
+		return capability.Failure("too_many_watches", err.Error())
+	}
+	if err != nil {
+		return capability.Failure("watch_failed", err.Error())
+	}
+	return &capability.Response{Success: true, Data: map[string]interface{}{"id": id}}
+}
+
+func (c *Capability) watchEventsAction(params map[string]interface{}) *capability.Response {
+	id, _ := params["id"].(string)
+	events, dropped, err := c.WatchEvents(id)
+	if err != nil {
+		return capability.Failure("not_found", err.Error())
+	}
+	return &capability.Response{Success: true, Data: map[string]interface{}{"events": events, "dropped": dropped}}
+}
+
+func (c *Capability) unwatchAction(params map[string]interface{}) *capability.Response {
+	id, _ := params["id"].(string)
+	if err := c.Unwatch(id); err != nil {
+		if errors.Is(err, errWatchNotFound) {
+			return capability.Failure("not_found", err.Error())
+		}
+		return capability.Failure("watch_failed", err.Error())
+	}
+	return &capability.Response{Success: true}
+}
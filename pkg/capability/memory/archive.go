@@ -0,0 +1,433 @@
+package memory
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// Archive format identifiers. Import accepts versions up to ArchiveVersion.
+const (
+	ArchiveFormat  = "spawn-memory"
+	ArchiveVersion = 1
+)
+
+// Import modes.
+const (
+	// ImportMerge adds the archive to existing memory, replacing entries
+	// with the same key.
+	ImportMerge = "merge"
+	// ImportReplace clears the agent's namespaces first.
+	ImportReplace = "replace"
+)
+
+// edgeRecord is the type of edge records; the other records are typed by
+// their expiry kind.
+const edgeRecord = "edge"
+
+// ArchiveHeader is the first line of a memory archive.
+type ArchiveHeader struct {
+	Format    string    `json:"format"`
+	Version   int       `json:"version"`
+	Agent     string    `json:"agent"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// archiveRecord is one entry of a memory archive: a kv pair, vector record,
+// graph node or graph edge of a namespace.
+type archiveRecord struct {
+	Type      string                 `json:"type"`
+	Namespace string                 `json:"namespace"`
+	Key       string                 `json:"key,omitempty"`
+	Value     []byte                 `json:"value,omitempty"`
+	Vector    []float32              `json:"vector,omitempty"`
+	Text      string                 `json:"text,omitempty"`
+	Metadata  map[string]interface{} `json:"metadata,omitempty"`
+	Node      *Node                  `json:"node,omitempty"`
+	Edge      *Edge                  `json:"edge,omitempty"`
+	ExpiresAt *time.Time             `json:"expires_at,omitempty"`
+}
+
+// ExportOptions selects what Export writes. No namespaces means all of the
+// agent's namespaces; shared namespaces are never included.
+type ExportOptions struct {
+	Namespaces []string
+}
+
+// ImportOptions controls Import. Mode is ImportMerge (the default) or
+// ImportReplace; Namespaces, if set, limits both the records imported and
+// the namespaces replaced.
+type ImportOptions struct {
+	Mode       string
+	Namespaces []string
+}
+
+// ImportStats counts what Import wrote. Expired counts entries skipped
+// because their TTL passed since the export.
+type ImportStats struct {
+	KV      int `json:"kv"`
+	Vectors int `json:"vectors"`
+	Nodes   int `json:"nodes"`
+	Edges   int `json:"edges"`
+	Expired int `json:"expired"`
+}
+
+// Namespaces lists the namespaces holding an agent's data.
+func (c *Capability) Namespaces(agent string) ([]string, error) {
+	if !validName.MatchString(agent) {
+		return nil, fmt.Errorf("invalid agent id %q", agent)
+	}
+	found := map[string]bool{}
+	err := c.kv.db.View(func(tx *bbolt.Tx) error {
+		return tx.ForEach(func(name []byte, _ *bbolt.Bucket) error {
+			for _, prefix := range []string{"kv/", "graph_nodes/"} {
+				if rest, ok := strings.CutPrefix(string(name), prefix+agent+"/"); ok {
+					found[rest] = true
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list namespaces: %w", err)
+	}
+	if c.vectorDir != "" {
+		entries, err := os.ReadDir(filepath.Join(c.vectorDir, agent))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("list namespaces: %w", err)
+		}
+		for _, e := range entries {
+			if e.IsDir() && validName.MatchString(e.Name()) {
+				found[e.Name()] = true
+			}
+		}
+	}
+	out := make([]string, 0, len(found))
+	for ns := range found {
+		out = append(out, ns)
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// Export writes an agent's key/value, vector and graph data to w as a
+// gzip-compressed archive of JSON lines: an ArchiveHeader, then one record
+// per entry. Entries keep their expiry times.
+func (c *Capability) Export(_ context.Context, w io.Writer, agent string, opts ExportOptions) error {
+	namespaces, err := c.selectNamespaces(agent, opts.Namespaces)
+	if err != nil {
+		return fmt.Errorf("export memory: %w", err)
+	}
+	zw := gzip.NewWriter(w)
+	enc := json.NewEncoder(zw)
+	if err := enc.Encode(ArchiveHeader{Format: ArchiveFormat, Version: ArchiveVersion, Agent: agent, CreatedAt: time.Now().UTC()}); err != nil {
+		return fmt.Errorf("export memory: %w", err)
+	}
+	now := time.Now()
+	for _, ns := range namespaces {
+		sc, err := c.scope(agent + "/" + ns)
+		if err != nil {
+			return fmt.Errorf("export memory: %w", err)
+		}
+		expiry := func(kind, key string) *time.Time {
+			if at, ok := sc.kv.expiryOf(kind, key); ok {
+				return &at
+			}
+			return nil
+		}
+		live := func(at *time.Time) bool { return at == nil || now.Before(*at) }
+		err = sc.kv.each(now, func(key string, value []byte, at time.Time) error {
+			rec := archiveRecord{Type: kindKV, Namespace: ns, Key: key, Value: value}
+			if !at.IsZero() {
+				rec.ExpiresAt = &at
+			}
+			return enc.Encode(rec)
+		})
+		if err != nil {
+			return fmt.Errorf("export memory: %w", err)
+		}
+		for _, r := range sc.vector.Records() {
+			if at := expiry(kindVector, r.Key); live(at) {
+				rec := archiveRecord{Type: kindVector, Namespace: ns, Key: r.Key, Vector: r.Vector, Text: r.Text, Metadata: r.Metadata, ExpiresAt: at}
+				if err := enc.Encode(rec); err != nil {
+					return fmt.Errorf("export memory: %w", err)
+				}
+			}
+		}
+		for _, n := range sc.graph.Nodes() {
+			if at := expiry(kindNode, n.ID); live(at) {
+				if err := enc.Encode(archiveRecord{Type: kindNode, Namespace: ns, Node: n, ExpiresAt: at}); err != nil {
+					return fmt.Errorf("export memory: %w", err)
+				}
+			}
+		}
+		for _, e := range sc.graph.Edges() {
+			if err := enc.Encode(archiveRecord{Type: edgeRecord, Namespace: ns, Edge: e}); err != nil {
+				return fmt.Errorf("export memory: %w", err)
+			}
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("export memory: %w", err)
+	}
+	return nil
+}
+
+// Import reads an archive written by Export into an agent's memory, which
+// need not be the agent it was exported from. The whole archive is read
+// and checked against the agent's stores, including vector dimensions and
+// metric, before anything is cleared or written; only a storage failure
+// while writing can leave an import part done.
+func (c *Capability) Import(ctx context.Context, r io.Reader, agent string, opts ImportOptions) (*ImportStats, error) {
+	switch opts.Mode {
+	case "":
+		opts.Mode = ImportMerge
+	case ImportMerge, ImportReplace:
+	default:
+		return nil, fmt.Errorf("import memory: unknown mode %q: want merge or replace", opts.Mode)
+	}
+	if !validName.MatchString(agent) {
+		return nil, fmt.Errorf("import memory: invalid agent id %q", agent)
+	}
+	records, err := readArchive(r)
+	if err != nil {
+		return nil, fmt.Errorf("import memory: %w", err)
+	}
+	wanted := func(ns string) bool {
+		if len(opts.Namespaces) == 0 {
+			return true
+		}
+		for _, want := range opts.Namespaces {
+			if ns == want {
+				return true
+			}
+		}
+		return false
+	}
+	if err := c.checkImport(agent, records, wanted); err != nil {
+		return nil, fmt.Errorf("import memory: %w", err)
+	}
+
+	if opts.Mode == ImportReplace {
+		namespaces, err := c.selectNamespaces(agent, opts.Namespaces)
+		if err != nil {
+			return nil, fmt.Errorf("import memory: %w", err)
+		}
+		for _, ns := range namespaces {
+			if err := c.clearScope(ctx, agent+"/"+ns); err != nil {
+				return nil, fmt.Errorf("import memory: %w", err)
+			}
+		}
+	}
+
+	stats := &ImportStats{}
+	now := time.Now()
+	for _, rec := range records {
+		if !wanted(rec.Namespace) {
+			continue
+		}
+		var at time.Time
+		if rec.ExpiresAt != nil {
+			if !now.Before(*rec.ExpiresAt) {
+				stats.Expired++
+				continue
+			}
+			at = *rec.ExpiresAt
+		}
+		sc, err := c.scope(agent + "/" + rec.Namespace)
+		if err != nil {
+			return stats, fmt.Errorf("import memory: %w", err)
+		}
+		switch rec.Type {
+		case kindKV:
+			value := rec.Value
+			if value == nil {
+				value = []byte{}
+			}
+			err = sc.kv.setAt(rec.Key, value, at)
+			stats.KV++
+		case kindVector:
+			err = sc.vector.PutRecord(ctx, VectorRecord{Key: rec.Key, Vector: rec.Vector, Text: rec.Text, Metadata: rec.Metadata})
+			if err == nil {
+				err = sc.kv.setExpiry(kindVector, rec.Key, at)
+			}
+			stats.Vectors++
+		case kindNode:
+			err = sc.graph.PutNode(ctx, *rec.Node)
+			if err == nil {
+				err = sc.kv.setExpiry(kindNode, rec.Node.ID, at)
+			}
+			stats.Nodes++
+		case edgeRecord:
+			err = sc.graph.PutEdge(ctx, *rec.Edge)
+			stats.Edges++
+		}
+		if err != nil {
+			return stats, fmt.Errorf("import memory: %s %s: %w", rec.Type, rec.Namespace, err)
+		}
+	}
+	return stats, nil
+}
+
+// readArchive decodes and checks a whole archive.
+func readArchive(r io.Reader) ([]archiveRecord, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("read archive: %w", err)
+	}
+	defer zr.Close()
+	dec := json.NewDecoder(zr)
+	var header ArchiveHeader
+	if err := dec.Decode(&header); err != nil {
+		return nil, fmt.Errorf("read archive header: %w", err)
+	}
+	if header.Format != ArchiveFormat {
+		return nil, fmt.Errorf("not a memory archive")
+	}
+	if header.Version < 1 || header.Version > ArchiveVersion {
+		return nil, fmt.Errorf("unsupported archive version %d", header.Version)
+	}
+	var records []archiveRecord
+	for line := 2; ; line++ {
+		var rec archiveRecord
+		if err := dec.Decode(&rec); errors.Is(err, io.EOF) {
+			return records, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("read archive record %d: %w", line, err)
+		}
+		if !validName.MatchString(rec.Namespace) {
+			return nil, fmt.Errorf("archive record %d: invalid namespace %q", line, rec.Namespace)
+		}
+		switch {
+		case rec.Type == kindKV || rec.Type == kindVector:
+			if rec.Key == "" {
+				return nil, fmt.Errorf("archive record %d: %s without key", line, rec.Type)
+			}
+		case rec.Type == kindNode && rec.Node != nil && rec.Node.ID != "":
+		case rec.Type == edgeRecord && rec.Edge != nil && rec.Edge.From != "" && rec.Edge.To != "" && rec.Edge.Type != "":
+		default:
+			return nil, fmt.Errorf("archive record %d: invalid %q record", line, rec.Type)
+		}
+		records = append(records, rec)
+	}
+}
+
+// checkImport checks that the wanted vector records fit the agent's vector
+// stores, so a replace never clears memory it then cannot refill.
+func (c *Capability) checkImport(agent string, records []archiveRecord, wanted func(string) bool) error {
+	dims := map[string]int{}
+	for _, rec := range records {
+		if rec.Type != kindVector || !wanted(rec.Namespace) {
+			continue
+		}
+		sc, err := c.scope(agent + "/" + rec.Namespace)
+		if err != nil {
+			return err
+		}
+		want, ok := dims[rec.Namespace]
+		if !ok {
+			want = sc.vector.Dimensions()
+		}
+		if want == 0 {
+			want = len(rec.Vector)
+		}
+		dims[rec.Namespace] = want
+		if err := sc.vector.checkVector(rec.Vector, want); err != nil {
+			return fmt.Errorf("vector %s in %s: %w", rec.Key, rec.Namespace, err)
+		}
+	}
+	return nil
+}
+
+// selectNamespaces returns the agent's namespaces, or the requested ones.
+func (c *Capability) selectNamespaces(agent string, requested []string) ([]string, error) {
+	if len(requested) == 0 {
+		return c.Namespaces(agent)
+	}
+	if !validName.MatchString(agent) {
+		return nil, fmt.Errorf("invalid agent id %q", agent)
+	}
+	for _, ns := range requested {
+		if !validName.MatchString(ns) {
+			return nil, fmt.Errorf("invalid namespace %q", ns)
+		}
+	}
+	return requested, nil
+}
+
+// clearScope deletes everything stored in a scope.
+func (c *Capability) clearScope(ctx context.Context, name string) error {
+	sc, err := c.scope(name)
+	if err != nil {
+		return err
+	}
+	for _, r := range sc.vector.Records() {
+		if err := sc.vector.Delete(ctx, r.Key); err != nil {
+			return err
+		}
+	}
+	if err := sc.graph.clear(); err != nil {
+		return err
+	}
+	return sc.kv.clear()
+}
+
+// Backup writes a consistent copy of the memory database and every
+// persisted vector index to w as a tar archive, while they stay in use.
+// Each file is copied in a bbolt read transaction: memory.db, then
+// vectors/<agent>/<namespace>/vectors.db and
+// vectors/_shared/<namespace>/vectors.db. Restore by extracting the files
+// into place while the daemon is stopped.
+func (c *Capability) Backup(_ context.Context, w io.Writer) error {
+	tw := tar.NewWriter(w)
+	copyTx := func(name string) func(*bbolt.Tx) error {
+		return func(tx *bbolt.Tx) error {
+			hdr := &tar.Header{Name: name, Mode: 0o600, Size: tx.Size(), ModTime: time.Now(), Typeflag: tar.TypeReg}
+			if err := tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+			_, err := tx.WriteTo(tw)
+			return err
+		}
+	}
+	if err := c.kv.db.View(copyTx("memory.db")); err != nil {
+		return fmt.Errorf("backup memory: %w", err)
+	}
+	if c.vectorDir != "" {
+		scopes, err := filepath.Glob(filepath.Join(c.vectorDir, "*", "*", vectorFile))
+		if err != nil {
+			return fmt.Errorf("backup memory: %w", err)
+		}
+		sort.Strings(scopes)
+		for _, file := range scopes {
+			rel, err := filepath.Rel(c.vectorDir, filepath.Dir(file))
+			if err != nil {
+				return fmt.Errorf("backup memory: %w", err)
+			}
+			name := filepath.ToSlash(rel)
+			sc, err := c.scope(name)
+			if err != nil {
+				return fmt.Errorf("backup memory: %w", err)
+			}
+			if err := sc.vector.snapshot(copyTx(path.Join("vectors", name, vectorFile))); err != nil {
+				return fmt.Errorf("backup memory: %s: %w", name, err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("backup memory: %w", err)
+	}
+	return nil
+}
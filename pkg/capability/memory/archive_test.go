@@ -0,0 +1,155 @@
+package memory
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExportImport(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	src := newTestMemory(t)
+	for ns, params := range map[string][]map[string]interface{}{
+		"default": {
+			{"action": "kv_set", "key": "k", "value": "v"},
+			{"action": "kv_set", "key": "temp", "value": "t", "ttl": "1h"},
+			{"action": "vector_put", "key": "doc", "vector": []interface{}{1.0, 0.0}, "text": "hello", "metadata": map[string]interface{}{"lang": "en"}},
+			{"action": "graph_put_node", "id": "ada", "labels": []interface{}{"Person"}},
+			{"action": "graph_put_edge", "from": "ada", "to": "acme", "type": "WORKS_AT"},
+		},
+		"notes": {
+			{"action": "kv_set", "key": "n", "value": "note"},
+		},
+	} {
+		for _, p := range params {
+			p["namespace"] = ns
+			if resp := executeAs(t, src, "alice", p["action"].(string), p); !resp.Success {
+				t.Fatalf("%v: %+v", p, resp.Error)
+			}
+		}
+	}
+	executeAs(t, src, "bob", "kv_set", map[string]interface{}{"key": "bob", "value": "secret"})
+
+	var archive bytes.Buffer
+	if err := src.Export(ctx, &archive, "alice", ExportOptions{}); err != nil {
+		t.Fatalf("export: %v", err)
+	}
+
+	dst := newTestMemory(t)
+	executeAs(t, dst, "carol", "kv_set", map[string]interface{}{"key": "old", "value": "kept"})
+	stats, err := dst.Import(ctx, bytes.NewReader(archive.Bytes()), "carol", ImportOptions{})
+	if err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	if *stats != (ImportStats{KV: 3, Vectors: 1, Nodes: 2, Edges: 1}) {
+		t.Fatalf("stats = %+v", *stats)
+	}
+	sc := testScope(t, dst, "carol/default")
+	if rec, ok := sc.vector.Get(ctx, "doc"); !ok || rec.Text != "hello" || rec.Metadata["lang"] != "en" {
+		t.Fatalf("imported vector = %+v", rec)
+	}
+	if out, _ := sc.graph.Neighbors(ctx, "ada", DirectionOut, nil); len(out) != 1 || out[0].Node.ID != "acme" {
+		t.Fatalf("imported edge = %+v", out)
+	}
+	if at, ok := sc.kv.expiryOf(kindKV, "temp"); !ok || at.IsZero() {
+		t.Fatalf("ttl was not kept")
+	}
+	if got := executeAs(t, dst, "carol", "kv_get", map[string]interface{}{"key": "n", "namespace": "notes"}).Data; got != "note" {
+		t.Fatalf("notes kv = %q", got)
+	}
+	if got := executeAs(t, dst, "carol", "kv_get", map[string]interface{}{"key": "old"}).Data; got != "kept" {
+		t.Fatalf("merge dropped existing key: %q", got)
+	}
+	if ns, _ := dst.Namespaces("carol"); len(ns) != 2 {
+		t.Fatalf("namespaces = %v", ns)
+	}
+
+	// Replace clears what the archive does not hold.
+	if _, err := dst.Import(ctx, bytes.NewReader(archive.Bytes()), "carol", ImportOptions{Mode: ImportReplace}); err != nil {
+		t.Fatalf("replace: %v", err)
+	}
+	if got := executeAs(t, dst, "carol", "kv_get", map[string]interface{}{"key": "old"}).Data; got != "" {
+		t.Fatalf("replace kept %q", got)
+	}
+	if sc.vector.Len() != 1 {
+		t.Fatalf("replace left %d vectors", sc.vector.Len())
+	}
+
+	// An archive that does not fit the target's vectors leaves it intact.
+	executeAs(t, dst, "dave", "vector_put", map[string]interface{}{"key": "wide", "vector": []interface{}{1.0, 0.0, 0.0}})
+	executeAs(t, dst, "dave", "kv_set", map[string]interface{}{"key": "keep", "value": "me"})
+	if _, err := dst.Import(ctx, bytes.NewReader(archive.Bytes()), "dave", ImportOptions{Mode: ImportReplace}); !errors.Is(err, ErrDimensionMismatch) {
+		t.Fatalf("expected a dimension mismatch, got %v", err)
+	}
+	if got := executeAs(t, dst, "dave", "kv_get", map[string]interface{}{"key": "keep"}).Data; got != "me" {
+		t.Fatalf("failed replace cleared memory: %q", got)
+	}
+
+	if _, err := dst.Import(ctx, bytes.NewReader([]byte("not gzip")), "carol", ImportOptions{}); err == nil {
+		t.Fatalf("garbage imported")
+	}
+	if _, err := dst.Import(ctx, bytes.NewReader(archive.Bytes()), "carol", ImportOptions{Mode: "overwrite"}); err == nil {
+		t.Fatalf("unknown mode accepted")
+	}
+}
+
+func TestBackup(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	c, err := New(filepath.Join(dir, "kv.db"))
+	if err != nil {
+		t.Fatalf("new memory: %v", err)
+	}
+	defer c.Shutdown(context.Background())
+	c.SetVectorStorage(filepath.Join(dir, "vectors"), VectorOptions{})
+	executeAs(t, c, "alice", "kv_set", map[string]interface{}{"key": "k", "value": "v"})
+	executeAs(t, c, "alice", "vector_put", map[string]interface{}{"key": "doc", "vector": []interface{}{1.0, 0.0}})
+
+	var buf bytes.Buffer
+	if err := c.Backup(context.Background(), &buf); err != nil {
+		t.Fatalf("backup: %v", err)
+	}
+	restore := t.TempDir()
+	tr := tar.NewReader(&buf)
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("read tar: %v", err)
+		}
+		names = append(names, hdr.Name)
+		path := filepath.Join(restore, filepath.FromSlash(hdr.Name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(tr)
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(names) != 2 || names[0] != "memory.db" || names[1] != "vectors/alice/default/vectors.db" {
+		t.Fatalf("backup files = %v", names)
+	}
+
+	restored, err := New(filepath.Join(restore, "memory.db"))
+	if err != nil {
+		t.Fatalf("open restored memory: %v", err)
+	}
+	defer restored.Shutdown(context.Background())
+	restored.SetVectorStorage(filepath.Join(restore, "vectors"), VectorOptions{})
+	if got := executeAs(t, restored, "alice", "kv_get", map[string]interface{}{"key": "k"}).Data; got != "v" {
+		t.Fatalf("restored kv = %q", got)
+	}
+	if n := testScope(t, restored, "alice/default").vector.Len(); n != 1 {
+		t.Fatalf("restored vectors = %d", n)
+	}
+}
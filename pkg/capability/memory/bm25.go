@@ -0,0 +1,118 @@
+package memory
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// BM25 parameters: k1 limits how much repeated terms count and b how much
+// long documents are penalised.
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// keywordIndex is an inverted index over record texts, scored with BM25.
+// It is rebuilt from the stored texts when a store is opened.
+type keywordIndex struct {
+	postings map[string]map[uint32]int // term -> id -> term frequency
+	lengths  map[uint32]int
+	total    int
+}
+
+func newKeywordIndex() *keywordIndex {
+	return &keywordIndex{postings: map[string]map[uint32]int{}, lengths: map[uint32]int{}}
+}
+
+// tokenize lowercases text and splits it into runs of letters, digits and
+// underscores, so identifiers such as ERR_CONN_RESET or parseConfig stay
+// whole.
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	})
+}
+
+func (k *keywordIndex) add(id uint32, text string) {
+	terms := tokenize(text)
+	if len(terms) == 0 {
+		return
+	}
+	for _, term := range terms {
+		docs := k.postings[term]
+		if docs == nil {
+			docs = map[uint32]int{}
+			k.postings[term] = docs
+		}
+		docs[id]++
+	}
+	k.lengths[id] = len(terms)
+	k.total += len(terms)
+}
+
+func (k *keywordIndex) remove(id uint32, text string) {
+	length, ok := k.lengths[id]
+	if !ok {
+		return
+	}
+	for _, term := range tokenize(text) {
+		if docs := k.postings[term]; docs != nil {
+			delete(docs, id)
+			if len(docs) == 0 {
+				delete(k.postings, term)
+			}
+		}
+	}
+	delete(k.lengths, id)
+	k.total -= length
+}
+
+// search scores the documents accepted by keep against query and returns
+// the best n, highest score first.
+func (k *keywordIndex) search(query string, n int, keep func(uint32) bool) []candidate {
+	docs := len(k.lengths)
+	if docs == 0 || n <= 0 {
+		return nil
+	}
+	avg := float64(k.total) / float64(docs)
+	scores := map[uint32]float64{}
+	seen := map[string]bool{}
+	for _, term := range tokenize(query) {
+		if seen[term] {
+			continue
+		}
+		seen[term] = true
+		postings := k.postings[term]
+		if len(postings) == 0 {
+			continue
+		}
+		df := float64(len(postings))
+		idf := math.Log(1 + (float64(docs)-df+0.5)/(df+0.5))
+		for id, tf := range postings {
+			if !keep(id) {
+				continue
+			}
+			f := float64(tf)
+			norm := bm25K1 * (1 - bm25B + bm25B*float64(k.lengths[id])/avg)
+			scores[id] += idf * f * (bm25K1 + 1) / (f + norm)
+		}
+	}
+	// candidate.dist holds the negated score so nearer means better, as
+	// for vector results.
+	out := make([]candidate, 0, len(scores))
+	for id, score := range scores {
+		out = append(out, candidate{id, -score})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].dist == out[j].dist {
+			return out[i].id < out[j].id
+		}
+		return out[i].dist < out[j].dist
+	})
+	if len(out) > n {
+		out = out[:n]
+	}
+	return out
+}
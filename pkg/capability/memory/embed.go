@@ -0,0 +1,177 @@
+package memory
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Embedding defaults.
+const (
+	DefaultChunkSize    = 1000
+	DefaultChunkOverlap = 100
+	DefaultEmbedBatch   = 64
+	DefaultEmbedCache   = 10000
+)
+
+// Embedder turns texts into vectors. llm.Provider implements it.
+type Embedder interface {
+	Embed(ctx context.Context, input []string) ([][]float32, error)
+}
+
+// EmbedOptions configures how remember and recall embed text.
+type EmbedOptions struct {
+	// ChunkSize and ChunkOverlap are in bytes; chunks break between words
+	// and overlap the previous chunk's tail. A negative overlap disables
+	// it.
+	ChunkSize    int
+	ChunkOverlap int
+	// BatchSize caps the texts sent in one Embed call.
+	BatchSize int
+	// CacheSize is the number of embeddings kept, by text, to avoid
+	// embedding the same text twice.
+	CacheSize int
+}
+
+func (o EmbedOptions) withDefaults() EmbedOptions {
+	if o.ChunkSize <= 0 {
+		o.ChunkSize = DefaultChunkSize
+	}
+	if o.ChunkOverlap < 0 || o.ChunkOverlap >= o.ChunkSize {
+		o.ChunkOverlap = 0
+	} else if o.ChunkOverlap == 0 {
+		o.ChunkOverlap = min(DefaultChunkOverlap, o.ChunkSize/4)
+	}
+	if o.BatchSize <= 0 {
+		o.BatchSize = DefaultEmbedBatch
+	}
+	if o.CacheSize <= 0 {
+		o.CacheSize = DefaultEmbedCache
+	}
+	return o
+}
+
+// embedder batches Embed calls and caches their results.
+type embedder struct {
+	provider Embedder
+	opts     EmbedOptions
+
+	mu    sync.Mutex
+	order *list.List // of cacheEntry, most recent first
+	cache map[[sha256.Size]byte]*list.Element
+}
+
+type cacheEntry struct {
+	sum [sha256.Size]byte
+	vec []float32
+}
+
+func newEmbedder(provider Embedder, opts EmbedOptions) *embedder {
+	return &embedder{provider: provider, opts: opts.withDefaults(), order: list.New(), cache: map[[sha256.Size]byte]*list.Element{}}
+}
+
+// embed returns one vector per text.
+func (e *embedder) embed(ctx context.Context, texts []string) ([][]float32, error) {
+	out := make([][]float32, len(texts))
+	var missing []int
+	e.mu.Lock()
+	for i, text := range texts {
+		if el, ok := e.cache[sha256.Sum256([]byte(text))]; ok {
+			e.order.MoveToFront(el)
+			out[i] = el.Value.(cacheEntry).vec
+		} else {
+			missing = append(missing, i)
+		}
+	}
+	e.mu.Unlock()
+
+	for start := 0; start < len(missing); start += e.opts.BatchSize {
+		batch := missing[start:min(start+e.opts.BatchSize, len(missing))]
+		input := make([]string, len(batch))
+		for j, i := range batch {
+			input[j] = texts[i]
+		}
+		vecs, err := e.provider.Embed(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("embed: %w", err)
+		}
+		if len(vecs) != len(input) {
+			return nil, fmt.Errorf("embed: provider returned %d vectors for %d texts", len(vecs), len(input))
+		}
+		e.mu.Lock()
+		for j, i := range batch {
+			out[i] = vecs[j]
+			e.store(sha256.Sum256([]byte(texts[i])), vecs[j])
+		}
+		e.mu.Unlock()
+	}
+	return out, nil
+}
+
+func (e *embedder) store(sum [sha256.Size]byte, vec []float32) {
+	if el, ok := e.cache[sum]; ok {
+		e.order.MoveToFront(el)
+		return
+	}
+	e.cache[sum] = e.order.PushFront(cacheEntry{sum: sum, vec: vec})
+	for e.order.Len() > e.opts.CacheSize {
+		oldest := e.order.Back()
+		e.order.Remove(oldest)
+		delete(e.cache, oldest.Value.(cacheEntry).sum)
+	}
+}
+
+// chunkText splits text into chunks of at most size bytes, breaking
+// between words. Each chunk after the first repeats up to overlap
+// bytes of words from the end of the previous one. Words longer than
+// size are split.
+func chunkText(text string, size, overlap int) []string {
+	var words []string
+	for _, w := range strings.Fields(text) {
+		for len(w) > size {
+			cut := size
+			for cut > 1 && !utf8.RuneStart(w[cut]) {
+				cut--
+			}
+			words = append(words, w[:cut])
+			w = w[cut:]
+		}
+		words = append(words, w)
+	}
+	var chunks []string
+	for start := 0; start < len(words); {
+		end, length := start, 0
+		for end < len(words) && length+len(words[end])+min(end-start, 1) <= size {
+			length += len(words[end]) + min(end-start, 1)
+			end++
+		}
+		chunks = append(chunks, strings.Join(words[start:end], " "))
+		if end == len(words) {
+			break
+		}
+		// Step back over words that fit in the overlap, but always advance.
+		next, tail := end, 0
+		for next-1 > start && tail+len(words[next-1])+1 <= overlap {
+			next--
+			tail += len(words[next]) + 1
+		}
+		start = next
+	}
+	return chunks
+}
+
+// textKey derives a record key from text when the caller gives none.
+func textKey(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return "mem-" + hex.EncodeToString(sum[:8])
+}
+
+// chunkKey is the vector key of chunk i of a remembered text.
+func chunkKey(key string, i int) string {
+	return fmt.Sprintf("%s#%d", key, i)
+}
@@ -0,0 +1,195 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// EpisodeNamespace is the namespace holding an agent's episodes.
+const EpisodeNamespace = "episodes"
+
+// Episode outcomes.
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// Episode summarises one finished task so later tasks can draw on it.
+type Episode struct {
+	ID        string        `json:"id"`
+	TaskID    string        `json:"task_id,omitempty"`
+	Task      string        `json:"task"`
+	Summary   string        `json:"summary"`
+	Outcome   string        `json:"outcome"`
+	Error     string        `json:"error,omitempty"`
+	Tools     []string      `json:"tools,omitempty"`
+	CostUSD   float64       `json:"cost_usd"`
+	Tokens    int64         `json:"tokens"`
+	Duration  time.Duration `json:"duration"`
+	CreatedAt time.Time     `json:"created_at"`
+	// Vector embeds Task and Summary when an embedder is set.
+	Vector []float32 `json:"vector,omitempty"`
+	// Score is the relevance of a recalled episode; higher is better.
+	Score float64 `json:"score,omitempty"`
+}
+
+// EpisodeRetention bounds an agent's episodes. Zero values keep them all.
+type EpisodeRetention struct {
+	// MaxEpisodes keeps only the most recent episodes.
+	MaxEpisodes int
+	// MaxAge expires episodes like a ttl.
+	MaxAge time.Duration
+}
+
+// RecordEpisode stores ep in the agent's episodes namespace, embedding it
+// when the agent has an embedder, and prunes episodes beyond the retention.
+func (c *Capability) RecordEpisode(ctx context.Context, agent string, ep Episode, keep EpisodeRetention) error {
+	sc, err := c.episodes(agent)
+	if err != nil {
+		return fmt.Errorf("record episode: %w", err)
+	}
+	if ep.CreatedAt.IsZero() {
+		ep.CreatedAt = time.Now().UTC()
+	}
+	if ep.ID == "" {
+		// Zero-padded timestamps keep the keys in chronological order.
+		ep.ID = fmt.Sprintf("%020d", ep.CreatedAt.UnixNano())
+	}
+	ep.Score = 0
+	if embed := c.settingsFor(agent).embed; embed != nil {
+		vecs, err := embed.embed(ctx, []string{ep.text()})
+		if err != nil {
+			return fmt.Errorf("record episode: %w", err)
+		}
+		ep.Vector = vecs[0]
+	}
+	data, err := json.Marshal(ep)
+	if err != nil {
+		return fmt.Errorf("record episode: %w", err)
+	}
+	if err := sc.kv.SetTTL(ctx, ep.ID, data, keep.MaxAge); err != nil {
+		return fmt.Errorf("record episode: %w", err)
+	}
+	if keep.MaxEpisodes <= 0 {
+		return nil
+	}
+	all, err := c.loadEpisodes(sc)
+	if err != nil {
+		return fmt.Errorf("record episode: %w", err)
+	}
+	for _, old := range all[:max(len(all)-keep.MaxEpisodes, 0)] {
+		if err := sc.kv.Delete(ctx, old.ID); err != nil {
+			return fmt.Errorf("prune episodes: %w", err)
+		}
+	}
+	return nil
+}
+
+// RecallEpisodes returns up to k of the agent's episodes most relevant to
+// query, fusing BM25 keyword ranks with embedding similarity as
+// hybrid_search does. Episodes relevant to neither are left out; an empty
+// query returns the most recent episodes.
+func (c *Capability) RecallEpisodes(ctx context.Context, agent, query string, k int) ([]Episode, error) {
+	sc, err := c.episodes(agent)
+	if err != nil {
+		return nil, fmt.Errorf("recall episodes: %w", err)
+	}
+	all, err := c.loadEpisodes(sc)
+	if err != nil || k <= 0 {
+		return nil, err
+	}
+	if strings.TrimSpace(query) == "" {
+		recent := all[max(len(all)-k, 0):]
+		out := make([]Episode, 0, len(recent))
+		for i := len(recent) - 1; i >= 0; i-- {
+			out = append(out, recent[i].recalled(0))
+		}
+		return out, nil
+	}
+
+	fused := map[int]float64{}
+	index := newKeywordIndex()
+	for i, ep := range all {
+		index.add(uint32(i), ep.text())
+	}
+	for rank, found := range index.search(query, len(all), func(uint32) bool { return true }) {
+		fused[int(found.id)] += 1 / float64(DefaultRRFConstant+rank+1)
+	}
+	if embed := c.settingsFor(agent).embed; embed != nil {
+		vecs, err := embed.embed(ctx, []string{query})
+		if err != nil {
+			return nil, fmt.Errorf("recall episodes: %w", err)
+		}
+		q := vecs[0]
+		normalize(q)
+		var ranked []candidate
+		for i, ep := range all {
+			if len(ep.Vector) != len(q) || !normalize(ep.Vector) {
+				continue
+			}
+			ranked = append(ranked, candidate{uint32(i), -dot(q, ep.Vector)})
+		}
+		sort.SliceStable(ranked, func(i, j int) bool { return ranked[i].dist < ranked[j].dist })
+		for rank, found := range ranked {
+			fused[int(found.id)] += 1 / float64(DefaultRRFConstant+rank+1)
+		}
+	}
+
+	order := make([]int, 0, len(fused))
+	for i := range fused {
+		order = append(order, i)
+	}
+	// Newer episodes win ties.
+	sort.Slice(order, func(a, b int) bool {
+		if fused[order[a]] != fused[order[b]] {
+			return fused[order[a]] > fused[order[b]]
+		}
+		return order[a] > order[b]
+	})
+	out := make([]Episode, 0, min(k, len(order)))
+	for _, i := range order[:min(k, len(order))] {
+		out = append(out, all[i].recalled(fused[i]))
+	}
+	return out, nil
+}
+
+// episodes returns the scope of an agent's episodes, which are never
+// shared.
+func (c *Capability) episodes(agent string) (*scope, error) {
+	if !validName.MatchString(agent) {
+		return nil, fmt.Errorf("invalid agent id %q", agent)
+	}
+	return c.scope(agent + "/" + EpisodeNamespace)
+}
+
+// loadEpisodes returns a scope's live episodes, oldest first.
+func (c *Capability) loadEpisodes(sc *scope) ([]Episode, error) {
+	var out []Episode
+	err := sc.kv.each(time.Now(), func(key string, value []byte, _ time.Time) error {
+		var ep Episode
+		if err := json.Unmarshal(value, &ep); err != nil {
+			return fmt.Errorf("decode episode %s: %w", key, err)
+		}
+		out = append(out, ep)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("load episodes: %w", err)
+	}
+	return out, nil
+}
+
+// text is what episodes are searched and embedded by.
+func (ep Episode) text() string {
+	return ep.Task + "\n" + ep.Summary
+}
+
+func (ep Episode) recalled(score float64) Episode {
+	ep.Vector = nil
+	ep.Score = score
+	return ep
+}
@@ -0,0 +1,78 @@
+package memory
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestEpisodes(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	c := newTestMemory(t)
+	start := time.Now().UTC()
+	for i, ep := range []Episode{
+		{Task: "fix ERR_CONN_RESET in the fetcher", Summary: "Retried on connection reset.", Outcome: OutcomeSuccess, Tools: []string{"net"}},
+		{Task: "write release notes", Summary: "Drafted notes for v2.", Outcome: OutcomeSuccess},
+		{Task: "deploy the fetcher", Summary: "Deploy failed: quota exceeded.", Outcome: OutcomeFailure},
+	} {
+		ep.CreatedAt = start.Add(time.Duration(i) * time.Second)
+		if err := c.RecordEpisode(ctx, "alice", ep, EpisodeRetention{}); err != nil {
+			t.Fatalf("record: %v", err)
+		}
+	}
+
+	got, err := c.RecallEpisodes(ctx, "alice", "ERR_CONN_RESET fetcher", 2)
+	if err != nil || len(got) != 2 {
+		t.Fatalf("recall = %+v, %v", got, err)
+	}
+	if got[0].Summary != "Retried on connection reset." || got[0].Tools[0] != "net" || got[0].Score <= got[1].Score {
+		t.Fatalf("best episode = %+v", got[0])
+	}
+	if got[1].Outcome != OutcomeFailure {
+		t.Fatalf("second episode = %+v", got[1])
+	}
+	if got, _ := c.RecallEpisodes(ctx, "alice", "kubernetes", 3); len(got) != 0 {
+		t.Fatalf("unrelated recall = %+v", got)
+	}
+	if got, _ := c.RecallEpisodes(ctx, "alice", "", 1); len(got) != 1 || got[0].Task != "deploy the fetcher" {
+		t.Fatalf("recent = %+v", got)
+	}
+	if got, _ := c.RecallEpisodes(ctx, "bob", "fetcher", 3); len(got) != 0 {
+		t.Fatalf("bob recalled %+v", got)
+	}
+
+	// Retention drops the oldest episodes.
+	err = c.RecordEpisode(ctx, "alice", Episode{Task: "feed the cat", Summary: "Fed the cat.", CreatedAt: start.Add(time.Minute)}, EpisodeRetention{MaxEpisodes: 2})
+	if err != nil {
+		t.Fatalf("record: %v", err)
+	}
+	if got, _ := c.RecallEpisodes(ctx, "alice", "", 10); len(got) != 2 || got[1].Task != "deploy the fetcher" {
+		t.Fatalf("after retention = %+v", got)
+	}
+}
+
+func TestEpisodesWithEmbedder(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	c := newTestMemory(t)
+	c.SetEmbedder(&wordEmbedder{}, EmbedOptions{})
+	for _, ep := range []Episode{
+		{Task: "groom the dog", Summary: "Brushed the dog."},
+		{Task: "clean the tank", Summary: "Changed the fish water."},
+	} {
+		if err := c.RecordEpisode(ctx, "alice", ep, EpisodeRetention{MaxAge: time.Hour}); err != nil {
+			t.Fatalf("record: %v", err)
+		}
+	}
+	// "fishes" matches no keyword, only the embedding.
+	got, err := c.RecallEpisodes(ctx, "alice", "fishes", 1)
+	if err != nil || len(got) != 1 || got[0].Task != "clean the tank" || got[0].Vector != nil {
+		t.Fatalf("recall = %+v, %v", got, err)
+	}
+	sc := testScope(t, c, "alice/"+EpisodeNamespace)
+	pairs, _ := sc.kv.List(ctx, "", "", 10)
+	if len(pairs) != 2 || pairs[0].ExpiresAt == nil {
+		t.Fatalf("episodes = %+v", pairs)
+	}
+}
@@ -0,0 +1,79 @@
+package memory
+
+import (
+	"encoding/binary"
+	"strings"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// expiryBucket indexes entries with a TTL across all stores and scopes:
+// kind \x00 scope \x00 key -> deadline in Unix nanoseconds.
+const expiryBucket = "memory_expiry"
+
+// Kinds of entries that can expire. Edges expire with their nodes.
+const (
+	kindKV     = "kv"
+	kindVector = "vector"
+	kindNode   = "node"
+)
+
+// expiryEntry is an expired entry due for deletion.
+type expiryEntry struct {
+	kind, scope, key string
+}
+
+func deadline(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(ttl)
+}
+
+func expiryKey(kind, scope, key string) []byte {
+	return []byte(kind + "\x00" + scope + "\x00" + key)
+}
+
+// putExpiry records the deadline of an entry, or clears it for a zero time.
+func putExpiry(tx *bbolt.Tx, kind, scope, key string, at time.Time) error {
+	b := tx.Bucket([]byte(expiryBucket))
+	if at.IsZero() {
+		return b.Delete(expiryKey(kind, scope, key))
+	}
+	var v [8]byte
+	binary.BigEndian.PutUint64(v[:], uint64(at.UnixNano()))
+	return b.Put(expiryKey(kind, scope, key), v[:])
+}
+
+func expiryOf(tx *bbolt.Tx, kind, scope, key string) (time.Time, bool) {
+	v := tx.Bucket([]byte(expiryBucket)).Get(expiryKey(kind, scope, key))
+	if len(v) != 8 {
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(v))), true
+}
+
+func expired(tx *bbolt.Tx, kind, scope, key string, now time.Time) bool {
+	at, ok := expiryOf(tx, kind, scope, key)
+	return ok && !now.Before(at)
+}
+
+// dueExpiries lists up to limit entries whose deadline has passed.
+func dueExpiries(db *bbolt.DB, now time.Time, limit int) ([]expiryEntry, error) {
+	var out []expiryEntry
+	err := db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket([]byte(expiryBucket)).Cursor()
+		for k, v := c.First(); k != nil && len(out) < limit; k, v = c.Next() {
+			if len(v) != 8 || now.Before(time.Unix(0, int64(binary.BigEndian.Uint64(v)))) {
+				continue
+			}
+			parts := strings.SplitN(string(k), "\x00", 3)
+			if len(parts) == 3 {
+				out = append(out, expiryEntry{kind: parts[0], scope: parts[1], key: parts[2]})
+			}
+		}
+		return nil
+	})
+	return out, err
+}
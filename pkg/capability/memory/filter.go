@@ -0,0 +1,197 @@
+package memory
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Filter restricts a search to records whose metadata matches every
+// condition.
+type Filter []Condition
+
+// Condition tests one metadata field. Eq and In compare values; Gt, Gte, Lt
+// and Lte bound numbers, or strings such as RFC 3339 timestamps, and may be
+// combined into a range. A field holding a list matches if any element
+// does.
+type Condition struct {
+	Field string
+	Eq    interface{}
+	In    []interface{}
+	Gt    interface{}
+	Gte   interface{}
+	Lt    interface{}
+	Lte   interface{}
+}
+
+// ParseFilter reads a filter in the JSON form used by the memory actions:
+// each field maps to a value for equality, or to an object with any of
+// "eq", "in", "gt", "gte", "lt" and "lte", e.g.
+//
+//	{"lang": "go", "tag": {"in": ["api", "db"]}, "year": {"gte": 2020}}
+func ParseFilter(raw map[string]interface{}) (Filter, error) {
+	fields := make([]string, 0, len(raw))
+	for field := range raw {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	filter := make(Filter, 0, len(raw))
+	for _, field := range fields {
+		cond := Condition{Field: field}
+		ops, ok := raw[field].(map[string]interface{})
+		if !ok {
+			cond.Eq = raw[field]
+			filter = append(filter, cond)
+			continue
+		}
+		if len(ops) == 0 {
+			return nil, fmt.Errorf("filter %s: no operators", field)
+		}
+		for op, v := range ops {
+			switch op {
+			case "eq":
+				cond.Eq = v
+			case "in":
+				list, ok := v.([]interface{})
+				if !ok {
+					return nil, fmt.Errorf("filter %s: in takes a list", field)
+				}
+				cond.In = list
+			case "gt", "gte", "lt", "lte":
+				if _, isNum := toFloat(v); !isNum {
+					if _, isStr := v.(string); !isStr {
+						return nil, fmt.Errorf("filter %s: %s takes a number or string", field, op)
+					}
+				}
+				switch op {
+				case "gt":
+					cond.Gt = v
+				case "gte":
+					cond.Gte = v
+				case "lt":
+					cond.Lt = v
+				default:
+					cond.Lte = v
+				}
+			default:
+				return nil, fmt.Errorf("filter %s: unknown operator %q", field, op)
+			}
+		}
+		filter = append(filter, cond)
+	}
+	return filter, nil
+}
+
+// Match reports whether metadata satisfies every condition.
+func (f Filter) Match(metadata map[string]interface{}) bool {
+	for _, cond := range f {
+		v, ok := metadata[cond.Field]
+		if !ok || !cond.match(v) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c Condition) match(v interface{}) bool {
+	if list, ok := v.([]interface{}); ok {
+		for _, item := range list {
+			if c.match(item) {
+				return true
+			}
+		}
+		return false
+	}
+	if c.Eq != nil && !equal(v, c.Eq) {
+		return false
+	}
+	if c.In != nil {
+		found := false
+		for _, want := range c.In {
+			if equal(v, want) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	for _, bound := range []struct {
+		limit interface{}
+		ok    func(int) bool
+	}{
+		{c.Gt, func(cmp int) bool { return cmp > 0 }},
+		{c.Gte, func(cmp int) bool { return cmp >= 0 }},
+		{c.Lt, func(cmp int) bool { return cmp < 0 }},
+		{c.Lte, func(cmp int) bool { return cmp <= 0 }},
+	} {
+		if bound.limit == nil {
+			continue
+		}
+		cmp, comparable := compare(v, bound.limit)
+		if !comparable || !bound.ok(cmp) {
+			return false
+		}
+	}
+	return true
+}
+
+func equal(a, b interface{}) bool {
+	if cmp, ok := compare(a, b); ok {
+		return cmp == 0
+	}
+	ab, aok := a.(bool)
+	bb, bok := b.(bool)
+	return aok && bok && ab == bb
+}
+
+// compare orders two numbers or two strings.
+func compare(a, b interface{}) (int, bool) {
+	if af, ok := toFloat(a); ok {
+		bf, ok := toFloat(b)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case af < bf:
+			return -1, true
+		case af > bf:
+			return 1, true
+		}
+		return 0, true
+	}
+	as, aok := a.(string)
+	bs, bok := b.(string)
+	if !aok || !bok {
+		return 0, false
+	}
+	switch {
+	case as < bs:
+		return -1, true
+	case as > bs:
+		return 1, true
+	}
+	return 0, true
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	}
+	return 0, false
+}
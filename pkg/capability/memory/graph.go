@@ -2,24 +2,477 @@ package memory
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
 	"sync"
+
+	"go.etcd.io/bbolt"
+)
+
+// Traversal directions.
+const (
+	DirectionOut  = "out"
+	DirectionIn   = "in"
+	DirectionBoth = "both"
 )
 
-// GraphStore is an embedded graph store.
+// ErrNodeNotFound is returned for operations on a missing node.
+var ErrNodeNotFound = errors.New("graph node not found")
+
+// Node is a graph vertex.
+type Node struct {
+	ID         string                 `json:"id"`
+	Labels     []string               `json:"labels,omitempty"`
+	Properties map[string]interface{} `json:"properties,omitempty"`
+}
+
+// Edge is a typed, directed relationship. There is at most one edge of a
+// type between two nodes.
+type Edge struct {
+	From       string                 `json:"from"`
+	To         string                 `json:"to"`
+	Type       string                 `json:"type"`
+	Properties map[string]interface{} `json:"properties,omitempty"`
+}
+
+func (e *Edge) key() string { return edgeKey(e.From, e.Type, e.To) }
+
+func edgeKey(from, typ, to string) string { return from + "\x00" + typ + "\x00" + to }
+
+// Neighbor is a node reached over one edge.
+type Neighbor struct {
+	Edge *Edge `json:"edge"`
+	Node *Node `json:"node"`
+}
+
+// Reached is a node found by Traverse at its shortest distance.
+type Reached struct {
+	Node  *Node `json:"node"`
+	Depth int   `json:"depth"`
+}
+
+// Path is a walk from the first node to the last; Edges[i] joins Nodes[i]
+// and Nodes[i+1].
+type Path struct {
+	Nodes []*Node `json:"nodes"`
+	Edges []*Edge `json:"edges"`
+}
+
+// GraphStore is a property graph held in memory and, when opened on a
+// bbolt database, written through to it.
 type GraphStore struct {
 	mu    sync.RWMutex
-	nodes map[string]map[string]interface{}
+	nodes map[string]*Node
+	out   map[string]map[string]*Edge // from -> edge key -> edge
+	in    map[string]map[string]*Edge // to -> edge key -> edge
+
+	db          *bbolt.DB
+	nodesBucket []byte
+	edgesBucket []byte
 }
 
-// NewGraphStore creates a graph store.
+// NewGraphStore creates an in-memory graph store.
 func NewGraphStore() *GraphStore {
-	return &GraphStore{nodes: make(map[string]map[string]interface{})}
+	return &GraphStore{nodes: map[string]*Node{}, out: map[string]map[string]*Edge{}, in: map[string]map[string]*Edge{}}
 }
 
-// UpsertNode inserts or updates a graph node.
-func (g *GraphStore) UpsertNode(_ context.Context, id string, payload map[string]interface{}) error {
+// OpenGraphStore loads the graph of a memory scope persisted in db,
+// creating its buckets.
+func OpenGraphStore(db *bbolt.DB, scope string) (*GraphStore, error) {
+	g := NewGraphStore()
+	g.db = db
+	g.nodesBucket = []byte("graph_nodes/" + scope)
+	g.edgesBucket = []byte("graph_edges/" + scope)
+	err := db.Update(func(tx *bbolt.Tx) error {
+		nodes, err := tx.CreateBucketIfNotExists(g.nodesBucket)
+		if err != nil {
+			return err
+		}
+		edges, err := tx.CreateBucketIfNotExists(g.edgesBucket)
+		if err != nil {
+			return err
+		}
+		if err := nodes.ForEach(func(_, v []byte) error {
+			var n Node
+			if err := json.Unmarshal(v, &n); err != nil {
+				return err
+			}
+			g.nodes[n.ID] = &n
+			return nil
+		}); err != nil {
+			return err
+		}
+		return edges.ForEach(func(_, v []byte) error {
+			var e Edge
+			if err := json.Unmarshal(v, &e); err != nil {
+				return err
+			}
+			g.link(&e)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("load graph: %w", err)
+	}
+	return g, nil
+}
+
+// UpsertNode creates a node or replaces its properties, keeping its labels.
+func (g *GraphStore) UpsertNode(ctx context.Context, id string, payload map[string]interface{}) error {
+	g.mu.RLock()
+	var labels []string
+	if n := g.nodes[id]; n != nil {
+		labels = n.Labels
+	}
+	g.mu.RUnlock()
+	return g.PutNode(ctx, Node{ID: id, Labels: labels, Properties: payload})
+}
+
+// PutNode creates or replaces a node. Properties must be JSON-encodable.
+func (g *GraphStore) PutNode(_ context.Context, node Node) error {
+	if node.ID == "" {
+		return fmt.Errorf("graph put node: id is required")
+	}
+	props, err := jsonMetadata(node.Properties)
+	if err != nil {
+		return fmt.Errorf("graph put node: %w", err)
+	}
+	n := &Node{ID: node.ID, Labels: append([]string(nil), node.Labels...), Properties: props}
+	sort.Strings(n.Labels)
 	g.mu.Lock()
 	defer g.mu.Unlock()
-	g.nodes[id] = payload
+	if err := g.write(func(tx *bbolt.Tx) error { return putJSON(tx, g.nodesBucket, n.ID, n) }); err != nil {
+		return fmt.Errorf("graph put node: %w", err)
+	}
+	g.nodes[n.ID] = n
 	return nil
 }
+
+// GetNode returns a node, or nil if it does not exist.
+func (g *GraphStore) GetNode(_ context.Context, id string) *Node {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.nodes[id]
+}
+
+// DeleteNode removes a node and its edges. Missing nodes are not an error.
+func (g *GraphStore) DeleteNode(_ context.Context, id string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.nodes[id] == nil {
+		return nil
+	}
+	var edges []*Edge
+	for _, e := range g.out[id] {
+		edges = append(edges, e)
+	}
+	for _, e := range g.in[id] {
+		if e.From != id {
+			edges = append(edges, e)
+		}
+	}
+	err := g.write(func(tx *bbolt.Tx) error {
+		for _, e := range edges {
+			if err := tx.Bucket(g.edgesBucket).Delete([]byte(e.key())); err != nil {
+				return err
+			}
+		}
+		return tx.Bucket(g.nodesBucket).Delete([]byte(id))
+	})
+	if err != nil {
+		return fmt.Errorf("graph delete node: %w", err)
+	}
+	for _, e := range edges {
+		g.unlink(e)
+	}
+	delete(g.nodes, id)
+	return nil
+}
+
+// PutEdge creates or replaces the edge of its type between two nodes,
+// creating missing endpoints without labels.
+func (g *GraphStore) PutEdge(_ context.Context, edge Edge) error {
+	if edge.From == "" || edge.To == "" || edge.Type == "" {
+		return fmt.Errorf("graph put edge: from, to and type are required")
+	}
+	props, err := jsonMetadata(edge.Properties)
+	if err != nil {
+		return fmt.Errorf("graph put edge: %w", err)
+	}
+	e := &Edge{From: edge.From, To: edge.To, Type: edge.Type, Properties: props}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var created []*Node
+	for _, id := range []string{e.From, e.To} {
+		if g.nodes[id] == nil && (len(created) == 0 || id != created[0].ID) {
+			// The second check skips a self-loop's missing node twice.
+			created = append(created, &Node{ID: id})
+		}
+	}
+	err = g.write(func(tx *bbolt.Tx) error {
+		for _, n := range created {
+			if err := putJSON(tx, g.nodesBucket, n.ID, n); err != nil {
+				return err
+			}
+		}
+		return putJSON(tx, g.edgesBucket, e.key(), e)
+	})
+	if err != nil {
+		return fmt.Errorf("graph put edge: %w", err)
+	}
+	for _, n := range created {
+		g.nodes[n.ID] = n
+	}
+	if old := g.out[e.From][e.key()]; old != nil {
+		g.unlink(old)
+	}
+	g.link(e)
+	return nil
+}
+
+// UpsertEdge is PutEdge with positional arguments.
+func (g *GraphStore) UpsertEdge(ctx context.Context, from, typ, to string, props map[string]interface{}) error {
+	return g.PutEdge(ctx, Edge{From: from, To: to, Type: typ, Properties: props})
+}
+
+// DeleteEdge removes an edge. Missing edges are not an error.
+func (g *GraphStore) DeleteEdge(_ context.Context, from, typ, to string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	e := g.out[from][edgeKey(from, typ, to)]
+	if e == nil {
+		return nil
+	}
+	if err := g.write(func(tx *bbolt.Tx) error { return tx.Bucket(g.edgesBucket).Delete([]byte(e.key())) }); err != nil {
+		return fmt.Errorf("graph delete edge: %w", err)
+	}
+	g.unlink(e)
+	return nil
+}
+
+// Nodes returns every node, ordered by ID.
+func (g *GraphStore) Nodes() []*Node {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	out := make([]*Node, 0, len(g.nodes))
+	for _, n := range g.nodes {
+		out = append(out, n)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// Edges returns every edge, ordered by source, type and target.
+func (g *GraphStore) Edges() []*Edge {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	var out []*Edge
+	for _, edges := range g.out {
+		for _, e := range edges {
+			out = append(out, e)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].key() < out[j].key() })
+	return out
+}
+
+// clear removes every node and edge.
+func (g *GraphStore) clear() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	err := g.write(func(tx *bbolt.Tx) error {
+		for _, name := range [][]byte{g.nodesBucket, g.edgesBucket} {
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
+			if _, err := tx.CreateBucket(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("graph clear: %w", err)
+	}
+	g.nodes, g.out, g.in = map[string]*Node{}, map[string]map[string]*Edge{}, map[string]map[string]*Edge{}
+	return nil
+}
+
+// Neighbors returns the nodes one edge away from id in direction, over
+// edges of the given types (any type if none).
+func (g *GraphStore) Neighbors(_ context.Context, id, direction string, types []string) ([]Neighbor, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	if g.nodes[id] == nil {
+		return nil, fmt.Errorf("%w: %s", ErrNodeNotFound, id)
+	}
+	steps, err := g.steps(id, direction, types)
+	if err != nil {
+		return nil, err
+	}
+	return steps, nil
+}
+
+// Traverse returns the nodes within depth edges of start, breadth first,
+// excluding start, up to limit nodes (0 for no limit).
+func (g *GraphStore) Traverse(_ context.Context, start, direction string, types []string, depth, limit int) ([]Reached, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	if g.nodes[start] == nil {
+		return nil, fmt.Errorf("%w: %s", ErrNodeNotFound, start)
+	}
+	seen := map[string]bool{start: true}
+	frontier := []string{start}
+	var out []Reached
+	for d := 1; d <= depth && len(frontier) > 0; d++ {
+		var next []string
+		for _, id := range frontier {
+			steps, err := g.steps(id, direction, types)
+			if err != nil {
+				return nil, err
+			}
+			for _, s := range steps {
+				if seen[s.Node.ID] {
+					continue
+				}
+				seen[s.Node.ID] = true
+				out = append(out, Reached{Node: s.Node, Depth: d})
+				if limit > 0 && len(out) >= limit {
+					return out, nil
+				}
+				next = append(next, s.Node.ID)
+			}
+		}
+		frontier = next
+	}
+	return out, nil
+}
+
+// ShortestPath returns a path with the fewest edges from one node to
+// another, or nil if none is within maxDepth edges (0 for no limit).
+func (g *GraphStore) ShortestPath(_ context.Context, from, to, direction string, types []string, maxDepth int) (*Path, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	for _, id := range []string{from, to} {
+		if g.nodes[id] == nil {
+			return nil, fmt.Errorf("%w: %s", ErrNodeNotFound, id)
+		}
+	}
+	type hop struct {
+		prev string
+		edge *Edge
+	}
+	parent := map[string]hop{from: {}}
+	frontier := []string{from}
+	for d := 0; len(frontier) > 0 && (maxDepth <= 0 || d < maxDepth); d++ {
+		if _, ok := parent[to]; ok {
+			break
+		}
+		var next []string
+		for _, id := range frontier {
+			steps, err := g.steps(id, direction, types)
+			if err != nil {
+				return nil, err
+			}
+			for _, s := range steps {
+				if _, ok := parent[s.Node.ID]; ok {
+					continue
+				}
+				parent[s.Node.ID] = hop{prev: id, edge: s.Edge}
+				next = append(next, s.Node.ID)
+			}
+		}
+		frontier = next
+	}
+	if _, ok := parent[to]; !ok {
+		return nil, nil
+	}
+	path := &Path{}
+	for id := to; ; id = parent[id].prev {
+		path.Nodes = append([]*Node{g.nodes[id]}, path.Nodes...)
+		if id == from {
+			break
+		}
+		path.Edges = append([]*Edge{parent[id].edge}, path.Edges...)
+	}
+	return path, nil
+}
+
+// steps lists the edges leaving id in direction, sorted for stable output.
+func (g *GraphStore) steps(id, direction string, types []string) ([]Neighbor, error) {
+	var out []Neighbor
+	add := func(edges map[string]*Edge, outgoing bool) {
+		for _, e := range edges {
+			if len(types) > 0 && !containsString(types, e.Type) {
+				continue
+			}
+			other := e.To
+			if !outgoing {
+				other = e.From
+			}
+			out = append(out, Neighbor{Edge: e, Node: g.nodes[other]})
+		}
+	}
+	switch direction {
+	case DirectionOut, "":
+		add(g.out[id], true)
+	case DirectionIn:
+		add(g.in[id], false)
+	case DirectionBoth:
+		add(g.out[id], true)
+		add(g.in[id], false)
+	default:
+		return nil, fmt.Errorf("unknown direction %q: want out, in or both", direction)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Node.ID != out[j].Node.ID {
+			return out[i].Node.ID < out[j].Node.ID
+		}
+		return out[i].Edge.key() < out[j].Edge.key()
+	})
+	return out, nil
+}
+
+func (g *GraphStore) link(e *Edge) {
+	if g.out[e.From] == nil {
+		g.out[e.From] = map[string]*Edge{}
+	}
+	if g.in[e.To] == nil {
+		g.in[e.To] = map[string]*Edge{}
+	}
+	g.out[e.From][e.key()] = e
+	g.in[e.To][e.key()] = e
+}
+
+func (g *GraphStore) unlink(e *Edge) {
+	delete(g.out[e.From], e.key())
+	delete(g.in[e.To], e.key())
+}
+
+// write runs fn in a bbolt transaction when the store is persistent.
+func (g *GraphStore) write(fn func(*bbolt.Tx) error) error {
+	if g.db == nil {
+		return nil
+	}
+	return g.db.Update(fn)
+}
+
+func putJSON(tx *bbolt.Tx, bucket []byte, key string, v interface{}) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(bucket).Put([]byte(key), raw)
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
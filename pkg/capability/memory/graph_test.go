@@ -0,0 +1,208 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.etcd.io/bbolt"
+)
+
+func openTestGraph(t *testing.T, path string) (*GraphStore, *bbolt.DB) {
+	t.Helper()
+	db, err := bbolt.Open(path, 0o600, nil)
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	g, err := OpenGraphStore(db, "agent/default")
+	if err != nil {
+		t.Fatalf("open graph: %v", err)
+	}
+	return g, db
+}
+
+func TestGraphTraversalAndPersistence(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "graph.db")
+	g, db := openTestGraph(t, path)
+
+	for _, n := range []Node{
+		{ID: "ada", Labels: []string{"Person"}, Properties: map[string]interface{}{"name": "Ada"}},
+		{ID: "bob", Labels: []string{"Person"}, Properties: map[string]interface{}{"name": "Bob"}},
+		{ID: "acme", Labels: []string{"Company"}},
+	} {
+		if err := g.PutNode(ctx, n); err != nil {
+			t.Fatalf("put node: %v", err)
+		}
+	}
+	for _, e := range []Edge{
+		{From: "ada", To: "acme", Type: "WORKS_AT", Properties: map[string]interface{}{"since": 2020}},
+		{From: "bob", To: "acme", Type: "WORKS_AT"},
+		{From: "ada", To: "bob", Type: "KNOWS"},
+		{From: "acme", To: "berlin", Type: "LOCATED_IN"},
+	} {
+		if err := g.PutEdge(ctx, e); err != nil {
+			t.Fatalf("put edge: %v", err)
+		}
+	}
+	if g.GetNode(ctx, "berlin") == nil {
+		t.Fatalf("edge endpoint was not created")
+	}
+
+	in, err := g.Neighbors(ctx, "acme", DirectionIn, []string{"WORKS_AT"})
+	if err != nil || len(in) != 2 || in[0].Node.ID != "ada" || in[1].Node.ID != "bob" {
+		t.Fatalf("in neighbors = %+v, %v", in, err)
+	}
+	reached, err := g.Traverse(ctx, "ada", DirectionOut, nil, 2, 0)
+	if err != nil || len(reached) != 3 {
+		t.Fatalf("traverse = %+v, %v", reached, err)
+	}
+	if last := reached[2]; last.Node.ID != "berlin" || last.Depth != 2 {
+		t.Fatalf("farthest = %+v", last)
+	}
+	path1, err := g.ShortestPath(ctx, "bob", "berlin", DirectionOut, nil, 0)
+	if err != nil || path1 == nil || len(path1.Edges) != 2 || path1.Nodes[1].ID != "acme" {
+		t.Fatalf("path = %+v, %v", path1, err)
+	}
+	if p, err := g.ShortestPath(ctx, "berlin", "ada", DirectionOut, nil, 0); err != nil || p != nil {
+		t.Fatalf("reverse path = %+v, %v", p, err)
+	}
+	if _, err := g.Neighbors(ctx, "nobody", DirectionOut, nil); !errors.Is(err, ErrNodeNotFound) {
+		t.Fatalf("missing node err = %v", err)
+	}
+
+	if err := g.DeleteNode(ctx, "bob"); err != nil {
+		t.Fatalf("delete node: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	g, db = openTestGraph(t, path)
+	defer db.Close()
+	if n := g.GetNode(ctx, "ada"); n == nil || n.Properties["name"] != "Ada" || len(n.Labels) != 1 {
+		t.Fatalf("reloaded node = %+v", n)
+	}
+	out, err := g.Neighbors(ctx, "ada", DirectionBoth, nil)
+	if err != nil || len(out) != 1 || out[0].Edge.Type != "WORKS_AT" || out[0].Edge.Properties["since"] != float64(2020) {
+		t.Fatalf("reloaded neighbors = %+v, %v", out, err)
+	}
+}
+
+func TestGraphPatternQuery(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	g := NewGraphStore()
+	_ = g.PutNode(ctx, Node{ID: "ada", Labels: []string{"Person"}, Properties: map[string]interface{}{"name": "Ada"}})
+	_ = g.PutNode(ctx, Node{ID: "bob", Labels: []string{"Person"}, Properties: map[string]interface{}{"name": "Bob"}})
+	_ = g.PutNode(ctx, Node{ID: "acme", Labels: []string{"Company"}})
+	_ = g.PutEdge(ctx, Edge{From: "ada", To: "acme", Type: "WORKS_AT"})
+	_ = g.PutEdge(ctx, Edge{From: "bob", To: "acme", Type: "WORKS_AT"})
+	_ = g.PutEdge(ctx, Edge{From: "ada", To: "bob", Type: "KNOWS"})
+
+	cases := []struct {
+		pattern string
+		want    int
+	}{
+		{`(a:Person)-[:WORKS_AT]->(b)`, 2},
+		{`(a:Person {name: "Ada"})-[r:WORKS_AT]->(b:Company)`, 1},
+		{`(c:Company)<-[:WORKS_AT]-(p)`, 2},
+		{`(a)-[:KNOWS]->(b)-[:WORKS_AT]->(c)<-[:WORKS_AT]-(a)`, 1},
+		{`(a {id: 'bob'})--(b)`, 2},
+		{`(a:Person)-[:WORKS_AT|KNOWS]->(b:Person)`, 1},
+		{`(a:Robot)-->(b)`, 0},
+	}
+	for _, tc := range cases {
+		pat, err := ParsePattern(tc.pattern)
+		if err != nil {
+			t.Fatalf("parse %s: %v", tc.pattern, err)
+		}
+		rows, err := g.Match(ctx, pat, 0)
+		if err != nil || len(rows) != tc.want {
+			t.Fatalf("%s = %d rows (%v), want %d", tc.pattern, len(rows), err, tc.want)
+		}
+	}
+
+	pat, _ := ParsePattern(`(a:Person {name: "Ada"})-[r:WORKS_AT]->(b)`)
+	rows, _ := g.Match(ctx, pat, 0)
+	if b, ok := rows[0]["b"].(*Node); !ok || b.ID != "acme" {
+		t.Fatalf("binding b = %+v", rows[0]["b"])
+	}
+	if r, ok := rows[0]["r"].(*Edge); !ok || r.From != "ada" {
+		t.Fatalf("binding r = %+v", rows[0]["r"])
+	}
+
+	for _, bad := range []string{``, `(a`, `(a)->(b)`, `(a)<-[:X]->(b)`, `(a {name: })`} {
+		if _, err := ParsePattern(bad); err == nil {
+			t.Fatalf("parse %q succeeded", bad)
+		}
+	}
+}
+
+func TestGraphPatternQueryIsBounded(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	g := NewGraphStore()
+	ids := make([]string, 12)
+	for i := range ids {
+		ids[i] = string(rune('a' + i))
+		_ = g.PutNode(ctx, Node{ID: ids[i]})
+	}
+	for _, from := range ids {
+		for _, to := range ids {
+			if from != to {
+				_ = g.PutEdge(ctx, Edge{From: from, To: to, Type: "KNOWS"})
+			}
+		}
+	}
+	chain := strings.Repeat("()--", MaxPatternHops) + "(:Missing)"
+	pat, err := ParsePattern(chain)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if _, err := g.Match(ctx, pat, 1); !errors.Is(err, ErrPatternTooComplex) {
+		t.Fatalf("exhaustive match err = %v", err)
+	}
+	pat, _ = ParsePattern("()--" + chain)
+	if _, err := g.Match(ctx, pat, 1); !errors.Is(err, ErrPatternTooComplex) {
+		t.Fatalf("long pattern err = %v", err)
+	}
+	pat, _ = ParsePattern(`(a {id: 'a'})-->(b {id: 'b'})`)
+	if rows, err := g.Match(ctx, pat, 0); err != nil || len(rows) != 1 {
+		t.Fatalf("match = %d rows, %v", len(rows), err)
+	}
+}
+
+func TestGraphActions(t *testing.T) {
+	t.Parallel()
+	c := newTestMemory(t)
+	resp := execute(t, c, "graph_put_node", map[string]interface{}{"id": "ada", "labels": []interface{}{"Person"}})
+	if !resp.Success {
+		t.Fatalf("put node: %+v", resp.Error)
+	}
+	resp = execute(t, c, "graph_put_edge", map[string]interface{}{"from": "ada", "to": "acme", "type": "WORKS_AT"})
+	if !resp.Success {
+		t.Fatalf("put edge: %+v", resp.Error)
+	}
+	resp = execute(t, c, "graph_query", map[string]interface{}{"pattern": "(a:Person)-[:WORKS_AT]->(b)"})
+	if rows, ok := resp.Data.([]map[string]interface{}); !resp.Success || !ok || len(rows) != 1 {
+		t.Fatalf("query = %+v", resp)
+	}
+	if resp := execute(t, c, "graph_get_node", map[string]interface{}{"id": "nobody"}); resp.Error == nil || resp.Error.Code != "not_found" {
+		t.Fatalf("get missing = %+v", resp)
+	}
+	if resp := execute(t, c, "graph_path", map[string]interface{}{"from": "acme", "to": "ada"}); resp.Error == nil || resp.Error.Code != "no_path" {
+		t.Fatalf("path = %+v", resp)
+	}
+	if resp := execute(t, c, "graph_query", map[string]interface{}{"pattern": "(a"}); resp.Error == nil || resp.Error.Code != "invalid_pattern" {
+		t.Fatalf("bad pattern = %+v", resp)
+	}
+
+	c.SetGraphEnabled(false)
+	if resp := execute(t, c, "graph_neighbors", map[string]interface{}{"id": "ada"}); resp.Error == nil || resp.Error.Code != "graph_disabled" {
+		t.Fatalf("disabled = %+v", resp)
+	}
+}
@@ -0,0 +1,401 @@
+package memory
+
+import (
+	"container/heap"
+	"math"
+	"sort"
+)
+
+// HNSW parameters. maxLinks applies to layers above 0; layer 0 keeps twice
+// as many, as in the original paper.
+const (
+	defaultMaxLinks       = 16
+	defaultEfConstruction = 200
+	defaultEfSearch       = 64
+	maxLevel              = 16
+)
+
+// hnswNode is one vector in the graph. links[l] are its neighbours on
+// layer l; ids of deleted nodes may linger there and are skipped.
+type hnswNode struct {
+	id    uint32
+	key   string
+	vec   []float32
+	links [][]uint32
+
+	text string
+	meta map[string]interface{}
+}
+
+func (n *hnswNode) level() int { return len(n.links) - 1 }
+
+// candidate is a node and its distance to the current query.
+type candidate struct {
+	id   uint32
+	dist float64
+}
+
+// nearHeap pops the nearest candidate first; farHeap the farthest.
+type nearHeap []candidate
+type farHeap []candidate
+
+func (h nearHeap) Len() int            { return len(h) }
+func (h nearHeap) Less(i, j int) bool  { return h[i].dist < h[j].dist }
+func (h nearHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *nearHeap) Push(x interface{}) { *h = append(*h, x.(candidate)) }
+func (h *nearHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+func (h farHeap) Len() int            { return len(h) }
+func (h farHeap) Less(i, j int) bool  { return h[i].dist > h[j].dist }
+func (h farHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *farHeap) Push(x interface{}) { *h = append(*h, x.(candidate)) }
+func (h *farHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// graph is an HNSW index. It is not safe for concurrent use; VectorStore
+// serialises access.
+type graph struct {
+	metric         string
+	maxLinks       int
+	efConstruction int
+	levelMult      float64
+
+	nodes    []*hnswNode // indexed by id; nil for deleted ids
+	keys     map[string]uint32
+	entry    uint32
+	hasEntry bool
+	top      int
+	rand     func() float64
+}
+
+func newGraph(metric string, maxLinks, efConstruction int, rand func() float64) *graph {
+	return &graph{
+		metric:         metric,
+		maxLinks:       maxLinks,
+		efConstruction: efConstruction,
+		levelMult:      1 / math.Log(float64(maxLinks)),
+		keys:           map[string]uint32{},
+		rand:           rand,
+	}
+}
+
+func (g *graph) len() int { return len(g.keys) }
+
+func (g *graph) node(id uint32) *hnswNode {
+	if int(id) >= len(g.nodes) {
+		return nil
+	}
+	return g.nodes[id]
+}
+
+func (g *graph) linkLimit(level int) int {
+	if level == 0 {
+		return 2 * g.maxLinks
+	}
+	return g.maxLinks
+}
+
+// distance is lower for closer vectors. Cosine vectors are stored
+// normalised, so cosine distance is 1 - dot.
+func (g *graph) distance(a, b []float32) float64 {
+	switch g.metric {
+	case MetricL2:
+		var sum float64
+		for i := range a {
+			d := float64(a[i]) - float64(b[i])
+			sum += d * d
+		}
+		return sum
+	case MetricDot:
+		return -dot(a, b)
+	default:
+		return 1 - dot(a, b)
+	}
+}
+
+// score converts a distance to a similarity where higher is better: cosine
+// similarity, the dot product, or 1/(1+d) for Euclidean distance d.
+func (g *graph) score(dist float64) float64 {
+	switch g.metric {
+	case MetricL2:
+		return 1 / (1 + math.Sqrt(dist))
+	case MetricDot:
+		return -dist
+	default:
+		return 1 - dist
+	}
+}
+
+func (g *graph) randomLevel() int {
+	level := int(math.Floor(-math.Log(1-g.rand()) * g.levelMult))
+	if level > maxLevel {
+		level = maxLevel
+	}
+	return level
+}
+
+// insert adds n, assigning its level and links, and returns the ids of
+// existing nodes whose links changed.
+func (g *graph) insert(n *hnswNode) []uint32 {
+	level := g.randomLevel()
+	n.links = make([][]uint32, level+1)
+	for int(n.id) >= len(g.nodes) {
+		g.nodes = append(g.nodes, nil)
+	}
+	g.nodes[n.id] = n
+	g.keys[n.key] = n.id
+	if !g.hasEntry {
+		g.entry, g.hasEntry, g.top = n.id, true, level
+		return nil
+	}
+
+	dirty := map[uint32]struct{}{}
+	ep := []candidate{{g.entry, g.distance(n.vec, g.nodes[g.entry].vec)}}
+	for l := g.top; l > level; l-- {
+		ep = g.searchLayer(n.vec, ep, 1, l)
+	}
+	for l := min(level, g.top); l >= 0; l-- {
+		found := g.searchLayer(n.vec, ep, g.efConstruction, l)
+		n.links[l] = g.selectNeighbors(n.vec, found, g.linkLimit(l))
+		for _, id := range n.links[l] {
+			g.link(id, n.id, l)
+			dirty[id] = struct{}{}
+		}
+		ep = found
+	}
+	if level > g.top {
+		g.entry, g.top = n.id, level
+	}
+	return sortedIDs(dirty)
+}
+
+// link adds to to from's layer-l links, pruning them if over the limit.
+func (g *graph) link(from, to uint32, l int) {
+	n := g.nodes[from]
+	n.links[l] = append(n.links[l], to)
+	if len(n.links[l]) > g.linkLimit(l) {
+		n.links[l] = g.selectNeighbors(n.vec, g.candidates(n.vec, n.links[l], l), g.linkLimit(l))
+	}
+}
+
+// remove deletes id, reconnecting its neighbours to each other, and returns
+// the ids of nodes whose links changed.
+func (g *graph) remove(id uint32) []uint32 {
+	n := g.node(id)
+	if n == nil {
+		return nil
+	}
+	g.nodes[id] = nil
+	delete(g.keys, n.key)
+
+	dirty := map[uint32]struct{}{}
+	for l, links := range n.links {
+		for _, nb := range links {
+			m := g.node(nb)
+			if m == nil || m.level() < l {
+				continue
+			}
+			pool := make([]uint32, 0, len(m.links[l])+len(links))
+			for _, other := range m.links[l] {
+				if other != id {
+					pool = append(pool, other)
+				}
+			}
+			for _, other := range links {
+				if other != nb {
+					pool = append(pool, other)
+				}
+			}
+			m.links[l] = g.selectNeighbors(m.vec, g.candidates(m.vec, pool, l), g.linkLimit(l))
+			dirty[nb] = struct{}{}
+		}
+	}
+
+	if g.entry == id {
+		g.hasEntry, g.top = false, 0
+		for _, m := range g.nodes {
+			if m != nil && (!g.hasEntry || m.level() > g.top) {
+				g.entry, g.hasEntry, g.top = m.id, true, m.level()
+			}
+		}
+	}
+	return sortedIDs(dirty)
+}
+
+// search returns up to k nearest nodes to q, nearest first.
+func (g *graph) search(q []float32, k, ef int) []candidate {
+	if !g.hasEntry || k <= 0 {
+		return nil
+	}
+	// The beam never needs to be wider than the graph.
+	ef = min(max(ef, k), g.len())
+	ep := []candidate{{g.entry, g.distance(q, g.nodes[g.entry].vec)}}
+	for l := g.top; l > 0; l-- {
+		ep = g.searchLayer(q, ep, 1, l)
+	}
+	found := g.searchLayer(q, ep, ef, 0)
+	if len(found) > k {
+		found = found[:k]
+	}
+	return found
+}
+
+// exact scans the nodes accepted by keep and returns the k nearest to q.
+func (g *graph) exact(q []float32, k int, keep func(*hnswNode) bool) []candidate {
+	var out []candidate
+	for _, n := range g.nodes {
+		if n != nil && keep(n) {
+			out = append(out, candidate{n.id, g.distance(q, n.vec)})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].dist < out[j].dist })
+	if len(out) > k {
+		out = out[:k]
+	}
+	return out
+}
+
+// searchLayer is the HNSW beam search: it returns up to ef nodes on layer l
+// nearest to q, nearest first.
+func (g *graph) searchLayer(q []float32, entry []candidate, ef, l int) []candidate {
+	visited := make(map[uint32]struct{}, ef*4)
+	near := make(nearHeap, 0, ef)
+	far := make(farHeap, 0, ef+1)
+	for _, c := range entry {
+		visited[c.id] = struct{}{}
+		heap.Push(&near, c)
+		heap.Push(&far, c)
+		if far.Len() > ef {
+			heap.Pop(&far)
+		}
+	}
+	for near.Len() > 0 {
+		c := heap.Pop(&near).(candidate)
+		if far.Len() >= ef && c.dist > far[0].dist {
+			break
+		}
+		n := g.node(c.id)
+		if n == nil || n.level() < l {
+			continue
+		}
+		for _, id := range n.links[l] {
+			if _, seen := visited[id]; seen {
+				continue
+			}
+			visited[id] = struct{}{}
+			m := g.node(id)
+			if m == nil {
+				continue
+			}
+			d := g.distance(q, m.vec)
+			if far.Len() < ef || d < far[0].dist {
+				heap.Push(&near, candidate{id, d})
+				heap.Push(&far, candidate{id, d})
+				if far.Len() > ef {
+					heap.Pop(&far)
+				}
+			}
+		}
+	}
+	out := make([]candidate, far.Len())
+	for i := len(out) - 1; i >= 0; i-- {
+		out[i] = heap.Pop(&far).(candidate)
+	}
+	return out
+}
+
+// candidates computes distances from q to the live nodes in ids that reach
+// layer l, dropping duplicates, nearest first.
+func (g *graph) candidates(q []float32, ids []uint32, l int) []candidate {
+	seen := make(map[uint32]struct{}, len(ids))
+	out := make([]candidate, 0, len(ids))
+	for _, id := range ids {
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		seen[id] = struct{}{}
+		if m := g.node(id); m != nil && m.level() >= l {
+			out = append(out, candidate{id, g.distance(q, m.vec)})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].dist < out[j].dist })
+	return out
+}
+
+// selectNeighbors is the HNSW neighbour heuristic: a candidate is kept only
+// if it is closer to q than to any neighbour already kept, which spreads
+// links across clusters. Remaining slots are filled with the nearest
+// pruned candidates. cands must be sorted nearest first.
+func (g *graph) selectNeighbors(q []float32, cands []candidate, limit int) []uint32 {
+	out := make([]uint32, 0, limit)
+	var pruned []uint32
+	for _, c := range cands {
+		if len(out) >= limit {
+			break
+		}
+		cv := g.nodes[c.id].vec
+		keep := true
+		for _, id := range out {
+			if g.distance(cv, g.nodes[id].vec) < c.dist {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			out = append(out, c.id)
+		} else {
+			pruned = append(pruned, c.id)
+		}
+	}
+	for _, id := range pruned {
+		if len(out) >= limit {
+			break
+		}
+		out = append(out, id)
+	}
+	return out
+}
+
+func dot(a, b []float32) float64 {
+	var s0, s1, s2, s3 float32
+	i := 0
+	for ; i+4 <= len(a); i += 4 {
+		s0 += a[i] * b[i]
+		s1 += a[i+1] * b[i+1]
+		s2 += a[i+2] * b[i+2]
+		s3 += a[i+3] * b[i+3]
+	}
+	for ; i < len(a); i++ {
+		s0 += a[i] * b[i]
+	}
+	return float64(s0 + s1 + s2 + s3)
+}
+
+func normalize(vec []float32) bool {
+	norm := math.Sqrt(dot(vec, vec))
+	if norm == 0 {
+		return false
+	}
+	for i := range vec {
+		vec[i] = float32(float64(vec[i]) / norm)
+	}
+	return true
+}
+
+func sortedIDs(set map[uint32]struct{}) []uint32 {
+	out := make([]uint32, 0, len(set))
+	for id := range set {
+		out = append(out, id)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out
+}
@@ -0,0 +1,99 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+
+	"spawn.dev/pkg/capability"
+)
+
+// DefaultRRFConstant damps the weight of top ranks in reciprocal rank
+// fusion; 60 is the value from the original paper.
+const DefaultRRFConstant = 60
+
+// HybridQuery combines a keyword (BM25) search for Text with a vector
+// search for Vector. Each result scores the sum over both rankings of
+// weight/(RRFConstant+rank). Either half may be left out; weights of zero
+// default to 1 when both are zero.
+type HybridQuery struct {
+	Text          string
+	Vector        []float32
+	Limit         int
+	Offset        int
+	Filter        Filter
+	KeywordWeight float64
+	VectorWeight  float64
+	RRFConstant   int
+}
+
+// KeywordSearch ranks records by the BM25 score of their text against
+// query. Scores are unbounded; higher is better.
+func (s *VectorStore) KeywordSearch(_ context.Context, query string, limit, offset int, filter Filter) ([]capability.VectorMatch, error) {
+	limit, offset, err := window(limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("keyword search: %w", err)
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	found := s.keywords.search(query, min(offset+limit, s.graph.len()), s.keep(filter))
+	out := make([]capability.VectorMatch, len(found))
+	for i, c := range found {
+		out[i] = s.match(c.id, -c.dist)
+	}
+	return page(out, offset, limit), nil
+}
+
+// Hybrid runs both searches and fuses their rankings.
+func (s *VectorStore) Hybrid(_ context.Context, query HybridQuery) ([]capability.VectorMatch, error) {
+	var err error
+	if query.Limit, query.Offset, err = window(query.Limit, query.Offset); err != nil {
+		return nil, fmt.Errorf("hybrid search: %w", err)
+	}
+	if query.KeywordWeight < 0 || query.VectorWeight < 0 {
+		return nil, fmt.Errorf("hybrid search: weights must not be negative")
+	}
+	if query.KeywordWeight == 0 && query.VectorWeight == 0 {
+		query.KeywordWeight, query.VectorWeight = 1, 1
+	}
+	if query.RRFConstant <= 0 {
+		query.RRFConstant = DefaultRRFConstant
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.graph.len() == 0 {
+		return []capability.VectorMatch{}, nil
+	}
+
+	// Rank deeper than the page so results ranked well by only one
+	// search can still make it.
+	need := min(query.Offset+query.Limit, s.graph.len())
+	depth := min(max(2*need, 50), s.graph.len())
+	fused := map[uint32]float64{}
+	add := func(ranked []candidate, weight float64) {
+		for rank, c := range ranked {
+			fused[c.id] += weight / float64(query.RRFConstant+rank+1)
+		}
+	}
+	if len(query.Vector) > 0 && query.VectorWeight > 0 {
+		q, err := s.prepare(query.Vector)
+		if err != nil {
+			return nil, fmt.Errorf("hybrid search: %w", err)
+		}
+		add(s.nearest(q, depth, query.Filter), query.VectorWeight)
+	}
+	if query.Text != "" && query.KeywordWeight > 0 {
+		add(s.keywords.search(query.Text, depth, s.keep(query.Filter)), query.KeywordWeight)
+	}
+	out := make([]capability.VectorMatch, 0, len(fused))
+	for id, score := range fused {
+		out = append(out, s.match(id, score))
+	}
+	return page(out, query.Offset, query.Limit), nil
+}
+
+func (s *VectorStore) keep(filter Filter) func(uint32) bool {
+	return func(id uint32) bool {
+		n := s.graph.node(id)
+		return n != nil && filter.Match(n.meta)
+	}
+}
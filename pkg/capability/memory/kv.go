@@ -1,19 +1,40 @@
 package memory
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 
 	"go.etcd.io/bbolt"
 )
 
 const kvBucket = "spawn_memory"
 
-// KVStore is a bbolt-backed key/value store.
+// LegacyNamespace is the shared namespace holding the keys written before
+// memory was scoped per agent, when every agent used one unscoped bucket.
+const LegacyNamespace = "legacy"
+
+// kvOpenTimeout bounds the wait for another process's lock on the db.
+const kvOpenTimeout = 5 * time.Second
+
+// KVStore is a bbolt-backed key/value store. Scoped stores returned by
+// Scope share the database under their own bucket.
 type KVStore struct {
-	db *bbolt.DB
+	db     *bbolt.DB
+	bucket []byte
+	scope  string
+	view   bool // a scoped view; Close leaves the db open
+}
+
+// KVPair is a listed key and value.
+type KVPair struct {
+	Key       string     `json:"key"`
+	Value     string     `json:"value"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
 }
 
 // NewKVStore creates or opens a kv store at path.
@@ -24,36 +45,93 @@ func NewKVStore(path string) (*KVStore, error) {
 	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
 		return nil, fmt.Errorf("mkdir kv dir: %w", err)
 	}
-	db, err := bbolt.Open(path, 0o600, nil)
+	db, err := bbolt.Open(path, 0o600, &bbolt.Options{Timeout: kvOpenTimeout})
+	if errors.Is(err, bbolt.ErrTimeout) {
+		return nil, fmt.Errorf("open kv db: %s is locked by another process", path)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("open kv db: %w", err)
 	}
 	if err := db.Update(func(tx *bbolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists([]byte(kvBucket))
-		return err
+		for _, name := range []string{kvBucket, expiryBucket} {
+			if _, err := tx.CreateBucketIfNotExists([]byte(name)); err != nil {
+				return err
+			}
+		}
+		return nil
 	}); err != nil {
+		db.Close()
 		return nil, fmt.Errorf("init kv bucket: %w", err)
 	}
-	return &KVStore{db: db}, nil
+	return &KVStore{db: db, bucket: []byte(kvBucket)}, nil
+}
+
+// migrateUnscoped moves the keys of the unscoped bucket into the shared
+// LegacyNamespace, which agents listing it in memory.shared read. Keys
+// already there are kept, and the bucket is left empty so later opens
+// have nothing to move.
+func migrateUnscoped(db *bbolt.DB) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		old := tx.Bucket([]byte(kvBucket))
+		if old == nil {
+			return nil
+		}
+		if k, _ := old.Cursor().First(); k == nil {
+			return nil
+		}
+		dst, err := tx.CreateBucketIfNotExists([]byte("kv/" + sharedScope + "/" + LegacyNamespace))
+		if err != nil {
+			return fmt.Errorf("migrate unscoped keys: %w", err)
+		}
+		if err := old.ForEach(func(k, v []byte) error {
+			if dst.Get(k) != nil {
+				return nil
+			}
+			return dst.Put(k, v)
+		}); err != nil {
+			return fmt.Errorf("migrate unscoped keys: %w", err)
+		}
+		if err := tx.DeleteBucket([]byte(kvBucket)); err != nil {
+			return fmt.Errorf("migrate unscoped keys: %w", err)
+		}
+		_, err = tx.CreateBucket([]byte(kvBucket))
+		return err
+	})
+}
+
+// Scope returns the store for one memory scope, kept in its own bucket.
+func (s *KVStore) Scope(scope string) (*KVStore, error) {
+	bucket := []byte("kv/" + scope)
+	if err := s.db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucket)
+		return err
+	}); err != nil {
+		return nil, fmt.Errorf("init kv scope: %w", err)
+	}
+	return &KVStore{db: s.db, bucket: bucket, scope: scope, view: true}, nil
+}
+
+// Set writes a key/value pair that does not expire.
+func (s *KVStore) Set(ctx context.Context, key string, value []byte) error {
+	return s.SetTTL(ctx, key, value, 0)
 }
 
-// Set writes a key/value pair.
-func (s *KVStore) Set(_ context.Context, key string, value []byte) error {
+// SetTTL writes a key/value pair that expires after ttl, or never if ttl
+// is not positive.
+func (s *KVStore) SetTTL(_ context.Context, key string, value []byte, ttl time.Duration) error {
 	return s.db.Update(func(tx *bbolt.Tx) error {
-		return tx.Bucket([]byte(kvBucket)).Put([]byte(key), value)
+		if err := tx.Bucket(s.bucket).Put([]byte(key), value); err != nil {
+			return err
+		}
+		return putExpiry(tx, kindKV, s.scope, key, deadline(ttl))
 	})
 }
 
-// Get reads a value by key.
+// Get reads a value by key. Missing and expired keys return nil.
 func (s *KVStore) Get(_ context.Context, key string) ([]byte, error) {
 	var out []byte
 	err := s.db.View(func(tx *bbolt.Tx) error {
-		v := tx.Bucket([]byte(kvBucket)).Get([]byte(key))
-		if v == nil {
-			out = nil
-			return nil
-		}
-		out = append([]byte(nil), v...)
+		out = s.get(tx, key, time.Now())
 		return nil
 	})
 	if err != nil {
@@ -62,9 +140,149 @@ func (s *KVStore) Get(_ context.Context, key string) ([]byte, error) {
 	return out, nil
 }
 
+func (s *KVStore) get(tx *bbolt.Tx, key string, now time.Time) []byte {
+	v := tx.Bucket(s.bucket).Get([]byte(key))
+	if v == nil || expired(tx, kindKV, s.scope, key, now) {
+		return nil
+	}
+	return append([]byte(nil), v...)
+}
+
+// Delete removes a key. Missing keys are not an error.
+func (s *KVStore) Delete(_ context.Context, key string) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		if err := tx.Bucket(s.bucket).Delete([]byte(key)); err != nil {
+			return err
+		}
+		return putExpiry(tx, kindKV, s.scope, key, time.Time{})
+	})
+}
+
+// List returns up to limit live pairs whose keys start with prefix, in key
+// order, starting after the key after.
+func (s *KVStore) List(_ context.Context, prefix, after string, limit int) ([]KVPair, error) {
+	out := []KVPair{}
+	now := time.Now()
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(s.bucket).Cursor()
+		k, v := c.Seek([]byte(prefix))
+		if after != "" && after >= prefix {
+			if k, v = c.Seek([]byte(after)); k != nil && string(k) == after {
+				k, v = c.Next()
+			}
+		}
+		for ; k != nil && bytes.HasPrefix(k, []byte(prefix)) && len(out) < limit; k, v = c.Next() {
+			key := string(k)
+			at, ok := expiryOf(tx, kindKV, s.scope, key)
+			if ok && !now.Before(at) {
+				continue
+			}
+			pair := KVPair{Key: key, Value: string(v)}
+			if ok {
+				pair.ExpiresAt = &at
+			}
+			out = append(out, pair)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list kv: %w", err)
+	}
+	return out, nil
+}
+
+// CompareAndSwap sets key to value, with ttl as for SetTTL, only if its
+// current value is old; a nil old means the key must not exist. It returns
+// whether the value was swapped and the value found.
+func (s *KVStore) CompareAndSwap(_ context.Context, key string, old, value []byte, ttl time.Duration) (bool, []byte, error) {
+	var swapped bool
+	var current []byte
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		current = s.get(tx, key, time.Now())
+		if (old == nil) != (current == nil) || !bytes.Equal(old, current) {
+			return nil
+		}
+		if err := tx.Bucket(s.bucket).Put([]byte(key), value); err != nil {
+			return err
+		}
+		swapped = true
+		return putExpiry(tx, kindKV, s.scope, key, deadline(ttl))
+	})
+	if err != nil {
+		return false, nil, fmt.Errorf("compare and swap kv: %w", err)
+	}
+	return swapped, current, nil
+}
+
+// setAt writes a key/value pair that expires at a given time, or never for
+// a zero time.
+func (s *KVStore) setAt(key string, value []byte, at time.Time) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		if err := tx.Bucket(s.bucket).Put([]byte(key), value); err != nil {
+			return err
+		}
+		return putExpiry(tx, kindKV, s.scope, key, at)
+	})
+}
+
+// clear removes every key of the scope and every expiry recorded for it.
+func (s *KVStore) clear() error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		if err := tx.DeleteBucket(s.bucket); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucket(s.bucket); err != nil {
+			return err
+		}
+		for _, kind := range []string{kindKV, kindVector, kindNode} {
+			prefix := expiryKey(kind, s.scope, "")
+			c := tx.Bucket([]byte(expiryBucket)).Cursor()
+			for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Seek(prefix) {
+				if err := c.Delete(); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// each calls fn for every live pair in key order, with its expiry time
+// (zero if none), in one read transaction.
+func (s *KVStore) each(now time.Time, fn func(key string, value []byte, at time.Time) error) error {
+	return s.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(s.bucket).ForEach(func(k, v []byte) error {
+			at, _ := expiryOf(tx, kindKV, s.scope, string(k))
+			if !at.IsZero() && !now.Before(at) {
+				return nil
+			}
+			return fn(string(k), append([]byte(nil), v...), at)
+		})
+	})
+}
+
+// expiryOf returns when an entry of kind in this scope expires.
+func (s *KVStore) expiryOf(kind, key string) (time.Time, bool) {
+	var at time.Time
+	var ok bool
+	_ = s.db.View(func(tx *bbolt.Tx) error {
+		at, ok = expiryOf(tx, kind, s.scope, key)
+		return nil
+	})
+	return at, ok
+}
+
+// setExpiry records when an entry of kind held in another store of this
+// scope expires; a zero time clears it.
+func (s *KVStore) setExpiry(kind, key string, at time.Time) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return putExpiry(tx, kind, s.scope, key, at)
+	})
+}
+
 // Close closes the db.
 func (s *KVStore) Close() error {
-	if s == nil || s.db == nil {
+	if s == nil || s.db == nil || s.view {
 		return nil
 	}
 	return s.db.Close()
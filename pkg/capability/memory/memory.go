@@ -2,84 +2,623 @@ package memory
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"sync"
+	"time"
 
 	"spawn.dev/pkg/capability"
 )
 
-// Capability combines vector, graph and key/value stores.
+// Capability combines vector, graph and key/value stores. Each agent's
+// data is kept apart, by namespace, in scopes opened on first use;
+// namespaces an agent lists as shared use the same stores for every agent
+// sharing them. One capability serves many agents: Configure gives each
+// its own settings, and the Set* methods set the defaults of the rest.
 type Capability struct {
-	vector *VectorStore
-	graph  *GraphStore
-	kv     *KVStore
+	kv        *KVStore
+	vectorDir string
+
+	mu       sync.Mutex
+	defaults settings
+	agents   map[string]settings
+	scopes   map[string]*scope
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// Settings are one agent's memory options, normally from its
+// spec.capabilities.memory config.
+type Settings struct {
+	// Vector sets the dimensions and metric of the agent's vector stores
+	// opened from now on; stores already open keep theirs.
+	Vector VectorOptions
+	// Embedder, normally the agent's llm.Provider, enables remember and
+	// recall and embeds episodes.
+	Embedder Embedder
+	Embed    EmbedOptions
+	// Graph enables the graph_* actions.
+	Graph bool
+	// TTL is the lifetime of entries written without a ttl param; zero
+	// keeps them until deleted.
+	TTL time.Duration
+	// Shared lists the namespaces the agent shares with other agents.
+	Shared []string
+}
+
+// settings are the options applied to one agent's requests.
+type settings struct {
+	embed  *embedder
+	graph  bool
+	vector VectorOptions
+	shared map[string]bool
+	ttl    time.Duration
 }
 
-// New returns memory capability backed by embedded stores.
+// New returns memory capability backed by embedded stores. Key/value and
+// graph data are kept in the database at path; vectors are kept in memory
+// until SetVectorStorage gives them a directory.
 func New(path string) (*Capability, error) {
 	kv, err := NewKVStore(path)
 	if err != nil {
 		return nil, fmt.Errorf("new memory capability: %w", err)
 	}
-	return &Capability{vector: NewVectorStore(), graph: NewGraphStore(), kv: kv}, nil
+	if err := migrateUnscoped(kv.db); err != nil {
+		kv.Close()
+		return nil, fmt.Errorf("new memory capability: %w", err)
+	}
+	return &Capability{
+		kv:       kv,
+		defaults: settings{graph: true, shared: map[string]bool{}},
+		agents:   map[string]settings{},
+		scopes:   map[string]*scope{},
+	}, nil
+}
+
+func (c *Capability) Name() string                      { return "memory" }
+func (c *Capability) Version() string                   { return "v1" }
+func (c *Capability) Description() string               { return "Persistent vector/graph/kv memory" }
+func (c *Capability) HealthCheck(context.Context) error { return nil }
+
+// Initialize starts deleting expired entries in the background.
+func (c *Capability) Initialize(context.Context, map[string]interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stop == nil {
+		c.stop, c.done = make(chan struct{}), make(chan struct{})
+		go c.sweep(c.stop, c.done)
+	}
+	return nil
+}
+
+// SetVectorStorage persists vector indexes under dir, normally
+// storage.vector.path: each agent namespace in dir/<agent>/<namespace> and
+// each shared namespace in dir/_shared/<namespace>. opts are the default
+// vector options. It must be called before the first request.
+func (c *Capability) SetVectorStorage(dir string, opts VectorOptions) {
+	c.vectorDir, c.defaults.vector = dir, opts
+}
+
+// Configure sets the memory options of agent's requests and episodes,
+// replacing any set before, so agents sharing the capability keep their
+// own embedder, TTL, vector options, graph setting and shared namespaces.
+func (c *Capability) Configure(agent string, s Settings) {
+	set := settings{graph: s.Graph, vector: s.Vector, shared: map[string]bool{}, ttl: s.TTL}
+	if s.Embedder != nil {
+		set.embed = newEmbedder(s.Embedder, s.Embed)
+	}
+	for _, ns := range s.Shared {
+		set.shared[ns] = true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.agents[agent] = set
+}
+
+// settingsFor returns the options of agent's requests: those given to
+// Configure, or the defaults.
+func (c *Capability) settingsFor(agent string) settings {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.settingsLocked(agent)
+}
+
+// settingsLocked is settingsFor with c.mu held.
+func (c *Capability) settingsLocked(agent string) settings {
+	if set, ok := c.agents[agent]; ok {
+		return set
+	}
+	return c.defaults
+}
+
+// SetVectorOptions sets the default dimensions and metric of vector stores
+// opened from now on; stores already open keep theirs.
+func (c *Capability) SetVectorOptions(opts VectorOptions) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.defaults.vector = opts
 }
 
-func (c *Capability) Name() string                                             { return "memory" }
-func (c *Capability) Version() string                                          { return "v1" }
-func (c *Capability) Description() string                                      { return "Persistent vector/graph/kv memory" }
-func (c *Capability) Initialize(context.Context, map[string]interface{}) error { return nil }
-func (c *Capability) Shutdown(context.Context) error                           { return c.kv.Close() }
-func (c *Capability) HealthCheck(context.Context) error                        { return nil }
+// SetEmbedder enables the remember and recall actions by default, which
+// embed text through e, normally an llm.Provider.
+func (c *Capability) SetEmbedder(e Embedder, opts EmbedOptions) {
+	embed := newEmbedder(e, opts)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.defaults.embed = embed
+}
+
+// SetGraphEnabled turns the graph_* actions on or off by default. They are
+// on unless an agent's settings turn them off.
+func (c *Capability) SetGraphEnabled(enabled bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.defaults.graph = enabled
+}
+
+// SetSharedNamespaces makes the named namespaces shared by default:
+// requests from any agent naming one of them use the same stores.
+func (c *Capability) SetSharedNamespaces(namespaces ...string) {
+	shared := map[string]bool{}
+	for _, ns := range namespaces {
+		shared[ns] = true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.defaults.shared = shared
+}
+
+// SetTTL sets the default lifetime of entries written without a ttl param;
+// zero, the default, keeps them until deleted.
+func (c *Capability) SetTTL(ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.defaults.ttl = ttl
+}
+
+func (c *Capability) Shutdown(context.Context) error {
+	c.mu.Lock()
+	stop, done := c.stop, c.done
+	c.stop = nil
+	c.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+	return errors.Join(c.closeScopes(), c.kv.Close())
+}
 
 func (c *Capability) Schema() *capability.Schema {
-	return &capability.Schema{Actions: []capability.Action{{Name: "kv_get"}, {Name: "kv_set"}, {Name: "vector_put"}, {Name: "vector_search"}}}
+	return &capability.Schema{Actions: []capability.Action{
+		{Name: "kv_get"}, {Name: "kv_set"}, {Name: "kv_delete"},
+		{Name: "kv_list", Description: "List keys starting with prefix, with their values, after the key after"},
+		{Name: "kv_cas", Description: "Set key to value only if it currently holds expected; a missing expected means the key must not exist"},
+		{Name: "vector_put"}, {Name: "vector_delete"}, {Name: "vector_search"},
+		{Name: "remember", Description: "Chunk, embed and store text under key, with optional metadata"},
+		{Name: "recall", Description: "Embed query and return the closest remembered chunks, optionally filtered"},
+		{Name: "hybrid_search", Description: "Fuse BM25 keyword and vector rankings for query; keyword_weight and vector_weight tune the mix"},
+		{Name: "graph_put_node", Description: "Create or replace node id with labels and properties"},
+		{Name: "graph_get_node"}, {Name: "graph_delete_node", Description: "Delete node id and its edges"},
+		{Name: "graph_put_edge", Description: "Create or replace the edge of type from one node to another, with properties"},
+		{Name: "graph_delete_edge"},
+		{Name: "graph_neighbors", Description: "List nodes one edge from id, by direction (out, in, both) and edge types"},
+		{Name: "graph_traverse", Description: "List nodes within depth edges of id, breadth first"},
+		{Name: "graph_path", Description: "Find a shortest path between two nodes"},
+		{Name: "graph_query", Description: "Match a pattern such as (a:Person)-[:WORKS_AT]->(b) and return its bindings"},
+	}}
 }
 
 func (c *Capability) Execute(ctx context.Context, req *capability.Request) (*capability.Response, error) {
 	if req == nil {
-		return &capability.Response{Success: false, Error: &capability.Error{Code: "invalid_request", Message: "nil request"}}, nil
+		return capability.Failure("invalid_request", "nil request"), nil
+	}
+	name, err := c.scopeName(req)
+	if err != nil {
+		return capability.Failure("invalid_scope", err.Error()), nil
+	}
+	set := c.settingsFor(req.Context.AgentID)
+	sc, err := c.requestScope(name)
+	if errors.Is(err, ErrTooManyNamespaces) {
+		return capability.Failure("namespace_limit", err.Error()), nil
+	}
+	if err != nil {
+		return capability.Failure("scope_failed", err.Error()), nil
 	}
 	switch req.Action {
-	case "kv_set":
+	case "kv_set", "kv_get", "kv_delete", "kv_list", "kv_cas":
+		return c.kvAction(ctx, sc, set, req.Action, req.Params), nil
+	case "vector_put":
 		k, _ := req.Params["key"].(string)
-		v, _ := req.Params["value"].(string)
-		if err := c.kv.Set(ctx, k, []byte(v)); err != nil {
-			return &capability.Response{Success: false, Error: &capability.Error{Code: "kv_set_failed", Message: err.Error()}}, nil
+		vecAny, err := floatsParam(req.Params["vector"])
+		if err != nil {
+			return capability.Failure("invalid_params", err.Error()), nil
 		}
-		return &capability.Response{Success: true}, nil
-	case "kv_get":
-		k, _ := req.Params["key"].(string)
-		val, err := c.kv.Get(ctx, k)
+		ttl, err := ttlParam(req.Params, set.ttl)
 		if err != nil {
-			return &capability.Response{Success: false, Error: &capability.Error{Code: "kv_get_failed", Message: err.Error()}}, nil
+			return capability.Failure("invalid_params", err.Error()), nil
 		}
-		return &capability.Response{Success: true, Data: string(val)}, nil
-	case "vector_put":
+		text, _ := req.Params["text"].(string)
+		meta, _ := req.Params["metadata"].(map[string]interface{})
+		if err := sc.vector.PutRecord(ctx, VectorRecord{Key: k, Vector: vecAny, Text: text, Metadata: meta}); err != nil {
+			return capability.Failure("vector_put_failed", err.Error()), nil
+		}
+		if err := sc.kv.setExpiry(kindVector, k, deadline(ttl)); err != nil {
+			return capability.Failure("vector_put_failed", err.Error()), nil
+		}
+		return &capability.Response{Success: true}, nil
+	case "vector_delete":
 		k, _ := req.Params["key"].(string)
-		vecAny, _ := req.Params["vector"].([]float32)
-		if err := c.vector.Put(ctx, k, vecAny); err != nil {
-			return &capability.Response{Success: false, Error: &capability.Error{Code: "vector_put_failed", Message: err.Error()}}, nil
+		if err := sc.vector.Delete(ctx, k); err != nil {
+			return capability.Failure("vector_delete_failed", err.Error()), nil
+		}
+		if err := sc.kv.setExpiry(kindVector, k, time.Time{}); err != nil {
+			return capability.Failure("vector_delete_failed", err.Error()), nil
 		}
 		return &capability.Response{Success: true}, nil
 	case "vector_search":
-		vecAny, _ := req.Params["vector"].([]float32)
-		keys, err := c.vector.Search(ctx, vecAny, 5)
+		vecAny, err := floatsParam(req.Params["vector"])
 		if err != nil {
-			return &capability.Response{Success: false, Error: &capability.Error{Code: "vector_search_failed", Message: err.Error()}}, nil
+			return capability.Failure("invalid_params", err.Error()), nil
+		}
+		return c.search(ctx, sc, vecAny, req.Params, "vector_search_failed"), nil
+	case "remember":
+		return c.remember(ctx, sc, set, req.Params), nil
+	case "recall":
+		embed := set.embed
+		if embed == nil {
+			return capability.Failure("no_embedder", "recall needs an embedding provider"), nil
+		}
+		text, _ := req.Params["query"].(string)
+		if strings.TrimSpace(text) == "" {
+			return capability.Failure("invalid_params", "query is required"), nil
 		}
-		return &capability.Response{Success: true, Data: keys}, nil
+		vecs, err := embed.embed(ctx, []string{text})
+		if err != nil {
+			return capability.Failure("embed_failed", err.Error()), nil
+		}
+		return c.search(ctx, sc, vecs[0], req.Params, "recall_failed"), nil
+	case "hybrid_search":
+		return c.hybrid(ctx, sc, set, req.Params), nil
+	case "graph_put_node", "graph_get_node", "graph_delete_node", "graph_put_edge", "graph_delete_edge",
+		"graph_neighbors", "graph_traverse", "graph_path", "graph_query":
+		if !set.graph {
+			return capability.Failure("graph_disabled", "graph memory is disabled for this agent"), nil
+		}
+		return c.graphAction(ctx, sc, set, req.Action, req.Params), nil
 	default:
-		return &capability.Response{Success: false, Error: &capability.Error{Code: "invalid_action", Message: req.Action}}, nil
+		return capability.Failure("invalid_action", req.Action), nil
+	}
+}
+
+// kvAction runs the kv_* actions. Values are strings; kv_set and kv_cas
+// take a ttl.
+func (c *Capability) kvAction(ctx context.Context, sc *scope, set settings, action string, params map[string]interface{}) *capability.Response {
+	k, _ := params["key"].(string)
+	v, _ := params["value"].(string)
+	switch action {
+	case "kv_set":
+		ttl, err := ttlParam(params, set.ttl)
+		if err != nil {
+			return capability.Failure("invalid_params", err.Error())
+		}
+		if err := sc.kv.SetTTL(ctx, k, []byte(v), ttl); err != nil {
+			return capability.Failure("kv_set_failed", err.Error())
+		}
+		return &capability.Response{Success: true}
+	case "kv_get":
+		val, err := sc.kv.Get(ctx, k)
+		if err != nil {
+			return capability.Failure("kv_get_failed", err.Error())
+		}
+		return &capability.Response{Success: true, Data: string(val)}
+	case "kv_delete":
+		if err := sc.kv.Delete(ctx, k); err != nil {
+			return capability.Failure("kv_delete_failed", err.Error())
+		}
+		return &capability.Response{Success: true}
+	case "kv_list":
+		prefix, _ := params["prefix"].(string)
+		after, _ := params["after"].(string)
+		limit := capability.IntParam(params, "limit", 0)
+		if limit <= 0 || limit > 1000 {
+			limit = 100
+		}
+		pairs, err := sc.kv.List(ctx, prefix, after, limit)
+		if err != nil {
+			return capability.Failure("kv_list_failed", err.Error())
+		}
+		return &capability.Response{Success: true, Data: pairs}
+	default: // kv_cas
+		ttl, err := ttlParam(params, set.ttl)
+		if err != nil {
+			return capability.Failure("invalid_params", err.Error())
+		}
+		var old []byte
+		if expected, ok := params["expected"].(string); ok {
+			old = []byte(expected)
+		}
+		swapped, current, err := sc.kv.CompareAndSwap(ctx, k, old, []byte(v), ttl)
+		if err != nil {
+			return capability.Failure("kv_cas_failed", err.Error())
+		}
+		if !swapped {
+			var found interface{}
+			if current != nil {
+				found = string(current)
+			}
+			return &capability.Response{Success: false, Data: map[string]interface{}{"value": found}, Error: &capability.Error{Code: "cas_conflict", Message: fmt.Sprintf("%s does not hold the expected value", k)}}
+		}
+		return &capability.Response{Success: true}
 	}
 }
 
-// VectorStore is a tiny in-memory vector store.
-type VectorStore struct {
-	mu   sync.RWMutex
-	data map[string][]float32
+// floatParam reads a numeric parameter, defaulting to 0.
+func floatParam(params map[string]interface{}, key string) float64 {
+	f, _ := toFloat(params[key])
+	return f
 }
 
-// NewVectorStore returns an in-memory vector store.
-func NewVectorStore() *VectorStore {
-	return &VectorStore{data: make(map[string][]float32)}
+// search runs a vector query with the limit, offset and filter params.
+func (c *Capability) search(ctx context.Context, sc *scope, vec []float32, params map[string]interface{}, code string) *capability.Response {
+	query := VectorQuery{Vector: vec, Limit: capability.IntParam(params, "limit", 0), Offset: capability.IntParam(params, "offset", 0)}
+	if raw, ok := params["filter"].(map[string]interface{}); ok {
+		filter, err := ParseFilter(raw)
+		if err != nil {
+			return capability.Failure("invalid_filter", err.Error())
+		}
+		query.Filter = filter
+	}
+	matches, err := sc.vector.Query(ctx, query)
+	if err != nil {
+		return capability.Failure(code, err.Error())
+	}
+	return &capability.Response{Success: true, Data: matches}
+}
+
+// hybrid runs hybrid_search. The vector half uses the vector param, or the
+// embedded query when the agent has an embedder; without either the
+// search is keyword only.
+func (c *Capability) hybrid(ctx context.Context, sc *scope, set settings, params map[string]interface{}) *capability.Response {
+	text, _ := params["query"].(string)
+	query := HybridQuery{
+		Text:          text,
+		Limit:         capability.IntParam(params, "limit", 0),
+		Offset:        capability.IntParam(params, "offset", 0),
+		KeywordWeight: floatParam(params, "keyword_weight"),
+		VectorWeight:  floatParam(params, "vector_weight"),
+		RRFConstant:   capability.IntParam(params, "k", 0),
+	}
+	if raw, ok := params["filter"].(map[string]interface{}); ok {
+		filter, err := ParseFilter(raw)
+		if err != nil {
+			return capability.Failure("invalid_filter", err.Error())
+		}
+		query.Filter = filter
+	}
+	if raw, ok := params["vector"]; ok {
+		vec, err := floatsParam(raw)
+		if err != nil {
+			return capability.Failure("invalid_params", err.Error())
+		}
+		query.Vector = vec
+	} else if embed := set.embed; embed != nil && strings.TrimSpace(text) != "" {
+		vecs, err := embed.embed(ctx, []string{text})
+		if err != nil {
+			return capability.Failure("embed_failed", err.Error())
+		}
+		query.Vector = vecs[0]
+	}
+	if strings.TrimSpace(text) == "" && query.Vector == nil {
+		return capability.Failure("invalid_params", "query or vector is required")
+	}
+	matches, err := sc.vector.Hybrid(ctx, query)
+	if err != nil {
+		return capability.Failure("hybrid_search_failed", err.Error())
+	}
+	return &capability.Response{Success: true, Data: matches}
+}
+
+// graphAction runs the graph_* actions. Node and edge ids are strings;
+// direction is out (default), in or both, and types limits the edge types
+// followed. A node's ttl also ends its edges.
+func (c *Capability) graphAction(ctx context.Context, sc *scope, set settings, action string, params map[string]interface{}) *capability.Response {
+	fail := func(code string, err error) *capability.Response {
+		if errors.Is(err, ErrNodeNotFound) {
+			code = "not_found"
+		}
+		return capability.Failure(code, err.Error())
+	}
+	id, _ := params["id"].(string)
+	from, _ := params["from"].(string)
+	to, _ := params["to"].(string)
+	typ, _ := params["type"].(string)
+	direction, _ := params["direction"].(string)
+	types := stringsParam(params["types"])
+	props, _ := params["properties"].(map[string]interface{})
+	switch action {
+	case "graph_put_node":
+		ttl, err := ttlParam(params, set.ttl)
+		if err != nil {
+			return fail("invalid_params", err)
+		}
+		if err := sc.graph.PutNode(ctx, Node{ID: id, Labels: stringsParam(params["labels"]), Properties: props}); err != nil {
+			return fail("graph_put_failed", err)
+		}
+		if err := sc.kv.setExpiry(kindNode, id, deadline(ttl)); err != nil {
+			return fail("graph_put_failed", err)
+		}
+		return &capability.Response{Success: true}
+	case "graph_get_node":
+		n := sc.graph.GetNode(ctx, id)
+		if n == nil {
+			return fail("", fmt.Errorf("%w: %s", ErrNodeNotFound, id))
+		}
+		return &capability.Response{Success: true, Data: n}
+	case "graph_delete_node":
+		if err := sc.graph.DeleteNode(ctx, id); err != nil {
+			return fail("graph_delete_failed", err)
+		}
+		if err := sc.kv.setExpiry(kindNode, id, time.Time{}); err != nil {
+			return fail("graph_delete_failed", err)
+		}
+		return &capability.Response{Success: true}
+	case "graph_put_edge":
+		if err := sc.graph.PutEdge(ctx, Edge{From: from, To: to, Type: typ, Properties: props}); err != nil {
+			return fail("graph_put_failed", err)
+		}
+		return &capability.Response{Success: true}
+	case "graph_delete_edge":
+		if err := sc.graph.DeleteEdge(ctx, from, typ, to); err != nil {
+			return fail("graph_delete_failed", err)
+		}
+		return &capability.Response{Success: true}
+	case "graph_neighbors":
+		out, err := sc.graph.Neighbors(ctx, id, direction, types)
+		if err != nil {
+			return fail("graph_query_failed", err)
+		}
+		return &capability.Response{Success: true, Data: out}
+	case "graph_traverse":
+		depth := capability.IntParam(params, "depth", 0)
+		if depth <= 0 {
+			depth = 2
+		}
+		out, err := sc.graph.Traverse(ctx, id, direction, types, depth, capability.IntParam(params, "limit", 0))
+		if err != nil {
+			return fail("graph_query_failed", err)
+		}
+		return &capability.Response{Success: true, Data: out}
+	case "graph_path":
+		path, err := sc.graph.ShortestPath(ctx, from, to, direction, types, capability.IntParam(params, "max_depth", 0))
+		if err != nil {
+			return fail("graph_query_failed", err)
+		}
+		if path == nil {
+			return capability.Failure("no_path", fmt.Sprintf("no path from %s to %s", from, to))
+		}
+		return &capability.Response{Success: true, Data: path}
+	default: // graph_query
+		src, _ := params["pattern"].(string)
+		pattern, err := ParsePattern(src)
+		if err != nil {
+			return fail("invalid_pattern", err)
+		}
+		limit := capability.IntParam(params, "limit", 0)
+		if limit <= 0 {
+			limit = 100
+		}
+		rows, err := sc.graph.Match(ctx, pattern, limit)
+		if errors.Is(err, ErrPatternTooComplex) {
+			return fail("pattern_too_complex", err)
+		}
+		if err != nil {
+			return fail("graph_query_failed", err)
+		}
+		return &capability.Response{Success: true, Data: rows}
+	}
+}
+
+// stringsParam reads a list of strings given as []string or a JSON-decoded
+// array, skipping non-strings.
+func stringsParam(v interface{}) []string {
+	switch list := v.(type) {
+	case []string:
+		return list
+	case []interface{}:
+		out := make([]string, 0, len(list))
+		for _, item := range list {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	default:
+		return nil
+	}
+}
+
+// remember stores text as embedded chunks keyed "<key>#<n>", replacing any
+// chunks previously stored under key. Each chunk's metadata is the given
+// metadata plus "source" (the key) and "chunk" (its index).
+func (c *Capability) remember(ctx context.Context, sc *scope, set settings, params map[string]interface{}) *capability.Response {
+	embed := set.embed
+	if embed == nil {
+		return capability.Failure("no_embedder", "remember needs an embedding provider")
+	}
+	text, _ := params["text"].(string)
+	if strings.TrimSpace(text) == "" {
+		return capability.Failure("invalid_params", "text is required")
+	}
+	key, _ := params["key"].(string)
+	if key == "" {
+		key = textKey(text)
+	}
+	meta, _ := params["metadata"].(map[string]interface{})
+	ttl, err := ttlParam(params, set.ttl)
+	if err != nil {
+		return capability.Failure("invalid_params", err.Error())
+	}
+	opts := embed.opts
+	chunks := chunkText(text, opts.ChunkSize, opts.ChunkOverlap)
+	vecs, err := embed.embed(ctx, chunks)
+	if err != nil {
+		return capability.Failure("embed_failed", err.Error())
+	}
+	for i, chunk := range chunks {
+		chunkMeta := make(map[string]interface{}, len(meta)+2)
+		for k, v := range meta {
+			chunkMeta[k] = v
+		}
+		chunkMeta["source"] = key
+		chunkMeta["chunk"] = i
+		rec := VectorRecord{Key: chunkKey(key, i), Vector: vecs[i], Text: chunk, Metadata: chunkMeta}
+		if err := sc.vector.PutRecord(ctx, rec); err != nil {
+			return capability.Failure("remember_failed", err.Error())
+		}
+		if err := sc.kv.setExpiry(kindVector, rec.Key, deadline(ttl)); err != nil {
+			return capability.Failure("remember_failed", err.Error())
+		}
+	}
+	for i := len(chunks); ; i++ {
+		if _, ok := sc.vector.Get(ctx, chunkKey(key, i)); !ok {
+			break
+		}
+		if err := sc.vector.Delete(ctx, chunkKey(key, i)); err != nil {
+			return capability.Failure("remember_failed", err.Error())
+		}
+		if err := sc.kv.setExpiry(kindVector, chunkKey(key, i), time.Time{}); err != nil {
+			return capability.Failure("remember_failed", err.Error())
+		}
+	}
+	return &capability.Response{Success: true, Data: map[string]interface{}{"key": key, "chunks": len(chunks)}}
+}
+
+// floatsParam reads a vector given as []float32, []float64 or a
+// JSON-decoded array of numbers.
+func floatsParam(v interface{}) ([]float32, error) {
+	switch vec := v.(type) {
+	case []float32:
+		return vec, nil
+	case []float64:
+		out := make([]float32, len(vec))
+		for i, f := range vec {
+			out[i] = float32(f)
+		}
+		return out, nil
+	case []interface{}:
+		out := make([]float32, len(vec))
+		for i, item := range vec {
+			f, ok := toFloat(item)
+			if !ok {
+				return nil, fmt.Errorf("vector element %d is %T, not a number", i, item)
+			}
+			out[i] = float32(f)
+		}
+		return out, nil
+	case nil:
+		return nil, fmt.Errorf("vector is required")
+	default:
+		return nil, fmt.Errorf("vector must be an array of numbers, got %T", v)
+	}
 }
@@ -0,0 +1,273 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"strings"
+	"sync"
+	"testing"
+
+	"spawn.dev/pkg/capability"
+)
+
+// wordEmbedder embeds text as counts of a few marker words, and records
+// its calls.
+type wordEmbedder struct {
+	mu    sync.Mutex
+	calls [][]string
+}
+
+func (e *wordEmbedder) Embed(_ context.Context, input []string) ([][]float32, error) {
+	e.mu.Lock()
+	e.calls = append(e.calls, input)
+	e.mu.Unlock()
+	markers := []string{"cat", "dog", "fish", "bird"}
+	out := make([][]float32, len(input))
+	for i, text := range input {
+		vec := make([]float32, len(markers)+1)
+		vec[len(markers)] = 0.01
+		for j, m := range markers {
+			vec[j] = float32(strings.Count(text, m))
+		}
+		out[i] = vec
+	}
+	return out, nil
+}
+
+func newTestMemory(t *testing.T) *Capability {
+	t.Helper()
+	c, err := New(t.TempDir() + "/kv.db")
+	if err != nil {
+		t.Fatalf("new memory: %v", err)
+	}
+	t.Cleanup(func() { _ = c.Shutdown(context.Background()) })
+	return c
+}
+
+func execute(t *testing.T, c *Capability, action string, params map[string]interface{}) *capability.Response {
+	t.Helper()
+	return executeAs(t, c, "default", action, params)
+}
+
+func executeAs(t *testing.T, c *Capability, agent, action string, params map[string]interface{}) *capability.Response {
+	t.Helper()
+	req := &capability.Request{Action: action, Params: params}
+	if agent != "" {
+		req.Context = &capability.ExecutionContext{AgentID: agent}
+	}
+	resp, err := c.Execute(context.Background(), req)
+	if err != nil {
+		t.Fatalf("%s: %v", action, err)
+	}
+	return resp
+}
+
+func testScope(t *testing.T, c *Capability, name string) *scope {
+	t.Helper()
+	sc, err := c.scope(name)
+	if err != nil {
+		t.Fatalf("scope %s: %v", name, err)
+	}
+	return sc
+}
+
+func TestRememberAndRecall(t *testing.T) {
+	t.Parallel()
+	c := newTestMemory(t)
+	embedder := &wordEmbedder{}
+	c.SetEmbedder(embedder, EmbedOptions{ChunkSize: 40, ChunkOverlap: 10, BatchSize: 2})
+
+	text := "the cat sat on the mat with another cat. " +
+		"later a dog barked at the dog next door. " +
+		"fish swim and fish swim again in the bowl."
+	resp := execute(t, c, "remember", map[string]interface{}{"key": "pets", "text": text, "metadata": map[string]interface{}{"topic": "animals"}})
+	if !resp.Success {
+		t.Fatalf("remember failed: %+v", resp.Error)
+	}
+	chunks := resp.Data.(map[string]interface{})["chunks"].(int)
+	if chunks < 3 {
+		t.Fatalf("expected text split into at least 3 chunks, got %d", chunks)
+	}
+	for _, call := range embedder.calls {
+		if len(call) > 2 {
+			t.Fatalf("batch of %d exceeds batch size 2", len(call))
+		}
+	}
+
+	resp = execute(t, c, "recall", map[string]interface{}{"query": "dog", "limit": float64(1)})
+	if !resp.Success {
+		t.Fatalf("recall failed: %+v", resp.Error)
+	}
+	matches := resp.Data.([]capability.VectorMatch)
+	if len(matches) != 1 || !strings.Contains(matches[0].Text, "dog") || matches[0].Metadata["source"] != "pets" || matches[0].Metadata["topic"] != "animals" {
+		t.Fatalf("unexpected recall result: %+v", matches)
+	}
+
+	// The same query is answered from the cache.
+	calls := len(embedder.calls)
+	execute(t, c, "recall", map[string]interface{}{"query": "dog"})
+	if len(embedder.calls) != calls {
+		t.Fatalf("repeated query was embedded again")
+	}
+
+	// Remembering a shorter text under the same key drops the old chunks.
+	resp = execute(t, c, "remember", map[string]interface{}{"key": "pets", "text": "one bird"})
+	if !resp.Success || resp.Data.(map[string]interface{})["chunks"] != 1 {
+		t.Fatalf("re-remember failed: %+v", resp)
+	}
+	if n := testScope(t, c, "default/default").vector.Len(); n != 1 {
+		t.Fatalf("expected 1 chunk after replacing, got %d", n)
+	}
+
+	resp = execute(t, c, "recall", map[string]interface{}{"query": "bird", "filter": map[string]interface{}{"source": "other"}})
+	if !resp.Success || len(resp.Data.([]capability.VectorMatch)) != 0 {
+		t.Fatalf("filtered recall should be empty: %+v", resp)
+	}
+}
+
+func TestRememberWithoutEmbedder(t *testing.T) {
+	t.Parallel()
+	c := newTestMemory(t)
+	resp := execute(t, c, "remember", map[string]interface{}{"text": "hello"})
+	if resp.Success || resp.Error.Code != "no_embedder" {
+		t.Fatalf("expected no_embedder, got %+v", resp)
+	}
+}
+
+func TestVectorActionsAcceptJSONArrays(t *testing.T) {
+	t.Parallel()
+	c := newTestMemory(t)
+	var params map[string]interface{}
+	if err := json.Unmarshal([]byte(`{"key": "a", "vector": [1, 0, 0], "text": "first"}`), &params); err != nil {
+		t.Fatal(err)
+	}
+	if resp := execute(t, c, "vector_put", params); !resp.Success {
+		t.Fatalf("vector_put failed: %+v", resp.Error)
+	}
+	_ = json.Unmarshal([]byte(`{"vector": [0.9, 0.1, 0], "limit": 1}`), &params)
+	resp := execute(t, c, "vector_search", params)
+	if !resp.Success {
+		t.Fatalf("vector_search failed: %+v", resp.Error)
+	}
+	if matches := resp.Data.([]capability.VectorMatch); len(matches) != 1 || matches[0].Key != "a" || matches[0].Text != "first" {
+		t.Fatalf("unexpected matches: %+v", matches)
+	}
+	resp = execute(t, c, "vector_search", map[string]interface{}{"vector": []interface{}{"x"}})
+	if resp.Success || resp.Error.Code != "invalid_params" {
+		t.Fatalf("expected invalid_params, got %+v", resp)
+	}
+}
+
+func TestSearchPagesAreBounded(t *testing.T) {
+	t.Parallel()
+	c := newTestMemory(t)
+	if resp := execute(t, c, "vector_put", map[string]interface{}{"key": "a", "vector": []interface{}{1.0, 0.0}, "text": "only one"}); !resp.Success {
+		t.Fatalf("vector_put failed: %+v", resp.Error)
+	}
+	for _, action := range []string{"vector_search", "hybrid_search"} {
+		resp := execute(t, c, action, map[string]interface{}{"vector": []interface{}{1.0, 0.0}, "query": "one", "limit": 2e9, "offset": 1e6})
+		if !resp.Success {
+			t.Fatalf("%s with a huge page failed: %+v", action, resp.Error)
+		}
+		resp = execute(t, c, action, map[string]interface{}{"vector": []interface{}{1.0, 0.0}, "query": "one", "limit": 2e9})
+		if matches := resp.Data.([]capability.VectorMatch); !resp.Success || len(matches) != 1 {
+			t.Fatalf("%s: expected the one match, got %+v", action, resp)
+		}
+	}
+	store := NewVectorStore()
+	_ = store.Put(context.Background(), "a", []float32{1, 0})
+	if _, err := store.Query(context.Background(), VectorQuery{Vector: []float32{1, 0}, Limit: 10, Offset: math.MaxInt - 5}); err == nil {
+		t.Fatal("expected an overflowing offset to be rejected")
+	}
+}
+
+func TestChunkText(t *testing.T) {
+	t.Parallel()
+	chunks := chunkText("aaa bbb ccc ddd eee", 8, 4)
+	want := []string{"aaa bbb", "bbb ccc", "ccc ddd", "ddd eee"}
+	if strings.Join(chunks, "|") != strings.Join(want, "|") {
+		t.Fatalf("chunks = %q, want %q", chunks, want)
+	}
+	if got := chunkText("abcdefghij", 4, 0); strings.Join(got, "|") != "abcd|efgh|ij" {
+		t.Fatalf("long word chunks = %q", got)
+	}
+}
+
+func TestHybridSearch(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	dir := t.TempDir()
+	store, err := OpenVectorStore(dir, VectorOptions{Dimensions: 2})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	records := []VectorRecord{
+		{Key: "timeout", Vector: []float32{1, 0}, Text: "connection timed out while dialing the server"},
+		{Key: "reset", Vector: []float32{0.8, 0.6}, Text: "dial failed with ERR_CONN_RESET from the proxy"},
+		{Key: "refused", Vector: []float32{0.9, 0.1}, Text: "connection refused by the server"},
+		{Key: "disk", Vector: []float32{0, 1}, Text: "disk full while writing the log"},
+	}
+	for _, rec := range records {
+		if err := store.PutRecord(ctx, rec); err != nil {
+			t.Fatalf("put: %v", err)
+		}
+	}
+	if err := store.Delete(ctx, "disk"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	store.Close()
+	if store, err = OpenVectorStore(dir, VectorOptions{}); err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer store.Close()
+
+	keyword, err := store.KeywordSearch(ctx, "err_conn_reset", 5, 0, nil)
+	if err != nil || len(keyword) != 1 || keyword[0].Key != "reset" {
+		t.Fatalf("keyword search = %+v, %v", keyword, err)
+	}
+	if got, _ := store.KeywordSearch(ctx, "disk", 5, 0, nil); len(got) != 0 {
+		t.Fatalf("deleted record still indexed: %+v", got)
+	}
+
+	// The vector alone prefers timeout; the identifier pulls reset up.
+	query := HybridQuery{Text: "ERR_CONN_RESET", Vector: []float32{1, 0}, Limit: 3}
+	fused, err := store.Hybrid(ctx, query)
+	if err != nil {
+		t.Fatalf("hybrid: %v", err)
+	}
+	if len(fused) != 3 || fused[0].Key != "reset" {
+		t.Fatalf("hybrid ranking = %+v, want reset first", fused)
+	}
+	query.KeywordWeight, query.VectorWeight = 0.01, 1
+	if fused, _ = store.Hybrid(ctx, query); fused[0].Key != "timeout" {
+		t.Fatalf("vector-weighted ranking = %+v, want timeout first", fused)
+	}
+	if _, err := store.Hybrid(ctx, HybridQuery{Text: "x", KeywordWeight: -1}); err == nil {
+		t.Fatalf("expected negative weight error")
+	}
+}
+
+func TestHybridSearchAction(t *testing.T) {
+	t.Parallel()
+	c := newTestMemory(t)
+	_ = execute(t, c, "vector_put", map[string]interface{}{"key": "a", "vector": []interface{}{1.0, 0.0}, "text": "uses parseConfig to load settings", "metadata": map[string]interface{}{"lang": "go"}})
+	_ = execute(t, c, "vector_put", map[string]interface{}{"key": "b", "vector": []interface{}{0.0, 1.0}, "text": "settings loader", "metadata": map[string]interface{}{"lang": "py"}})
+
+	// Without an embedder or vector the search is keyword only.
+	resp := execute(t, c, "hybrid_search", map[string]interface{}{"query": "parseConfig"})
+	if !resp.Success {
+		t.Fatalf("hybrid_search failed: %+v", resp.Error)
+	}
+	if matches := resp.Data.([]capability.VectorMatch); len(matches) != 1 || matches[0].Key != "a" {
+		t.Fatalf("unexpected matches: %+v", matches)
+	}
+	resp = execute(t, c, "hybrid_search", map[string]interface{}{"query": "settings", "vector": []interface{}{0.0, 1.0}, "filter": map[string]interface{}{"lang": "go"}})
+	if matches := resp.Data.([]capability.VectorMatch); !resp.Success || len(matches) != 1 || matches[0].Key != "a" {
+		t.Fatalf("filtered hybrid matches: %+v", resp)
+	}
+	resp = execute(t, c, "hybrid_search", map[string]interface{}{})
+	if resp.Success || resp.Error.Code != "invalid_params" {
+		t.Fatalf("expected invalid_params, got %+v", resp)
+	}
+}
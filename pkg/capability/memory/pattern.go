@@ -0,0 +1,459 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// MaxPatternHops caps the relationships in a pattern passed to Match.
+const MaxPatternHops = 8
+
+// MaxPatternSteps caps the nodes and edges Match looks at for one pattern:
+// a chain that matches nothing can still explore exponentially many paths,
+// all while holding the graph's read lock.
+const MaxPatternSteps = 100_000
+
+// ErrPatternTooComplex is returned by Match for a pattern over
+// MaxPatternHops or one that needs more than MaxPatternSteps.
+var ErrPatternTooComplex = errors.New("graph pattern too complex")
+
+// Pattern is a parsed graph pattern: a chain of node patterns joined by
+// relationship patterns, Rels[i] joining Nodes[i] and Nodes[i+1].
+type Pattern struct {
+	Nodes []NodePattern
+	Rels  []RelPattern
+}
+
+// NodePattern matches nodes carrying every label and property. The
+// property "id" matches the node ID.
+type NodePattern struct {
+	Var        string
+	Labels     []string
+	Properties map[string]interface{}
+}
+
+// RelPattern matches an edge of any of Types (any type if empty) in
+// Direction, read left to right, with every property.
+type RelPattern struct {
+	Var        string
+	Types      []string
+	Direction  string
+	Properties map[string]interface{}
+}
+
+// ParsePattern reads a pattern in a small subset of Cypher syntax:
+//
+//	(a:Person {name: "Ada"})-[r:WORKS_AT|FOUNDED]->(b:Company)<-[:INVESTED_IN]-(c)
+//
+// Relationships are written -[...]-> (out), <-[...]- (in) or -[...]-
+// (either way); the brackets may be left out, as in (a)-->(b). Property
+// values are strings, numbers, true, false or null.
+func ParsePattern(src string) (*Pattern, error) {
+	p := &patternParser{src: src}
+	pat, err := p.parse()
+	if err != nil {
+		return nil, fmt.Errorf("parse pattern at offset %d: %w", p.pos, err)
+	}
+	return pat, nil
+}
+
+// Match returns the variable bindings for each way pattern matches the
+// graph, up to limit (0 for no limit). Named node variables bind *Node
+// values and named relationship variables *Edge values; a variable used
+// twice must bind the same node. Each edge is used at most once per match.
+func (g *GraphStore) Match(_ context.Context, pattern *Pattern, limit int) ([]map[string]interface{}, error) {
+	if pattern == nil || len(pattern.Nodes) == 0 {
+		return nil, fmt.Errorf("graph match: empty pattern")
+	}
+	if len(pattern.Rels) > MaxPatternHops {
+		return nil, fmt.Errorf("graph match: %w: %d relationships, at most %d", ErrPatternTooComplex, len(pattern.Rels), MaxPatternHops)
+	}
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	var starts []*Node
+	if id, ok := pattern.Nodes[0].Properties["id"].(string); ok {
+		if n := g.nodes[id]; n != nil {
+			starts = append(starts, n)
+		}
+	} else {
+		for _, n := range g.nodes {
+			starts = append(starts, n)
+		}
+		sort.Slice(starts, func(i, j int) bool { return starts[i].ID < starts[j].ID })
+	}
+
+	m := &matcher{g: g, pattern: pattern, limit: limit, nodes: map[string]*Node{}, edges: map[string]*Edge{}, used: map[string]bool{}}
+	for _, n := range starts {
+		if err := m.bindNode(0, n); err != nil {
+			return nil, fmt.Errorf("graph match: %w", err)
+		}
+		if m.full() {
+			break
+		}
+	}
+	if m.out == nil {
+		m.out = []map[string]interface{}{}
+	}
+	return m.out, nil
+}
+
+type matcher struct {
+	g       *GraphStore
+	pattern *Pattern
+	limit   int
+	nodes   map[string]*Node
+	edges   map[string]*Edge
+	used    map[string]bool
+	out     []map[string]interface{}
+	steps   int
+}
+
+func (m *matcher) full() bool { return m.limit > 0 && len(m.out) >= m.limit }
+
+// bindNode tries n for node pattern i and, if it fits, continues along the
+// chain.
+func (m *matcher) bindNode(i int, n *Node) error {
+	if err := m.step(1); err != nil {
+		return err
+	}
+	np := m.pattern.Nodes[i]
+	if !nodeMatches(np, n) {
+		return nil
+	}
+	if np.Var != "" {
+		if bound, ok := m.nodes[np.Var]; ok {
+			if bound.ID != n.ID {
+				return nil
+			}
+		} else {
+			m.nodes[np.Var] = n
+			defer delete(m.nodes, np.Var)
+		}
+	}
+	if i == len(m.pattern.Rels) {
+		m.emit()
+		return nil
+	}
+	rp := m.pattern.Rels[i]
+	steps, err := m.g.steps(n.ID, rp.Direction, rp.Types)
+	if err != nil {
+		return err
+	}
+	if err := m.step(len(steps)); err != nil {
+		return err
+	}
+	for _, s := range steps {
+		if m.full() {
+			return nil
+		}
+		key := s.Edge.key()
+		if m.used[key] || !propsMatch(rp.Properties, s.Edge.Properties) {
+			continue
+		}
+		if rp.Var != "" {
+			if bound, ok := m.edges[rp.Var]; ok && bound.key() != key {
+				continue
+			}
+		}
+		m.used[key] = true
+		_, rebound := m.edges[rp.Var]
+		if rp.Var != "" && !rebound {
+			m.edges[rp.Var] = s.Edge
+		}
+		err := m.bindNode(i+1, s.Node)
+		if rp.Var != "" && !rebound {
+			delete(m.edges, rp.Var)
+		}
+		delete(m.used, key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// step counts n more nodes or edges looked at, failing past
+// MaxPatternSteps.
+func (m *matcher) step(n int) error {
+	if m.steps += n; m.steps > MaxPatternSteps {
+		return fmt.Errorf("%w: needs more than %d steps", ErrPatternTooComplex, MaxPatternSteps)
+	}
+	return nil
+}
+
+func (m *matcher) emit() {
+	row := make(map[string]interface{}, len(m.nodes)+len(m.edges))
+	for name, n := range m.nodes {
+		row[name] = n
+	}
+	for name, e := range m.edges {
+		row[name] = e
+	}
+	m.out = append(m.out, row)
+}
+
+func nodeMatches(np NodePattern, n *Node) bool {
+	for _, label := range np.Labels {
+		found := false
+		for _, have := range n.Labels {
+			if have == label {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	for k, want := range np.Properties {
+		if k == "id" {
+			if id, ok := want.(string); !ok || id != n.ID {
+				return false
+			}
+			continue
+		}
+		if have, ok := n.Properties[k]; !ok || !propEqual(have, want) {
+			return false
+		}
+	}
+	return true
+}
+
+func propsMatch(want, have map[string]interface{}) bool {
+	for k, v := range want {
+		if h, ok := have[k]; !ok || !propEqual(h, v) {
+			return false
+		}
+	}
+	return true
+}
+
+func propEqual(have, want interface{}) bool {
+	if want == nil {
+		return have == nil
+	}
+	return equal(have, want)
+}
+
+type patternParser struct {
+	src string
+	pos int
+}
+
+func (p *patternParser) parse() (*Pattern, error) {
+	pat := &Pattern{}
+	node, err := p.node()
+	if err != nil {
+		return nil, err
+	}
+	pat.Nodes = append(pat.Nodes, node)
+	for {
+		p.space()
+		if p.pos == len(p.src) {
+			return pat, nil
+		}
+		rel, err := p.rel()
+		if err != nil {
+			return nil, err
+		}
+		node, err := p.node()
+		if err != nil {
+			return nil, err
+		}
+		pat.Rels = append(pat.Rels, rel)
+		pat.Nodes = append(pat.Nodes, node)
+	}
+}
+
+func (p *patternParser) node() (NodePattern, error) {
+	var np NodePattern
+	if err := p.expect("("); err != nil {
+		return np, err
+	}
+	np.Var = p.ident()
+	for p.accept(":") {
+		label := p.ident()
+		if label == "" {
+			return np, fmt.Errorf("expected label")
+		}
+		np.Labels = append(np.Labels, label)
+	}
+	props, err := p.props()
+	if err != nil {
+		return np, err
+	}
+	np.Properties = props
+	return np, p.expect(")")
+}
+
+func (p *patternParser) rel() (RelPattern, error) {
+	rp := RelPattern{Direction: DirectionBoth}
+	incoming := p.accept("<")
+	if err := p.expect("-"); err != nil {
+		return rp, err
+	}
+	if p.accept("[") {
+		rp.Var = p.ident()
+		if p.accept(":") {
+			for {
+				typ := p.ident()
+				if typ == "" {
+					return rp, fmt.Errorf("expected relationship type")
+				}
+				rp.Types = append(rp.Types, typ)
+				if !p.accept("|") {
+					break
+				}
+				p.accept(":")
+			}
+		}
+		props, err := p.props()
+		if err != nil {
+			return rp, err
+		}
+		rp.Properties = props
+		if err := p.expect("]"); err != nil {
+			return rp, err
+		}
+	}
+	if err := p.expect("-"); err != nil {
+		return rp, err
+	}
+	outgoing := p.accept(">")
+	switch {
+	case incoming && outgoing:
+		return rp, fmt.Errorf("relationship cannot point both ways")
+	case incoming:
+		rp.Direction = DirectionIn
+	case outgoing:
+		rp.Direction = DirectionOut
+	}
+	return rp, nil
+}
+
+func (p *patternParser) props() (map[string]interface{}, error) {
+	if !p.accept("{") {
+		return nil, nil
+	}
+	props := map[string]interface{}{}
+	if p.accept("}") {
+		return props, nil
+	}
+	for {
+		key := p.ident()
+		if key == "" {
+			return nil, fmt.Errorf("expected property name")
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		props[key] = v
+		if p.accept("}") {
+			return props, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *patternParser) value() (interface{}, error) {
+	p.space()
+	if p.pos == len(p.src) {
+		return nil, fmt.Errorf("expected value")
+	}
+	switch quote := p.src[p.pos]; quote {
+	case '"', '\'':
+		var b strings.Builder
+		for i := p.pos + 1; i < len(p.src); i++ {
+			switch c := p.src[i]; {
+			case c == '\\' && i+1 < len(p.src):
+				i++
+				b.WriteByte(p.src[i])
+			case c == quote:
+				p.pos = i + 1
+				return b.String(), nil
+			default:
+				b.WriteByte(c)
+			}
+		}
+		return nil, fmt.Errorf("unterminated string")
+	}
+	start := p.pos
+	for p.pos < len(p.src) && strings.IndexByte("+-.eE0123456789", p.src[p.pos]) >= 0 {
+		p.pos++
+	}
+	if p.pos > start {
+		f, err := strconv.ParseFloat(p.src[start:p.pos], 64)
+		if err != nil {
+			p.pos = start
+			return nil, fmt.Errorf("invalid number")
+		}
+		return f, nil
+	}
+	switch word := p.ident(); word {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null":
+		return nil, nil
+	default:
+		p.pos = start
+		return nil, fmt.Errorf("expected value")
+	}
+}
+
+// ident reads a run of letters, digits and underscores, or a backquoted
+// name.
+func (p *patternParser) ident() string {
+	p.space()
+	if p.pos < len(p.src) && p.src[p.pos] == '`' {
+		if end := strings.IndexByte(p.src[p.pos+1:], '`'); end >= 0 {
+			name := p.src[p.pos+1 : p.pos+1+end]
+			p.pos += end + 2
+			return name
+		}
+	}
+	start := p.pos
+	for p.pos < len(p.src) {
+		r := rune(p.src[p.pos])
+		if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			break
+		}
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+func (p *patternParser) accept(tok string) bool {
+	p.space()
+	if strings.HasPrefix(p.src[p.pos:], tok) {
+		p.pos += len(tok)
+		return true
+	}
+	return false
+}
+
+func (p *patternParser) expect(tok string) error {
+	if !p.accept(tok) {
+		return fmt.Errorf("expected %q", tok)
+	}
+	return nil
+}
+
+func (p *patternParser) space() {
+	for p.pos < len(p.src) && unicode.IsSpace(rune(p.src[p.pos])) {
+		p.pos++
+	}
+}
@@ -0,0 +1,228 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"spawn.dev/pkg/capability"
+)
+
+// DefaultNamespace is the namespace used when a request names none.
+const DefaultNamespace = "default"
+
+// sharedScope prefixes the scopes of shared namespaces. Agent names cannot
+// start with an underscore, so it never collides with an agent's scope.
+const sharedScope = "_shared"
+
+// MaxNamespaces caps the namespaces an agent's requests may create,
+// including its episodes namespace.
+const MaxNamespaces = 64
+
+// ErrTooManyNamespaces is returned for a request naming a new namespace
+// when its agent already has MaxNamespaces.
+var ErrTooManyNamespaces = errors.New("too many namespaces")
+
+// expirySweepInterval is how often expired entries are deleted.
+const expirySweepInterval = time.Minute
+
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,127}$`)
+
+// scope holds the stores of one agent namespace, or of a shared one.
+type scope struct {
+	name   string
+	kv     *KVStore
+	vector *VectorStore
+	graph  *GraphStore
+}
+
+// scopeName returns the scope of a request: "<agent>/<namespace>", or
+// "_shared/<namespace>" for namespaces the agent shares. Requests must name
+// their agent in Context.AgentID, the identity episodes are kept under too.
+func (c *Capability) scopeName(req *capability.Request) (string, error) {
+	if req.Context == nil || req.Context.AgentID == "" {
+		return "", fmt.Errorf("request has no agent id")
+	}
+	agent := req.Context.AgentID
+	ns, _ := req.Params["namespace"].(string)
+	if ns == "" {
+		ns = DefaultNamespace
+	}
+	if !validName.MatchString(ns) {
+		return "", fmt.Errorf("invalid namespace %q", ns)
+	}
+	if c.settingsFor(agent).shared[ns] {
+		return sharedScope + "/" + ns, nil
+	}
+	if !validName.MatchString(agent) {
+		return "", fmt.Errorf("invalid agent id %q", agent)
+	}
+	return agent + "/" + ns, nil
+}
+
+// scope returns the stores of a scope, opening them on first use.
+func (c *Capability) scope(name string) (*scope, error) {
+	return c.openScope(name, false)
+}
+
+// requestScope is scope for agent requests, which may not open a new
+// namespace once the agent has MaxNamespaces: each open scope keeps its
+// vector index file open until Shutdown.
+func (c *Capability) requestScope(name string) (*scope, error) {
+	return c.openScope(name, true)
+}
+
+func (c *Capability) openScope(name string, limit bool) (*scope, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if sc := c.scopes[name]; sc != nil {
+		return sc, nil
+	}
+	agent, ns, _ := strings.Cut(name, "/")
+	if limit && agent != sharedScope {
+		if err := c.checkNamespaceLimit(agent, ns); err != nil {
+			return nil, err
+		}
+	}
+	kv, err := c.kv.Scope(name)
+	if err != nil {
+		return nil, err
+	}
+	graph, err := OpenGraphStore(c.kv.db, name)
+	if err != nil {
+		return nil, err
+	}
+	dir := ""
+	if c.vectorDir != "" {
+		dir = filepath.Join(c.vectorDir, filepath.FromSlash(name))
+	}
+	// Agent scopes take their agent's vector options and shared scopes the
+	// defaults, whichever agent opens them.
+	vector, err := OpenVectorStore(dir, c.settingsLocked(agent).vector)
+	if err != nil {
+		return nil, fmt.Errorf("open scope %s: %w", name, err)
+	}
+	sc := &scope{name: name, kv: kv, vector: vector, graph: graph}
+	c.scopes[name] = sc
+	return sc, nil
+}
+
+// checkNamespaceLimit fails with ErrTooManyNamespaces when ns would be
+// more than MaxNamespaces for agent, counting stored and open namespaces.
+// c.mu must be held.
+func (c *Capability) checkNamespaceLimit(agent, ns string) error {
+	stored, err := c.Namespaces(agent)
+	if err != nil {
+		return err
+	}
+	seen := map[string]bool{}
+	for _, name := range stored {
+		seen[name] = true
+	}
+	for name := range c.scopes {
+		if open, ok := strings.CutPrefix(name, agent+"/"); ok {
+			seen[open] = true
+		}
+	}
+	if !seen[ns] && len(seen) >= MaxNamespaces {
+		return fmt.Errorf("%w: agent %s already has %d", ErrTooManyNamespaces, agent, len(seen))
+	}
+	return nil
+}
+
+// ttlParam reads the ttl param, a duration such as "24h" or a number of
+// seconds, defaulting to def, the agent's TTL. Zero means no expiry.
+func ttlParam(params map[string]interface{}, def time.Duration) (time.Duration, error) {
+	switch v := params["ttl"].(type) {
+	case nil:
+		return def, nil
+	case string:
+		return ParseTTL(v)
+	default:
+		secs, ok := toFloat(v)
+		if !ok {
+			return 0, fmt.Errorf("ttl must be a duration or a number of seconds")
+		}
+		return time.Duration(secs * float64(time.Second)), nil
+	}
+}
+
+// ParseTTL reads a TTL written as a Go duration ("90m", "24h") or a whole
+// number of days ("7d"). Zero means no expiry.
+func ParseTTL(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid ttl %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid ttl %q", s)
+	}
+	return d, nil
+}
+
+// expire deletes the entries whose TTL passed before now.
+func (c *Capability) expire(ctx context.Context, now time.Time) error {
+	for {
+		due, err := dueExpiries(c.kv.db, now, 256)
+		if err != nil || len(due) == 0 {
+			return err
+		}
+		var errs []error
+		for _, e := range due {
+			sc, err := c.scope(e.scope)
+			if err != nil {
+				return err
+			}
+			switch e.kind {
+			case kindKV:
+				err = sc.kv.Delete(ctx, e.key)
+			case kindVector:
+				err = sc.vector.Delete(ctx, e.key)
+			case kindNode:
+				err = sc.graph.DeleteNode(ctx, e.key)
+			}
+			if err == nil && e.kind != kindKV {
+				err = sc.kv.setExpiry(e.kind, e.key, time.Time{})
+			}
+			errs = append(errs, err)
+		}
+		if err := errors.Join(errs...); err != nil {
+			return fmt.Errorf("expire memory: %w", err)
+		}
+	}
+}
+
+// sweep runs expire until stop is closed.
+func (c *Capability) sweep(stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(expirySweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			_ = c.expire(context.Background(), now)
+		}
+	}
+}
+
+func (c *Capability) closeScopes() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var errs []error
+	for name, sc := range c.scopes {
+		errs = append(errs, sc.vector.Close())
+		delete(c.scopes, name)
+	}
+	return errors.Join(errs...)
+}
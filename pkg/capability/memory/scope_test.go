@@ -0,0 +1,254 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.etcd.io/bbolt"
+
+	"spawn.dev/pkg/capability"
+)
+
+func TestMemoryIsolation(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	c, err := New(filepath.Join(dir, "kv.db"))
+	if err != nil {
+		t.Fatalf("new memory: %v", err)
+	}
+	c.SetVectorStorage(filepath.Join(dir, "vectors"), VectorOptions{})
+	c.SetSharedNamespaces("team")
+
+	put := func(agent, ns, value string) {
+		t.Helper()
+		for action, params := range map[string]map[string]interface{}{
+			"kv_set":         {"key": "k", "value": value},
+			"vector_put":     {"key": value, "vector": []interface{}{1.0, 0.0}},
+			"graph_put_node": {"id": value},
+		} {
+			params["namespace"] = ns
+			if resp := executeAs(t, c, agent, action, params); !resp.Success {
+				t.Fatalf("%s as %s/%s: %+v", action, agent, ns, resp.Error)
+			}
+		}
+	}
+	put("alice", "", "alice-default")
+	put("alice", "notes", "alice-notes")
+	put("bob", "", "bob-default")
+	put("alice", "team", "alice-team")
+
+	kvGet := func(agent, ns string) interface{} {
+		t.Helper()
+		return executeAs(t, c, agent, "kv_get", map[string]interface{}{"key": "k", "namespace": ns}).Data
+	}
+	cases := []struct{ agent, ns, want string }{
+		{"alice", "", "alice-default"},
+		{"alice", "notes", "alice-notes"},
+		{"bob", "", "bob-default"},
+		{"bob", "notes", ""},
+		{"bob", "team", "alice-team"},
+	}
+	for _, tc := range cases {
+		if got := kvGet(tc.agent, tc.ns); got != tc.want {
+			t.Fatalf("kv %s/%s = %q, want %q", tc.agent, tc.ns, got, tc.want)
+		}
+		if tc.want == "" {
+			continue
+		}
+		resp := executeAs(t, c, tc.agent, "vector_search", map[string]interface{}{"vector": []interface{}{1.0, 0.0}, "namespace": tc.ns})
+		if matches := resp.Data; !resp.Success || len(matches.([]capability.VectorMatch)) != 1 || matches.([]capability.VectorMatch)[0].Key != tc.want {
+			t.Fatalf("vector %s/%s = %+v", tc.agent, tc.ns, resp)
+		}
+		resp = executeAs(t, c, tc.agent, "graph_get_node", map[string]interface{}{"id": tc.want, "namespace": tc.ns})
+		if !resp.Success {
+			t.Fatalf("graph %s/%s: %+v", tc.agent, tc.ns, resp.Error)
+		}
+	}
+	if resp := executeAs(t, c, "bob", "graph_get_node", map[string]interface{}{"id": "alice-default"}); resp.Success {
+		t.Fatalf("bob read alice's graph")
+	}
+	if resp := executeAs(t, c, "bob", "kv_get", map[string]interface{}{"key": "k", "namespace": "../x"}); resp.Error == nil || resp.Error.Code != "invalid_scope" {
+		t.Fatalf("bad namespace = %+v", resp)
+	}
+	if resp := executeAs(t, c, "", "kv_get", map[string]interface{}{"key": "k"}); resp.Error == nil || resp.Error.Code != "invalid_scope" {
+		t.Fatalf("request without an agent = %+v", resp)
+	}
+
+	// Scoped vectors persist in their own directories.
+	if err := c.Shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	c, err = New(filepath.Join(dir, "kv.db"))
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer c.Shutdown(context.Background())
+	c.SetVectorStorage(filepath.Join(dir, "vectors"), VectorOptions{})
+	if n := testScope(t, c, "alice/notes").vector.Len(); n != 1 {
+		t.Fatalf("reopened alice/notes has %d vectors", n)
+	}
+}
+
+func TestNamespaceLimit(t *testing.T) {
+	t.Parallel()
+	c := newTestMemory(t)
+	c.SetVectorStorage(t.TempDir(), VectorOptions{})
+	for i := 0; i < MaxNamespaces; i++ {
+		if resp := execute(t, c, "kv_get", map[string]interface{}{"key": "k", "namespace": fmt.Sprintf("ns%d", i)}); !resp.Success {
+			t.Fatalf("namespace %d: %+v", i, resp.Error)
+		}
+	}
+	if resp := execute(t, c, "kv_set", map[string]interface{}{"key": "k", "value": "v", "namespace": "one-too-many"}); resp.Success || resp.Error.Code != "namespace_limit" {
+		t.Fatalf("expected namespace_limit, got %+v", resp)
+	}
+	if resp := execute(t, c, "kv_set", map[string]interface{}{"key": "k", "value": "v", "namespace": "ns0"}); !resp.Success {
+		t.Fatalf("existing namespace refused: %+v", resp.Error)
+	}
+	if resp := executeAs(t, c, "other", "kv_set", map[string]interface{}{"key": "k", "value": "v", "namespace": "one-too-many"}); !resp.Success {
+		t.Fatalf("another agent was limited: %+v", resp.Error)
+	}
+}
+
+func TestMemoryTTL(t *testing.T) {
+	t.Parallel()
+	c := newTestMemory(t)
+	ctx := context.Background()
+	c.SetTTL(time.Hour)
+
+	execute(t, c, "kv_set", map[string]interface{}{"key": "short", "value": "v", "ttl": "1ms"})
+	execute(t, c, "kv_set", map[string]interface{}{"key": "default", "value": "v"})
+	execute(t, c, "kv_set", map[string]interface{}{"key": "forever", "value": "v", "ttl": 0})
+	execute(t, c, "vector_put", map[string]interface{}{"key": "vec", "vector": []interface{}{1.0}, "ttl": 0.001})
+	execute(t, c, "graph_put_node", map[string]interface{}{"id": "n", "ttl": "1ms"})
+	execute(t, c, "graph_put_edge", map[string]interface{}{"from": "n", "to": "m", "type": "T"})
+	time.Sleep(5 * time.Millisecond)
+
+	// Expired keys are hidden before the sweep.
+	if got := execute(t, c, "kv_get", map[string]interface{}{"key": "short"}).Data; got != "" {
+		t.Fatalf("expired key read as %q", got)
+	}
+	if err := c.expire(ctx, time.Now()); err != nil {
+		t.Fatalf("expire: %v", err)
+	}
+	sc := testScope(t, c, "default/default")
+	if sc.vector.Len() != 0 || sc.graph.GetNode(ctx, "n") != nil {
+		t.Fatalf("expired vector or node survived")
+	}
+	if out, _ := sc.graph.Neighbors(ctx, "m", DirectionIn, nil); len(out) != 0 {
+		t.Fatalf("expired node's edge survived")
+	}
+	if err := c.expire(ctx, time.Now().Add(2*time.Hour)); err != nil {
+		t.Fatalf("expire: %v", err)
+	}
+	pairs, err := sc.kv.List(ctx, "", "", 10)
+	if err != nil || len(pairs) != 1 || pairs[0].Key != "forever" {
+		t.Fatalf("after expiry = %+v, %v", pairs, err)
+	}
+}
+
+func TestKVListAndCAS(t *testing.T) {
+	t.Parallel()
+	c := newTestMemory(t)
+	for _, k := range []string{"task/1", "task/2", "task/3", "note/1"} {
+		execute(t, c, "kv_set", map[string]interface{}{"key": k, "value": k})
+	}
+	resp := execute(t, c, "kv_list", map[string]interface{}{"prefix": "task/", "limit": 2})
+	pairs := resp.Data.([]KVPair)
+	if len(pairs) != 2 || pairs[0].Key != "task/1" || pairs[1].Key != "task/2" {
+		t.Fatalf("list = %+v", pairs)
+	}
+	resp = execute(t, c, "kv_list", map[string]interface{}{"prefix": "task/", "after": "task/2"})
+	if pairs := resp.Data.([]KVPair); len(pairs) != 1 || pairs[0].Key != "task/3" {
+		t.Fatalf("next page = %+v", pairs)
+	}
+
+	if resp := execute(t, c, "kv_delete", map[string]interface{}{"key": "task/3"}); !resp.Success {
+		t.Fatalf("delete: %+v", resp.Error)
+	}
+	if got := execute(t, c, "kv_get", map[string]interface{}{"key": "task/3"}).Data; got != "" {
+		t.Fatalf("deleted key = %q", got)
+	}
+
+	if resp := execute(t, c, "kv_cas", map[string]interface{}{"key": "lock", "value": "a"}); !resp.Success {
+		t.Fatalf("cas create: %+v", resp.Error)
+	}
+	resp = execute(t, c, "kv_cas", map[string]interface{}{"key": "lock", "value": "b"})
+	if resp.Success || resp.Error.Code != "cas_conflict" || resp.Data.(map[string]interface{})["value"] != "a" {
+		t.Fatalf("cas on existing key = %+v", resp)
+	}
+	if resp := execute(t, c, "kv_cas", map[string]interface{}{"key": "lock", "expected": "a", "value": "b"}); !resp.Success {
+		t.Fatalf("cas swap: %+v", resp.Error)
+	}
+	if resp := execute(t, c, "kv_cas", map[string]interface{}{"key": "lock", "expected": "a", "value": "c"}); resp.Success {
+		t.Fatalf("stale cas succeeded")
+	}
+}
+
+func TestMemoryConfigurePerAgent(t *testing.T) {
+	t.Parallel()
+	c := newTestMemory(t)
+	c.Configure("alice", Settings{Graph: true, Shared: []string{"team"}, TTL: time.Millisecond})
+	c.Configure("bob", Settings{})
+
+	node := map[string]interface{}{"id": "n"}
+	if resp := executeAs(t, c, "alice", "graph_put_node", node); !resp.Success {
+		t.Fatalf("graph as alice: %+v", resp.Error)
+	}
+	if resp := executeAs(t, c, "bob", "graph_put_node", node); resp.Success || resp.Error.Code != "graph_disabled" {
+		t.Fatalf("graph as bob = %+v", resp)
+	}
+
+	executeAs(t, c, "alice", "kv_set", map[string]interface{}{"key": "k", "value": "alice", "namespace": "team", "ttl": 0})
+	executeAs(t, c, "bob", "kv_set", map[string]interface{}{"key": "k", "value": "bob", "namespace": "team"})
+	executeAs(t, c, "alice", "kv_set", map[string]interface{}{"key": "short", "value": "v"})
+	executeAs(t, c, "bob", "kv_set", map[string]interface{}{"key": "long", "value": "v"})
+	time.Sleep(5 * time.Millisecond)
+	if got := executeAs(t, c, "alice", "kv_get", map[string]interface{}{"key": "k", "namespace": "team"}).Data; got != "alice" {
+		t.Fatalf("alice's shared namespace = %q", got)
+	}
+	if got := executeAs(t, c, "bob", "kv_get", map[string]interface{}{"key": "k", "namespace": "team"}).Data; got != "bob" {
+		t.Fatalf("bob's private team namespace = %q", got)
+	}
+	if got := executeAs(t, c, "alice", "kv_get", map[string]interface{}{"key": "short"}).Data; got != "" {
+		t.Fatalf("alice's ttl not applied: %q", got)
+	}
+	if got := executeAs(t, c, "bob", "kv_get", map[string]interface{}{"key": "long"}).Data; got != "v" {
+		t.Fatalf("alice's ttl applied to bob: %q", got)
+	}
+}
+
+func TestMemoryMigratesUnscopedKeys(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "kv.db")
+	db, err := bbolt.Open(path, 0o600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(kvBucket))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte("k"), []byte("old"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	c, err := New(path)
+	if err != nil {
+		t.Fatalf("new memory: %v", err)
+	}
+	defer c.Shutdown(context.Background())
+	c.Configure("alice", Settings{Shared: []string{LegacyNamespace}})
+	get := map[string]interface{}{"key": "k", "namespace": LegacyNamespace}
+	if got := executeAs(t, c, "alice", "kv_get", get).Data; got != "old" {
+		t.Fatalf("migrated key = %q", got)
+	}
+	if got := executeAs(t, c, "bob", "kv_get", get).Data; got != "" {
+		t.Fatalf("legacy namespace read without sharing it = %q", got)
+	}
+}
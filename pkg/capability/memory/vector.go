@@ -2,72 +2,662 @@ package memory
 
 import (
 	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"math"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
 	"sort"
+	"strconv"
+	"sync"
+
+	"go.etcd.io/bbolt"
+
+	"spawn.dev/pkg/capability"
+)
+
+// Vector metrics.
+const (
+	MetricCosine = "cosine"
+	MetricDot    = "dot"
+	MetricL2     = "l2"
+)
+
+// vectorFile is the index file inside a vector store directory.
+const vectorFile = "vectors.db"
+
+var (
+	vectorMetaBucket    = []byte("meta")
+	vectorDataBucket    = []byte("vectors")
+	vectorNodesBucket   = []byte("nodes")
+	vectorRecordsBucket = []byte("records")
 )
 
-// Put inserts a vector by key.
-func (s *VectorStore) Put(_ context.Context, key string, vec []float32) error {
+// exactSearchLimit is the number of filter matches below which a filtered
+// search scans them exactly instead of walking the graph.
+const exactSearchLimit = 2048
+
+// ErrDimensionMismatch is returned for vectors whose length differs from
+// the store's dimensions.
+var ErrDimensionMismatch = errors.New("vector dimension mismatch")
+
+// VectorRecord is a vector with the document it was made from.
+type VectorRecord struct {
+	Key      string                 `json:"key"`
+	Vector   []float32              `json:"vector"`
+	Text     string                 `json:"text,omitempty"`
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// MaxSearchLimit caps the results one search returns.
+const MaxSearchLimit = 1000
+
+// window defaults a search page's limit to 5, caps it at MaxSearchLimit and
+// rejects offsets whose page would run past the largest int.
+func window(limit, offset int) (int, int, error) {
+	if limit <= 0 {
+		limit = 5
+	}
+	limit = min(limit, MaxSearchLimit)
+	offset = max(offset, 0)
+	if offset > math.MaxInt-limit {
+		return 0, 0, fmt.Errorf("offset %d is too large", offset)
+	}
+	return limit, offset, nil
+}
+
+// VectorQuery is a search for the records nearest to Vector. Results are
+// paged by Offset and Limit (default 5, at most MaxSearchLimit) and
+// restricted by Filter.
+type VectorQuery struct {
+	Vector []float32
+	Limit  int
+	Offset int
+	Filter Filter
+}
+
+// VectorOptions configures a vector store.
+type VectorOptions struct {
+	// Dimensions every vector must have. 0 takes them from the first
+	// vector stored.
+	Dimensions int
+	// Metric is cosine, dot or l2 ("euclidean" is accepted). Empty keeps
+	// the metric of an existing store and makes new stores cosine.
+	Metric string
+	// MaxLinks, EfConstruction and EfSearch tune the HNSW index: more
+	// links and larger beams improve recall at the cost of memory and
+	// speed. Defaults are 16, 200 and 64.
+	MaxLinks       int
+	EfConstruction int
+	EfSearch       int
+}
+
+// ParseMetric normalises a metric name.
+func ParseMetric(metric string) (string, error) {
+	switch metric {
+	case "", MetricCosine:
+		return MetricCosine, nil
+	case MetricDot:
+		return MetricDot, nil
+	case MetricL2, "euclidean":
+		return MetricL2, nil
+	default:
+		return "", fmt.Errorf("unknown vector metric %q: want cosine, dot or l2", metric)
+	}
+}
+
+// VectorStore is an approximate nearest neighbour index (HNSW) over keyed
+// vectors, optionally persisted to disk.
+type VectorStore struct {
+	mu       sync.RWMutex
+	opts     VectorOptions
+	graph    *graph
+	nextID   uint32
+	db       *bbolt.DB
+	efSearch int
+	keywords *keywordIndex
+	// exactLimit is exactSearchLimit, lowered in tests.
+	exactLimit int
+}
+
+// NewVectorStore returns an in-memory cosine vector store.
+func NewVectorStore() *VectorStore {
+	s, _ := OpenVectorStore("", VectorOptions{})
+	return s
+}
+
+// OpenVectorStore opens or creates a persistent vector store in dir,
+// normally a per-agent directory under storage.vector.path. An empty dir
+// keeps the store in memory. Dimensions and metric, when set, must match
+// those the store was created with.
+func OpenVectorStore(dir string, opts VectorOptions) (*VectorStore, error) {
+	anyMetric := opts.Metric == ""
+	metric, err := ParseMetric(opts.Metric)
+	if err != nil {
+		return nil, err
+	}
+	opts.Metric = metric
+	if opts.Dimensions < 0 {
+		return nil, fmt.Errorf("vector dimensions must not be negative")
+	}
+	if opts.MaxLinks <= 1 {
+		opts.MaxLinks = defaultMaxLinks
+	}
+	if opts.EfConstruction <= 0 {
+		opts.EfConstruction = defaultEfConstruction
+	}
+	if opts.EfSearch <= 0 {
+		opts.EfSearch = defaultEfSearch
+	}
+	s := &VectorStore{
+		opts:     opts,
+		graph:    newGraph(opts.Metric, opts.MaxLinks, opts.EfConstruction, rand.New(rand.NewPCG(1, 2)).Float64),
+		efSearch: opts.EfSearch,
+		keywords: newKeywordIndex(),
+
+		exactLimit: exactSearchLimit,
+	}
+	if dir == "" {
+		return s, nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("mkdir vector dir: %w", err)
+	}
+	db, err := bbolt.Open(filepath.Join(dir, vectorFile), 0o600, nil)
+	if err != nil {
+		return nil, fmt.Errorf("open vector db: %w", err)
+	}
+	s.db = db
+	if err := s.load(anyMetric); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Dimensions returns the vector length, or 0 before the first vector.
+func (s *VectorStore) Dimensions() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.opts.Dimensions
+}
+
+// Len returns the number of stored vectors.
+func (s *VectorStore) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.graph.len()
+}
+
+// Put inserts or replaces the vector stored under key.
+func (s *VectorStore) Put(ctx context.Context, key string, vec []float32) error {
+	return s.PutRecord(ctx, VectorRecord{Key: key, Vector: vec})
+}
+
+// PutRecord inserts or replaces a record. Metadata must be JSON-encodable;
+// it is stored in its JSON form, so numbers are compared as float64.
+func (s *VectorStore) PutRecord(_ context.Context, rec VectorRecord) error {
+	if rec.Key == "" {
+		return fmt.Errorf("vector put: key is required")
+	}
+	meta, err := jsonMetadata(rec.Metadata)
+	if err != nil {
+		return fmt.Errorf("vector put: %w", err)
+	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	cp := make([]float32, len(vec))
-	copy(cp, vec)
-	s.data[key] = cp
-	return nil
+	if err := s.checkDimensions(rec.Vector); err != nil {
+		return fmt.Errorf("vector put: %w", err)
+	}
+	cp := make([]float32, len(rec.Vector))
+	copy(cp, rec.Vector)
+	if s.opts.Metric == MetricCosine && !normalize(cp) {
+		return fmt.Errorf("vector put: zero vector has no cosine direction")
+	}
+	newDims := s.opts.Dimensions == 0
+	if newDims {
+		s.opts.Dimensions = len(cp)
+	}
+
+	var removed []uint32
+	var dirty []uint32
+	if old, ok := s.graph.keys[rec.Key]; ok {
+		removed = append(removed, old)
+		s.keywords.remove(old, s.graph.nodes[old].text)
+		dirty = s.graph.remove(old)
+	}
+	n := &hnswNode{id: s.nextID, key: rec.Key, vec: cp, text: rec.Text, meta: meta}
+	s.nextID++
+	dirty = append(dirty, s.graph.insert(n)...)
+	s.keywords.add(n.id, n.text)
+	dirty = append(dirty, n.id)
+	return s.persist(dirty, removed, newDims)
 }
 
-// Search returns top keys ranked by cosine similarity.
-func (s *VectorStore) Search(_ context.Context, query []float32, limit int) ([]string, error) {
+// Delete removes key. Missing keys are not an error.
+func (s *VectorStore) Delete(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id, ok := s.graph.keys[key]
+	if !ok {
+		return nil
+	}
+	s.keywords.remove(id, s.graph.nodes[id].text)
+	return s.persist(s.graph.remove(id), []uint32{id}, false)
+}
+
+// Get returns the record stored under key. Cosine vectors are returned
+// normalised.
+func (s *VectorStore) Get(_ context.Context, key string) (*VectorRecord, bool) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	if limit <= 0 {
-		limit = 5
+	id, ok := s.graph.keys[key]
+	if !ok {
+		return nil, false
+	}
+	n := s.graph.nodes[id]
+	return &VectorRecord{Key: n.key, Vector: append([]float32(nil), n.vec...), Text: n.text, Metadata: n.meta}, true
+}
+
+// Records returns every stored record, ordered by key.
+func (s *VectorStore) Records() []VectorRecord {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]VectorRecord, 0, s.graph.len())
+	for _, id := range s.graph.keys {
+		n := s.graph.nodes[id]
+		out = append(out, VectorRecord{Key: n.key, Vector: append([]float32(nil), n.vec...), Text: n.text, Metadata: n.meta})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out
+}
+
+// snapshot runs fn in a read transaction on the store's file; in-memory
+// stores have none and are skipped.
+func (s *VectorStore) snapshot(fn func(*bbolt.Tx) error) error {
+	if s.db == nil {
+		return nil
+	}
+	return s.db.View(fn)
+}
+
+// Search returns up to limit (default 5) nearest records with their
+// scores: cosine similarity, dot product, or 1/(1+distance) for l2.
+func (s *VectorStore) Search(ctx context.Context, query []float32, limit int) ([]capability.VectorMatch, error) {
+	return s.Query(ctx, VectorQuery{Vector: query, Limit: limit})
+}
+
+// Query runs a filtered, paged search. Selective filters are answered by
+// an exact scan of the matching records; broad ones by widening the graph
+// search until a page of matches is found.
+func (s *VectorStore) Query(_ context.Context, query VectorQuery) ([]capability.VectorMatch, error) {
+	var err error
+	if query.Limit, query.Offset, err = window(query.Limit, query.Offset); err != nil {
+		return nil, fmt.Errorf("vector search: %w", err)
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.graph.len() == 0 {
+		return []capability.VectorMatch{}, nil
+	}
+	q, err := s.prepare(query.Vector)
+	if err != nil {
+		return nil, fmt.Errorf("vector search: %w", err)
+	}
+	found := s.nearest(q, query.Offset+query.Limit, query.Filter)
+	out := make([]capability.VectorMatch, len(found))
+	for i, c := range found {
+		out[i] = s.match(c.id, s.graph.score(c.dist))
+	}
+	return page(out, query.Offset, query.Limit), nil
+}
+
+// prepare checks a query vector's dimensions and normalises it for cosine.
+func (s *VectorStore) prepare(vec []float32) ([]float32, error) {
+	if len(vec) != s.opts.Dimensions {
+		return nil, fmt.Errorf("%w: got %d, want %d", ErrDimensionMismatch, len(vec), s.opts.Dimensions)
+	}
+	if s.opts.Metric != MetricCosine {
+		return vec, nil
 	}
-	type scored struct {
-		key   string
-		score float64
+	q := append([]float32(nil), vec...)
+	if !normalize(q) {
+		return nil, fmt.Errorf("zero vector has no cosine direction")
 	}
-	scores := make([]scored, 0, len(s.data))
-	for key, vec := range s.data {
-		score := cosine(query, vec)
-		scores = append(scores, scored{key: key, score: score})
+	return q, nil
+}
+
+// nearest returns the need nearest nodes to q that pass filter.
+func (s *VectorStore) nearest(q []float32, need int, filter Filter) []candidate {
+	need = min(need, s.graph.len())
+	if len(filter) == 0 {
+		return s.graph.search(q, need, s.efSearch)
 	}
-	sort.Slice(scores, func(i, j int) bool {
-		if scores[i].score == scores[j].score {
-			return scores[i].key < scores[j].key
+	return s.filtered(q, need, filter)
+}
+
+func (s *VectorStore) match(id uint32, score float64) capability.VectorMatch {
+	n := s.graph.nodes[id]
+	return capability.VectorMatch{Key: n.key, Score: score, Text: n.text, Metadata: n.meta}
+}
+
+// page sorts matches by score, then key, and returns those in
+// [offset, offset+limit).
+func page(matches []capability.VectorMatch, offset, limit int) []capability.VectorMatch {
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].Score == matches[j].Score {
+			return matches[i].Key < matches[j].Key
 		}
-		return scores[i].score > scores[j].score
+		return matches[i].Score > matches[j].Score
 	})
-	if len(scores) > limit {
-		scores = scores[:limit]
+	if offset >= len(matches) {
+		return []capability.VectorMatch{}
+	}
+	matches = matches[offset:]
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches
+}
+
+func (s *VectorStore) filtered(q []float32, need int, filter Filter) []candidate {
+	keep := func(n *hnswNode) bool { return filter.Match(n.meta) }
+	matching := 0
+	for _, n := range s.graph.nodes {
+		if n != nil && keep(n) {
+			matching++
+		}
+	}
+	total := s.graph.len()
+	if matching == 0 {
+		return nil
+	}
+	if matching <= s.exactLimit || matching <= need*20 {
+		return s.graph.exact(q, need, keep)
+	}
+	// Expect a fraction matching/total of the graph's results to pass.
+	ef := max(s.efSearch, 2*need*total/matching)
+	for ef < total {
+		found := s.graph.search(q, ef, ef)
+		kept := found[:0]
+		for _, c := range found {
+			if keep(s.graph.nodes[c.id]) {
+				kept = append(kept, c)
+			}
+		}
+		if len(kept) >= need {
+			return kept[:need]
+		}
+		ef *= 2
+	}
+	return s.graph.exact(q, need, keep)
+}
+
+// Close closes the index file.
+func (s *VectorStore) Close() error {
+	if s == nil || s.db == nil {
+		return nil
+	}
+	return s.db.Close()
+}
+
+// checkVector reports why vec could not be stored beside vectors of dims
+// dimensions (0 for any) under the store's metric.
+func (s *VectorStore) checkVector(vec []float32, dims int) error {
+	if len(vec) == 0 {
+		return fmt.Errorf("empty vector")
+	}
+	if dims != 0 && len(vec) != dims {
+		return fmt.Errorf("%w: got %d, want %d", ErrDimensionMismatch, len(vec), dims)
+	}
+	if s.opts.Metric == MetricCosine && !normalize(append([]float32(nil), vec...)) {
+		return fmt.Errorf("zero vector has no cosine direction")
+	}
+	return nil
+}
+
+func (s *VectorStore) checkDimensions(vec []float32) error {
+	if len(vec) == 0 {
+		return fmt.Errorf("empty vector")
 	}
-	out := make([]string, 0, len(scores))
-	for _, item := range scores {
-		out = append(out, item.key)
+	if s.opts.Dimensions != 0 && len(vec) != s.opts.Dimensions {
+		return fmt.Errorf("%w: got %d, want %d", ErrDimensionMismatch, len(vec), s.opts.Dimensions)
+	}
+	return nil
+}
+
+// persist writes the changed nodes, drops removed ones and records the
+// dimensions once they are known.
+func (s *VectorStore) persist(dirty, removed []uint32, dims bool) error {
+	if s.db == nil {
+		return nil
+	}
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		data, nodes, records := tx.Bucket(vectorDataBucket), tx.Bucket(vectorNodesBucket), tx.Bucket(vectorRecordsBucket)
+		for _, id := range removed {
+			if err := data.Delete(idKey(id)); err != nil {
+				return err
+			}
+			if err := nodes.Delete(idKey(id)); err != nil {
+				return err
+			}
+			if err := records.Delete(idKey(id)); err != nil {
+				return err
+			}
+		}
+		for _, id := range dirty {
+			n := s.graph.node(id)
+			if n == nil {
+				continue
+			}
+			if err := nodes.Put(idKey(id), encodeNode(n)); err != nil {
+				return err
+			}
+			if data.Get(idKey(id)) != nil {
+				continue
+			}
+			// Vectors and records never change under an id, so they are
+			// written once.
+			if err := data.Put(idKey(id), encodeVector(n.vec)); err != nil {
+				return err
+			}
+			if n.text == "" && n.meta == nil {
+				continue
+			}
+			payload, err := json.Marshal(vectorPayload{Text: n.text, Metadata: n.meta})
+			if err != nil {
+				return err
+			}
+			if err := records.Put(idKey(id), payload); err != nil {
+				return err
+			}
+		}
+		if dims {
+			return tx.Bucket(vectorMetaBucket).Put([]byte("dimensions"), []byte(strconv.Itoa(s.opts.Dimensions)))
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("persist vectors: %w", err)
+	}
+	return nil
+}
+
+// load checks the stored options against s.opts and rebuilds the graph
+// from disk.
+func (s *VectorStore) load(anyMetric bool) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		for _, name := range [][]byte{vectorMetaBucket, vectorDataBucket, vectorNodesBucket, vectorRecordsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return fmt.Errorf("init vector bucket: %w", err)
+			}
+		}
+		meta := tx.Bucket(vectorMetaBucket)
+		if stored := meta.Get([]byte("metric")); stored == nil {
+			if err := meta.Put([]byte("metric"), []byte(s.opts.Metric)); err != nil {
+				return err
+			}
+		} else if string(stored) != s.opts.Metric {
+			if !anyMetric {
+				return fmt.Errorf("vector store uses metric %s, not %s", stored, s.opts.Metric)
+			}
+			metric, err := ParseMetric(string(stored))
+			if err != nil {
+				return fmt.Errorf("vector store: %w", err)
+			}
+			s.opts.Metric, s.graph.metric = metric, metric
+		}
+		if stored := meta.Get([]byte("dimensions")); stored != nil {
+			dims, err := strconv.Atoi(string(stored))
+			if err != nil {
+				return fmt.Errorf("vector store: corrupt dimensions %q", stored)
+			}
+			if s.opts.Dimensions != 0 && s.opts.Dimensions != dims {
+				return fmt.Errorf("vector store has %d dimensions, not %d", dims, s.opts.Dimensions)
+			}
+			s.opts.Dimensions = dims
+		} else if s.opts.Dimensions != 0 {
+			if err := meta.Put([]byte("dimensions"), []byte(strconv.Itoa(s.opts.Dimensions))); err != nil {
+				return err
+			}
+		}
+
+		g := s.graph
+		data, records := tx.Bucket(vectorDataBucket), tx.Bucket(vectorRecordsBucket)
+		err := tx.Bucket(vectorNodesBucket).ForEach(func(k, v []byte) error {
+			id := binary.BigEndian.Uint32(k)
+			n, err := decodeNode(id, v)
+			if err != nil {
+				return fmt.Errorf("vector node %d: %w", id, err)
+			}
+			if n.vec, err = decodeVector(data.Get(k), s.opts.Dimensions); err != nil {
+				return fmt.Errorf("vector node %d: %w", id, err)
+			}
+			if raw := records.Get(k); raw != nil {
+				var payload vectorPayload
+				if err := json.Unmarshal(raw, &payload); err != nil {
+					return fmt.Errorf("vector record %d: %w", id, err)
+				}
+				n.text, n.meta = payload.Text, payload.Metadata
+				s.keywords.add(id, n.text)
+			}
+			for int(id) >= len(g.nodes) {
+				g.nodes = append(g.nodes, nil)
+			}
+			g.nodes[id] = n
+			g.keys[n.key] = id
+			// Ids ascend, so the first node on the highest layer becomes
+			// the entry point, as it was when it was inserted.
+			if !g.hasEntry || n.level() > g.top {
+				g.entry, g.hasEntry, g.top = id, true, n.level()
+			}
+			s.nextID = id + 1
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("load vectors: %w", err)
+		}
+		return nil
+	})
+}
+
+// vectorPayload is the stored form of a record's text and metadata.
+type vectorPayload struct {
+	Text     string                 `json:"text,omitempty"`
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// jsonMetadata returns metadata as it reads back from JSON.
+func jsonMetadata(meta map[string]interface{}) (map[string]interface{}, error) {
+	if len(meta) == 0 {
+		return nil, nil
+	}
+	raw, err := json.Marshal(meta)
+	if err != nil {
+		return nil, fmt.Errorf("metadata: %w", err)
+	}
+	var out map[string]interface{}
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return nil, fmt.Errorf("metadata: %w", err)
 	}
 	return out, nil
 }
 
-func cosine(a, b []float32) float64 {
-	if len(a) == 0 || len(b) == 0 {
-		return 0
+func idKey(id uint32) []byte {
+	return binary.BigEndian.AppendUint32(nil, id)
+}
+
+// encodeNode stores a node's key and links: the key, the layer count, then
+// each layer's link count and ids, as uvarints.
+func encodeNode(n *hnswNode) []byte {
+	buf := binary.AppendUvarint(nil, uint64(len(n.key)))
+	buf = append(buf, n.key...)
+	buf = binary.AppendUvarint(buf, uint64(len(n.links)))
+	for _, links := range n.links {
+		buf = binary.AppendUvarint(buf, uint64(len(links)))
+		for _, id := range links {
+			buf = binary.AppendUvarint(buf, uint64(id))
+		}
+	}
+	return buf
+}
+
+func decodeNode(id uint32, buf []byte) (*hnswNode, error) {
+	corrupt := errors.New("corrupt node record")
+	next := func() (uint64, error) {
+		v, n := binary.Uvarint(buf)
+		if n <= 0 {
+			return 0, corrupt
+		}
+		buf = buf[n:]
+		return v, nil
 	}
-	max := len(a)
-	if len(b) < max {
-		max = len(b)
+	keyLen, err := next()
+	if err != nil || keyLen > uint64(len(buf)) {
+		return nil, corrupt
 	}
-	var dot float64
-	var magA float64
-	var magB float64
-	for i := 0; i < max; i++ {
-		av := float64(a[i])
-		bv := float64(b[i])
-		dot += av * bv
-		magA += av * av
-		magB += bv * bv
+	n := &hnswNode{id: id, key: string(buf[:keyLen])}
+	buf = buf[keyLen:]
+	layers, err := next()
+	if err != nil || layers == 0 || layers > maxLevel+1 {
+		return nil, corrupt
+	}
+	n.links = make([][]uint32, layers)
+	for l := range n.links {
+		count, err := next()
+		if err != nil || count > uint64(len(buf)) {
+			return nil, corrupt
+		}
+		n.links[l] = make([]uint32, count)
+		for i := range n.links[l] {
+			v, err := next()
+			if err != nil || v > math.MaxUint32 {
+				return nil, corrupt
+			}
+			n.links[l][i] = uint32(v)
+		}
+	}
+	return n, nil
+}
+
+func encodeVector(vec []float32) []byte {
+	buf := make([]byte, 0, 4*len(vec))
+	for _, v := range vec {
+		buf = binary.LittleEndian.AppendUint32(buf, math.Float32bits(v))
+	}
+	return buf
+}
+
+func decodeVector(buf []byte, dims int) ([]float32, error) {
+	if len(buf) == 0 || len(buf)%4 != 0 || (dims != 0 && len(buf) != 4*dims) {
+		return nil, errors.New("corrupt vector")
 	}
-	if magA == 0 || magB == 0 {
-		return 0
+	vec := make([]float32, len(buf)/4)
+	for i := range vec {
+		vec[i] = math.Float32frombits(binary.LittleEndian.Uint32(buf[4*i:]))
 	}
-	return dot / (math.Sqrt(magA) * math.Sqrt(magB))
+	return vec, nil
 }
@@ -2,6 +2,9 @@ package memory
 
 import (
 	"context"
+	"errors"
+	"math/rand/v2"
+	"sort"
 	"testing"
 )
 
@@ -19,7 +22,263 @@ func TestVectorSearchCosineRanking(t *testing.T) {
 	if len(keys) != 2 {
 		t.Fatalf("expected 2 keys, got %d", len(keys))
 	}
-	if keys[0] != "a" {
-		t.Fatalf("expected top match to be a, got %s", keys[0])
+	if keys[0].Key != "a" {
+		t.Fatalf("expected top match to be a, got %s", keys[0].Key)
+	}
+	if keys[0].Score < 0.999 || keys[1].Key != "c" || keys[1].Score < 0.70 || keys[1].Score > 0.71 {
+		t.Fatalf("unexpected scores: %+v", keys)
+	}
+}
+
+func TestVectorMetricsAndDimensions(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	for _, tc := range []struct {
+		metric string
+		top    string
+		score  float64
+	}{
+		{MetricCosine, "unit", 1},
+		{MetricDot, "long", 10},
+		{"euclidean", "unit", 1},
+	} {
+		store, err := OpenVectorStore("", VectorOptions{Dimensions: 2, Metric: tc.metric})
+		if err != nil {
+			t.Fatalf("%s: open: %v", tc.metric, err)
+		}
+		_ = store.Put(ctx, "unit", []float32{1, 0})
+		_ = store.Put(ctx, "long", []float32{10, 1})
+		_ = store.Put(ctx, "near", []float32{1.1, 0})
+		matches, err := store.Search(ctx, []float32{1, 0}, 1)
+		if err != nil {
+			t.Fatalf("%s: search: %v", tc.metric, err)
+		}
+		if matches[0].Key != tc.top || matches[0].Score < tc.score-1e-6 || matches[0].Score > tc.score+1e-6 {
+			t.Fatalf("%s: got %+v, want %s scoring %v", tc.metric, matches[0], tc.top, tc.score)
+		}
+		if err := store.Put(ctx, "bad", []float32{1, 2, 3}); !errors.Is(err, ErrDimensionMismatch) {
+			t.Fatalf("%s: expected dimension mismatch, got %v", tc.metric, err)
+		}
+		if _, err := store.Search(ctx, []float32{1}, 1); !errors.Is(err, ErrDimensionMismatch) {
+			t.Fatalf("%s: expected dimension mismatch on search, got %v", tc.metric, err)
+		}
+	}
+	if _, err := OpenVectorStore("", VectorOptions{Metric: "manhattan"}); err == nil {
+		t.Fatalf("expected unknown metric error")
+	}
+}
+
+func TestVectorStorePersistsAndDeletes(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	dir := t.TempDir()
+	rng := rand.New(rand.NewPCG(7, 7))
+	vectors := make(map[string][]float32)
+	store, err := OpenVectorStore(dir, VectorOptions{Dimensions: 16, Metric: MetricL2})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	for i := 0; i < 300; i++ {
+		key := string(rune('a'+i%26)) + string(rune('a'+i/26))
+		vec := randomVector(rng, 16)
+		vectors[key] = vec
+		if err := store.Put(ctx, key, vec); err != nil {
+			t.Fatalf("put %s: %v", key, err)
+		}
+	}
+	for key := range vectors {
+		if key[1] == 'a' {
+			if err := store.Delete(ctx, key); err != nil {
+				t.Fatalf("delete %s: %v", key, err)
+			}
+			delete(vectors, key)
+		}
+	}
+	// Replacing a key moves it.
+	vectors["bb"] = randomVector(rng, 16)
+	if err := store.Put(ctx, "bb", vectors["bb"]); err != nil {
+		t.Fatalf("replace: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	if _, err := OpenVectorStore(dir, VectorOptions{Dimensions: 8, Metric: MetricL2}); err == nil {
+		t.Fatalf("expected reopen with other dimensions to fail")
+	}
+	if _, err := OpenVectorStore(dir, VectorOptions{Metric: MetricDot}); err == nil {
+		t.Fatalf("expected reopen with other metric to fail")
+	}
+	// Empty options keep the stored metric.
+	store, err = OpenVectorStore(dir, VectorOptions{})
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer store.Close()
+	if store.opts.Metric != MetricL2 {
+		t.Fatalf("reopened store uses %s, want l2", store.opts.Metric)
+	}
+	if store.Len() != len(vectors) || store.Dimensions() != 16 {
+		t.Fatalf("reopened store has %d vectors of %d dims, want %d of 16", store.Len(), store.Dimensions(), len(vectors))
+	}
+	matches, err := store.Search(ctx, vectors["bb"], 3)
+	if err != nil {
+		t.Fatalf("search: %v", err)
+	}
+	if matches[0].Key != "bb" || matches[0].Score != 1 {
+		t.Fatalf("expected exact match for bb, got %+v", matches)
+	}
+	for _, m := range matches {
+		if _, ok := vectors[m.Key]; !ok {
+			t.Fatalf("deleted key %s returned", m.Key)
+		}
+	}
+}
+
+func TestVectorRecall(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	const n, dims, k = 2000, 32, 10
+	rng := rand.New(rand.NewPCG(1, 1))
+	store := NewVectorStore()
+	vectors := make([][]float32, n)
+	for i := range vectors {
+		vectors[i] = randomVector(rng, dims)
+		_ = store.Put(ctx, keyOf(i), vectors[i])
+	}
+	hits, total := 0, 0
+	for q := 0; q < 50; q++ {
+		query := randomVector(rng, dims)
+		exact := make([]int, n)
+		for i := range exact {
+			exact[i] = i
+		}
+		sims := make([]float64, n)
+		for i := range sims {
+			sims[i] = cosineSim(query, vectors[i])
+		}
+		sort.Slice(exact, func(a, b int) bool { return sims[exact[a]] > sims[exact[b]] })
+		want := map[string]bool{}
+		for _, i := range exact[:k] {
+			want[keyOf(i)] = true
+		}
+		matches, err := store.Search(ctx, query, k)
+		if err != nil {
+			t.Fatalf("search: %v", err)
+		}
+		for _, m := range matches {
+			if want[m.Key] {
+				hits++
+			}
+		}
+		total += k
+	}
+	if recall := float64(hits) / float64(total); recall < 0.9 {
+		t.Fatalf("recall@%d = %.2f, want >= 0.9", k, recall)
+	}
+}
+
+func randomVector(rng *rand.Rand, dims int) []float32 {
+	vec := make([]float32, dims)
+	for i := range vec {
+		vec[i] = float32(rng.NormFloat64())
+	}
+	return vec
+}
+
+func cosineSim(a, b []float32) float64 {
+	a2, b2 := append([]float32(nil), a...), append([]float32(nil), b...)
+	normalize(a2)
+	normalize(b2)
+	return dot(a2, b2)
+}
+
+func keyOf(i int) string {
+	return "k" + string(rune('0'+i/1000)) + string(rune('0'+i/100%10)) + string(rune('0'+i/10%10)) + string(rune('0'+i%10))
+}
+
+func TestVectorFilteredSearch(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	dir := t.TempDir()
+	store, err := OpenVectorStore(dir, VectorOptions{Dimensions: 8})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	rng := rand.New(rand.NewPCG(3, 3))
+	langs := []string{"go", "python", "rust"}
+	for i := 0; i < 600; i++ {
+		err := store.PutRecord(ctx, VectorRecord{
+			Key:    keyOf(i),
+			Vector: randomVector(rng, 8),
+			Text:   "chunk " + keyOf(i),
+			Metadata: map[string]interface{}{
+				"lang": langs[i%3],
+				"year": 2000 + i%25,
+				"tags": []string{"t" + string(rune('0'+i%5)), "all"},
+			},
+		})
+		if err != nil {
+			t.Fatalf("put: %v", err)
+		}
+	}
+	store.Close()
+	store, err = OpenVectorStore(dir, VectorOptions{})
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer store.Close()
+
+	filter, err := ParseFilter(map[string]interface{}{
+		"lang": map[string]interface{}{"in": []interface{}{"go", "rust"}},
+		"year": map[string]interface{}{"gte": 2010, "lt": float64(2020)},
+		"tags": "t1",
+	})
+	if err != nil {
+		t.Fatalf("parse filter: %v", err)
+	}
+	query := randomVector(rng, 8)
+	all, err := store.Query(ctx, VectorQuery{Vector: query, Limit: 100, Filter: filter})
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	if len(all) == 0 {
+		t.Fatalf("no matches")
+	}
+	for _, m := range all {
+		lang, year := m.Metadata["lang"], m.Metadata["year"].(float64)
+		if lang == "python" || year < 2010 || year >= 2020 || m.Text != "chunk "+m.Key {
+			t.Fatalf("match violates filter: %+v", m)
+		}
+	}
+	page, err := store.Query(ctx, VectorQuery{Vector: query, Limit: 3, Offset: 2, Filter: filter})
+	if err != nil {
+		t.Fatalf("page: %v", err)
+	}
+	if len(page) != 3 || page[0].Key != all[2].Key || page[2].Key != all[4].Key {
+		t.Fatalf("page 2..5 = %+v, want keys of %+v", page, all[2:5])
+	}
+
+	// Broad filters search the graph and drop what does not match.
+	store.exactLimit = 0
+	goOnly, err := store.Query(ctx, VectorQuery{Vector: query, Limit: 10, Filter: Filter{{Field: "lang", Eq: "go"}}})
+	if err != nil {
+		t.Fatalf("graph query: %v", err)
+	}
+	if len(goOnly) != 10 {
+		t.Fatalf("graph query returned %d matches, want 10", len(goOnly))
+	}
+	for _, m := range goOnly {
+		if m.Metadata["lang"] != "go" {
+			t.Fatalf("graph match violates filter: %+v", m)
+		}
+	}
+
+	if _, err := ParseFilter(map[string]interface{}{"year": map[string]interface{}{"near": 1}}); err == nil {
+		t.Fatalf("expected unknown operator error")
+	}
+	rec, ok := store.Get(ctx, keyOf(7))
+	if !ok || rec.Metadata["lang"] != "python" || rec.Text != "chunk "+keyOf(7) {
+		t.Fatalf("unexpected record: %+v", rec)
 	}
 }
@@ -0,0 +1,538 @@
+package net
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Cache results reported in response data as "cache".
+const (
+	CacheHit         = "hit"
+	CacheMiss        = "miss"
+	CacheRevalidated = "revalidated"
+	CacheBypass      = "bypass"
+)
+
+// DefaultCacheSize is the per-agent cache budget when none is configured.
+const DefaultCacheSize = 64 << 20
+
+// CacheStore persists cache entries. Get returns nil, nil for missing keys.
+// *memory.KVStore satisfies it.
+type CacheStore interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	Set(ctx context.Context, key string, value []byte) error
+	Delete(ctx context.Context, key string) error
+}
+
+// DiskCacheStore keeps one file per cache entry in a directory.
+type DiskCacheStore struct {
+	dir string
+}
+
+// NewDiskCacheStore creates dir if needed and stores entries below it.
+func NewDiskCacheStore(dir string) (*DiskCacheStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("create cache dir: %w", err)
+	}
+	return &DiskCacheStore{dir: dir}, nil
+}
+
+func (s *DiskCacheStore) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:]))
+}
+
+// Get reads an entry.
+func (s *DiskCacheStore) Get(_ context.Context, key string) ([]byte, error) {
+	b, err := os.ReadFile(s.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return b, err
+}
+
+// Set writes an entry atomically.
+func (s *DiskCacheStore) Set(_ context.Context, key string, value []byte) error {
+	p := s.path(key)
+	if err := os.WriteFile(p+".tmp", value, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(p+".tmp", p)
+}
+
+// Delete removes an entry.
+func (s *DiskCacheStore) Delete(_ context.Context, key string) error {
+	if err := os.Remove(s.path(key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Cache is a private HTTP cache following RFC 9111 for GET requests:
+// responses are fresh for max-age, Expires or a heuristic based on
+// Last-Modified, and stale entries with an ETag or Last-Modified are
+// revalidated with a conditional request. Entries are evicted least recently
+// used first once the agent's size limit is exceeded.
+type Cache struct {
+	mu       sync.Mutex
+	store    CacheStore
+	agentID  string
+	prefix   string
+	maxBytes int64
+	index    map[string]*cacheIndexEntry
+	size     int64
+	metrics  *cacheMetrics
+	now      func() time.Time
+}
+
+type cacheIndexEntry struct {
+	Size int64     `json:"size"`
+	Used time.Time `json:"used"`
+}
+
+type cacheEntry struct {
+	URL        string            `json:"url"`
+	Status     int               `json:"status"`
+	Header     http.Header       `json:"header"`
+	Body       []byte            `json:"body"`
+	Stored     time.Time         `json:"stored"`
+	InitialAge time.Duration     `json:"initial_age"`
+	Vary       map[string]string `json:"vary,omitempty"`
+}
+
+type cacheContextKey int
+
+const (
+	forceRefreshKey cacheContextKey = iota
+	cacheResultKey
+)
+
+// NewCache returns the cache of agentID in store, limited to maxBytes.
+func NewCache(ctx context.Context, agentID string, store CacheStore, maxBytes int64) (*Cache, error) {
+	if maxBytes <= 0 {
+		maxBytes = DefaultCacheSize
+	}
+	c := &Cache{
+		store:    store,
+		agentID:  agentID,
+		prefix:   "net-cache/" + agentID + "/",
+		maxBytes: maxBytes,
+		index:    map[string]*cacheIndexEntry{},
+		now:      time.Now,
+	}
+	raw, err := store.Get(ctx, c.prefix+"index")
+	if err != nil {
+		return nil, fmt.Errorf("load cache index: %w", err)
+	}
+	if raw != nil {
+		if err := json.Unmarshal(raw, &c.index); err != nil {
+			return nil, fmt.Errorf("load cache index: %w", err)
+		}
+	}
+	for _, e := range c.index {
+		c.size += e.Size
+	}
+	return c, nil
+}
+
+// Size returns the bytes currently cached.
+func (c *Cache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+// RegisterMetrics exposes hit counts, store failures and cache size on reg.
+func (c *Cache) RegisterMetrics(reg prometheus.Registerer) error {
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "spawn_net_cache_requests_total",
+		Help: "Net cache lookups by result",
+	}, []string{"agent", "result"})
+	failures := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "spawn_net_cache_store_errors_total",
+		Help: "Responses served without being stored because the cache store failed",
+	}, []string{"agent"})
+	size := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "spawn_net_cache_bytes",
+		Help: "Bytes held in the net response cache",
+	}, []string{"agent"})
+	var err error
+	if requests, err = registerOrExisting(reg, requests); err != nil {
+		return err
+	}
+	if failures, err = registerOrExisting(reg, failures); err != nil {
+		return err
+	}
+	if size, err = registerOrExisting(reg, size); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.metrics = &cacheMetrics{requests: requests, failures: failures, size: size}
+	c.metrics.size.WithLabelValues(c.agentID).Set(float64(c.size))
+	return nil
+}
+
+type cacheMetrics struct {
+	requests *prometheus.CounterVec
+	failures *prometheus.CounterVec
+	size     *prometheus.GaugeVec
+}
+
+func (c *Cache) observe(ctx context.Context, result string) {
+	if p, ok := ctx.Value(cacheResultKey).(*string); ok {
+		*p = result
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.metrics != nil {
+		c.metrics.requests.WithLabelValues(c.agentID, result).Inc()
+	}
+}
+
+// storeFailed counts a response that could not be stored. The fetch itself
+// succeeded, so the response is still returned.
+func (c *Cache) storeFailed() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.metrics != nil {
+		c.metrics.failures.WithLabelValues(c.agentID).Inc()
+	}
+}
+
+func (c *Cache) key(u string) string {
+	sum := sha256.Sum256([]byte(u))
+	return c.prefix + hex.EncodeToString(sum[:])
+}
+
+func (c *Cache) load(ctx context.Context, key string) *cacheEntry {
+	raw, err := c.store.Get(ctx, key)
+	if err != nil || raw == nil {
+		return nil
+	}
+	var e cacheEntry
+	if json.Unmarshal(raw, &e) != nil {
+		return nil
+	}
+	c.mu.Lock()
+	if ie := c.index[key]; ie != nil {
+		ie.Used = c.now()
+	}
+	c.mu.Unlock()
+	return &e
+}
+
+// save stores e and evicts least recently used entries beyond the limit.
+func (c *Cache) save(ctx context.Context, key string, e *cacheEntry) error {
+	raw, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	size := int64(len(raw))
+	if size > c.maxBytes {
+		return c.remove(ctx, key)
+	}
+	if err := c.store.Set(ctx, key, raw); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if old := c.index[key]; old != nil {
+		c.size -= old.Size
+	}
+	c.index[key] = &cacheIndexEntry{Size: size, Used: c.now()}
+	c.size += size
+	if c.size > c.maxBytes {
+		keys := make([]string, 0, len(c.index))
+		for k := range c.index {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool { return c.index[keys[i]].Used.Before(c.index[keys[j]].Used) })
+		for _, k := range keys {
+			if c.size <= c.maxBytes {
+				break
+			}
+			if k == key {
+				continue
+			}
+			if err := c.store.Delete(ctx, k); err != nil {
+				return err
+			}
+			c.size -= c.index[k].Size
+			delete(c.index, k)
+		}
+	}
+	return c.saveIndex(ctx)
+}
+
+func (c *Cache) remove(ctx context.Context, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	old := c.index[key]
+	if old == nil {
+		return nil
+	}
+	if err := c.store.Delete(ctx, key); err != nil {
+		return err
+	}
+	c.size -= old.Size
+	delete(c.index, key)
+	return c.saveIndex(ctx)
+}
+
+// saveIndex persists the index. Callers hold c.mu.
+func (c *Cache) saveIndex(ctx context.Context) error {
+	if c.metrics != nil {
+		c.metrics.size.WithLabelValues(c.agentID).Set(float64(c.size))
+	}
+	raw, err := json.Marshal(c.index)
+	if err != nil {
+		return err
+	}
+	return c.store.Set(ctx, c.prefix+"index", raw)
+}
+
+// cacheTransport answers GET requests from a Cache.
+type cacheTransport struct {
+	cache *Cache
+	next  http.RoundTripper
+}
+
+func (t *cacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	c := t.cache
+	key := c.key(req.URL.String())
+	if req.Method != http.MethodGet {
+		resp, err := t.next.RoundTrip(req)
+		if err == nil && req.Method != http.MethodHead && resp.StatusCode < 400 {
+			// Unsafe methods invalidate the stored response for the URL.
+			_ = c.remove(ctx, key)
+		}
+		return resp, err
+	}
+	reqCC := parseCacheControl(req.Header)
+	if _, ok := reqCC["no-store"]; ok {
+		c.observe(ctx, CacheBypass)
+		return t.next.RoundTrip(req)
+	}
+	force, _ := ctx.Value(forceRefreshKey).(bool)
+
+	entry := c.load(ctx, key)
+	if entry != nil && !entry.varyMatches(req) {
+		entry = nil
+	}
+	now := c.now()
+	if entry != nil && !force {
+		_, noCache := reqCC["no-cache"]
+		if !noCache && entry.fresh(now) {
+			c.observe(ctx, CacheHit)
+			return entry.response(req, now), nil
+		}
+	}
+
+	outgoing := req
+	if entry != nil && !force && req.Header.Get("If-None-Match") == "" && req.Header.Get("If-Modified-Since") == "" {
+		etag, modified := entry.Header.Get("ETag"), entry.Header.Get("Last-Modified")
+		if etag != "" || modified != "" {
+			outgoing = req.Clone(ctx)
+			if etag != "" {
+				outgoing.Header.Set("If-None-Match", etag)
+			}
+			if modified != "" {
+				outgoing.Header.Set("If-Modified-Since", modified)
+			}
+		}
+	}
+	resp, err := t.next.RoundTrip(outgoing)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotModified && outgoing != req {
+		resp.Body.Close()
+		for k, v := range resp.Header {
+			entry.Header[k] = v
+		}
+		entry.Stored, entry.InitialAge = c.now(), ageHeader(resp.Header)
+		if err := c.save(ctx, key, entry); err != nil {
+			c.storeFailed()
+		}
+		c.observe(ctx, CacheRevalidated)
+		return entry.response(req, entry.Stored), nil
+	}
+	c.observe(ctx, CacheMiss)
+	if !storable(resp) {
+		if entry != nil {
+			_ = c.remove(ctx, key)
+		}
+		return resp, nil
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, c.maxBytes+1))
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	if int64(len(body)) > c.maxBytes {
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		return resp, nil
+	}
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	stored := &cacheEntry{
+		URL:        req.URL.String(),
+		Status:     resp.StatusCode,
+		Header:     resp.Header.Clone(),
+		Body:       body,
+		Stored:     c.now(),
+		InitialAge: ageHeader(resp.Header),
+	}
+	for _, name := range varyNames(resp.Header) {
+		if stored.Vary == nil {
+			stored.Vary = map[string]string{}
+		}
+		stored.Vary[name] = req.Header.Get(name)
+	}
+	if err := c.save(ctx, key, stored); err != nil {
+		c.storeFailed()
+	}
+	return resp, nil
+}
+
+// storable reports whether a response may be cached: a status that is
+// cacheable by default, no no-store or Vary: *, and either explicit
+// freshness or a validator to revalidate with.
+func storable(resp *http.Response) bool {
+	switch resp.StatusCode {
+	case 200, 203, 204, 300, 301, 308, 404, 405, 410, 414, 501:
+	default:
+		return false
+	}
+	cc := parseCacheControl(resp.Header)
+	if _, ok := cc["no-store"]; ok {
+		return false
+	}
+	if strings.TrimSpace(resp.Header.Get("Vary")) == "*" {
+		return false
+	}
+	_, maxAge := cc["max-age"]
+	_, public := cc["public"]
+	return maxAge || public || resp.Header.Get("Expires") != "" ||
+		resp.Header.Get("ETag") != "" || resp.Header.Get("Last-Modified") != ""
+}
+
+// fresh reports whether the entry's current age is below its freshness
+// lifetime (RFC 9111 sections 4.2.1 to 4.2.3).
+func (e *cacheEntry) fresh(now time.Time) bool {
+	cc := parseCacheControl(e.Header)
+	if _, ok := cc["no-cache"]; ok {
+		return false
+	}
+	age := e.InitialAge + now.Sub(e.Stored)
+	return age < e.lifetime()
+}
+
+func (e *cacheEntry) lifetime() time.Duration {
+	cc := parseCacheControl(e.Header)
+	if v, ok := cc["max-age"]; ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return 0
+		}
+		return time.Duration(n) * time.Second
+	}
+	date, err := http.ParseTime(e.Header.Get("Date"))
+	if err != nil {
+		date = e.Stored
+	}
+	if v := e.Header.Get("Expires"); v != "" {
+		expires, err := http.ParseTime(v)
+		if err != nil {
+			return 0
+		}
+		return expires.Sub(date)
+	}
+	if modified, err := http.ParseTime(e.Header.Get("Last-Modified")); err == nil && modified.Before(date) {
+		// Heuristic freshness: 10% of the time since last modification.
+		return date.Sub(modified) / 10
+	}
+	return 0
+}
+
+func (e *cacheEntry) varyMatches(req *http.Request) bool {
+	for name, value := range e.Vary {
+		if req.Header.Get(name) != value {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *cacheEntry) response(req *http.Request, now time.Time) *http.Response {
+	header := e.Header.Clone()
+	age := e.InitialAge + now.Sub(e.Stored)
+	header.Set("Age", strconv.Itoa(int(age.Seconds())))
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", e.Status, http.StatusText(e.Status)),
+		StatusCode:    e.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(e.Body)),
+		ContentLength: int64(len(e.Body)),
+		Request:       req,
+	}
+}
+
+// parseCacheControl returns Cache-Control directives in lower case with
+// their unquoted arguments.
+func parseCacheControl(h http.Header) map[string]string {
+	out := map[string]string{}
+	for _, line := range h.Values("Cache-Control") {
+		for _, part := range strings.Split(line, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+			if name != "" {
+				out[strings.ToLower(name)] = strings.Trim(value, `"`)
+			}
+		}
+	}
+	return out
+}
+
+func varyNames(h http.Header) []string {
+	var out []string
+	for _, line := range h.Values("Vary") {
+		for _, name := range strings.Split(line, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				out = append(out, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	return out
+}
+
+func ageHeader(h http.Header) time.Duration {
+	n, err := strconv.Atoi(h.Get("Age"))
+	if err != nil || n < 0 {
+		return 0
+	}
+	return time.Duration(n) * time.Second
+}
@@ -0,0 +1,333 @@
+package net
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Cassette modes.
+const (
+	CassetteRecord = "record"
+	CassetteReplay = "replay"
+)
+
+// Environment variables used by "spawn test" to switch agents to cassettes.
+const (
+	EnvCassetteMode = "SPAWN_NET_CASSETTE_MODE"
+	EnvCassetteDir  = "SPAWN_NET_CASSETTE_DIR"
+)
+
+const cassetteVersion = 1
+
+// cassetteTail closes the interactions array and the file. Each recorded
+// interaction is written over it, followed by a new tail, so recording
+// appends to the file instead of rewriting it.
+const cassetteTail = "\n  ]\n}\n"
+
+// defaultIgnoredHeaders are neither matched nor recorded: they vary between
+// runs or carry credentials.
+var defaultIgnoredHeaders = []string{"Authorization", "Cookie", "User-Agent", "Accept-Encoding", "Proxy-Authorization"}
+
+// ErrCassetteMiss is returned in replay mode for requests with no recording.
+var ErrCassetteMiss = errors.New("no cassette interaction matches request")
+
+// CassetteOptions configures a cassette. Request headers other than
+// IgnoreHeaders (plus the defaults) must match on replay.
+type CassetteOptions struct {
+	Path          string
+	Mode          string
+	IgnoreHeaders []string
+}
+
+// Interaction is one recorded request/response pair.
+type Interaction struct {
+	Request  CassetteRequest  `json:"request"`
+	Response CassetteResponse `json:"response"`
+}
+
+// CassetteRequest identifies a request by method, URL, headers and body hash.
+type CassetteRequest struct {
+	Method     string      `json:"method"`
+	URL        string      `json:"url"`
+	Headers    http.Header `json:"headers,omitempty"`
+	BodySHA256 string      `json:"body_sha256,omitempty"`
+}
+
+// CassetteResponse is a recorded response. Bodies that are not valid UTF-8
+// are stored in BodyBase64. Truncated bodies were longer than the net
+// capability's response limit and only its first limit+1 bytes were
+// recorded, so replays report them truncated too.
+type CassetteResponse struct {
+	Status     int         `json:"status"`
+	Headers    http.Header `json:"headers,omitempty"`
+	Body       string      `json:"body,omitempty"`
+	BodyBase64 string      `json:"body_base64,omitempty"`
+	Truncated  bool        `json:"truncated,omitempty"`
+}
+
+type cassetteFile struct {
+	Version      int           `json:"version"`
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Cassette records HTTP interactions to a file or replays them from it.
+// Replays are deterministic: identical requests are served in recorded order
+// and the last match repeats once the others are used up.
+type Cassette struct {
+	mu           sync.Mutex
+	opts         CassetteOptions
+	ignore       map[string]bool
+	interactions []Interaction
+	used         []bool
+	// size is the length of the recorded file without its tail, zero
+	// until the first interaction is recorded.
+	size int64
+}
+
+// OpenCassette opens a cassette. Replay mode loads an existing file; record
+// mode starts an empty cassette, overwriting the file at the first
+// interaction and appending each one after it.
+func OpenCassette(opts CassetteOptions) (*Cassette, error) {
+	if opts.Path == "" {
+		return nil, fmt.Errorf("open cassette: path is required")
+	}
+	c := &Cassette{opts: opts, ignore: map[string]bool{}}
+	for _, h := range append(append([]string{}, defaultIgnoredHeaders...), opts.IgnoreHeaders...) {
+		c.ignore[http.CanonicalHeaderKey(h)] = true
+	}
+	switch opts.Mode {
+	case CassetteRecord:
+		return c, nil
+	case CassetteReplay:
+		raw, err := os.ReadFile(opts.Path)
+		if err != nil {
+			return nil, fmt.Errorf("open cassette: %w", err)
+		}
+		var file cassetteFile
+		if err := json.Unmarshal(raw, &file); err != nil {
+			return nil, fmt.Errorf("open cassette %s: %w", opts.Path, err)
+		}
+		if file.Version != cassetteVersion {
+			return nil, fmt.Errorf("open cassette %s: unsupported version %d", opts.Path, file.Version)
+		}
+		c.interactions = file.Interactions
+		c.used = make([]bool, len(file.Interactions))
+		return c, nil
+	default:
+		return nil, fmt.Errorf("open cassette: mode must be %s or %s", CassetteRecord, CassetteReplay)
+	}
+}
+
+// CassetteOptionsFromEnv returns options for the agent name when "spawn
+// test" selected a cassette mode through the environment.
+func CassetteOptionsFromEnv(name string) (CassetteOptions, bool) {
+	mode := os.Getenv(EnvCassetteMode)
+	if mode == "" {
+		return CassetteOptions{}, false
+	}
+	dir := os.Getenv(EnvCassetteDir)
+	if dir == "" {
+		dir = "testdata/cassettes"
+	}
+	return CassetteOptions{Path: filepath.Join(dir, name+".json"), Mode: mode}, true
+}
+
+// Interactions returns the recorded or loaded interactions.
+func (c *Cassette) Interactions() []Interaction {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Interaction(nil), c.interactions...)
+}
+
+// key builds the matched part of a request, consuming and restoring its body.
+func (c *Cassette) key(req *http.Request) (CassetteRequest, error) {
+	key := CassetteRequest{Method: req.Method, URL: req.URL.String()}
+	if len(req.Header) > 0 {
+		key.Headers = http.Header{}
+		for k, v := range req.Header {
+			if !c.ignore[http.CanonicalHeaderKey(k)] {
+				key.Headers[http.CanonicalHeaderKey(k)] = append([]string(nil), v...)
+			}
+		}
+		if len(key.Headers) == 0 {
+			key.Headers = nil
+		}
+	}
+	if req.Body != nil && req.Body != http.NoBody {
+		body, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return key, fmt.Errorf("read request body: %w", err)
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		if len(body) > 0 {
+			sum := sha256.Sum256(body)
+			key.BodySHA256 = hex.EncodeToString(sum[:])
+		}
+	}
+	return key, nil
+}
+
+func (k CassetteRequest) matches(other CassetteRequest) bool {
+	if k.Method != other.Method || k.URL != other.URL || k.BodySHA256 != other.BodySHA256 || len(k.Headers) != len(other.Headers) {
+		return false
+	}
+	for name, values := range k.Headers {
+		if strings.Join(values, "\x00") != strings.Join(other.Headers[name], "\x00") {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *Cassette) replay(req *http.Request) (*http.Response, error) {
+	key, err := c.key(req)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	last := -1
+	for i, in := range c.interactions {
+		if !in.Request.matches(key) {
+			continue
+		}
+		last = i
+		if !c.used[i] {
+			c.used[i] = true
+			return in.Response.httpResponse(req)
+		}
+	}
+	if last < 0 {
+		return nil, fmt.Errorf("%w: %s %s", ErrCassetteMiss, req.Method, req.URL.Redacted())
+	}
+	return c.interactions[last].Response.httpResponse(req)
+}
+
+// record sends req through next and records the response, reading at most
+// limit+1 bytes of its body so an oversized body is still seen as such.
+func (c *Cassette) record(req *http.Request, next http.RoundTripper, limit int64) (*http.Response, error) {
+	key, err := c.key(req)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("read response body: %w", err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	recorded := CassetteResponse{Status: resp.StatusCode, Headers: resp.Header.Clone(), Truncated: int64(len(body)) > limit}
+	if utf8.Valid(body) {
+		recorded.Body = string(body)
+	} else {
+		recorded.BodyBase64 = base64.StdEncoding.EncodeToString(body)
+	}
+	for name := range recorded.Headers {
+		if c.ignore[name] || name == "Set-Cookie" {
+			delete(recorded.Headers, name)
+		}
+	}
+	in := Interaction{Request: key, Response: recorded}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.append(in); err != nil {
+		return nil, err
+	}
+	c.interactions = append(c.interactions, in)
+	return resp, nil
+}
+
+// append writes in to the cassette file over its tail, creating the file
+// at the first interaction. Callers hold c.mu.
+func (c *Cassette) append(in Interaction) error {
+	raw, err := json.MarshalIndent(in, "    ", "  ")
+	if err != nil {
+		return fmt.Errorf("save cassette: %w", err)
+	}
+	var buf bytes.Buffer
+	flag := os.O_WRONLY
+	if c.size == 0 {
+		if err := os.MkdirAll(filepath.Dir(c.opts.Path), 0o755); err != nil {
+			return fmt.Errorf("save cassette: %w", err)
+		}
+		flag |= os.O_CREATE | os.O_TRUNC
+		fmt.Fprintf(&buf, "{\n  \"version\": %d,\n  \"interactions\": [\n    ", cassetteVersion)
+	} else {
+		buf.WriteString(",\n    ")
+	}
+	buf.Write(raw)
+	written := int64(buf.Len())
+	buf.WriteString(cassetteTail)
+
+	f, err := os.OpenFile(c.opts.Path, flag, 0o644)
+	if err != nil {
+		return fmt.Errorf("save cassette: %w", err)
+	}
+	if _, err := f.WriteAt(buf.Bytes(), c.size); err != nil {
+		f.Close()
+		return fmt.Errorf("save cassette: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("save cassette: %w", err)
+	}
+	c.size += written
+	return nil
+}
+
+func (r CassetteResponse) httpResponse(req *http.Request) (*http.Response, error) {
+	body := []byte(r.Body)
+	if r.BodyBase64 != "" {
+		var err error
+		if body, err = base64.StdEncoding.DecodeString(r.BodyBase64); err != nil {
+			return nil, fmt.Errorf("decode cassette body: %w", err)
+		}
+	}
+	header := r.Headers.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", r.Status, http.StatusText(r.Status)),
+		StatusCode:    r.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// cassetteTransport routes requests through a cassette, recording at most
+// maxBody+1 bytes of each response body.
+type cassetteTransport struct {
+	cassette *Cassette
+	next     http.RoundTripper
+	maxBody  int64
+}
+
+func (t *cassetteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.cassette.opts.Mode == CassetteReplay {
+		return t.cassette.replay(req)
+	}
+	return t.cassette.record(req, t.next, t.maxBody)
+}
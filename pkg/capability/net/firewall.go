@@ -1,7 +1,179 @@
 package net
 
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"syscall"
+	"time"
+)
+
 // Policy defines basic allow/deny rules.
 type Policy struct {
 	Allowlist []string
 	Denylist  []string
 }
+
+// Allows reports whether host (optionally with a port) passes the lists:
+// denied patterns always win and an empty allowlist allows everything.
+func (p Policy) Allows(host string) bool {
+	normalizedHost := normalizeHost(host)
+	if normalizedHost == "" {
+		return false
+	}
+	for _, denied := range p.Denylist {
+		if matchesDomain(normalizedHost, denied) {
+			return false
+		}
+	}
+	if len(p.Allowlist) == 0 {
+		return true
+	}
+	for _, allowed := range p.Allowlist {
+		if matchesDomain(normalizedHost, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// blockedByDefault lists ranges an agent may not reach unless they are in
+// the CIDR allowlist: loopback, private, link-local (including the cloud
+// metadata address 169.254.169.254), CGNAT, multicast and reserved space.
+var blockedByDefault = mustPrefixes(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.0.2.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"198.51.100.0/24",
+	"203.0.113.0/24",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+	"::/128",
+	"::1/128",
+	"64:ff9b::/96",
+	"2001:db8::/32",
+	"fc00::/7",
+	"fe80::/10",
+	"ff00::/8",
+)
+
+// IPPolicy is checked against the address every connection is made to,
+// after DNS resolution. DenyCIDRs always win; AllowCIDRs exempt ranges from
+// the default block list.
+type IPPolicy struct {
+	allow []netip.Prefix
+	deny  []netip.Prefix
+}
+
+// BlockedIPError reports a connection refused by the IP policy.
+type BlockedIPError struct {
+	IP     netip.Addr
+	Reason string
+}
+
+func (e *BlockedIPError) Error() string {
+	return fmt.Sprintf("connection to %s blocked: %s", e.IP, e.Reason)
+}
+
+// NewIPPolicy parses CIDR allow and deny lists. Bare addresses are treated
+// as single-host prefixes.
+func NewIPPolicy(allowCIDRs, denyCIDRs []string) (*IPPolicy, error) {
+	allow, err := parsePrefixes(allowCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	deny, err := parsePrefixes(denyCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	return &IPPolicy{allow: allow, deny: deny}, nil
+}
+
+// Check returns a *BlockedIPError if ip may not be connected to.
+func (p *IPPolicy) Check(ip netip.Addr) error {
+	ip = ip.Unmap()
+	if p != nil {
+		for _, prefix := range p.deny {
+			if prefix.Contains(ip) {
+				return &BlockedIPError{IP: ip, Reason: "denied by " + prefix.String()}
+			}
+		}
+		for _, prefix := range p.allow {
+			if prefix.Contains(ip) {
+				return nil
+			}
+		}
+	}
+	for _, prefix := range blockedByDefault {
+		if prefix.Contains(ip) {
+			return &BlockedIPError{IP: ip, Reason: "private or reserved range " + prefix.String()}
+		}
+	}
+	return nil
+}
+
+// control runs inside the dialer after the address is resolved and before
+// connecting, so the checked IP is the one the connection uses; a name that
+// re-resolves elsewhere later (DNS rebinding) is checked again on that dial.
+func (p *IPPolicy) control(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return fmt.Errorf("dial %s: unresolved address", address)
+	}
+	return p.Check(ip)
+}
+
+// transport returns an HTTP transport whose connections are filtered by p.
+// Environment proxies are ignored: they would be the only address checked.
+func (p *IPPolicy) transport() *http.Transport {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Control: p.control}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = nil
+	t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return dialer.DialContext(ctx, network, addr)
+	}
+	return t
+}
+
+func parsePrefixes(values []string) ([]netip.Prefix, error) {
+	out := make([]netip.Prefix, 0, len(values))
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if !strings.Contains(v, "/") {
+			addr, err := netip.ParseAddr(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CIDR %q: %w", v, err)
+			}
+			out = append(out, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", v, err)
+		}
+		out = append(out, prefix.Masked())
+	}
+	return out, nil
+}
+
+func mustPrefixes(values ...string) []netip.Prefix {
+	out, err := parsePrefixes(values)
+	if err != nil {
+		panic(err)
+	}
+	return out
+}
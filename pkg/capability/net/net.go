@@ -2,10 +2,10 @@ package net
 
 import (
 	"context"
-	"io"
+	"errors"
+	"fmt"
 	"net"
 	"net/http"
-	"net/url"
 	"strings"
 	"time"
 
@@ -14,103 +14,178 @@ import (
 
 // Capability provides HTTP and DNS operations with policy checks.
 type Capability struct {
-	allow []string
-	deny  []string
-	http  *http.Client
+	allow        []string
+	deny         []string
+	http         *http.Client
+	userAgent    string
+	maxBody      int64
+	maxRedirects int
+	files        capability.Capability
+	limiter      *Limiter
+	ipPolicy     *IPPolicy
+	cassette     *Cassette
+	cache        *Cache
 }
 
-// New returns a network capability.
+// New returns a network capability. Connections to private, loopback and
+// metadata addresses are refused until allowed with SetIPPolicy.
 func New(allowlist, denylist []string) *Capability {
 	return &Capability{
-		allow: allowlist,
-		deny:  denylist,
-		http:  &http.Client{Timeout: 30 * time.Second},
+		allow:        allowlist,
+		deny:         denylist,
+		http:         &http.Client{Timeout: 30 * time.Second, Transport: (*IPPolicy)(nil).transport()},
+		userAgent:    defaultUserAgent,
+		maxBody:      defaultMaxBody,
+		maxRedirects: defaultMaxRedirects,
 	}
 }
 
-func (c *Capability) Name() string                                             { return "net" }
-func (c *Capability) Version() string                                          { return "v1" }
-func (c *Capability) Description() string                                      { return "HTTP and DNS with policy controls" }
-func (c *Capability) Initialize(context.Context, map[string]interface{}) error { return nil }
-func (c *Capability) Shutdown(context.Context) error                           { return nil }
-func (c *Capability) HealthCheck(context.Context) error                        { return nil }
+func (c *Capability) Name() string                      { return "net" }
+func (c *Capability) Version() string                   { return "v1" }
+func (c *Capability) Description() string               { return "HTTP and DNS with policy controls" }
+func (c *Capability) Shutdown(context.Context) error    { return nil }
+func (c *Capability) HealthCheck(context.Context) error { return nil }
+
+// Initialize applies optional config keys: "user_agent", "max_response_bytes"
+// and "max_redirects".
+func (c *Capability) Initialize(_ context.Context, config map[string]interface{}) error {
+	if ua, ok := config["user_agent"].(string); ok && ua != "" {
+		c.userAgent = ua
+	}
+	if n := capability.IntParam(config, "max_response_bytes", 0); n > 0 {
+		c.maxBody = int64(n)
+	}
+	c.maxRedirects = capability.IntParam(config, "max_redirects", c.maxRedirects)
+	c.setTransport()
+	return nil
+}
+
+// SetFileStore sets the fs capability that binary responses are written to.
+func (c *Capability) SetFileStore(files capability.Capability) {
+	c.files = files
+}
+
+// Policy returns the host allow/deny lists, for sharing with the egress proxy.
+func (c *Capability) Policy() Policy {
+	return Policy{Allowlist: c.allow, Denylist: c.deny}
+}
+
+// SetIPPolicy sets the CIDR lists every HTTP connection is checked against
+// once its host name has been resolved.
+func (c *Capability) SetIPPolicy(p *IPPolicy) {
+	c.ipPolicy = p
+	c.setTransport()
+}
+
+// SetCassette records requests to, or replays them from, cas. Replayed
+// responses never reach the network; nil turns the cassette off.
+func (c *Capability) SetCassette(cas *Cassette) {
+	c.cassette = cas
+	c.setTransport()
+}
+
+// Client returns an HTTP client that applies this capability's full policy:
+// host allow/deny lists on every request and redirect hop, the IP policy,
+// rate limits, cache and cassette. Other capabilities such as the browser
+// fetch through it; jar may be nil.
+func (c *Capability) Client(jar http.CookieJar) *http.Client {
+	client := *c.http
+	client.Jar = jar
+	client.Transport = &policyTransport{c: c}
+	client.CheckRedirect = func(_ *http.Request, via []*http.Request) error {
+		if len(via) > c.maxRedirects {
+			return fmt.Errorf("stopped after %d redirects", c.maxRedirects)
+		}
+		return nil
+	}
+	return &client
+}
+
+// ErrBlocked is returned by Client for hosts refused by policy.
+var ErrBlocked = errors.New("blocked by policy")
+
+// policyTransport checks hosts before the capability's current transport.
+type policyTransport struct {
+	c *Capability
+}
+
+func (t *policyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return nil, fmt.Errorf("%w: scheme %s", ErrBlocked, req.URL.Scheme)
+	}
+	if !t.c.allowed(req.URL.Hostname()) {
+		return nil, fmt.Errorf("%w: %s", ErrBlocked, req.URL.Hostname())
+	}
+	return t.c.http.Transport.RoundTrip(req)
+}
+
+func (c *Capability) setTransport() {
+	var rt http.RoundTripper = c.ipPolicy.transport()
+	if c.cassette != nil {
+		rt = &cassetteTransport{cassette: c.cassette, next: rt, maxBody: c.maxBody}
+	}
+	if c.limiter != nil {
+		rt = &limitTransport{limiter: c.limiter, next: rt}
+	}
+	if c.cache != nil {
+		rt = &cacheTransport{cache: c.cache, next: rt}
+	}
+	c.http.Transport = rt
+}
+
+// SetLimiter rate limits HTTP requests, including each redirect hop, with l.
+// Cache hits do not take tokens. A limiter may be shared with other egress
+// paths of the same agent.
+func (c *Capability) SetLimiter(l *Limiter) {
+	c.limiter = l
+	c.setTransport()
+}
+
+// SetCache answers GET requests from cache where RFC 9111 allows it.
+func (c *Capability) SetCache(cache *Cache) {
+	c.cache = cache
+	c.setTransport()
+}
 
 func (c *Capability) Schema() *capability.Schema {
-	return &capability.Schema{Actions: []capability.Action{{Name: "get"}, {Name: "resolve"}}}
+	return &capability.Schema{Actions: []capability.Action{
+		{Name: "get", Description: "GET a URL; shorthand for request with method GET"},
+		{Name: "request", Description: "HTTP request with any method, headers, query params and a json, form or raw body"},
+		{Name: "resolve"},
+	}}
 }
 
 func (c *Capability) Execute(ctx context.Context, req *capability.Request) (*capability.Response, error) {
 	if req == nil {
-		return &capability.Response{Success: false, Error: &capability.Error{Code: "invalid_request", Message: "nil request"}}, nil
+		return capability.Failure("invalid_request", "nil request"), nil
 	}
 	switch req.Action {
 	case "get":
-		rawURL, _ := req.Params["url"].(string)
-		targetURL, err := url.Parse(rawURL)
-		if err != nil || targetURL.Scheme == "" || targetURL.Host == "" {
-			return &capability.Response{Success: false, Error: &capability.Error{Code: "invalid_url", Message: "valid absolute url is required"}}, nil
-		}
-		if targetURL.Scheme != "http" && targetURL.Scheme != "https" {
-			return &capability.Response{Success: false, Error: &capability.Error{Code: "invalid_url", Message: "only http/https are allowed"}}, nil
-		}
-		if !c.allowed(targetURL.Hostname()) {
-			return &capability.Response{Success: false, Error: &capability.Error{Code: "blocked", Message: "url blocked by policy"}}, nil
-		}
-		request, err := http.NewRequestWithContext(ctx, http.MethodGet, targetURL.String(), nil)
-		if err != nil {
-			return &capability.Response{Success: false, Error: &capability.Error{Code: "http_failed", Message: err.Error()}}, nil
-		}
-		request.Header.Set("User-Agent", "spawn-net-capability/1.0")
-		resp, err := c.http.Do(request)
-		if err != nil {
-			return &capability.Response{Success: false, Error: &capability.Error{Code: "http_failed", Message: err.Error()}}, nil
-		}
-		defer resp.Body.Close()
-		body, err := io.ReadAll(io.LimitReader(resp.Body, 8*1024))
-		if err != nil {
-			return &capability.Response{Success: false, Error: &capability.Error{Code: "http_failed", Message: err.Error()}}, nil
+		params := map[string]interface{}{}
+		for k, v := range req.Params {
+			params[k] = v
 		}
-		return &capability.Response{Success: true, Data: map[string]interface{}{
-			"status":     resp.StatusCode,
-			"host":       targetURL.Hostname(),
-			"headers":    resp.Header,
-			"body":       string(body),
-			"body_bytes": len(body),
-		}}, nil
+		params["method"] = http.MethodGet
+		return c.request(ctx, params), nil
+	case "request":
+		return c.request(ctx, req.Params), nil
 	case "resolve":
 		host, _ := req.Params["host"].(string)
 		if !c.allowed(host) {
-			return &capability.Response{Success: false, Error: &capability.Error{Code: "blocked", Message: "host blocked by policy"}}, nil
+			return capability.Failure("blocked", "host blocked by policy"), nil
 		}
 		ips, err := Lookup(host)
 		if err != nil {
-			return &capability.Response{Success: false, Error: &capability.Error{Code: "dns_failed", Message: err.Error()}}, nil
+			return capability.Failure("dns_failed", err.Error()), nil
 		}
 		return &capability.Response{Success: true, Data: ips}, nil
 	default:
-		return &capability.Response{Success: false, Error: &capability.Error{Code: "invalid_action", Message: req.Action}}, nil
+		return capability.Failure("invalid_action", req.Action), nil
 	}
 }
 
 func (c *Capability) allowed(host string) bool {
-	normalizedHost := normalizeHost(host)
-	if normalizedHost == "" {
-		return false
-	}
-	for _, denied := range c.deny {
-		if matchesDomain(normalizedHost, denied) {
-			return false
-		}
-	}
-	if len(c.allow) == 0 {
-		return true
-	}
-	for _, allowed := range c.allow {
-		if matchesDomain(normalizedHost, allowed) {
-			return true
-		}
-	}
-	return false
+	return c.Policy().Allows(host)
 }
 
 func normalizeHost(host string) string {
@@ -138,3 +213,18 @@ func matchesDomain(host, pattern string) bool {
 	}
 	return host == pattern
 }
+
+// rateLimited converts a limiter error into a response carrying retry_after_ms.
+func rateLimited(err error) *capability.Response {
+	var limited *RateLimitedError
+	if !errors.As(err, &limited) {
+		return capability.Failure("rate_limited", err.Error())
+	}
+	resp := capability.Failure("rate_limited", limited.Error())
+	resp.Data = map[string]interface{}{
+		"retry_after_ms": limited.RetryAfter.Milliseconds(),
+		"scope":          limited.Scope,
+		"host":           limited.Host,
+	}
+	return resp
+}
@@ -1,14 +1,44 @@
 package net
 
 import (
+	"bufio"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	stdnet "net"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
 
 	"spawn.dev/pkg/capability"
+	"spawn.dev/pkg/capability/fs"
+	"spawn.dev/pkg/capability/memory"
+	"spawn.dev/pkg/observability"
 )
 
+// newLocal returns a capability allowed to reach httptest servers on loopback.
+func newLocal(t *testing.T, allowlist, denylist []string) *Capability {
+	t.Helper()
+	policy, err := NewIPPolicy([]string{"127.0.0.0/8", "::1"}, nil)
+	if err != nil {
+		t.Fatalf("ip policy: %v", err)
+	}
+	c := New(allowlist, denylist)
+	c.SetIPPolicy(policy)
+	return c
+}
+
 func TestHTTPAllowAndDenyRules(t *testing.T) {
 	t.Parallel()
 	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
@@ -16,7 +46,7 @@ func TestHTTPAllowAndDenyRules(t *testing.T) {
 	}))
 	defer srv.Close()
 
-	cap := New([]string{"127.0.0.1"}, nil)
+	cap := newLocal(t, []string{"127.0.0.1"}, nil)
 	resp, err := cap.Execute(context.Background(), &capability.Request{Action: "get", Params: map[string]interface{}{"url": srv.URL}})
 	if err != nil {
 		t.Fatalf("execute get: %v", err)
@@ -25,7 +55,7 @@ func TestHTTPAllowAndDenyRules(t *testing.T) {
 		t.Fatalf("expected allowed request, got %#v", resp.Error)
 	}
 
-	cap = New([]string{"*"}, []string{"127.0.0.1"})
+	cap = newLocal(t, []string{"*"}, []string{"127.0.0.1"})
 	resp, err = cap.Execute(context.Background(), &capability.Request{Action: "get", Params: map[string]interface{}{"url": srv.URL}})
 	if err != nil {
 		t.Fatalf("execute denied get: %v", err)
@@ -34,3 +64,704 @@ func TestHTTPAllowAndDenyRules(t *testing.T) {
 		t.Fatalf("expected denied request")
 	}
 }
+
+func TestHTTPRequestBodiesRedirectsAndBinary(t *testing.T) {
+	t.Parallel()
+	var srvURL string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/echo":
+			b, _ := io.ReadAll(r.Body)
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(map[string]string{
+				"method": r.Method, "query": r.URL.RawQuery, "type": r.Header.Get("Content-Type"),
+				"token": r.Header.Get("X-Token"), "ua": r.Header.Get("User-Agent"), "body": string(b),
+			})
+		case "/hop":
+			http.Redirect(w, r, srvURL+"/echo", http.StatusFound)
+		case "/escape":
+			http.Redirect(w, r, strings.Replace(srvURL, "127.0.0.1", "localhost", 1)+"/echo", http.StatusFound)
+		case "/big":
+			_, _ = w.Write([]byte(strings.Repeat("x", 100)))
+		case "/image.png":
+			w.Header().Set("Content-Type", "image/png")
+			_, _ = w.Write([]byte("\x89PNG\r\n\x1a\n\x00\x00"))
+		}
+	}))
+	defer srv.Close()
+	srvURL = srv.URL
+
+	files := fs.New(t.TempDir())
+	cap := newLocal(t, []string{"127.0.0.1"}, nil)
+	cap.SetFileStore(files)
+	ctx := context.Background()
+	run := func(params map[string]interface{}) *capability.Response {
+		t.Helper()
+		resp, err := cap.Execute(ctx, &capability.Request{Action: "request", Params: params})
+		if err != nil {
+			t.Fatalf("execute request: %v", err)
+		}
+		return resp
+	}
+
+	resp := run(map[string]interface{}{
+		"method":  "post",
+		"url":     srv.URL + "/echo",
+		"query":   map[string]interface{}{"q": "go", "tag": []interface{}{"a", "b"}},
+		"headers": map[string]interface{}{"X-Token": "secret"},
+		"json":    map[string]interface{}{"n": 1},
+	})
+	if !resp.Success {
+		t.Fatalf("request failed: %#v", resp.Error)
+	}
+	var echo map[string]string
+	if err := json.Unmarshal([]byte(resp.Data.(map[string]interface{})["body"].(string)), &echo); err != nil {
+		t.Fatalf("decode echo: %v", err)
+	}
+	if echo["method"] != "POST" || echo["query"] != "q=go&tag=a&tag=b" || echo["type"] != "application/json" || echo["token"] != "secret" || echo["body"] != `{"n":1}` {
+		t.Fatalf("unexpected echo %#v", echo)
+	}
+
+	resp = run(map[string]interface{}{"method": "PUT", "url": srv.URL + "/echo", "form": map[string]interface{}{"a": "1"}, "body": "x"})
+	if resp.Success || resp.Error.Code != "invalid_params" {
+		t.Fatalf("expected invalid_params for two bodies, got %#v", resp)
+	}
+
+	resp = run(map[string]interface{}{"url": srv.URL + "/hop"})
+	if data := resp.Data.(map[string]interface{}); !resp.Success || len(data["redirects"].([]string)) != 1 {
+		t.Fatalf("expected followed redirect, got %#v", resp)
+	}
+	resp = run(map[string]interface{}{"url": srv.URL + "/hop", "follow_redirects": false})
+	if data := resp.Data.(map[string]interface{}); data["status"] != http.StatusFound {
+		t.Fatalf("expected unfollowed redirect, got %#v", data["status"])
+	}
+	resp = run(map[string]interface{}{"url": srv.URL + "/escape"})
+	if resp.Success || resp.Error.Code != "blocked" {
+		t.Fatalf("expected redirect to disallowed host to be blocked, got %#v", resp)
+	}
+
+	resp = run(map[string]interface{}{"url": srv.URL + "/big", "max_bytes": float64(10)})
+	if data := resp.Data.(map[string]interface{}); data["truncated"] != true || data["body"] != "xxxxxxxxxx" {
+		t.Fatalf("expected truncated body, got %#v", data)
+	}
+
+	resp = run(map[string]interface{}{"url": srv.URL + "/image.png"})
+	data := resp.Data.(map[string]interface{})
+	if !resp.Success || data["body"] != nil || data["saved_to"] == nil {
+		t.Fatalf("expected binary body saved to fs, got %#v", data)
+	}
+	saved, _ := files.Execute(ctx, &capability.Request{Action: "read", Params: map[string]interface{}{"path": data["saved_to"]}})
+	if !saved.Success || saved.Data.(string) != "\x89PNG\r\n\x1a\n\x00\x00" {
+		t.Fatalf("unexpected saved file %#v", saved)
+	}
+
+	resp = run(map[string]interface{}{"url": srv.URL + "/image.png", "max_bytes": float64(9), "save_to": "partial.png"})
+	if resp.Success || resp.Error.Code != "body_too_large" || resp.Data.(map[string]interface{})["sha256"] != nil {
+		t.Fatalf("expected truncated binary to be refused, got %#v", resp)
+	}
+	if saved, _ := files.Execute(ctx, &capability.Request{Action: "read", Params: map[string]interface{}{"path": "partial.png"}}); saved.Success {
+		t.Fatalf("truncated binary saved: %#v", saved)
+	}
+}
+
+func TestHTTPRateLimit(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+	get := func(c *Capability, url string, params map[string]interface{}) *capability.Response {
+		t.Helper()
+		if params == nil {
+			params = map[string]interface{}{}
+		}
+		params["url"] = url
+		resp, err := c.Execute(context.Background(), &capability.Request{Action: "get", Params: params})
+		if err != nil {
+			t.Fatalf("execute get: %v", err)
+		}
+		return resp
+	}
+
+	reg := prometheus.NewRegistry()
+	failFast := newLocal(t, nil, nil)
+	limiter := NewLimiter("agent-a", RateLimitConfig{
+		Agent: RateLimit{Requests: 10, Per: time.Minute},
+		Host:  RateLimit{Requests: 1, Per: time.Minute},
+	})
+	if err := limiter.RegisterMetrics(reg); err != nil {
+		t.Fatalf("register metrics: %v", err)
+	}
+	failFast.SetLimiter(limiter)
+	if resp := get(failFast, srv.URL, nil); !resp.Success {
+		t.Fatalf("first request: %#v", resp.Error)
+	}
+	resp := get(failFast, srv.URL, nil)
+	if resp.Success || resp.Error.Code != "rate_limited" {
+		t.Fatalf("expected rate_limited, got %#v", resp)
+	}
+	data := resp.Data.(map[string]interface{})
+	if data["scope"] != ScopeHost || data["retry_after_ms"].(int64) <= 0 {
+		t.Fatalf("unexpected rate limit data %#v", data)
+	}
+	other := strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)
+	if resp := get(failFast, other, nil); !resp.Success {
+		t.Fatalf("other host should have its own bucket: %#v", resp.Error)
+	}
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("gather: %v", err)
+	}
+	found := map[string]bool{}
+	for _, f := range families {
+		found[f.GetName()] = true
+	}
+	if !found["spawn_net_rate_limited_total"] || !found["spawn_net_rate_limit_tokens"] {
+		t.Fatalf("expected limiter metrics, got %v", found)
+	}
+	if err := NewLimiter("agent-b", RateLimitConfig{}).RegisterMetrics(reg); err != nil {
+		t.Fatalf("second limiter on shared registry: %v", err)
+	}
+
+	waiting := newLocal(t, nil, nil)
+	waiting.SetLimiter(NewLimiter("agent-c", RateLimitConfig{Agent: RateLimit{Requests: 1, Per: 200 * time.Millisecond}, Wait: true}))
+	if resp := get(waiting, srv.URL, nil); !resp.Success {
+		t.Fatalf("first request: %#v", resp.Error)
+	}
+	start := time.Now()
+	if resp := get(waiting, srv.URL, nil); !resp.Success {
+		t.Fatalf("waited request: %#v", resp.Error)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Fatalf("expected request to wait for a token, took %s", elapsed)
+	}
+	resp = get(waiting, srv.URL, map[string]interface{}{"timeout_ms": 20})
+	if resp.Success || resp.Error.Code != "rate_limited" {
+		t.Fatalf("expected deadline to fail fast, got %#v", resp)
+	}
+}
+
+func TestLimiterForgetsRefilledHosts(t *testing.T) {
+	t.Parallel()
+	now := time.Unix(0, 0)
+	limiter := NewLimiter("agent-a", RateLimitConfig{Host: RateLimit{Requests: 2, Per: time.Minute}})
+	limiter.now = func() time.Time { return now }
+	for i := 0; i < 1000; i++ {
+		if err := limiter.Acquire(context.Background(), fmt.Sprintf("host%d.example.com", i)); err != nil {
+			t.Fatalf("acquire %d: %v", i, err)
+		}
+	}
+	if len(limiter.hosts) != 1000 {
+		t.Fatalf("%d host buckets, want 1000", len(limiter.hosts))
+	}
+	now = now.Add(time.Minute)
+	for i := 0; i < 3; i++ {
+		err := limiter.Acquire(context.Background(), "busy.example.com")
+		if limited := (*RateLimitedError)(nil); (i == 2) != errors.As(err, &limited) {
+			t.Fatalf("acquire %d: %v", i, err)
+		}
+	}
+	if len(limiter.hosts) != 1 {
+		t.Fatalf("%d host buckets after refill, want 1", len(limiter.hosts))
+	}
+}
+
+func TestHTTPBlocksPrivateAddresses(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+	get := func(c *Capability, url string) *capability.Response {
+		t.Helper()
+		resp, err := c.Execute(context.Background(), &capability.Request{Action: "get", Params: map[string]interface{}{"url": url}})
+		if err != nil {
+			t.Fatalf("execute get: %v", err)
+		}
+		return resp
+	}
+
+	// The host name passes the allowlist but resolves to loopback.
+	named := strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)
+	resp := get(New([]string{"localhost"}, nil), named)
+	if resp.Success || resp.Error.Code != "blocked" {
+		t.Fatalf("expected loopback to be blocked, got %#v", resp)
+	}
+	if ip := resp.Data.(map[string]interface{})["ip"].(string); ip != "127.0.0.1" && ip != "::1" {
+		t.Fatalf("expected blocked ip in data, got %q", ip)
+	}
+
+	resp = get(newLocal(t, nil, nil), srv.URL)
+	if !resp.Success {
+		t.Fatalf("allowed cidr: %#v", resp.Error)
+	}
+	if ip := resp.Data.(map[string]interface{})["ip"]; ip != "127.0.0.1" {
+		t.Fatalf("expected resolved ip 127.0.0.1, got %v", ip)
+	}
+
+	policy, err := NewIPPolicy([]string{"127.0.0.0/8"}, []string{"127.0.0.1/32"})
+	if err != nil {
+		t.Fatalf("ip policy: %v", err)
+	}
+	denied := New(nil, nil)
+	denied.SetIPPolicy(policy)
+	if resp := get(denied, srv.URL); resp.Success || resp.Error.Code != "blocked" {
+		t.Fatalf("deny cidr should win over allow, got %#v", resp)
+	}
+
+	for _, ip := range []string{"169.254.169.254", "10.1.2.3", "::ffff:192.168.0.1", "fd00:ec2::254"} {
+		if err := (*IPPolicy)(nil).Check(netip.MustParseAddr(ip)); err == nil {
+			t.Fatalf("expected %s to be blocked by default", ip)
+		}
+	}
+	if err := (*IPPolicy)(nil).Check(netip.MustParseAddr("93.184.216.34")); err != nil {
+		t.Fatalf("public address blocked: %v", err)
+	}
+	if _, err := NewIPPolicy([]string{"10.0.0.0/33"}, nil); err == nil {
+		t.Fatalf("expected invalid cidr error")
+	}
+}
+
+func TestHTTPCassetteRecordReplay(t *testing.T) {
+	t.Parallel()
+	hits := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		b, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte(r.Method + " " + string(b) + " " + strings.Repeat("!", hits)))
+	}))
+	path := t.TempDir() + "/cassettes/agent.json"
+	run := func(c *Capability, params map[string]interface{}) *capability.Response {
+		t.Helper()
+		resp, err := c.Execute(context.Background(), &capability.Request{Action: "request", Params: params})
+		if err != nil {
+			t.Fatalf("execute request: %v", err)
+		}
+		return resp
+	}
+	body := func(resp *capability.Response) string {
+		t.Helper()
+		if !resp.Success {
+			t.Fatalf("request failed: %#v", resp.Error)
+		}
+		return resp.Data.(map[string]interface{})["body"].(string)
+	}
+
+	recorder, err := OpenCassette(CassetteOptions{Path: path, Mode: CassetteRecord})
+	if err != nil {
+		t.Fatalf("open record cassette: %v", err)
+	}
+	live := newLocal(t, nil, nil)
+	live.SetCassette(recorder)
+	post := map[string]interface{}{"method": "POST", "url": srv.URL + "/a", "body": "x", "headers": map[string]interface{}{"Authorization": "Bearer secret"}}
+	first, second := body(run(live, post)), body(run(live, post))
+	get := body(run(live, map[string]interface{}{"url": srv.URL + "/b"}))
+	srv.Close()
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read cassette: %v", err)
+	}
+	if strings.Contains(string(raw), "secret") {
+		t.Fatalf("cassette recorded an ignored header: %s", raw)
+	}
+
+	player, err := OpenCassette(CassetteOptions{Path: path, Mode: CassetteReplay})
+	if err != nil {
+		t.Fatalf("open replay cassette: %v", err)
+	}
+	offline := New(nil, nil)
+	offline.SetCassette(player)
+	post["headers"] = map[string]interface{}{"Authorization": "Bearer other"}
+	if got := body(run(offline, post)); got != first {
+		t.Fatalf("first replay = %q, want %q", got, first)
+	}
+	if got := body(run(offline, post)); got != second {
+		t.Fatalf("second replay = %q, want %q", got, second)
+	}
+	if got := body(run(offline, post)); got != second {
+		t.Fatalf("repeated replay = %q, want %q", got, second)
+	}
+	if got := body(run(offline, map[string]interface{}{"url": srv.URL + "/b"})); got != get {
+		t.Fatalf("get replay = %q, want %q", got, get)
+	}
+
+	post["body"] = "y"
+	if resp := run(offline, post); resp.Success || resp.Error.Code != "cassette_miss" {
+		t.Fatalf("expected body hash mismatch to miss, got %#v", resp)
+	}
+	if resp := run(offline, map[string]interface{}{"url": srv.URL + "/b", "headers": map[string]interface{}{"X-Trace": "1"}}); resp.Success || resp.Error.Code != "cassette_miss" {
+		t.Fatalf("expected header mismatch to miss, got %#v", resp)
+	}
+	ignoring, err := OpenCassette(CassetteOptions{Path: path, Mode: CassetteReplay, IgnoreHeaders: []string{"x-trace"}})
+	if err != nil {
+		t.Fatalf("open replay cassette: %v", err)
+	}
+	offline.SetCassette(ignoring)
+	if got := body(run(offline, map[string]interface{}{"url": srv.URL + "/b", "headers": map[string]interface{}{"X-Trace": "1"}})); got != get {
+		t.Fatalf("ignored header replay = %q, want %q", got, get)
+	}
+}
+
+func TestHTTPCassetteCapsRecordedBodies(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte(strings.Repeat("x", 1000)))
+	}))
+	defer srv.Close()
+	path := t.TempDir() + "/agent.json"
+	recorder, err := OpenCassette(CassetteOptions{Path: path, Mode: CassetteRecord})
+	if err != nil {
+		t.Fatalf("open record cassette: %v", err)
+	}
+	live := newLocal(t, nil, nil)
+	live.SetCassette(recorder)
+	if err := live.Initialize(context.Background(), map[string]interface{}{"max_response_bytes": 10}); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{"/a", "/b"} {
+		resp, err := live.Execute(context.Background(), &capability.Request{Action: "request", Params: map[string]interface{}{"url": srv.URL + p}})
+		if err != nil || !resp.Success || resp.Data.(map[string]interface{})["truncated"] != true {
+			t.Fatalf("request %s = %#v, %v", p, resp, err)
+		}
+	}
+
+	player, err := OpenCassette(CassetteOptions{Path: path, Mode: CassetteReplay})
+	if err != nil {
+		t.Fatalf("open replay cassette: %v", err)
+	}
+	recorded := player.Interactions()
+	if len(recorded) != 2 {
+		t.Fatalf("recorded %d interactions, want 2", len(recorded))
+	}
+	for _, in := range recorded {
+		if !in.Response.Truncated || len(in.Response.Body) != 11 {
+			t.Fatalf("recorded response = %+v", in.Response)
+		}
+	}
+}
+
+func TestHTTPCache(t *testing.T) {
+	t.Parallel()
+	var mu sync.Mutex
+	hits := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits[r.URL.Path]++
+		n := hits[r.URL.Path]
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/fresh":
+			w.Header().Set("Cache-Control", "max-age=60")
+		case "/etag":
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("ETag", `"v1"`)
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		case "/nostore":
+			w.Header().Set("Cache-Control", "no-store")
+		case "/big":
+			w.Header().Set("Cache-Control", "max-age=60")
+			_, _ = w.Write([]byte(strings.Repeat("b", 4096)))
+			return
+		}
+		_, _ = fmt.Fprintf(w, "%s %d", r.URL.Path, n)
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	kv, err := memory.NewKVStore(t.TempDir() + "/kv.db")
+	if err != nil {
+		t.Fatalf("kv store: %v", err)
+	}
+	defer kv.Close()
+	disk, err := NewDiskCacheStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("disk store: %v", err)
+	}
+	for name, store := range map[string]CacheStore{"kv": kv, "disk": disk} {
+		cache, err := NewCache(ctx, "agent-"+name, store, 2048)
+		if err != nil {
+			t.Fatalf("%s: new cache: %v", name, err)
+		}
+		reg := prometheus.NewRegistry()
+		if err := cache.RegisterMetrics(reg); err != nil {
+			t.Fatalf("%s: register metrics: %v", name, err)
+		}
+		c := newLocal(t, nil, nil)
+		c.SetCache(cache)
+		get := func(path string, params map[string]interface{}) (string, string) {
+			t.Helper()
+			if params == nil {
+				params = map[string]interface{}{}
+			}
+			params["url"] = srv.URL + path
+			resp, err := c.Execute(ctx, &capability.Request{Action: "get", Params: params})
+			if err != nil || !resp.Success {
+				t.Fatalf("%s: get %s: %v %#v", name, path, err, resp.Error)
+			}
+			data := resp.Data.(map[string]interface{})
+			result, _ := data["cache"].(string)
+			return data["body"].(string), result
+		}
+		mu.Lock()
+		hits = map[string]int{}
+		mu.Unlock()
+
+		if body, result := get("/fresh", nil); body != "/fresh 1" || result != CacheMiss {
+			t.Fatalf("%s: first fetch %q %q", name, body, result)
+		}
+		if body, result := get("/fresh", nil); body != "/fresh 1" || result != CacheHit {
+			t.Fatalf("%s: cached fetch %q %q", name, body, result)
+		}
+		if body, result := get("/fresh", map[string]interface{}{"force_refresh": true}); body != "/fresh 2" || result != CacheMiss {
+			t.Fatalf("%s: forced fetch %q %q", name, body, result)
+		}
+		get("/etag", nil)
+		if body, result := get("/etag", nil); body != "/etag 1" || result != CacheRevalidated {
+			t.Fatalf("%s: revalidated fetch %q %q", name, body, result)
+		}
+		get("/nostore", nil)
+		if body, _ := get("/nostore", nil); body != "/nostore 2" {
+			t.Fatalf("%s: no-store response was cached: %q", name, body)
+		}
+		if body, result := get("/big", nil); len(body) != 4096 || result != CacheMiss {
+			t.Fatalf("%s: oversized fetch %d %q", name, len(body), result)
+		}
+		if _, result := get("/big", nil); result != CacheMiss {
+			t.Fatalf("%s: oversized response should not be cached, got %q", name, result)
+		}
+		if cache.Size() > 2048 {
+			t.Fatalf("%s: cache size %d over limit", name, cache.Size())
+		}
+
+		reopened, err := NewCache(ctx, "agent-"+name, store, 2048)
+		if err != nil {
+			t.Fatalf("%s: reopen cache: %v", name, err)
+		}
+		if reopened.Size() != cache.Size() {
+			t.Fatalf("%s: reopened size %d, want %d", name, reopened.Size(), cache.Size())
+		}
+
+		families, err := reg.Gather()
+		if err != nil {
+			t.Fatalf("gather: %v", err)
+		}
+		var hitCount float64
+		for _, f := range families {
+			if f.GetName() != "spawn_net_cache_requests_total" {
+				continue
+			}
+			for _, m := range f.GetMetric() {
+				for _, l := range m.GetLabel() {
+					if l.GetName() == "result" && l.GetValue() == CacheHit {
+						hitCount = m.GetCounter().GetValue()
+					}
+				}
+			}
+		}
+		if hitCount != 1 {
+			t.Fatalf("%s: expected 1 cache hit metric, got %v", name, hitCount)
+		}
+	}
+}
+
+// failingStore is a cache store whose writes fail.
+type failingStore struct {
+	CacheStore
+}
+
+func (failingStore) Set(context.Context, string, []byte) error {
+	return errors.New("disk full")
+}
+
+func TestHTTPCacheStoreFailure(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		if r.URL.Path == "/fresh" {
+			w.Header().Set("Cache-Control", "max-age=60")
+			_, _ = w.Write([]byte("body"))
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		_, _ = w.Write([]byte("body"))
+	}))
+	defer srv.Close()
+	disk, err := NewDiskCacheStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("disk store: %v", err)
+	}
+	cache, err := NewCache(ctx, "agent", disk, 0)
+	if err != nil {
+		t.Fatalf("new cache: %v", err)
+	}
+	reg := prometheus.NewRegistry()
+	if err := cache.RegisterMetrics(reg); err != nil {
+		t.Fatalf("register metrics: %v", err)
+	}
+	c := newLocal(t, nil, nil)
+	c.SetCache(cache)
+	get := func(path string) *capability.Response {
+		t.Helper()
+		resp, err := c.Execute(ctx, &capability.Request{Action: "get", Params: map[string]interface{}{"url": srv.URL + path}})
+		if err != nil || !resp.Success || resp.Data.(map[string]interface{})["body"] != "body" {
+			t.Fatalf("get = %#v, %v", resp, err)
+		}
+		return resp
+	}
+	// The stored entry is revalidated through a store that cannot write.
+	get("/etag")
+	cache.store = failingStore{disk}
+	if result := get("/etag").Data.(map[string]interface{})["cache"]; result != CacheRevalidated {
+		t.Fatalf("revalidation result = %v", result)
+	}
+	// A new URL cannot be stored either.
+	if result := get("/fresh").Data.(map[string]interface{})["cache"]; result != CacheMiss {
+		t.Fatalf("store failure result = %v", result)
+	}
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("gather: %v", err)
+	}
+	for _, f := range families {
+		if f.GetName() == "spawn_net_cache_store_errors_total" {
+			if got := f.GetMetric()[0].GetCounter().GetValue(); got != 2 {
+				t.Fatalf("store errors = %v, want 2", got)
+			}
+			return
+		}
+	}
+	t.Fatal("no store error metric")
+}
+
+func TestEgressProxy(t *testing.T) {
+	t.Parallel()
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("plain " + r.Header.Get("Proxy-Authorization")))
+	}))
+	defer plain.Close()
+	tlsSrv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("tunnel"))
+	}))
+	defer tlsSrv.Close()
+
+	ipPolicy, err := NewIPPolicy([]string{"127.0.0.0/8"}, nil)
+	if err != nil {
+		t.Fatalf("ip policy: %v", err)
+	}
+	events := observability.NewEventStream()
+	sub, cancel := events.Subscribe()
+	defer cancel()
+	tlsURL, _ := url.Parse(tlsSrv.URL)
+	tlsPort, _ := strconv.Atoi(tlsURL.Port())
+	proxy := NewProxy(ProxyConfig{
+		AgentID:     "agent-a",
+		Policy:      Policy{Allowlist: []string{"127.0.0.1"}},
+		IPPolicy:    ipPolicy,
+		Limiter:     NewLimiter("agent-a", RateLimitConfig{Host: RateLimit{Requests: 3, Per: time.Minute}}),
+		TunnelPorts: []int{tlsPort},
+		Events:      events,
+	})
+	if err := proxy.Start(); err != nil {
+		t.Fatalf("start proxy: %v", err)
+	}
+	defer proxy.Close()
+	if env := proxy.Env(); env["HTTPS_PROXY"] != proxy.URL() || env["NO_PROXY"] != "" {
+		t.Fatalf("unexpected proxy env %#v", env)
+	}
+
+	proxyURL, _ := url.Parse(proxy.URL())
+	transport := tlsSrv.Client().Transport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyURL(proxyURL)
+	client := &http.Client{Transport: transport}
+	fetch := func(u string) (int, string) {
+		t.Helper()
+		resp, err := client.Get(u)
+		if err != nil {
+			t.Fatalf("get %s: %v", u, err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(b)
+	}
+
+	if status, body := fetch(plain.URL); status != http.StatusOK || body != "plain " {
+		t.Fatalf("forwarded request: %d %q", status, body)
+	}
+	if status, body := fetch(tlsSrv.URL); status != http.StatusOK || body != "tunnel" {
+		t.Fatalf("tunneled request: %d %q", status, body)
+	}
+	if status, _ := fetch(strings.Replace(plain.URL, "127.0.0.1", "localhost", 1)); status != http.StatusForbidden {
+		t.Fatalf("expected denied host to get 403, got %d", status)
+	}
+	fetch(plain.URL)
+	if status, _ := fetch(plain.URL); status != http.StatusTooManyRequests {
+		t.Fatalf("expected rate limited request to get 429, got %d", status)
+	}
+	// Tunnels are logged once they close.
+	transport.CloseIdleConnections()
+	var conns []ProxyConnection
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if conns = proxy.Connections(); len(conns) == 5 {
+			break
+		}
+	}
+	if len(conns) != 5 {
+		t.Fatalf("expected 5 logged connections, got %#v", conns)
+	}
+	statuses := map[string]int{}
+	for _, c := range conns {
+		if c.Method == http.MethodConnect && (!c.Allowed || c.IP != "127.0.0.1" || c.BytesIn == 0 || c.BytesOut == 0) {
+			t.Fatalf("unexpected tunnel log %#v", c)
+		}
+		statuses[fmt.Sprintf("%s %d %t", c.Method, c.Status, c.Allowed)]++
+	}
+	want := map[string]int{"GET 200 true": 2, "CONNECT 200 true": 1, "GET 403 false": 1, "GET 429 false": 1}
+	for k, n := range want {
+		if statuses[k] != n {
+			t.Fatalf("expected %d %q connections, got %v", n, k, statuses)
+		}
+	}
+	select {
+	case ev := <-sub:
+		if ev.Type != "net.egress" || ev.Payload["agent"] != "agent-a" {
+			t.Fatalf("unexpected event %#v", ev)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected egress event")
+	}
+
+	// CONNECT only reaches the tunnel ports.
+	conn, err := stdnet.Dial("tcp", proxy.Addr())
+	if err != nil {
+		t.Fatalf("dial proxy: %v", err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %[1]s\r\n\r\n", plain.Listener.Addr())
+	if resp, err := http.ReadResponse(bufio.NewReader(conn), nil); err != nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("CONNECT to a non-tunnel port = %+v, %v", resp, err)
+	}
+
+	blocked := NewProxy(ProxyConfig{AgentID: "agent-b"})
+	if err := blocked.Start(); err != nil {
+		t.Fatalf("start proxy: %v", err)
+	}
+	defer blocked.Close()
+	blockedURL, _ := url.Parse(blocked.URL())
+	transport.Proxy = http.ProxyURL(blockedURL)
+	if status, _ := fetch(plain.URL); status != http.StatusForbidden {
+		t.Fatalf("expected loopback destination to be refused, got %d", status)
+	}
+}
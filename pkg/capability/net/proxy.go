@@ -0,0 +1,336 @@
+package net
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"spawn.dev/pkg/observability"
+	"spawn.dev/pkg/sandbox"
+)
+
+const maxProxyLog = 1000
+
+// hopHeaders are connection-specific and not forwarded by the proxy.
+var hopHeaders = []string{
+	"Connection", "Proxy-Connection", "Keep-Alive", "Proxy-Authenticate",
+	"Proxy-Authorization", "Te", "Trailer", "Transfer-Encoding", "Upgrade",
+}
+
+// DefaultTunnelPorts are the ports CONNECT tunnels may reach when
+// ProxyConfig.TunnelPorts is empty.
+var DefaultTunnelPorts = []int{443}
+
+// ProxyConfig configures an agent's egress proxy. Policy, IPPolicy and
+// Limiter are the ones used by the agent's net capability. TunnelPorts
+// lists the ports CONNECT may reach, DefaultTunnelPorts if empty, so the
+// proxy is not a way into arbitrary TCP services.
+type ProxyConfig struct {
+	AgentID     string
+	Addr        string
+	Policy      Policy
+	IPPolicy    *IPPolicy
+	Limiter     *Limiter
+	TunnelPorts []int
+	Logger      *zap.Logger
+	Events      *observability.EventStream
+}
+
+// ProxyConnection is the log record of one proxied request or tunnel.
+type ProxyConnection struct {
+	Agent    string        `json:"agent"`
+	Method   string        `json:"method"`
+	Host     string        `json:"host"`
+	IP       string        `json:"ip,omitempty"`
+	Allowed  bool          `json:"allowed"`
+	Reason   string        `json:"reason,omitempty"`
+	Status   int           `json:"status"`
+	BytesIn  int64         `json:"bytes_in"`
+	BytesOut int64         `json:"bytes_out"`
+	Duration time.Duration `json:"duration"`
+	Time     time.Time     `json:"time"`
+}
+
+// Proxy is a per-agent HTTP forward and CONNECT proxy that applies the
+// agent's net policy to processes running in its sandboxes.
+type Proxy struct {
+	cfg       ProxyConfig
+	dialer    *net.Dialer
+	transport *http.Transport
+	ln        net.Listener
+	srv       *http.Server
+	mu        sync.Mutex
+	log       []ProxyConnection
+}
+
+// NewProxy returns a proxy; Start begins listening.
+func NewProxy(cfg ProxyConfig) *Proxy {
+	if cfg.Addr == "" {
+		cfg.Addr = "127.0.0.1:0"
+	}
+	if len(cfg.TunnelPorts) == 0 {
+		cfg.TunnelPorts = DefaultTunnelPorts
+	}
+	t := cfg.IPPolicy.transport()
+	return &Proxy{
+		cfg:       cfg,
+		dialer:    &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Control: cfg.IPPolicy.control},
+		transport: t,
+	}
+}
+
+// Start listens on the configured address and serves in the background.
+func (p *Proxy) Start() error {
+	ln, err := net.Listen("tcp", p.cfg.Addr)
+	if err != nil {
+		return fmt.Errorf("start egress proxy: %w", err)
+	}
+	p.ln = ln
+	p.srv = &http.Server{Handler: p, ReadHeaderTimeout: 30 * time.Second}
+	go func() { _ = p.srv.Serve(ln) }()
+	return nil
+}
+
+// Addr returns the listening address.
+func (p *Proxy) Addr() string {
+	if p.ln == nil {
+		return ""
+	}
+	return p.ln.Addr().String()
+}
+
+// URL returns the proxy URL to hand to sandboxed processes.
+func (p *Proxy) URL() string {
+	return "http://" + p.Addr()
+}
+
+// Close stops the proxy and its open tunnels.
+func (p *Proxy) Close() error {
+	if p.srv == nil {
+		return nil
+	}
+	p.transport.CloseIdleConnections()
+	return p.srv.Close()
+}
+
+// Connections returns the most recent connection log, oldest first.
+func (p *Proxy) Connections() []ProxyConnection {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]ProxyConnection(nil), p.log...)
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rec := ProxyConnection{Agent: p.cfg.AgentID, Method: r.Method, Time: time.Now().UTC()}
+	defer func() {
+		rec.Duration = time.Since(rec.Time)
+		p.record(rec)
+	}()
+
+	host := r.Host
+	if r.Method != http.MethodConnect {
+		if !r.URL.IsAbs() || (r.URL.Scheme != "http" && r.URL.Scheme != "https") {
+			rec.Status, rec.Reason = http.StatusBadRequest, "not a proxy request"
+			http.Error(w, rec.Reason, rec.Status)
+			return
+		}
+		host = r.URL.Host
+	}
+	rec.Host = host
+	if r.Method == http.MethodConnect && !p.tunnelAllowed(host) {
+		rec.Status, rec.Reason = http.StatusForbidden, "tunnel port not allowed"
+		http.Error(w, rec.Reason, rec.Status)
+		return
+	}
+	if status, reason := p.check(r.Context(), w, host); status != 0 {
+		rec.Status, rec.Reason = status, reason
+		return
+	}
+	rec.Allowed = true
+	if r.Method == http.MethodConnect {
+		p.tunnel(w, r, &rec)
+		return
+	}
+	p.forward(w, r, &rec)
+}
+
+// check applies the host policy and rate limits, writing the refusal if
+// there is one.
+func (p *Proxy) check(ctx context.Context, w http.ResponseWriter, host string) (int, string) {
+	if !p.cfg.Policy.Allows(host) {
+		reason := "host blocked by policy"
+		http.Error(w, reason, http.StatusForbidden)
+		return http.StatusForbidden, reason
+	}
+	if err := p.cfg.Limiter.Acquire(ctx, host); err != nil {
+		var limited *RateLimitedError
+		if errors.As(err, &limited) {
+			w.Header().Set("Retry-After", strconv.Itoa(int(limited.RetryAfter.Seconds())+1))
+		}
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return http.StatusTooManyRequests, err.Error()
+	}
+	return 0, ""
+}
+
+// tunnelAllowed reports whether a CONNECT to hostPort, port 443 if none is
+// given, may be opened.
+func (p *Proxy) tunnelAllowed(hostPort string) bool {
+	port := 443
+	if _, raw, err := net.SplitHostPort(hostPort); err == nil {
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return false
+		}
+		port = n
+	}
+	for _, allowed := range p.cfg.TunnelPorts {
+		if port == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Proxy) tunnel(w http.ResponseWriter, r *http.Request, rec *ProxyConnection) {
+	hostPort := r.Host
+	if _, _, err := net.SplitHostPort(hostPort); err != nil {
+		hostPort = net.JoinHostPort(hostPort, "443")
+	}
+	upstream, err := p.dialer.DialContext(r.Context(), "tcp", hostPort)
+	if err != nil {
+		rec.Allowed, rec.Status, rec.Reason = false, dialStatus(err), err.Error()
+		http.Error(w, err.Error(), rec.Status)
+		return
+	}
+	defer upstream.Close()
+	rec.IP = remoteIP(upstream)
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		rec.Status, rec.Reason = http.StatusInternalServerError, "hijacking not supported"
+		http.Error(w, rec.Reason, rec.Status)
+		return
+	}
+	client, buf, err := hj.Hijack()
+	if err != nil {
+		rec.Status, rec.Reason = http.StatusInternalServerError, err.Error()
+		return
+	}
+	defer client.Close()
+	rec.Status = http.StatusOK
+	if _, err := client.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+		return
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		// Bytes the client sent before the tunnel was up are still buffered.
+		n, _ := io.Copy(upstream, io.MultiReader(buf.Reader, client))
+		rec.BytesOut = n
+		if tcp, ok := upstream.(*net.TCPConn); ok {
+			_ = tcp.CloseWrite()
+		}
+	}()
+	rec.BytesIn, _ = io.Copy(client, upstream)
+	_ = client.Close()
+	wg.Wait()
+}
+
+func (p *Proxy) forward(w http.ResponseWriter, r *http.Request, rec *ProxyConnection) {
+	trace := &httptrace.ClientTrace{GotConn: func(info httptrace.GotConnInfo) { rec.IP = remoteIP(info.Conn) }}
+	out := r.Clone(httptrace.WithClientTrace(r.Context(), trace))
+	out.RequestURI = ""
+	removeHopHeaders(out.Header)
+	resp, err := p.transport.RoundTrip(out)
+	if err != nil {
+		rec.Allowed, rec.Status, rec.Reason = false, dialStatus(err), err.Error()
+		http.Error(w, err.Error(), rec.Status)
+		return
+	}
+	defer resp.Body.Close()
+	removeHopHeaders(resp.Header)
+	for k, v := range resp.Header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(resp.StatusCode)
+	rec.Status = resp.StatusCode
+	rec.BytesIn, _ = io.Copy(w, resp.Body)
+	rec.BytesOut = r.ContentLength
+}
+
+func (p *Proxy) record(rec ProxyConnection) {
+	p.mu.Lock()
+	if len(p.log) >= maxProxyLog {
+		p.log = p.log[1:]
+	}
+	p.log = append(p.log, rec)
+	p.mu.Unlock()
+
+	if p.cfg.Logger != nil {
+		p.cfg.Logger.Info("egress",
+			zap.String("agent", rec.Agent),
+			zap.String("method", rec.Method),
+			zap.String("host", rec.Host),
+			zap.String("ip", rec.IP),
+			zap.Bool("allowed", rec.Allowed),
+			zap.String("reason", rec.Reason),
+			zap.Int("status", rec.Status),
+			zap.Int64("bytes_in", rec.BytesIn),
+			zap.Int64("bytes_out", rec.BytesOut),
+			zap.Duration("duration", rec.Duration),
+		)
+	}
+	if p.cfg.Events != nil {
+		p.cfg.Events.Publish(observability.Event{
+			Type: "net.egress",
+			Payload: map[string]interface{}{
+				"agent": rec.Agent, "method": rec.Method, "host": rec.Host, "ip": rec.IP,
+				"allowed": rec.Allowed, "reason": rec.Reason, "status": rec.Status,
+			},
+			Timestamp: rec.Time,
+		})
+	}
+}
+
+// Env returns the proxy variables for sandboxed processes.
+func (p *Proxy) Env() map[string]string {
+	return sandbox.ProxyEnv(p.URL())
+}
+
+func dialStatus(err error) int {
+	var blocked *BlockedIPError
+	if errors.As(err, &blocked) {
+		return http.StatusForbidden
+	}
+	return http.StatusBadGateway
+}
+
+func removeHopHeaders(h http.Header) {
+	for _, f := range h.Values("Connection") {
+		for _, name := range strings.Split(f, ",") {
+			h.Del(strings.TrimSpace(name))
+		}
+	}
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+}
+
+func remoteIP(c net.Conn) string {
+	if addr, ok := c.RemoteAddr().(*net.TCPAddr); ok {
+		return addr.IP.String()
+	}
+	return ""
+}
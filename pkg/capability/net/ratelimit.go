@@ -0,0 +1,255 @@
+package net
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Rate limit scopes.
+const (
+	ScopeAgent = "agent"
+	ScopeHost  = "host"
+)
+
+// RateLimitConfig configures token buckets for one agent. A zero RateLimit
+// disables that scope. With Wait set, requests block until a token is free
+// or the request deadline would pass; otherwise they fail immediately.
+type RateLimitConfig struct {
+	Agent RateLimit
+	Host  RateLimit
+	Wait  bool
+}
+
+// RateLimitedError reports which bucket was empty and when to retry.
+type RateLimitedError struct {
+	Scope      string
+	Host       string
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitedError) Error() string {
+	if e.Scope == ScopeHost {
+		return fmt.Sprintf("rate limit for host %s exceeded, retry after %s", e.Host, e.RetryAfter.Round(time.Millisecond))
+	}
+	return fmt.Sprintf("agent rate limit exceeded, retry after %s", e.RetryAfter.Round(time.Millisecond))
+}
+
+// bucket is a token bucket holding up to burst tokens, refilled at rate
+// tokens per second. Tokens go negative while waiters hold reservations.
+type bucket struct {
+	tokens float64
+	burst  float64
+	rate   float64
+	last   time.Time
+}
+
+func newBucket(l RateLimit, now time.Time) *bucket {
+	if l.Requests <= 0 || l.Per <= 0 {
+		return nil
+	}
+	return &bucket{tokens: float64(l.Requests), burst: float64(l.Requests), rate: float64(l.Requests) / l.Per.Seconds(), last: now}
+}
+
+func (b *bucket) advance(now time.Time) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens += elapsed * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+}
+
+// delay is how long until a token is available.
+func (b *bucket) delay() time.Duration {
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// Limiter enforces per-agent and per-host token buckets.
+type Limiter struct {
+	mu      sync.Mutex
+	cfg     RateLimitConfig
+	agentID string
+	agent   *bucket
+	hosts   map[string]*bucket
+	metrics *limiterMetrics
+	now     func() time.Time
+}
+
+// NewLimiter returns a limiter for agentID. agentID labels its metrics.
+func NewLimiter(agentID string, cfg RateLimitConfig) *Limiter {
+	l := &Limiter{cfg: cfg, agentID: agentID, hosts: map[string]*bucket{}, now: time.Now}
+	l.agent = newBucket(cfg.Agent, l.now())
+	return l
+}
+
+// Acquire takes one token from the agent bucket and the bucket for host.
+func (l *Limiter) Acquire(ctx context.Context, host string) error {
+	if l == nil {
+		return nil
+	}
+	host = normalizeHost(host)
+	l.mu.Lock()
+	now := l.now()
+	hb := l.hosts[host]
+	if hb == nil {
+		if hb = newBucket(l.cfg.Host, now); hb != nil {
+			l.hosts[host] = hb
+		}
+	}
+	var wait time.Duration
+	scope := ""
+	for _, s := range []struct {
+		name string
+		b    *bucket
+	}{{ScopeAgent, l.agent}, {ScopeHost, hb}} {
+		if s.b == nil {
+			continue
+		}
+		s.b.advance(now)
+		if d := s.b.delay(); d > wait {
+			wait, scope = d, s.name
+		}
+	}
+	if wait > 0 {
+		limited := &RateLimitedError{Scope: scope, Host: host, RetryAfter: wait}
+		deadline, hasDeadline := ctx.Deadline()
+		if !l.cfg.Wait || (hasDeadline && now.Add(wait).After(deadline)) {
+			l.metrics.limited(l.agentID, scope, "rejected")
+			l.mu.Unlock()
+			return limited
+		}
+		l.metrics.limited(l.agentID, scope, "waited")
+	}
+	l.take(hb, -1)
+	l.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		l.take(hb, 1)
+		l.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// take adds delta tokens to the agent bucket and the host bucket hb and
+// updates the gauges. Callers hold l.mu.
+func (l *Limiter) take(hb *bucket, delta float64) {
+	if l.agent != nil {
+		l.agent.tokens += delta
+		l.metrics.tokens(l.agentID, ScopeAgent, l.agent.tokens)
+	}
+	if hb != nil {
+		hb.tokens += delta
+		l.metrics.tokens(l.agentID, ScopeHost, l.sweepHosts(l.now()))
+	}
+}
+
+// sweepHosts drops the host buckets that have refilled, which behave just
+// like the fresh bucket the host would get on its next request, and
+// returns the fewest tokens left in any other. Callers hold l.mu.
+func (l *Limiter) sweepHosts(now time.Time) float64 {
+	least := float64(l.cfg.Host.Requests)
+	for host, b := range l.hosts {
+		b.advance(now)
+		if b.tokens >= b.burst {
+			delete(l.hosts, host)
+			continue
+		}
+		least = min(least, b.tokens)
+	}
+	return least
+}
+
+// limitTransport takes a token for every request that leaves the cache,
+// including each redirect hop.
+type limitTransport struct {
+	limiter *Limiter
+	next    http.RoundTripper
+}
+
+func (t *limitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.limiter.Acquire(req.Context(), req.URL.Hostname()); err != nil {
+		return nil, err
+	}
+	return t.next.RoundTrip(req)
+}
+
+// RegisterMetrics exposes limiter state on reg. Several limiters may share
+// one registry; they are told apart by their agent label.
+func (l *Limiter) RegisterMetrics(reg prometheus.Registerer) error {
+	m, err := registerLimiterMetrics(reg)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	l.metrics = m
+	l.mu.Unlock()
+	return nil
+}
+
+type limiterMetrics struct {
+	available *prometheus.GaugeVec
+	throttled *prometheus.CounterVec
+}
+
+func registerLimiterMetrics(reg prometheus.Registerer) (*limiterMetrics, error) {
+	available := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "spawn_net_rate_limit_tokens",
+		Help: "Tokens currently available in net rate limit buckets; for the host scope, the fewest left for any host",
+	}, []string{"agent", "scope"})
+	throttled := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "spawn_net_rate_limited_total",
+		Help: "Net requests that hit a rate limit, by outcome",
+	}, []string{"agent", "scope", "outcome"})
+	var err error
+	if available, err = registerOrExisting(reg, available); err != nil {
+		return nil, err
+	}
+	if throttled, err = registerOrExisting(reg, throttled); err != nil {
+		return nil, err
+	}
+	return &limiterMetrics{available: available, throttled: throttled}, nil
+}
+
+func registerOrExisting[T prometheus.Collector](reg prometheus.Registerer, c T) (T, error) {
+	if err := reg.Register(c); err != nil {
+		var are prometheus.AlreadyRegisteredError
+		if errors.As(err, &are) {
+			if existing, ok := are.ExistingCollector.(T); ok {
+				return existing, nil
+			}
+		}
+		return c, fmt.Errorf("register net metrics: %w", err)
+	}
+	return c, nil
+}
+
+func (m *limiterMetrics) tokens(agent, scope string, v float64) {
+	if m != nil {
+		m.available.WithLabelValues(agent, scope).Set(v)
+	}
+}
+
+func (m *limiterMetrics) limited(agent, scope, outcome string) {
+	if m != nil {
+		m.throttled.WithLabelValues(agent, scope, outcome).Inc()
+	}
+}
@@ -0,0 +1,301 @@
+package net
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"spawn.dev/pkg/capability"
+)
+
+const (
+	defaultUserAgent    = "spawn-net-capability/1.0"
+	defaultMaxBody      = 1 << 20
+	defaultMaxRedirects = 10
+	downloadDir         = "downloads"
+)
+
+var errRedirectBlocked = errors.New("redirect blocked by policy")
+
+// request performs an HTTP request described by params:
+//
+//	method, url, headers, query, json | form | body, max_bytes,
+//	follow_redirects, timeout_ms, save_to, force_refresh
+func (c *Capability) request(ctx context.Context, params map[string]interface{}) *capability.Response {
+	method := strings.ToUpper(capability.StringParam(params, "method", http.MethodGet))
+	rawURL, _ := params["url"].(string)
+	targetURL, err := url.Parse(rawURL)
+	if err != nil || targetURL.Scheme == "" || targetURL.Host == "" {
+		return capability.Failure("invalid_url", "valid absolute url is required")
+	}
+	if targetURL.Scheme != "http" && targetURL.Scheme != "https" {
+		return capability.Failure("invalid_url", "only http/https are allowed")
+	}
+	if !c.allowed(targetURL.Hostname()) {
+		return capability.Failure("blocked", "url blocked by policy")
+	}
+	if q, ok := params["query"].(map[string]interface{}); ok {
+		values := targetURL.Query()
+		for k, v := range q {
+			for _, s := range stringValues(v) {
+				values.Add(k, s)
+			}
+		}
+		targetURL.RawQuery = values.Encode()
+	}
+	body, contentType, err := requestBody(params)
+	if err != nil {
+		return capability.Failure("invalid_params", err.Error())
+	}
+	if ms := capability.IntParam(params, "timeout_ms", 0); ms > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(ms)*time.Millisecond)
+		defer cancel()
+	}
+
+	var remoteIP, cacheResult string
+	if force, _ := params["force_refresh"].(bool); force {
+		ctx = context.WithValue(ctx, forceRefreshKey, true)
+	}
+	if c.cache != nil {
+		ctx = context.WithValue(ctx, cacheResultKey, &cacheResult)
+	}
+	trace := &httptrace.ClientTrace{GotConn: func(info httptrace.GotConnInfo) {
+		if addr, ok := info.Conn.RemoteAddr().(*net.TCPAddr); ok {
+			remoteIP = addr.IP.String()
+		}
+	}}
+	httpReq, err := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, trace), method, targetURL.String(), body)
+	if err != nil {
+		return capability.Failure("invalid_params", err.Error())
+	}
+	httpReq.Header.Set("User-Agent", c.userAgent)
+	if contentType != "" {
+		httpReq.Header.Set("Content-Type", contentType)
+	}
+	if h, ok := params["headers"].(map[string]interface{}); ok {
+		for k, v := range h {
+			httpReq.Header.Del(k)
+			for _, s := range stringValues(v) {
+				httpReq.Header.Add(k, s)
+			}
+		}
+	}
+
+	var redirects []string
+	client := *c.http
+	follow := true
+	if v, ok := params["follow_redirects"].(bool); ok {
+		follow = v
+	}
+	client.CheckRedirect = func(next *http.Request, via []*http.Request) error {
+		if !follow {
+			return http.ErrUseLastResponse
+		}
+		if len(via) > c.maxRedirects {
+			return fmt.Errorf("stopped after %d redirects", c.maxRedirects)
+		}
+		if next.URL.Scheme != "http" && next.URL.Scheme != "https" {
+			return fmt.Errorf("%w: %s", errRedirectBlocked, next.URL.Redacted())
+		}
+		if !c.allowed(next.URL.Hostname()) {
+			return fmt.Errorf("%w: %s", errRedirectBlocked, next.URL.Hostname())
+		}
+		redirects = append(redirects, next.URL.String())
+		return nil
+	}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		if errors.Is(err, errRedirectBlocked) {
+			return capability.Failure("blocked", err.Error())
+		}
+		if errors.Is(err, ErrCassetteMiss) {
+			return capability.Failure("cassette_miss", err.Error())
+		}
+		var blocked *BlockedIPError
+		if errors.As(err, &blocked) {
+			resp := capability.Failure("blocked", blocked.Error())
+			resp.Data = map[string]interface{}{"ip": blocked.IP.String()}
+			return resp
+		}
+		var limited *RateLimitedError
+		if errors.As(err, &limited) {
+			return rateLimited(limited)
+		}
+		return capability.Failure("http_failed", err.Error())
+	}
+	defer resp.Body.Close()
+
+	limit := c.maxBody
+	if n := int64(capability.IntParam(params, "max_bytes", 0)); n > 0 && n < limit {
+		limit = n
+	}
+	payload, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return capability.Failure("http_failed", err.Error())
+	}
+	truncated := int64(len(payload)) > limit
+	if truncated {
+		payload = payload[:limit]
+	}
+
+	data := map[string]interface{}{
+		"status":       resp.StatusCode,
+		"url":          resp.Request.URL.String(),
+		"host":         resp.Request.URL.Hostname(),
+		"ip":           remoteIP,
+		"headers":      resp.Header,
+		"content_type": resp.Header.Get("Content-Type"),
+		"body_bytes":   len(payload),
+		"truncated":    truncated,
+	}
+	if len(redirects) > 0 {
+		data["redirects"] = redirects
+	}
+	if cacheResult != "" {
+		data["cache"] = cacheResult
+	}
+	if isText(resp.Header.Get("Content-Type"), payload) {
+		data["body"] = string(payload)
+		return &capability.Response{Success: true, Data: data}
+	}
+	// A partial binary is of no use and its hash would describe a file that
+	// does not exist, so it is neither saved nor returned.
+	if truncated {
+		failed := capability.Failure("body_too_large", fmt.Sprintf("binary response exceeds %d bytes", limit))
+		failed.Data = data
+		return failed
+	}
+
+	sum := sha256.Sum256(payload)
+	data["sha256"] = hex.EncodeToString(sum[:])
+	if c.files == nil {
+		data["body_base64"] = base64.StdEncoding.EncodeToString(payload)
+		return &capability.Response{Success: true, Data: data}
+	}
+	dest := capability.StringParam(params, "save_to", "")
+	if dest == "" {
+		dest = path.Join(downloadDir, hex.EncodeToString(sum[:8])+extension(resp.Header.Get("Content-Type"), resp.Request.URL.Path))
+	}
+	saved, err := c.files.Execute(ctx, &capability.Request{Action: "write", Params: map[string]interface{}{"path": dest, "content": string(payload)}})
+	if err != nil {
+		return capability.Failure("save_failed", err.Error())
+	}
+	if !saved.Success {
+		return capability.Failure("save_failed", saved.Error.Message)
+	}
+	data["saved_to"] = dest
+	return &capability.Response{Success: true, Data: data}
+}
+
+// requestBody builds the body from exactly one of "json", "form" or "body".
+func requestBody(params map[string]interface{}) (io.Reader, string, error) {
+	var set []string
+	for _, k := range []string{"json", "form", "body"} {
+		if _, ok := params[k]; ok {
+			set = append(set, k)
+		}
+	}
+	if len(set) > 1 {
+		return nil, "", fmt.Errorf("only one of json, form or body may be set, got %s", strings.Join(set, ", "))
+	}
+	if v, ok := params["json"]; ok {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, "", fmt.Errorf("encode json body: %w", err)
+		}
+		return bytes.NewReader(b), "application/json", nil
+	}
+	if v, ok := params["form"]; ok {
+		form, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, "", fmt.Errorf("form must be an object")
+		}
+		values := url.Values{}
+		keys := make([]string, 0, len(form))
+		for k := range form {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			for _, s := range stringValues(form[k]) {
+				values.Add(k, s)
+			}
+		}
+		return strings.NewReader(values.Encode()), "application/x-www-form-urlencoded", nil
+	}
+	if v, ok := params["body"]; ok {
+		s, ok := v.(string)
+		if !ok {
+			return nil, "", fmt.Errorf("body must be a string")
+		}
+		return strings.NewReader(s), "", nil
+	}
+	return nil, "", nil
+}
+
+// stringValues flattens a scalar or list parameter into strings.
+func stringValues(v interface{}) []string {
+	switch t := v.(type) {
+	case nil:
+		return nil
+	case string:
+		return []string{t}
+	case []string:
+		return t
+	case []interface{}:
+		out := make([]string, 0, len(t))
+		for _, item := range t {
+			out = append(out, stringValues(item)...)
+		}
+		return out
+	default:
+		return []string{fmt.Sprint(t)}
+	}
+}
+
+// isText reports whether a response should be returned inline.
+func isText(contentType string, body []byte) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case strings.HasPrefix(mediaType, "text/"),
+		strings.HasSuffix(mediaType, "+json"),
+		strings.HasSuffix(mediaType, "+xml"):
+		return true
+	case mediaType == "application/json",
+		mediaType == "application/xml",
+		mediaType == "application/javascript",
+		mediaType == "application/x-www-form-urlencoded":
+		return true
+	case mediaType == "":
+		return !bytes.ContainsRune(body[:min(len(body), 8000)], 0)
+	default:
+		return strings.HasPrefix(http.DetectContentType(body), "text/")
+	}
+}
+
+func extension(contentType, urlPath string) string {
+	if ext := path.Ext(urlPath); ext != "" && len(ext) <= 6 {
+		return ext
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if exts, _ := mime.ExtensionsByType(mediaType); len(exts) > 0 {
+		return exts[0]
+	}
+	return ".bin"
+}
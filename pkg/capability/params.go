@@ -0,0 +1,31 @@
+package capability
+
+// StringParam reads a string parameter, returning def when it is missing
+// or empty.
+func StringParam(params map[string]interface{}, key, def string) string {
+	if v, ok := params[key].(string); ok && v != "" {
+		return v
+	}
+	return def
+}
+
+// IntParam reads an integer parameter, accepting JSON-decoded float64
+// values, and returns def when it is missing.
+func IntParam(params map[string]interface{}, key string, def int) int {
+	switch v := params[key].(type) {
+	case int:
+		return v
+	case int64:
+		return int(v)
+	case float64:
+		return int(v)
+	default:
+		return def
+	}
+}
+
+// Failure returns an unsuccessful response carrying an error code and
+// message.
+func Failure(code, message string) *Response {
+	return &Response{Success: false, Error: &Error{Code: code, Message: message}}
+}
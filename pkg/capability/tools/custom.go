@@ -0,0 +1,277 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"spawn.dev/pkg/sandbox"
+)
+
+// Handler schemes of custom tools.
+const (
+	SchemeExec = "exec"
+	SchemeHTTP = "http"
+	SchemeWasm = "wasm"
+)
+
+// DefaultCustomTimeout bounds a custom tool call without a timeout.
+const DefaultCustomTimeout = 30 * time.Second
+
+// DefaultWasmRuntime is the WASI runtime that runs wasm:// modules.
+const DefaultWasmRuntime = "wasmtime"
+
+// maxResponseBytes caps the output read from a custom handler, whether its
+// stdout or an HTTP response body; larger results fail the call.
+const maxResponseBytes = 8 << 20
+
+// handlerClient posts to http:// and https:// handlers. Handlers are local
+// services, so it only connects to loopback addresses and does not follow
+// redirects: a handler cannot send the input on to another host.
+var handlerClient = &http.Client{
+	CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{Timeout: 10 * time.Second, Control: loopbackOnly}).DialContext,
+	},
+}
+
+// loopbackOnly refuses connections to addresses other than loopback. It
+// runs after name resolution, so a hostname resolving elsewhere is refused.
+func loopbackOnly(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return fmt.Errorf("handler address %s is not loopback", host)
+	}
+	return nil
+}
+
+// CustomTool is a tool declared in an agent's tools.custom config. Its
+// Handler is one of:
+//
+//   - exec://script.py runs a script or binary in the sandbox with the
+//     input as JSON on stdin and reads its result as JSON from stdout.
+//     .py, .js and .sh scripts run under python3, node and sh.
+//   - http://host/path (or https) POSTs the input as JSON to a service on
+//     a loopback address and reads a JSON response. Redirects are not
+//     followed.
+//   - wasm://module.wasm runs a WASI module in the sandbox, exchanging JSON
+//     on stdin and stdout as exec:// does.
+//
+// Relative paths are resolved against the directory set by SetToolDir.
+type CustomTool struct {
+	Name         string
+	Description  string
+	Schema       map[string]interface{}
+	OutputSchema map[string]interface{}
+	Handler      string
+	Timeout      time.Duration
+}
+
+// ParseHandler splits a handler into its scheme and target, checking the
+// scheme is supported.
+func ParseHandler(handler string) (scheme, target string, err error) {
+	scheme, target, ok := strings.Cut(handler, "://")
+	if !ok || target == "" {
+		return "", "", fmt.Errorf("handler %q must be exec://, http://, https:// or wasm://", handler)
+	}
+	switch scheme {
+	case SchemeExec, SchemeWasm:
+		return scheme, target, nil
+	case SchemeHTTP, "https":
+		return SchemeHTTP, handler, nil
+	default:
+		return "", "", fmt.Errorf("handler %q has unsupported scheme %q", handler, scheme)
+	}
+}
+
+// errNoSandbox fails exec:// and wasm:// calls when no sandbox is set;
+// handlers never fall back to running on the host.
+var errNoSandbox = errors.New("no sandbox is configured for exec:// and wasm:// handlers")
+
+// SetSandbox sets the sandbox exec:// and wasm:// handlers run in, normally
+// the agent's. Without one their calls fail.
+func (c *Capability) SetSandbox(sb sandbox.Sandbox) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sandbox = sb
+}
+
+// SetToolDir sets the directory relative handler paths are resolved
+// against, normally the agent config's directory. It applies to tools
+// registered afterwards.
+func (c *Capability) SetToolDir(dir string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.toolDir = dir
+}
+
+// SetWasmRuntime sets the WASI runtime command for wasm:// handlers,
+// DefaultWasmRuntime by default.
+func (c *Capability) SetWasmRuntime(command string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.wasmRuntime = command
+}
+
+// RegisterCustom registers a custom tool whose handler calls are bounded
+// by its timeout.
+func (c *Capability) RegisterCustom(tool CustomTool) error {
+	scheme, target, err := ParseHandler(tool.Handler)
+	if err != nil {
+		return fmt.Errorf("register tool %s: %w", tool.Name, err)
+	}
+	timeout := tool.Timeout
+	if timeout <= 0 {
+		timeout = DefaultCustomTimeout
+	}
+	var call func(context.Context, []byte, time.Duration) ([]byte, error)
+	switch scheme {
+	case SchemeExec:
+		path := c.toolPath(target)
+		call = func(ctx context.Context, input []byte, timeout time.Duration) ([]byte, error) {
+			return c.runSandboxed(ctx, scriptCommand(path), input, timeout)
+		}
+	case SchemeWasm:
+		path := c.toolPath(target)
+		call = func(ctx context.Context, input []byte, timeout time.Duration) ([]byte, error) {
+			c.mu.Lock()
+			runtime := c.wasmRuntime
+			c.mu.Unlock()
+			if runtime == "" {
+				runtime = DefaultWasmRuntime
+			}
+			return c.runSandboxed(ctx, []string{runtime, "run", path}, input, timeout)
+		}
+	case SchemeHTTP:
+		call = func(ctx context.Context, input []byte, _ time.Duration) ([]byte, error) {
+			return c.post(ctx, target, input)
+		}
+	}
+	return c.Register(Tool{
+		Name:         tool.Name,
+		Description:  tool.Description,
+		Schema:       tool.Schema,
+		OutputSchema: tool.OutputSchema,
+		Handler: func(ctx context.Context, input map[string]interface{}) (interface{}, error) {
+			if input == nil {
+				input = map[string]interface{}{}
+			}
+			body, err := json.Marshal(input)
+			if err != nil {
+				return nil, &Error{Code: CodeInvalidInput, Message: fmt.Sprintf("encode input: %v", err)}
+			}
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			out, err := call(ctx, body, timeout)
+			if ctx.Err() == context.DeadlineExceeded {
+				return nil, &Error{Code: CodeTimeout, Message: fmt.Sprintf("tool %s timed out after %s", tool.Name, timeout)}
+			}
+			if err != nil {
+				return nil, &Error{Code: CodeHandlerFailed, Message: fmt.Sprintf("tool %s: %v", tool.Name, err)}
+			}
+			var result interface{}
+			if err := json.Unmarshal(out, &result); err != nil {
+				return nil, &Error{Code: CodeInvalidOutput, Message: fmt.Sprintf("tool %s returned invalid JSON: %v", tool.Name, err)}
+			}
+			return result, nil
+		},
+	})
+}
+
+// toolPath resolves a handler path against the tool directory.
+func (c *Capability) toolPath(path string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if filepath.IsAbs(path) || c.toolDir == "" {
+		return path
+	}
+	return filepath.Join(c.toolDir, path)
+}
+
+// scriptCommand returns the command line running a script, choosing the
+// interpreter by extension.
+func scriptCommand(path string) []string {
+	switch filepath.Ext(path) {
+	case ".py":
+		return []string{"python3", path}
+	case ".js", ".mjs":
+		return []string{"node", path}
+	case ".sh":
+		return []string{"sh", path}
+	default:
+		return []string{path}
+	}
+}
+
+// runSandboxed runs argv in the sandbox with input on stdin and returns
+// its stdout. A non-zero exit is a handler failure carrying stderr.
+func (c *Capability) runSandboxed(ctx context.Context, argv []string, input []byte, timeout time.Duration) ([]byte, error) {
+	c.mu.Lock()
+	sb := c.sandbox
+	c.mu.Unlock()
+	if sb == nil {
+		return nil, errNoSandbox
+	}
+	res, err := sb.Exec(ctx, &sandbox.Command{Path: argv[0], Args: argv[1:], Timeout: timeout, Stdin: input, SplitOutput: true, MaxOutput: maxResponseBytes})
+	if err != nil {
+		return nil, err
+	}
+	if res.Truncated && res.ExitCode == 0 {
+		return nil, fmt.Errorf("output exceeds %d bytes", maxResponseBytes)
+	}
+	if res.ExitCode != 0 {
+		msg := strings.TrimSpace(res.Stderr)
+		if msg == "" {
+			msg = "no error output"
+		}
+		return nil, fmt.Errorf("exit status %d: %s", res.ExitCode, clip(msg, 2000))
+	}
+	return []byte(res.Stdout), nil
+}
+
+// post sends input to an HTTP handler and returns the response body. Non-2xx
+// responses, including redirects, are handler failures carrying the start
+// of the body.
+func (c *Capability) post(ctx context.Context, url string, input []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(input))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	resp, err := handlerClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxResponseBytes && resp.StatusCode/100 == 2 {
+		return nil, fmt.Errorf("response exceeds %d bytes", maxResponseBytes)
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("%s: %s", resp.Status, clip(strings.TrimSpace(string(body)), 2000))
+	}
+	return body, nil
+}
+
+func clip(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
@@ -0,0 +1,163 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"spawn.dev/pkg/capability"
+	"spawn.dev/pkg/sandbox"
+)
+
+func writeScript(t *testing.T, dir, name, body string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0o755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func invoke(t *testing.T, c *Capability, name string, input map[string]interface{}) *capability.Response {
+	t.Helper()
+	resp, err := c.Execute(context.Background(), &capability.Request{
+		Action: "invoke",
+		Params: map[string]interface{}{"name": name, "input": input},
+	})
+	if err != nil {
+		t.Fatalf("invoke %s: %v", name, err)
+	}
+	return resp
+}
+
+func TestCustomTools(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	// The script echoes its input back with a greeting, and logs to stderr.
+	writeScript(t, dir, "greet.sh", `read -r input; echo "debug" >&2; printf '{"greeting":"hi","input":%s}' "$input"`)
+	writeScript(t, dir, "fail.sh", `echo "bad things" >&2; exit 3`)
+	writeScript(t, dir, "text.sh", `echo not json`)
+	writeScript(t, dir, "slow.sh", `sleep 5`)
+	writeScript(t, dir, "huge.sh", `head -c 9000000 /dev/zero | tr '\0' 'x'`)
+	// A stand-in WASI runtime: "run <module>" echoes stdin.
+	writeScript(t, dir, "fakewasm", "#!/bin/sh\n"+`[ "$1" = run ] && [ -n "$2" ] && cat`)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/add", http.StatusTemporaryRedirect)
+			return
+		}
+		if r.URL.Path != "/add" {
+			http.NotFound(w, r)
+			return
+		}
+		var in map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil || r.Method != http.MethodPost {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"sum": in["a"].(float64) + in["b"].(float64)})
+	}))
+	defer srv.Close()
+
+	c := New()
+	sb, err := sandbox.NewNativeRuntime().Create(context.Background(), sandbox.DefaultConfig())
+	if err != nil {
+		t.Fatalf("create sandbox: %v", err)
+	}
+	c.SetToolDir(dir)
+	c.SetWasmRuntime(filepath.Join(dir, "fakewasm"))
+	schema := map[string]interface{}{"type": "object", "required": []interface{}{"name"}}
+	for _, tool := range []CustomTool{
+		{Name: "greet", Schema: schema, Handler: "exec://greet.sh"},
+		{Name: "fail", Handler: "exec://fail.sh"},
+		{Name: "text", Handler: "exec://text.sh"},
+		{Name: "slow", Handler: "exec://slow.sh", Timeout: 100 * time.Millisecond},
+		{Name: "huge", Handler: "exec://huge.sh"},
+		{Name: "add", Handler: srv.URL + "/add"},
+		{Name: "missing", Handler: srv.URL + "/missing"},
+		{Name: "redirect", Handler: srv.URL + "/redirect"},
+		{Name: "remote", Handler: "http://192.0.2.1/tool"},
+		{Name: "echo", Handler: "wasm://echo.wasm"},
+	} {
+		if err := c.RegisterCustom(tool); err != nil {
+			t.Fatalf("register %s: %v", tool.Name, err)
+		}
+	}
+
+	// Without a sandbox, exec:// handlers fail rather than run on the host.
+	if resp := invoke(t, c, "greet", map[string]interface{}{"name": "ada"}); resp.Success || !strings.Contains(resp.Error.Message, "no sandbox") {
+		t.Fatalf("greet without a sandbox = %+v", resp)
+	}
+	if err := sb.Start(context.Background()); err != nil {
+		t.Fatalf("start sandbox: %v", err)
+	}
+	c.SetSandbox(sb)
+
+	resp := invoke(t, c, "greet", map[string]interface{}{"name": "ada"})
+	out, _ := resp.Data.(map[string]interface{})
+	if !resp.Success || out["greeting"] != "hi" || out["input"].(map[string]interface{})["name"] != "ada" {
+		t.Fatalf("greet = %+v", resp)
+	}
+	if resp := invoke(t, c, "add", map[string]interface{}{"a": 1, "b": 2}); !resp.Success || resp.Data.(map[string]interface{})["sum"] != 3.0 {
+		t.Fatalf("add = %+v", resp)
+	}
+	if resp := invoke(t, c, "echo", map[string]interface{}{"x": "y"}); !resp.Success || resp.Data.(map[string]interface{})["x"] != "y" {
+		t.Fatalf("echo = %+v", resp.Error)
+	}
+
+	cases := []struct {
+		tool, code, message string
+		input               map[string]interface{}
+	}{
+		{"greet", CodeInvalidInput, "/name: is required", map[string]interface{}{}},
+		{"fail", CodeHandlerFailed, "bad things", nil},
+		{"text", CodeInvalidOutput, "invalid JSON", nil},
+		{"slow", CodeTimeout, "timed out", nil},
+		{"huge", CodeHandlerFailed, "output exceeds", nil},
+		{"missing", CodeHandlerFailed, "404", map[string]interface{}{}},
+		{"redirect", CodeHandlerFailed, "307", map[string]interface{}{"a": 1, "b": 2}},
+		{"remote", CodeHandlerFailed, "not loopback", map[string]interface{}{}},
+	}
+	for _, tc := range cases {
+		resp := invoke(t, c, tc.tool, tc.input)
+		if resp.Success || resp.Error.Code != tc.code || !strings.Contains(resp.Error.Message, tc.message) {
+			t.Fatalf("%s = %+v, want %s containing %q", tc.tool, resp.Error, tc.code, tc.message)
+		}
+	}
+
+	for _, handler := range []string{"", "script.py", "ftp://x", "exec://"} {
+		if err := c.RegisterCustom(CustomTool{Name: "bad", Handler: handler}); err == nil {
+			t.Fatalf("handler %q accepted", handler)
+		}
+	}
+}
+
+func TestRegisterWhileInvoking(t *testing.T) {
+	t.Parallel()
+	c := New()
+	echo := func(_ context.Context, input map[string]interface{}) (interface{}, error) { return input, nil }
+	if err := c.Register(Tool{Name: "echo", Handler: echo}); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			_ = c.Register(Tool{Name: fmt.Sprintf("tool%d", i), Handler: echo})
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		if resp := invoke(t, c, "echo", nil); !resp.Success {
+			t.Fatalf("echo = %+v", resp.Error)
+		}
+	}
+	<-done
+	if n := len(c.List()); n != 101 {
+		t.Fatalf("listed %d tools, want 101", n)
+	}
+}
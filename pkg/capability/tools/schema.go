@@ -1,13 +1,998 @@
 package tools
 
-import "fmt"
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/mail"
+	"net/netip"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
 
-// ValidateInput performs minimal schema validation.
-func ValidateInput(required []string, input map[string]interface{}) error {
-	for _, key := range required {
-		if _, ok := input[key]; !ok {
-			return fmt.Errorf("validate input: missing required field %q", key)
+// FieldError is one schema violation. Path is a JSON Pointer to the
+// failing value, "" for the value itself.
+type FieldError struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every violation found in a value, so a model can
+// fix them all on its next turn.
+type ValidationError struct {
+	Errors []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	parts := make([]string, len(e.Errors))
+	for i, fe := range e.Errors {
+		path := fe.Path
+		if path == "" {
+			path = "(root)"
+		}
+		parts[i] = path + ": " + fe.Message
+	}
+	return strings.Join(parts, "; ")
+}
+
+// JSONSchema is a compiled JSON Schema (draft 2020-12). It supports the
+// type, enum, const, object, array, string and number keywords, format,
+// allOf, anyOf, oneOf, not, if/then/else, dependentRequired, default and
+// $ref to "#" and "#/$defs/...". Unknown formats and keywords are ignored,
+// as the draft allows.
+type JSONSchema struct {
+	root *schemaNode
+	doc  map[string]interface{}
+	refs map[string]*schemaNode
+}
+
+type patternSchema struct {
+	re     *regexp.Regexp
+	schema *schemaNode
+}
+
+type schemaNode struct {
+	// always is set for the boolean schemas true and false.
+	always *bool
+
+	types    []string
+	enum     []interface{}
+	constVal interface{}
+	hasConst bool
+	def      interface{}
+	hasDef   bool
+	ref      string
+
+	properties        map[string]*schemaNode
+	required          []string
+	additional        *schemaNode
+	patternProps      []patternSchema
+	propertyNames     *schemaNode
+	minProps          *int
+	maxProps          *int
+	dependentRequired map[string][]string
+
+	items       *schemaNode
+	prefixItems []*schemaNode
+	minItems    *int
+	maxItems    *int
+	uniqueItems bool
+	contains    *schemaNode
+	minContains *int
+	maxContains *int
+
+	minLength *int
+	maxLength *int
+	pattern   *regexp.Regexp
+	format    string
+
+	minimum    *float64
+	maximum    *float64
+	exMinimum  *float64
+	exMaximum  *float64
+	multipleOf *float64
+
+	allOf []*schemaNode
+	anyOf []*schemaNode
+	oneOf []*schemaNode
+	not   *schemaNode
+	ifS   *schemaNode
+	thenS *schemaNode
+	elseS *schemaNode
+}
+
+// CompileSchema checks and compiles a schema. A nil or empty schema
+// accepts everything.
+func CompileSchema(schema map[string]interface{}) (*JSONSchema, error) {
+	if schema == nil {
+		schema = map[string]interface{}{}
+	}
+	doc, ok := normalizeJSON(schema).(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("compile schema: not a JSON object")
+	}
+	s := &JSONSchema{doc: doc, refs: map[string]*schemaNode{}}
+	root, err := s.compile(doc, "")
+	if err != nil {
+		return nil, fmt.Errorf("compile schema: %w", err)
+	}
+	s.root = root
+	s.refs["#"] = root
+	for ref := range s.pendingRefs(root, map[*schemaNode]bool{}) {
+		if _, err := s.resolve(ref); err != nil {
+			return nil, fmt.Errorf("compile schema: %w", err)
+		}
+	}
+	if err := s.checkCycles(); err != nil {
+		return nil, fmt.Errorf("compile schema: %w", err)
+	}
+	return s, nil
+}
+
+// Validate checks v against the schema. It returns v as decoded JSON with
+// missing properties that declare a default filled in, or a
+// *ValidationError.
+func (s *JSONSchema) Validate(v interface{}) (interface{}, error) {
+	v = normalizeJSON(v)
+	var errs []FieldError
+	v = s.validate(s.root, v, "", &errs, true)
+	if len(errs) > 0 {
+		sort.SliceStable(errs, func(i, j int) bool { return errs[i].Path < errs[j].Path })
+		return v, &ValidationError{Errors: errs}
+	}
+	return v, nil
+}
+
+// ValidateInput validates a tool's input against its schema and returns
+// the input with defaults filled in.
+func ValidateInput(schema map[string]interface{}, input map[string]interface{}) (map[string]interface{}, error) {
+	compiled, err := CompileSchema(schema)
+	if err != nil {
+		return nil, err
+	}
+	if input == nil {
+		input = map[string]interface{}{}
+	}
+	out, err := compiled.Validate(input)
+	if err != nil {
+		return nil, err
+	}
+	return out.(map[string]interface{}), nil
+}
+
+func (s *JSONSchema) compile(raw interface{}, at string) (*schemaNode, error) {
+	if b, ok := raw.(bool); ok {
+		return &schemaNode{always: &b}, nil
+	}
+	m, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: schema must be an object or boolean", pointerOrRoot(at))
+	}
+	n := &schemaNode{}
+	fail := func(key, msg string) error {
+		return fmt.Errorf("%s: %s", pointerOrRoot(at+"/"+key), msg)
+	}
+	sub := func(key string) (*schemaNode, error) {
+		v, ok := m[key]
+		if !ok {
+			return nil, nil
+		}
+		return s.compile(v, at+"/"+key)
+	}
+	list := func(key string) ([]*schemaNode, error) {
+		v, ok := m[key]
+		if !ok {
+			return nil, nil
+		}
+		items, ok := v.([]interface{})
+		if !ok || len(items) == 0 {
+			return nil, fail(key, "must be a non-empty array of schemas")
+		}
+		out := make([]*schemaNode, len(items))
+		for i, item := range items {
+			c, err := s.compile(item, fmt.Sprintf("%s/%s/%d", at, key, i))
+			if err != nil {
+				return nil, err
+			}
+			out[i] = c
+		}
+		return out, nil
+	}
+	count := func(key string) (*int, error) {
+		v, ok := m[key]
+		if !ok {
+			return nil, nil
+		}
+		f, ok := v.(float64)
+		if !ok || f < 0 || f != math.Trunc(f) {
+			return nil, fail(key, "must be a non-negative integer")
+		}
+		i := int(f)
+		return &i, nil
+	}
+	number := func(key string) (*float64, error) {
+		v, ok := m[key]
+		if !ok {
+			return nil, nil
+		}
+		f, ok := v.(float64)
+		if !ok {
+			return nil, fail(key, "must be a number")
+		}
+		return &f, nil
+	}
+
+	var err error
+	switch t := m["type"].(type) {
+	case nil:
+	case string:
+		n.types = []string{t}
+	case []interface{}:
+		for _, item := range t {
+			name, ok := item.(string)
+			if !ok {
+				return nil, fail("type", "must be a string or an array of strings")
+			}
+			n.types = append(n.types, name)
+		}
+	default:
+		return nil, fail("type", "must be a string or an array of strings")
+	}
+	for _, name := range n.types {
+		switch name {
+		case "null", "boolean", "object", "array", "number", "integer", "string":
+		default:
+			return nil, fail("type", fmt.Sprintf("unknown type %q", name))
+		}
+	}
+	if v, ok := m["enum"]; ok {
+		if n.enum, ok = v.([]interface{}); !ok {
+			return nil, fail("enum", "must be an array")
+		}
+	}
+	n.constVal, n.hasConst = m["const"]
+	n.def, n.hasDef = m["default"]
+	if v, ok := m["$ref"]; ok {
+		if n.ref, ok = v.(string); !ok || !strings.HasPrefix(n.ref, "#") {
+			return nil, fail("$ref", "only references within the schema (#...) are supported")
+		}
+	}
+
+	if v, ok := m["properties"]; ok {
+		props, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fail("properties", "must be an object")
+		}
+		n.properties = make(map[string]*schemaNode, len(props))
+		for name, p := range props {
+			if n.properties[name], err = s.compile(p, at+"/properties/"+escapePointer(name)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if v, ok := m["required"]; ok {
+		items, ok := v.([]interface{})
+		if !ok {
+			return nil, fail("required", "must be an array of strings")
+		}
+		for _, item := range items {
+			name, ok := item.(string)
+			if !ok {
+				return nil, fail("required", "must be an array of strings")
+			}
+			n.required = append(n.required, name)
+		}
+	}
+	if n.additional, err = sub("additionalProperties"); err != nil {
+		return nil, err
+	}
+	if v, ok := m["patternProperties"]; ok {
+		props, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fail("patternProperties", "must be an object")
+		}
+		patterns := make([]string, 0, len(props))
+		for p := range props {
+			patterns = append(patterns, p)
+		}
+		sort.Strings(patterns)
+		for _, p := range patterns {
+			re, err := regexp.Compile(p)
+			if err != nil {
+				return nil, fail("patternProperties", fmt.Sprintf("invalid pattern %q: %v", p, err))
+			}
+			c, err := s.compile(props[p], at+"/patternProperties/"+escapePointer(p))
+			if err != nil {
+				return nil, err
+			}
+			n.patternProps = append(n.patternProps, patternSchema{re: re, schema: c})
+		}
+	}
+	if n.propertyNames, err = sub("propertyNames"); err != nil {
+		return nil, err
+	}
+	if n.minProps, err = count("minProperties"); err != nil {
+		return nil, err
+	}
+	if n.maxProps, err = count("maxProperties"); err != nil {
+		return nil, err
+	}
+	if v, ok := m["dependentRequired"]; ok {
+		deps, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fail("dependentRequired", "must be an object of string arrays")
+		}
+		n.dependentRequired = map[string][]string{}
+		for name, d := range deps {
+			items, ok := d.([]interface{})
+			if !ok {
+				return nil, fail("dependentRequired", "must be an object of string arrays")
+			}
+			for _, item := range items {
+				dep, ok := item.(string)
+				if !ok {
+					return nil, fail("dependentRequired", "must be an object of string arrays")
+				}
+				n.dependentRequired[name] = append(n.dependentRequired[name], dep)
+			}
+		}
+	}
+
+	if n.items, err = sub("items"); err != nil {
+		return nil, err
+	}
+	if n.prefixItems, err = list("prefixItems"); err != nil {
+		return nil, err
+	}
+	if n.minItems, err = count("minItems"); err != nil {
+		return nil, err
+	}
+	if n.maxItems, err = count("maxItems"); err != nil {
+		return nil, err
+	}
+	if v, ok := m["uniqueItems"]; ok {
+		if n.uniqueItems, ok = v.(bool); !ok {
+			return nil, fail("uniqueItems", "must be a boolean")
+		}
+	}
+	if n.contains, err = sub("contains"); err != nil {
+		return nil, err
+	}
+	if n.minContains, err = count("minContains"); err != nil {
+		return nil, err
+	}
+	if n.maxContains, err = count("maxContains"); err != nil {
+		return nil, err
+	}
+
+	if n.minLength, err = count("minLength"); err != nil {
+		return nil, err
+	}
+	if n.maxLength, err = count("maxLength"); err != nil {
+		return nil, err
+	}
+	if v, ok := m["pattern"]; ok {
+		p, ok := v.(string)
+		if !ok {
+			return nil, fail("pattern", "must be a string")
+		}
+		if n.pattern, err = regexp.Compile(p); err != nil {
+			return nil, fail("pattern", fmt.Sprintf("invalid pattern: %v", err))
+		}
+	}
+	if v, ok := m["format"]; ok {
+		if n.format, ok = v.(string); !ok {
+			return nil, fail("format", "must be a string")
+		}
+	}
+
+	if n.minimum, err = number("minimum"); err != nil {
+		return nil, err
+	}
+	if n.maximum, err = number("maximum"); err != nil {
+		return nil, err
+	}
+	if n.exMinimum, err = number("exclusiveMinimum"); err != nil {
+		return nil, err
+	}
+	if n.exMaximum, err = number("exclusiveMaximum"); err != nil {
+		return nil, err
+	}
+	if n.multipleOf, err = number("multipleOf"); err != nil {
+		return nil, err
+	}
+	if n.multipleOf != nil && *n.multipleOf <= 0 {
+		return nil, fail("multipleOf", "must be greater than 0")
+	}
+
+	if n.allOf, err = list("allOf"); err != nil {
+		return nil, err
+	}
+	if n.anyOf, err = list("anyOf"); err != nil {
+		return nil, err
+	}
+	if n.oneOf, err = list("oneOf"); err != nil {
+		return nil, err
+	}
+	if n.not, err = sub("not"); err != nil {
+		return nil, err
+	}
+	if n.ifS, err = sub("if"); err != nil {
+		return nil, err
+	}
+	if n.thenS, err = sub("then"); err != nil {
+		return nil, err
+	}
+	if n.elseS, err = sub("else"); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// pendingRefs collects the $refs reachable from n.
+func (s *JSONSchema) pendingRefs(n *schemaNode, seen map[*schemaNode]bool) map[string]bool {
+	refs := map[string]bool{}
+	var walk func(*schemaNode)
+	walk = func(n *schemaNode) {
+		if n == nil || seen[n] {
+			return
+		}
+		seen[n] = true
+		if n.ref != "" {
+			refs[n.ref] = true
+		}
+		for _, c := range n.subschemas() {
+			walk(c)
+		}
+	}
+	walk(n)
+	return refs
+}
+
+// subschemas returns n's direct subschemas, not following $ref.
+func (n *schemaNode) subschemas() []*schemaNode {
+	var out []*schemaNode
+	for _, name := range sortedKeys(n.properties) {
+		out = append(out, n.properties[name])
+	}
+	for _, p := range n.patternProps {
+		out = append(out, p.schema)
+	}
+	out = append(out, n.prefixItems...)
+	out = append(out, n.allOf...)
+	out = append(out, n.anyOf...)
+	out = append(out, n.oneOf...)
+	for _, c := range []*schemaNode{n.additional, n.propertyNames, n.items, n.contains, n.not, n.ifS, n.thenS, n.elseS} {
+		if c != nil {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// checkCycles rejects $refs that lead back to themselves without
+// descending into a property or item, which would recurse forever on any
+// value. A node applies its $ref and its allOf, anyOf, oneOf, not, if,
+// then and else schemas to the value it was given.
+func (s *JSONSchema) checkCycles() error {
+	const visiting, done = 1, 2
+	state := map[*schemaNode]int{}
+	var refs []string
+	var visit func(*schemaNode) error
+	visit = func(n *schemaNode) error {
+		switch state[n] {
+		case visiting:
+			return fmt.Errorf("$ref cycle %s never descends into the value", strings.Join(refs, " -> "))
+		case done:
+			return nil
+		}
+		state[n] = visiting
+		if n.ref != "" {
+			refs = append(refs, n.ref)
+			if err := visit(s.refs[n.ref]); err != nil {
+				return err
+			}
+			refs = refs[:len(refs)-1]
+		}
+		inPlace := append(append(append([]*schemaNode(nil), n.allOf...), n.anyOf...), n.oneOf...)
+		for _, c := range append(inPlace, n.not, n.ifS, n.thenS, n.elseS) {
+			if c == nil {
+				continue
+			}
+			if err := visit(c); err != nil {
+				return err
+			}
+		}
+		state[n] = done
+		return nil
+	}
+	seen := map[*schemaNode]bool{}
+	var walk func(*schemaNode) error
+	walk = func(n *schemaNode) error {
+		if seen[n] {
+			return nil
+		}
+		seen[n] = true
+		if err := visit(n); err != nil {
+			return err
+		}
+		for _, c := range n.subschemas() {
+			if err := walk(c); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, ref := range sortedKeys(s.refs) {
+		if err := walk(s.refs[ref]); err != nil {
+			return err
 		}
 	}
 	return nil
 }
+
+// resolve compiles the schema a $ref points at, once.
+func (s *JSONSchema) resolve(ref string) (*schemaNode, error) {
+	if n, ok := s.refs[ref]; ok {
+		return n, nil
+	}
+	var target interface{} = s.doc
+	for _, token := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		m, ok := target.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("$ref %s not found", ref)
+		}
+		if target, ok = m[token]; !ok {
+			return nil, fmt.Errorf("$ref %s not found", ref)
+		}
+	}
+	n, err := s.compile(target, strings.TrimPrefix(ref, "#"))
+	if err != nil {
+		return nil, err
+	}
+	s.refs[ref] = n
+	for next := range s.pendingRefs(n, map[*schemaNode]bool{}) {
+		if _, err := s.resolve(next); err != nil {
+			return nil, err
+		}
+	}
+	return n, nil
+}
+
+// validate checks v against n, appending violations to errs, and returns v
+// with defaults filled in when fill is set. Defaults come only from
+// schemas v must satisfy: properties, allOf and the if branch taken, never
+// from anyOf, oneOf, not or if, which are probed with matches.
+func (s *JSONSchema) validate(n *schemaNode, v interface{}, path string, errs *[]FieldError, fill bool) interface{} {
+	fail := func(format string, args ...interface{}) {
+		*errs = append(*errs, FieldError{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+	if n.always != nil {
+		if !*n.always {
+			fail("no value is allowed here")
+		}
+		return v
+	}
+	if n.ref != "" {
+		v = s.validate(s.refs[n.ref], v, path, errs, fill)
+	}
+	if len(n.types) > 0 && !hasType(n.types, v) {
+		fail("expected %s, got %s", strings.Join(n.types, " or "), typeName(v))
+		return v
+	}
+	if n.enum != nil && !containsValue(n.enum, v) {
+		fail("must be one of %s", formatValues(n.enum))
+	}
+	if n.hasConst && !reflect.DeepEqual(n.constVal, v) {
+		fail("must be %s", formatValue(n.constVal))
+	}
+
+	switch val := v.(type) {
+	case map[string]interface{}:
+		v = s.validateObject(n, val, path, errs, fill)
+	case []interface{}:
+		v = s.validateArray(n, val, path, errs, fill)
+	case string:
+		s.validateString(n, val, fail)
+	case float64:
+		validateNumber(n, val, fail)
+	}
+
+	for _, c := range n.allOf {
+		v = s.validate(c, v, path, errs, fill)
+	}
+	if n.anyOf != nil {
+		matched := false
+		for _, c := range n.anyOf {
+			if s.matches(c, v) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			fail("must match at least one of the anyOf schemas")
+		}
+	}
+	if n.oneOf != nil {
+		matched := 0
+		for _, c := range n.oneOf {
+			if s.matches(c, v) {
+				matched++
+			}
+		}
+		if matched != 1 {
+			fail("must match exactly one of the oneOf schemas, matched %d", matched)
+		}
+	}
+	if n.not != nil && s.matches(n.not, v) {
+		fail("must not match the not schema")
+	}
+	if n.ifS != nil {
+		if s.matches(n.ifS, v) {
+			if n.thenS != nil {
+				v = s.validate(n.thenS, v, path, errs, fill)
+			}
+		} else if n.elseS != nil {
+			v = s.validate(n.elseS, v, path, errs, fill)
+		}
+	}
+	return v
+}
+
+// matches reports whether v is valid against n. It checks a copy of v
+// without filling defaults, so a probed schema never changes the value.
+func (s *JSONSchema) matches(n *schemaNode, v interface{}) bool {
+	var errs []FieldError
+	s.validate(n, normalizeJSON(v), "", &errs, false)
+	return len(errs) == 0
+}
+
+func (s *JSONSchema) validateObject(n *schemaNode, obj map[string]interface{}, path string, errs *[]FieldError, fill bool) map[string]interface{} {
+	fail := func(format string, args ...interface{}) {
+		*errs = append(*errs, FieldError{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+	for _, name := range sortedKeys(n.properties) {
+		if p := n.properties[name]; fill && p.hasDef {
+			if _, ok := obj[name]; !ok {
+				obj[name] = normalizeJSON(p.def)
+			}
+		}
+	}
+	for _, name := range n.required {
+		if _, ok := obj[name]; !ok {
+			*errs = append(*errs, FieldError{Path: path + "/" + escapePointer(name), Message: "is required"})
+		}
+	}
+	for _, name := range sortedKeys(n.dependentRequired) {
+		if _, ok := obj[name]; !ok {
+			continue
+		}
+		for _, dep := range n.dependentRequired[name] {
+			if _, ok := obj[dep]; !ok {
+				*errs = append(*errs, FieldError{Path: path + "/" + escapePointer(dep), Message: fmt.Sprintf("is required when %q is present", name)})
+			}
+		}
+	}
+	if n.minProps != nil && len(obj) < *n.minProps {
+		fail("must have at least %d properties", *n.minProps)
+	}
+	if n.maxProps != nil && len(obj) > *n.maxProps {
+		fail("must have at most %d properties", *n.maxProps)
+	}
+	for _, name := range sortedKeys(obj) {
+		at := path + "/" + escapePointer(name)
+		if n.propertyNames != nil {
+			var nameErrs []FieldError
+			s.validate(n.propertyNames, name, at, &nameErrs, false)
+			for _, fe := range nameErrs {
+				*errs = append(*errs, FieldError{Path: at, Message: "property name " + fe.Message})
+			}
+		}
+		known := false
+		if p, ok := n.properties[name]; ok {
+			known = true
+			obj[name] = s.validate(p, obj[name], at, errs, fill)
+		}
+		for _, pp := range n.patternProps {
+			if pp.re.MatchString(name) {
+				known = true
+				obj[name] = s.validate(pp.schema, obj[name], at, errs, fill)
+			}
+		}
+		if known || n.additional == nil {
+			continue
+		}
+		if n.additional.always != nil && !*n.additional.always {
+			*errs = append(*errs, FieldError{Path: at, Message: fmt.Sprintf("is not allowed; allowed properties are %s", formatNames(sortedKeys(n.properties)))})
+			continue
+		}
+		obj[name] = s.validate(n.additional, obj[name], at, errs, fill)
+	}
+	return obj
+}
+
+func (s *JSONSchema) validateArray(n *schemaNode, arr []interface{}, path string, errs *[]FieldError, fill bool) []interface{} {
+	fail := func(format string, args ...interface{}) {
+		*errs = append(*errs, FieldError{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+	if n.minItems != nil && len(arr) < *n.minItems {
+		fail("must have at least %d items", *n.minItems)
+	}
+	if n.maxItems != nil && len(arr) > *n.maxItems {
+		fail("must have at most %d items", *n.maxItems)
+	}
+	for i := range arr {
+		at := path + "/" + strconv.Itoa(i)
+		switch {
+		case i < len(n.prefixItems):
+			arr[i] = s.validate(n.prefixItems[i], arr[i], at, errs, fill)
+		case n.items != nil:
+			arr[i] = s.validate(n.items, arr[i], at, errs, fill)
+		}
+	}
+	if n.uniqueItems {
+		for i := 1; i < len(arr); i++ {
+			for j := 0; j < i; j++ {
+				if reflect.DeepEqual(arr[i], arr[j]) {
+					*errs = append(*errs, FieldError{Path: path + "/" + strconv.Itoa(i), Message: fmt.Sprintf("duplicates item %d; items must be unique", j)})
+					break
+				}
+			}
+		}
+	}
+	if n.contains != nil {
+		found := 0
+		for _, item := range arr {
+			if s.matches(n.contains, item) {
+				found++
+			}
+		}
+		lo := 1
+		if n.minContains != nil {
+			lo = *n.minContains
+		}
+		if found < lo {
+			fail("must contain at least %d matching items, found %d", lo, found)
+		}
+		if n.maxContains != nil && found > *n.maxContains {
+			fail("must contain at most %d matching items, found %d", *n.maxContains, found)
+		}
+	}
+	return arr
+}
+
+func (s *JSONSchema) validateString(n *schemaNode, str string, fail func(string, ...interface{})) {
+	length := utf8.RuneCountInString(str)
+	if n.minLength != nil && length < *n.minLength {
+		fail("must be at least %d characters long", *n.minLength)
+	}
+	if n.maxLength != nil && length > *n.maxLength {
+		fail("must be at most %d characters long", *n.maxLength)
+	}
+	if n.pattern != nil && !n.pattern.MatchString(str) {
+		fail("must match pattern %q", n.pattern.String())
+	}
+	if n.format != "" && !validFormat(n.format, str) {
+		fail("must be a valid %s", n.format)
+	}
+}
+
+func validateNumber(n *schemaNode, f float64, fail func(string, ...interface{})) {
+	if n.minimum != nil && f < *n.minimum {
+		fail("must be >= %v", *n.minimum)
+	}
+	if n.maximum != nil && f > *n.maximum {
+		fail("must be <= %v", *n.maximum)
+	}
+	if n.exMinimum != nil && f <= *n.exMinimum {
+		fail("must be > %v", *n.exMinimum)
+	}
+	if n.exMaximum != nil && f >= *n.exMaximum {
+		fail("must be < %v", *n.exMaximum)
+	}
+	if n.multipleOf != nil {
+		q := f / *n.multipleOf
+		if math.Abs(q-math.Round(q)) > 1e-9 {
+			fail("must be a multiple of %v", *n.multipleOf)
+		}
+	}
+}
+
+var (
+	uuidPattern     = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	hostnamePattern = regexp.MustCompile(`^(?i:[a-z0-9](?:[a-z0-9-]{0,61}[a-z0-9])?)(?:\.(?i:[a-z0-9](?:[a-z0-9-]{0,61}[a-z0-9])?))*$`)
+)
+
+// validFormat checks the formats tools commonly use. Unknown formats pass.
+func validFormat(format, s string) bool {
+	switch format {
+	case "date-time":
+		_, err := time.Parse(time.RFC3339Nano, s)
+		return err == nil
+	case "date":
+		_, err := time.Parse(time.DateOnly, s)
+		return err == nil
+	case "time":
+		_, err := time.Parse("15:04:05Z07:00", s)
+		return err == nil
+	case "email":
+		addr, err := mail.ParseAddress(s)
+		return err == nil && addr.Address == s
+	case "uri":
+		u, err := url.Parse(s)
+		return err == nil && u.Scheme != ""
+	case "uri-reference":
+		_, err := url.Parse(s)
+		return err == nil
+	case "uuid":
+		return uuidPattern.MatchString(s)
+	case "ipv4":
+		addr, err := netip.ParseAddr(s)
+		return err == nil && addr.Is4()
+	case "ipv6":
+		addr, err := netip.ParseAddr(s)
+		return err == nil && addr.Is6()
+	case "hostname":
+		return len(s) <= 253 && hostnamePattern.MatchString(s)
+	case "regex":
+		_, err := regexp.Compile(s)
+		return err == nil
+	default:
+		return true
+	}
+}
+
+func hasType(types []string, v interface{}) bool {
+	for _, t := range types {
+		switch t {
+		case "null":
+			if v == nil {
+				return true
+			}
+		case "boolean":
+			if _, ok := v.(bool); ok {
+				return true
+			}
+		case "object":
+			if _, ok := v.(map[string]interface{}); ok {
+				return true
+			}
+		case "array":
+			if _, ok := v.([]interface{}); ok {
+				return true
+			}
+		case "string":
+			if _, ok := v.(string); ok {
+				return true
+			}
+		case "number":
+			if _, ok := v.(float64); ok {
+				return true
+			}
+		case "integer":
+			if f, ok := v.(float64); ok && f == math.Trunc(f) && !math.IsInf(f, 0) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func typeName(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		if val == math.Trunc(val) {
+			return "integer"
+		}
+		return "number"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+func containsValue(values []interface{}, v interface{}) bool {
+	for _, candidate := range values {
+		if reflect.DeepEqual(candidate, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func formatValue(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+func formatValues(values []interface{}) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = formatValue(v)
+	}
+	return strings.Join(parts, ", ")
+}
+
+func formatNames(names []string) string {
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ", ")
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// escapePointer escapes a property name as a JSON Pointer token.
+func escapePointer(name string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(name, "~", "~0"), "/", "~1")
+}
+
+func pointerOrRoot(p string) string {
+	if p == "" {
+		return "(root)"
+	}
+	return p
+}
+
+// normalizeJSON returns a deep copy of v as decoded JSON: objects are
+// map[string]interface{}, arrays []interface{} and numbers float64. Values
+// of other Go types go through encoding/json.
+func normalizeJSON(v interface{}) interface{} {
+	switch val := v.(type) {
+	case nil, bool, string, float64:
+		return val
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			out[k] = normalizeJSON(item)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(val))
+		for i, item := range val {
+			out[i] = normalizeJSON(item)
+		}
+		return out
+	case int:
+		return float64(val)
+	case int64:
+		return float64(val)
+	case float32:
+		return float64(val)
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var out interface{}
+	if err := json.Unmarshal(b, &out); err != nil {
+		return v
+	}
+	return out
+}
@@ -0,0 +1,233 @@
+package tools
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"spawn.dev/pkg/capability"
+)
+
+var orderSchema = map[string]interface{}{
+	"$schema": "https://json-schema.org/draft/2020-12/schema",
+	"type":    "object",
+	"properties": map[string]interface{}{
+		"id":       map[string]interface{}{"type": "string", "format": "uuid"},
+		"status":   map[string]interface{}{"enum": []interface{}{"open", "closed"}, "default": "open"},
+		"email":    map[string]interface{}{"type": "string", "format": "email"},
+		"placed":   map[string]interface{}{"type": "string", "format": "date-time"},
+		"priority": map[string]interface{}{"type": "integer", "minimum": 1, "maximum": 5, "default": 3},
+		"lines": map[string]interface{}{
+			"type":     "array",
+			"minItems": 1,
+			"items":    map[string]interface{}{"$ref": "#/$defs/line"},
+		},
+		"tags": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}, "uniqueItems": true},
+	},
+	"required":             []interface{}{"id", "lines"},
+	"additionalProperties": false,
+	"$defs": map[string]interface{}{
+		"line": map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"sku":      map[string]interface{}{"type": "string", "pattern": "^[A-Z]{3}-[0-9]+$"},
+				"quantity": map[string]interface{}{"type": "integer", "exclusiveMinimum": 0},
+				"note":     map[string]interface{}{"type": []interface{}{"string", "null"}, "maxLength": 10},
+			},
+			"required": []interface{}{"sku", "quantity"},
+		},
+	},
+}
+
+func TestSchemaValidate(t *testing.T) {
+	t.Parallel()
+	s, err := CompileSchema(orderSchema)
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	valid := map[string]interface{}{
+		"id":     "0b4c3a52-9f5e-4c1a-9f07-4d8a1f6f2b10",
+		"email":  "ada@example.com",
+		"placed": "2026-10-18T09:30:00Z",
+		"lines":  []interface{}{map[string]interface{}{"sku": "ABC-1", "quantity": 2, "note": nil}},
+		"tags":   []string{"rush"},
+	}
+	out, err := s.Validate(valid)
+	if err != nil {
+		t.Fatalf("valid order: %v", err)
+	}
+	got := out.(map[string]interface{})
+	if got["status"] != "open" || got["priority"] != 3.0 {
+		t.Fatalf("defaults = %v, %v", got["status"], got["priority"])
+	}
+	if _, ok := valid["status"]; ok {
+		t.Fatalf("defaults were written to the caller's input")
+	}
+
+	cases := []struct {
+		name  string
+		value map[string]interface{}
+		want  []string
+	}{
+		{"missing required", map[string]interface{}{}, []string{"/id: is required", "/lines: is required"}},
+		{"wrong types", map[string]interface{}{"id": 7, "lines": "x", "priority": 2.5}, []string{"/id: expected string, got integer", "/lines: expected array, got string", "/priority: expected integer, got number"}},
+		{"nested items", map[string]interface{}{
+			"id":    "0b4c3a52-9f5e-4c1a-9f07-4d8a1f6f2b10",
+			"lines": []interface{}{map[string]interface{}{"sku": "abc", "quantity": 0, "note": "far too long"}},
+		}, []string{`/lines/0/sku: must match pattern "^[A-Z]{3}-[0-9]+$"`, "/lines/0/quantity: must be > 0", "/lines/0/note: must be at most 10 characters long"}},
+		{"formats and enum", map[string]interface{}{
+			"id": "nope", "email": "ada", "placed": "yesterday", "status": "lost", "lines": []interface{}{},
+		}, []string{"/id: must be a valid uuid", "/email: must be a valid email", "/placed: must be a valid date-time", "/status: must be one of \"open\", \"closed\"", "/lines: must have at least 1 items"}},
+		{"extra fields", map[string]interface{}{
+			"id": "0b4c3a52-9f5e-4c1a-9f07-4d8a1f6f2b10", "lines": []interface{}{map[string]interface{}{"sku": "ABC-1", "quantity": 1}},
+			"colour": "red", "tags": []interface{}{"a", "a"}, "priority": 9,
+		}, []string{"/colour: is not allowed; allowed properties are email, id, lines, placed, priority, status, tags", "/priority: must be <= 5", "/tags/1: duplicates item 0"}},
+	}
+	for _, tc := range cases {
+		_, err := s.Validate(tc.value)
+		verr, ok := err.(*ValidationError)
+		if !ok {
+			t.Fatalf("%s: err = %v", tc.name, err)
+		}
+		for _, want := range tc.want {
+			if !strings.Contains(verr.Error(), want) {
+				t.Fatalf("%s: %q does not report %q", tc.name, verr.Error(), want)
+			}
+		}
+		if len(verr.Errors) != len(tc.want) {
+			t.Fatalf("%s: %d errors, want %d: %v", tc.name, len(verr.Errors), len(tc.want), verr)
+		}
+	}
+}
+
+func TestSchemaCombinators(t *testing.T) {
+	t.Parallel()
+	s, err := CompileSchema(map[string]interface{}{
+		"oneOf": []interface{}{
+			map[string]interface{}{"type": "string"},
+			map[string]interface{}{"type": "number", "multipleOf": 0.5},
+		},
+		"not": map[string]interface{}{"const": "forbidden"},
+	})
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	for v, ok := range map[interface{}]bool{"x": true, 1.5: true, 1.2: false, true: false, "forbidden": false} {
+		if _, err := s.Validate(v); (err == nil) != ok {
+			t.Fatalf("%v: err = %v, want valid %v", v, err, ok)
+		}
+	}
+
+	// Recursive references.
+	tree, err := CompileSchema(map[string]interface{}{
+		"type":       "object",
+		"properties": map[string]interface{}{"children": map[string]interface{}{"type": "array", "items": map[string]interface{}{"$ref": "#"}}},
+		"if":         map[string]interface{}{"required": []interface{}{"leaf"}},
+		"then":       map[string]interface{}{"properties": map[string]interface{}{"children": map[string]interface{}{"maxItems": 0}}},
+	})
+	if err != nil {
+		t.Fatalf("compile tree: %v", err)
+	}
+	bad := map[string]interface{}{"children": []interface{}{map[string]interface{}{"leaf": true, "children": []interface{}{map[string]interface{}{}}}}}
+	if _, err := tree.Validate(bad); err == nil || !strings.Contains(err.Error(), "/children/0/children: must have at most 0 items") {
+		t.Fatalf("tree err = %v", err)
+	}
+
+	for _, schema := range []map[string]interface{}{
+		{"type": "text"},
+		{"pattern": "("},
+		{"minLength": -1},
+		{"$ref": "#/$defs/missing"},
+		{"properties": map[string]interface{}{"a": 5}},
+		{
+			"$defs": map[string]interface{}{"a": map[string]interface{}{"$ref": "#/$defs/b"}, "b": map[string]interface{}{"$ref": "#/$defs/a"}},
+			"$ref":  "#/$defs/a",
+		},
+		{"anyOf": []interface{}{map[string]interface{}{"$ref": "#"}}},
+	} {
+		if _, err := CompileSchema(schema); err == nil {
+			t.Fatalf("schema %v compiled", schema)
+		}
+	}
+}
+
+func TestSchemaDefaultsOnlyFromAppliedSchemas(t *testing.T) {
+	t.Parallel()
+	withDefault := func(name string, def interface{}) map[string]interface{} {
+		return map[string]interface{}{"properties": map[string]interface{}{name: map[string]interface{}{"default": def}}}
+	}
+	s, err := CompileSchema(map[string]interface{}{
+		"type":       "object",
+		"properties": map[string]interface{}{"y": map[string]interface{}{"type": "number"}, "size": map[string]interface{}{"default": 1}},
+		"anyOf": []interface{}{
+			map[string]interface{}{"required": []interface{}{"x"}, "properties": map[string]interface{}{"mode": map[string]interface{}{"default": "danger"}}},
+			map[string]interface{}{"required": []interface{}{"y"}},
+		},
+		"oneOf": []interface{}{withDefault("picked", true)},
+		"not":   map[string]interface{}{"allOf": []interface{}{withDefault("neg", true)}, "required": []interface{}{"z"}},
+		"if":    withDefault("probe", true),
+		"then":  withDefault("then", true),
+		"else":  withDefault("else", true),
+		"allOf": []interface{}{withDefault("all", true)},
+	})
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	out, err := s.Validate(map[string]interface{}{"y": 1})
+	if err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+	want := map[string]interface{}{"y": 1.0, "size": 1.0, "then": true, "all": true}
+	if !reflect.DeepEqual(out, want) {
+		t.Fatalf("got %v, want %v", out, want)
+	}
+}
+
+func TestInvokeValidatesSchemas(t *testing.T) {
+	t.Parallel()
+	c := New()
+	var got map[string]interface{}
+	err := c.Register(Tool{
+		Name:   "order",
+		Schema: orderSchema,
+		OutputSchema: map[string]interface{}{
+			"type": "object", "required": []interface{}{"ok"},
+		},
+		Handler: func(_ context.Context, input map[string]interface{}) (interface{}, error) {
+			got = input
+			if input["priority"] == 5.0 {
+				return map[string]interface{}{"error": "nope"}, nil
+			}
+			return map[string]interface{}{"ok": true}, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	input := map[string]interface{}{"id": "0b4c3a52-9f5e-4c1a-9f07-4d8a1f6f2b10", "lines": []interface{}{map[string]interface{}{"sku": "ABC-1", "quantity": 1}}}
+	if resp := invoke(t, c, "order", input); !resp.Success || got["status"] != "open" {
+		t.Fatalf("invoke = %+v, input %v", resp, got)
+	}
+
+	resp := invoke(t, c, "order", map[string]interface{}{"id": 1})
+	fields, _ := resp.Data.([]FieldError)
+	if resp.Success || resp.Error.Code != CodeInvalidInput || len(fields) != 2 || fields[0].Path != "/id" {
+		t.Fatalf("invalid input = %+v", resp)
+	}
+	if !strings.HasPrefix(resp.Error.Message, "invalid input for tool order: /id: expected string") {
+		t.Fatalf("message = %q", resp.Error.Message)
+	}
+
+	input["priority"] = 5
+	if resp := invoke(t, c, "order", input); resp.Success || resp.Error.Code != CodeInvalidOutput {
+		t.Fatalf("invalid output = %+v", resp)
+	}
+
+	if err := c.Register(Tool{Name: "broken", Schema: map[string]interface{}{"type": 5}}); err == nil {
+		t.Fatalf("invalid schema registered")
+	}
+	if _, err := c.Execute(context.Background(), &capability.Request{Action: "invoke", Params: map[string]interface{}{"name": "order"}}); err != nil {
+		t.Fatalf("invoke without input: %v", err)
+	}
+}
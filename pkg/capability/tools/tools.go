@@ -2,27 +2,65 @@ package tools
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sync"
 
 	"spawn.dev/pkg/capability"
+	"spawn.dev/pkg/sandbox"
 )
 
-// Tool is an invokable tool definition.
+// Tool is an invokable tool definition. Schema and OutputSchema are JSON
+// Schemas (draft 2020-12) for the input and result; when Schema is set the
+// Handler receives the input as decoded JSON with defaults filled in.
 type Tool struct {
-	Name        string
-	Description string
-	Schema      map[string]interface{}
-	Handler     func(context.Context, map[string]interface{}) (interface{}, error)
+	Name         string
+	Description  string
+	Schema       map[string]interface{}
+	OutputSchema map[string]interface{}
+	Handler      func(context.Context, map[string]interface{}) (interface{}, error)
+}
+
+// Error codes of tool failures.
+const (
+	CodeInvalidInput  = "invalid_input"
+	CodeTimeout       = "timeout"
+	CodeHandlerFailed = "handler_failed"
+	CodeInvalidOutput = "invalid_output"
+)
+
+// Error is a structured tool failure. Invoke reports Code as the error
+// code of its response.
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string { return e.Message }
+
+// registeredTool is a tool with its compiled schemas.
+type registeredTool struct {
+	Tool
+	input  *JSONSchema
+	output *JSONSchema
 }
 
 // Capability manages tool registration and invocation.
 type Capability struct {
-	registry map[string]Tool
+	// regMu guards registry: custom tools are registered when an agent
+	// starts, possibly while other tools are being invoked.
+	regMu    sync.RWMutex
+	registry map[string]registeredTool
+
+	mu          sync.Mutex
+	sandbox     sandbox.Sandbox
+	toolDir     string
+	wasmRuntime string
 }
 
 // New returns a tool capability.
 func New() *Capability {
-	return &Capability{registry: make(map[string]Tool)}
+	return &Capability{registry: make(map[string]registeredTool)}
 }
 
 func (c *Capability) Name() string                                             { return "tools" }
@@ -41,12 +79,28 @@ func (c *Capability) Register(tool Tool) error {
 	if tool.Name == "" {
 		return fmt.Errorf("register tool: name required")
 	}
-	c.registry[tool.Name] = tool
+	reg := registeredTool{Tool: tool}
+	var err error
+	if tool.Schema != nil {
+		if reg.input, err = CompileSchema(tool.Schema); err != nil {
+			return fmt.Errorf("register tool %s: input %w", tool.Name, err)
+		}
+	}
+	if tool.OutputSchema != nil {
+		if reg.output, err = CompileSchema(tool.OutputSchema); err != nil {
+			return fmt.Errorf("register tool %s: output %w", tool.Name, err)
+		}
+	}
+	c.regMu.Lock()
+	c.registry[tool.Name] = reg
+	c.regMu.Unlock()
 	return nil
 }
 
 // List returns registered tool names.
 func (c *Capability) List() []string {
+	c.regMu.RLock()
+	defer c.regMu.RUnlock()
 	out := make([]string, 0, len(c.registry))
 	for name := range c.registry {
 		out = append(out, name)
@@ -56,27 +110,60 @@ func (c *Capability) List() []string {
 
 func (c *Capability) Execute(ctx context.Context, req *capability.Request) (*capability.Response, error) {
 	if req == nil {
-		return &capability.Response{Success: false, Error: &capability.Error{Code: "invalid_request", Message: "nil request"}}, nil
+		return capability.Failure("invalid_request", "nil request"), nil
 	}
 	switch req.Action {
 	case "list":
 		return &capability.Response{Success: true, Data: c.List()}, nil
 	case "invoke":
 		name, _ := req.Params["name"].(string)
+		c.regMu.RLock()
 		tool, ok := c.registry[name]
+		c.regMu.RUnlock()
 		if !ok {
-			return &capability.Response{Success: false, Error: &capability.Error{Code: "not_found", Message: name}}, nil
+			return capability.Failure("not_found", name), nil
 		}
 		if tool.Handler == nil {
-			return &capability.Response{Success: false, Error: &capability.Error{Code: "no_handler", Message: name}}, nil
+			return capability.Failure("no_handler", name), nil
 		}
 		params, _ := req.Params["input"].(map[string]interface{})
+		if tool.input != nil {
+			if params == nil {
+				params = map[string]interface{}{}
+			}
+			checked, err := tool.input.Validate(params)
+			if err != nil {
+				return invalid(CodeInvalidInput, "invalid input for tool "+name, err), nil
+			}
+			params = checked.(map[string]interface{})
+		}
 		result, err := tool.Handler(ctx, params)
 		if err != nil {
-			return &capability.Response{Success: false, Error: &capability.Error{Code: "invoke_failed", Message: err.Error()}}, nil
+			code := "invoke_failed"
+			var toolErr *Error
+			if errors.As(err, &toolErr) {
+				code = toolErr.Code
+			}
+			return capability.Failure(code, err.Error()), nil
+		}
+		if tool.output != nil {
+			if _, err := tool.output.Validate(result); err != nil {
+				return invalid(CodeInvalidOutput, "invalid output from tool "+name, err), nil
+			}
 		}
 		return &capability.Response{Success: true, Data: result}, nil
 	default:
-		return &capability.Response{Success: false, Error: &capability.Error{Code: "invalid_action", Message: req.Action}}, nil
+		return capability.Failure("invalid_action", req.Action), nil
+	}
+}
+
+// invalid reports a schema violation. Data lists each failing field so the
+// caller, usually a model, can correct them.
+func invalid(code, prefix string, err error) *capability.Response {
+	resp := capability.Failure(code, prefix+": "+err.Error())
+	var verr *ValidationError
+	if errors.As(err, &verr) {
+		resp.Data = verr.Errors
 	}
+	return resp
 }
@@ -57,6 +57,9 @@ type StorageConfig struct {
 	State  DriverConfig `mapstructure:"state" yaml:"state"`
 	Vector DriverConfig `mapstructure:"vector" yaml:"vector"`
 	Files  DriverConfig `mapstructure:"files" yaml:"files"`
+	// Memory.Path is the bbolt file holding agents' key/value and graph
+	// memory.
+	Memory DriverConfig `mapstructure:"memory" yaml:"memory"`
 }
 
 type DriverConfig struct {
@@ -37,6 +37,11 @@ func New(cfg Config) *Gateway {
 	}
 }
 
+// SetMemory serves memory export, import and backup over REST.
+func (g *Gateway) SetMemory(m restgw.MemoryArchiver) {
+	g.rest.SetMemory(m)
+}
+
 // Start starts all gateway servers.
 func (g *Gateway) Start(ctx context.Context) error {
 	g.mu.Lock()
@@ -0,0 +1,127 @@
+package rest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"spawn.dev/pkg/capability/memory"
+)
+
+// MemoryArchiver exports, imports and backs up agent memory.
+type MemoryArchiver interface {
+	Export(ctx context.Context, w io.Writer, agent string, opts memory.ExportOptions) error
+	Import(ctx context.Context, r io.Reader, agent string, opts memory.ImportOptions) (*memory.ImportStats, error)
+	Backup(ctx context.Context, w io.Writer) error
+}
+
+// MaxImportBytes caps the size of an uploaded memory archive, which the
+// importer holds in memory while checking it.
+const MaxImportBytes = 256 << 20
+
+// SetMemory enables the memory endpoints.
+func (s *Server) SetMemory(m MemoryArchiver) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.memory = m
+}
+
+func (s *Server) memoryArchiver() (MemoryArchiver, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.memory == nil {
+		return nil, echo.NewHTTPError(http.StatusServiceUnavailable, "memory is not configured")
+	}
+	return s.memory, nil
+}
+
+// registerMemoryRoutes adds the memory endpoints. They read and replace
+// every agent's memory, and the gateway has no authentication, so they
+// only answer clients on the loopback interface.
+func (s *Server) registerMemoryRoutes() {
+	s.e.GET("/v1/agents/:agent/memory/export", s.exportMemory, loopbackOnly)
+	s.e.POST("/v1/agents/:agent/memory/import", s.importMemory, loopbackOnly)
+	s.e.GET("/v1/memory/backup", s.backupMemory, loopbackOnly)
+}
+
+// loopbackOnly rejects requests from other hosts. It checks the connection's
+// address rather than forwarding headers, which clients can forge.
+func loopbackOnly(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		host, _, err := net.SplitHostPort(c.Request().RemoteAddr)
+		if err != nil {
+			host = c.Request().RemoteAddr
+		}
+		if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+			return echo.NewHTTPError(http.StatusForbidden, "memory endpoints are only served on localhost")
+		}
+		return next(c)
+	}
+}
+
+func (s *Server) exportMemory(c echo.Context) error {
+	m, err := s.memoryArchiver()
+	if err != nil {
+		return err
+	}
+	agent := c.Param("agent")
+	opts := memory.ExportOptions{Namespaces: queryList(c, "namespace")}
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "application/gzip")
+	res.Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", agent+".memory.jsonl.gz"))
+	res.WriteHeader(http.StatusOK)
+	// The status is sent; a failure now can only cut the archive short,
+	// which the importer rejects as a truncated gzip stream.
+	return m.Export(c.Request().Context(), res, agent, opts)
+}
+
+func (s *Server) importMemory(c echo.Context) error {
+	m, err := s.memoryArchiver()
+	if err != nil {
+		return err
+	}
+	opts := memory.ImportOptions{Mode: c.QueryParam("mode"), Namespaces: queryList(c, "namespace")}
+	body := http.MaxBytesReader(c.Response(), c.Request().Body, MaxImportBytes)
+	stats, err := m.Import(c.Request().Context(), body, c.Param("agent"), opts)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return echo.NewHTTPError(http.StatusRequestEntityTooLarge, fmt.Sprintf("memory archive exceeds %d bytes", MaxImportBytes))
+		}
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return c.JSON(http.StatusOK, stats)
+}
+
+func (s *Server) backupMemory(c echo.Context) error {
+	m, err := s.memoryArchiver()
+	if err != nil {
+		return err
+	}
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "application/x-tar")
+	name := "spawn-memory-" + time.Now().UTC().Format("20060102T150405Z") + ".tar"
+	res.Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", name))
+	res.WriteHeader(http.StatusOK)
+	return m.Backup(c.Request().Context(), res)
+}
+
+// queryList reads a query parameter given repeatedly or comma separated.
+func queryList(c echo.Context, name string) []string {
+	var out []string
+	for _, v := range c.QueryParams()[name] {
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				out = append(out, item)
+			}
+		}
+	}
+	return out
+}
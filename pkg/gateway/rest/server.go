@@ -12,10 +12,11 @@ import (
 
 // Server hosts REST endpoints.
 type Server struct {
-	addr string
-	e    *echo.Echo
-	http *http.Server
-	mu   sync.Mutex
+	addr   string
+	e      *echo.Echo
+	http   *http.Server
+	mu     sync.Mutex
+	memory MemoryArchiver
 }
 
 // New returns a REST server.
@@ -23,6 +24,7 @@ func New(addr string) *Server {
 	e := echo.New()
 	s := &Server{addr: addr, e: e}
 	registerRoutes(e)
+	s.registerMemoryRoutes()
 	return s
 }
 
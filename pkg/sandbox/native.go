@@ -1,6 +1,7 @@
 package sandbox
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
@@ -103,16 +104,48 @@ func (s *nativeSandbox) Exec(ctx context.Context, cmd *Command) (*ExecResult, er
 
 	ec := exec.CommandContext(runCtx, cmd.Path, cmd.Args...)
 	env := os.Environ()
+	if s.config != nil {
+		for k, v := range s.config.Env {
+			env = append(env, k+"="+v)
+		}
+	}
 	for k, v := range cmd.Env {
 		env = append(env, k+"="+v)
 	}
+	// The native runtime cannot firewall the process, so the proxy
+	// variables are its only egress control; they come last so neither
+	// inherited nor command variables override them.
+	if s.config != nil && s.config.EgressProxy != "" {
+		for k, v := range ProxyEnv(s.config.EgressProxy) {
+			env = append(env, k+"="+v)
+		}
+	}
 	ec.Env = env
-	out, err := ec.CombinedOutput()
-	res := &ExecResult{Stdout: string(out), Duration: time.Since(start)}
+	// Children left behind by a killed command can hold its output open;
+	// stop waiting for them shortly after the timeout.
+	ec.WaitDelay = time.Second
+	if cmd.Stdin != nil {
+		ec.Stdin = bytes.NewReader(cmd.Stdin)
+	}
+	stdout := &limitedBuffer{limit: cmd.MaxOutput}
+	stderr := &limitedBuffer{limit: cmd.MaxOutput}
+	ec.Stdout, ec.Stderr = stdout, stdout
+	if cmd.SplitOutput {
+		ec.Stderr = stderr
+	}
+	err := ec.Run()
+	res := &ExecResult{
+		Stdout:    stdout.buf.String(),
+		Stderr:    stderr.buf.String(),
+		Duration:  time.Since(start),
+		Truncated: stdout.truncated || stderr.truncated,
+	}
 	if err != nil {
 		if exitErr, ok := err.(*exec.ExitError); ok {
 			res.ExitCode = exitErr.ExitCode()
-			res.Stderr = err.Error()
+			if !cmd.SplitOutput {
+				res.Stderr = err.Error()
+			}
 			return res, nil
 		}
 		return nil, fmt.Errorf("exec command: %w", err)
@@ -124,7 +157,7 @@ func (s *nativeSandbox) Exec(ctx context.Context, cmd *Command) (*ExecResult, er
 func (s *nativeSandbox) CopyIn(context.Context, string, string) error  { return nil }
 func (s *nativeSandbox) CopyOut(context.Context, string, string) error { return nil }
 func (s *nativeSandbox) NetworkConfig() *NetworkConfig {
-	return &NetworkConfig{Policy: s.config.Network}
+	return networkConfig(s.config)
 }
 func (s *nativeSandbox) State() SandboxState { return s.state }
 func (s *nativeSandbox) Metrics() *SandboxMetrics {
@@ -141,3 +174,24 @@ func (nilReader) Read(_ []byte) (int, error) { return 0, io.EOF }
 type nopWriteCloser struct{ io.Writer }
 
 func (nopWriteCloser) Close() error { return nil }
+
+// limitedBuffer keeps the first limit bytes written to it, or all of them
+// when limit is 0, and notes whether any were dropped. It never fails a
+// write, so the command is not killed for writing too much.
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if b.limit > 0 {
+		if room := max(b.limit-b.buf.Len(), 0); len(p) > room {
+			p = p[:room]
+			b.truncated = true
+		}
+	}
+	b.buf.Write(p)
+	return n, nil
+}
@@ -2,6 +2,7 @@ package sandbox
 
 import (
 	"context"
+	"strings"
 	"testing"
 )
 
@@ -23,3 +24,31 @@ func TestNativeSandboxExec(t *testing.T) {
 		t.Fatalf("expected exit code 0, got %d", res.ExitCode)
 	}
 }
+
+func TestNativeSandboxEgressProxy(t *testing.T) {
+	t.Setenv("NO_PROXY", "*")
+	cfg := DefaultConfig()
+	cfg.Network = NetworkFull
+	cfg.EgressProxy = "http://127.0.0.1:3128"
+	sb, err := NewNativeRuntime().Create(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("create sandbox: %v", err)
+	}
+	res, err := sb.Exec(context.Background(), &Command{
+		Path: "sh",
+		Args: []string{"-c", `echo "$HTTPS_PROXY|$http_proxy|$NO_PROXY"`},
+		Env:  map[string]string{"HTTPS_PROXY": "http://elsewhere:3128"},
+	})
+	if err != nil {
+		t.Fatalf("exec sandbox: %v", err)
+	}
+	if got := strings.TrimSpace(res.Stdout); got != "http://127.0.0.1:3128|http://127.0.0.1:3128|" {
+		t.Fatalf("unexpected proxy env %q", got)
+	}
+	nc := sb.NetworkConfig()
+	// Nothing stops processes ignoring the proxy, so it is not reported as
+	// the only reachable address.
+	if nc.Policy != NetworkFull || nc.EgressProxy != cfg.EgressProxy || len(nc.Allowed) != 0 {
+		t.Fatalf("unexpected network config %#v", nc)
+	}
+}
@@ -0,0 +1,28 @@
+package sandbox
+
+// ProxyEnv returns the variables that point common HTTP clients (curl, pip,
+// python requests, npm, go) at proxyURL and clear any NO_PROXY exemptions.
+func ProxyEnv(proxyURL string) map[string]string {
+	return map[string]string{
+		"HTTP_PROXY":  proxyURL,
+		"HTTPS_PROXY": proxyURL,
+		"http_proxy":  proxyURL,
+		"https_proxy": proxyURL,
+		"ALL_PROXY":   proxyURL,
+		"all_proxy":   proxyURL,
+		"NO_PROXY":    "",
+		"no_proxy":    "",
+	}
+}
+
+// networkConfig derives the network settings of cfg. The native runtime,
+// which the docker, gvisor and firecracker runtimes wrap, cannot firewall
+// its processes: an egress proxy is only advertised to them through
+// ProxyEnv, so the policy reported is the configured one and Allowed is
+// left empty rather than claiming the proxy is the only reachable address.
+func networkConfig(cfg *Config) *NetworkConfig {
+	if cfg == nil {
+		return &NetworkConfig{}
+	}
+	return &NetworkConfig{Policy: cfg.Network, EgressProxy: cfg.EgressProxy}
+}
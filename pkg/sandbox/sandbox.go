@@ -47,6 +47,7 @@ type Config struct {
 	ReadOnlyRoot bool              `yaml:"readOnlyRoot"`
 	Mounts       []Mount           `yaml:"mounts"`
 	Env          map[string]string `yaml:"env"`
+	EgressProxy  string            `yaml:"egressProxy"`
 	StartTimeout time.Duration     `yaml:"startTimeout"`
 	ExecTimeout  time.Duration     `yaml:"execTimeout"`
 }
@@ -57,6 +58,12 @@ type Command struct {
 	Args    []string
 	Env     map[string]string
 	Timeout time.Duration
+	// Stdin is written to the command's standard input.
+	Stdin []byte
+	// SplitOutput keeps standard error out of Stdout, in Stderr.
+	SplitOutput bool
+	// MaxOutput caps the bytes kept of Stdout and of Stderr; 0 keeps all.
+	MaxOutput int
 }
 
 // ExecResult captures command output and metadata.
@@ -65,6 +72,8 @@ type ExecResult struct {
 	Stdout   string
 	Stderr   string
 	Duration time.Duration
+	// Truncated is set when output beyond Command.MaxOutput was dropped.
+	Truncated bool
 }
 
 // Mount is a filesystem mount definition.
@@ -74,9 +83,14 @@ type Mount struct {
 	Mode   string `yaml:"mode"`
 }
 
-// NetworkConfig contains sandbox network settings.
+// NetworkConfig contains the network settings a sandbox enforces.
+// EgressProxy is the proxy its processes are pointed at through their
+// environment; Allowed lists the only reachable addresses when the runtime
+// firewalls the sandbox, and is empty when it does not.
 type NetworkConfig struct {
-	Policy NetworkPolicy
+	Policy      NetworkPolicy
+	EgressProxy string
+	Allowed     []string
 }
 
 // SandboxMetrics captures current resource usage.